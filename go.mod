@@ -5,4 +5,5 @@ go 1.23
 require (
 	github.com/gofrs/uuid v4.0.0+incompatible
 	github.com/gorilla/mux v1.8.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -2,8 +2,13 @@ package cart
 
 import (
 	"errors"
+	"sort"
+	"time"
 
 	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/shipping"
+	"github.com/gambarini/flip-shop/internal/model/tax"
+	"github.com/gambarini/flip-shop/internal/model/tier"
 	"github.com/gambarini/flip-shop/utils"
 	"github.com/gofrs/uuid"
 )
@@ -15,30 +20,83 @@ var (
 	ErrItemQtyAddedInvalid = errors.New("item quantity invalid")
 	// ErrItemNotInCart is returned when applying a discount to a non-existent cart item.
 	ErrItemNotInCart = errors.New("item is not in the cart")
+	// ErrCancelReasonRequired is returned when cancelling without a reason.
+	ErrCancelReasonRequired = errors.New("cancellation reason is required")
 )
 
 type (
 	// Status represents the state of a cart.
-	// For simplicity only 2 states are supported.
 	Status string
 
 	// Cart represents a shopping cart with purchases and totals.
-	// Total is expressed in integer cents (int64).
+	// Amounts are expressed in integer cents (int64). Subtotal is the price of the purchases
+	// before Discount, the total of the line discounts, and OrderDiscount, the discount on the cart
+	// as a whole; Total is the grand total, which adds ShippingCost and also includes Tax
+	// unless TaxInclusive tells the prices already contain it. Totals are computed when the cart is submitted.
+	// FreeShipping waives the cost of the shipping method.
+	// Coupons lists the coupon codes attached to the cart, once per use.
+	// Promotions lists the promotions considered when the cart was submitted.
+	// OrderID references the order placed when the cart was submitted.
+	// History records the status transitions of the cart, oldest first.
 	Cart struct {
-		CartID     string
-		Purchases  map[item.Sku]Purchase
-		CartStatus Status
-		Total      int64
+		CartID        string
+		Purchases     map[item.Sku]Purchase
+		CartStatus    Status
+		Subtotal      int64
+		Discount      int64
+		OrderDiscount int64
+		Tax           int64
+		TaxInclusive  bool
+		ShippingCost  int64
+		FreeShipping  bool
+		Total         int64
+		Shipping      Shipping
+		Coupons       []string
+		Promotions    []PromotionCheck
+		OrderID       string
+		CancelReason  string
+		History       []Transition
 	}
 
 	// Purchase captures an item purchase in the cart, including discount applied.
-	// Price and Discount are expressed in integer cents (int64).
+	// Price, Discount and Tax are expressed in integer cents (int64); Tax is the tax of the
+	// line after discount, computed when the cart is submitted. OrderDiscount is the share of
+	// the cart OrderDiscount allocated to the line on submit, in proportion to its value after Discount.
+	// Price is the unit price the line sells at: ListPrice, the price of the item when it was first
+	// purchased, lowered by the price break the line quantity gets among PriceBreaks.
+	// Weight is the weight of a unit in grams.
+	// ReservedUntil is the deadline of the stock reserved for the line; zero means it is held until submit.
 	Purchase struct {
-		Sku      item.Sku
-		Name     string
-		Price    int64
-		Qty      int
-		Discount int64
+		Sku           item.Sku
+		Name          string
+		ListPrice     int64
+		PriceBreaks   tier.Breaks
+		Price         int64
+		Qty           int
+		Discount      int64
+		OrderDiscount int64
+		TaxCategory   item.TaxCategory
+		Category      item.Category
+		Tax           int64
+		Weight        int
+		ReservedUntil time.Time
+	}
+
+	// PromotionCheck records a promotion considered for the cart: an automatic promotion, identified by
+	// PromotionID, or the promotion of a Coupon code. Promotion is its kind. Applied tells whether it changed
+	// the cart; Skipped is the reason a promotion was not in effect, and so not applied.
+	PromotionCheck struct {
+		PromotionID string
+		Promotion   string
+		Coupon      string
+		Applied     bool
+		Skipped     string
+	}
+
+	// Shipping is how and where the cart is shipped. A zero Method means the cart is not shipped.
+	Shipping struct {
+		Method  shipping.Method
+		Address shipping.Address
 	}
 )
 
@@ -48,6 +106,12 @@ const (
 
 	// CartStatusSubmitted indicates the cart has been submitted and no longer accepts purchases.
 	CartStatusSubmitted = Status("Submitted")
+
+	// CartStatusExpired indicates every reservation of the cart lapsed and it no longer accepts purchases.
+	CartStatusExpired = Status("Expired")
+
+	// CartStatusCancelled indicates the cart, or the order placed from it, was cancelled.
+	CartStatusCancelled = Status("Cancelled")
 )
 
 // NewAvailableCart creates a new cart in Available status with an auto-generated ID.
@@ -64,24 +128,60 @@ func NewAvailableCart() (cart Cart) {
 
 // PurchaseItem updates the cart with a purchase for the given item and quantity.
 // Quantity may be negative to remove items; zero removes the item entry.
-func (c *Cart) PurchaseItem(i item.Item, qty int) (err error) {
+// The line is priced with the price break its new quantity gets among breaks, if any.
+func (c *Cart) PurchaseItem(i item.Item, qty int, breaks ...tier.Break) (err error) {
 
-	if c.CartStatus != CartStatusAvailable {
+	if !c.CartStatus.Editable() {
 		return ErrCartNotAvailable
 	}
 
+	if err := c.addPurchase(i, qty); err != nil {
+		return err
+	}
+
+	if p, ok := c.Purchases[i.Sku]; ok {
+		p.PriceBreaks = breaks
+		p.Price = p.PriceBreaks.UnitPrice(p.ListPrice, p.Qty)
+		c.Purchases[i.Sku] = p
+	}
+
+	return nil
+}
+
+// AddPromotionItem adds units of an item granted by a promotion while the cart is being submitted.
+// Unlike PurchaseItem, it also accepts a cart that is checking out.
+func (c *Cart) AddPromotionItem(i item.Item, qty int) (err error) {
+
+	if !CanTransition(c.CartStatus, CartStatusSubmitted) {
+		return ErrCartNotAvailable
+	}
+
+	return c.addPurchase(i, qty)
+}
+
+func (c *Cart) addPurchase(i item.Item, qty int) (err error) {
+
 	p, ok := c.Purchases[i.Sku]
 
 	if !ok {
 		p = Purchase{
-			Sku:      i.Sku,
-			Name:     i.Name,
-			Price:    i.Price,
-			Qty:      0,
-			Discount: 0,
+			Sku:         i.Sku,
+			Name:        i.Name,
+			ListPrice:   i.Price,
+			Price:       i.Price,
+			Qty:         0,
+			Discount:    0,
+			TaxCategory: i.TaxCategory,
+			Category:    i.Category,
+			Weight:      i.Weight,
 		}
 	}
 
+	// lines of carts stored before price breaks sell at their list price
+	if p.ListPrice == 0 {
+		p.ListPrice = p.Price
+	}
+
 	fQty := p.Qty + qty
 
 	switch {
@@ -101,6 +201,7 @@ func (c *Cart) PurchaseItem(i item.Item, qty int) (err error) {
 }
 
 // DiscountPurchase adds a discount to an existing purchase by SKU.
+// The discount of a purchase never exceeds its value, Price times Qty.
 func (c *Cart) DiscountPurchase(sku item.Sku, discount int64) (err error) {
 
 	p, ok := c.Purchases[sku]
@@ -109,28 +210,192 @@ func (c *Cart) DiscountPurchase(sku item.Sku, discount int64) (err error) {
 		return ErrItemNotInCart
 	}
 
-	p.Discount += discount
+	p.Discount = utils.SaturatingAddInt64(p.Discount, discount)
+
+	if gross := utils.SaturatingMulInt64Int(p.Price, p.Qty); p.Discount > gross {
+		p.Discount = gross
+	}
 
 	c.Purchases[sku] = p
 
 	return nil
 }
 
-// SubmitCart finalizes the cart total and moves it to Submitted status.
-func (c *Cart) SubmitCart() (err error) {
+// DiscountOrder adds a discount to the cart as a whole. The discount of the cart never exceeds
+// the value of its purchases after their own discounts; a negative discount is ignored.
+func (c *Cart) DiscountOrder(discount int64) {
+	if discount > 0 {
+		c.OrderDiscount = min(utils.SaturatingAddInt64(c.OrderDiscount, discount), c.Merchandise())
+	}
+}
+
+// WaiveShipping makes the shipping of the cart free.
+func (c *Cart) WaiveShipping() {
+	c.FreeShipping = true
+}
 
-	if c.CartStatus != CartStatusAvailable {
+// Merchandise returns the value of the purchases after their discounts, before OrderDiscount.
+func (c Cart) Merchandise() (value int64) {
+	for _, p := range c.Purchases {
+		value = utils.SaturatingAddInt64(value, utils.SaturatingSubInt64(utils.SaturatingMulInt64Int(p.Price, p.Qty), p.Discount))
+	}
+	return value
+}
 
+// HoldPurchase sets the deadline of the stock reserved for a purchase.
+func (c *Cart) HoldPurchase(sku item.Sku, until time.Time) (err error) {
+
+	p, ok := c.Purchases[sku]
+
+	if !ok {
+		return ErrItemNotInCart
+	}
+
+	p.ReservedUntil = until
+
+	c.Purchases[sku] = p
+
+	return nil
+}
+
+// ShipWith chooses how and where the cart is shipped, while it still holds its purchases.
+// The shipping cost is computed when the cart is submitted.
+func (c *Cart) ShipWith(m shipping.Method, to shipping.Address) (err error) {
+
+	if !c.CartStatus.Holding() {
 		return ErrCartNotAvailable
 	}
 
-	for _, p := range c.Purchases {
-		line := utils.SaturatingMulInt64Int(p.Price, p.Qty)
-		line = utils.SaturatingSubInt64(line, p.Discount)
-		c.Total = utils.SaturatingAddInt64(c.Total, line)
+	if err := to.Validate(); err != nil {
+		return err
+	}
+
+	c.Shipping = Shipping{Method: m, Address: to}
+
+	return nil
+}
+
+// AttachCoupon records one use of a coupon code by the cart, while it still holds its purchases.
+// Whether the coupon can be used is up to the caller.
+func (c *Cart) AttachCoupon(code string) (err error) {
+
+	if !c.CartStatus.Holding() {
+		return ErrCartNotAvailable
+	}
+
+	c.Coupons = append(c.Coupons, code)
+
+	return nil
+}
+
+// CouponUses returns how many times a coupon code is attached to the cart.
+func (c Cart) CouponUses(code string) (uses int) {
+	for _, attached := range c.Coupons {
+		if attached == code {
+			uses++
+		}
+	}
+	return uses
+}
+
+// ExpirePurchases removes the purchases whose reservation deadline is not after now
+// and returns them ordered by SKU, so the caller can release their stock.
+// A cart left without purchases moves to Expired status.
+func (c *Cart) ExpirePurchases(now time.Time) (expired []Purchase, err error) {
+
+	if !c.CartStatus.Holding() {
+		return nil, ErrCartNotAvailable
+	}
+
+	for sku, p := range c.Purchases {
+		if p.ReservedUntil.IsZero() || p.ReservedUntil.After(now) {
+			continue
+		}
+		expired = append(expired, p)
+		delete(c.Purchases, sku)
+	}
+
+	sort.Slice(expired, func(i, j int) bool { return expired[i].Sku < expired[j].Sku })
+
+	if len(expired) > 0 && len(c.Purchases) == 0 {
+		if err := c.Transition(CartStatusExpired, now); err != nil {
+			return nil, err
+		}
+	}
+
+	return expired, nil
+}
+
+// SubmitCart finalizes the cart totals, taxing every purchase with the rates of taxes and
+// pricing the chosen shipping, and moves it to Submitted status at the given time.
+// OrderDiscount is shared among the purchases, lowering their tax.
+func (c *Cart) SubmitCart(at time.Time, taxes tax.Table) (err error) {
+
+	if err := c.Transition(CartStatusSubmitted, at); err != nil {
+		return err
+	}
+
+	c.Subtotal, c.Discount, c.Tax, c.ShippingCost = 0, 0, 0, 0
+	c.TaxInclusive = taxes.Inclusive
+	c.OrderDiscount = min(c.OrderDiscount, c.Merchandise())
+
+	// shares of the order discount are allocated in SKU order so they do not depend on map iteration
+	skus := make([]item.Sku, 0, len(c.Purchases))
+	for sku := range c.Purchases {
+		skus = append(skus, sku)
+	}
+	sort.Slice(skus, func(i, j int) bool { return skus[i] < skus[j] })
+
+	nets := make([]int64, len(skus))
+	for i, sku := range skus {
+		p := c.Purchases[sku]
+		nets[i] = utils.SaturatingSubInt64(utils.SaturatingMulInt64Int(p.Price, p.Qty), p.Discount)
+	}
+	shares := utils.AllocateInt64(c.OrderDiscount, nets)
+
+	var weight int64
+
+	for i, sku := range skus {
+		p := c.Purchases[sku]
+		gross := utils.SaturatingMulInt64Int(p.Price, p.Qty)
+		weight = utils.SaturatingAddInt64(weight, utils.SaturatingMulInt64Int(int64(p.Weight), p.Qty))
+
+		p.OrderDiscount = shares[i]
+		p.Tax = taxes.Tax(p.TaxCategory, utils.SaturatingSubInt64(nets[i], p.OrderDiscount))
+		c.Purchases[sku] = p
+
+		c.Subtotal = utils.SaturatingAddInt64(c.Subtotal, gross)
+		c.Discount = utils.SaturatingAddInt64(c.Discount, p.Discount)
+		c.Tax = utils.SaturatingAddInt64(c.Tax, p.Tax)
 	}
 
-	c.CartStatus = CartStatusSubmitted
+	c.Total = utils.SaturatingSubInt64(utils.SaturatingSubInt64(c.Subtotal, c.Discount), c.OrderDiscount)
+
+	if c.Shipping.Method.Code != "" && !c.FreeShipping {
+		c.ShippingCost = c.Shipping.Method.Price(c.Total, weight)
+	}
+
+	if !c.TaxInclusive {
+		c.Total = utils.SaturatingAddInt64(c.Total, c.Tax)
+	}
+
+	c.Total = utils.SaturatingAddInt64(c.Total, c.ShippingCost)
+
+	return nil
+}
+
+// Cancel moves the cart to Cancelled status at the given time, recording why.
+// Releasing the reservations of a cart that was not submitted, or restoring the
+// stock of the order placed from it, is up to the caller.
+func (c *Cart) Cancel(reason string, at time.Time) (err error) {
+
+	previous := c.CancelReason
+	c.CancelReason = reason
+
+	if err := c.Transition(CartStatusCancelled, at); err != nil {
+		c.CancelReason = previous
+		return err
+	}
 
 	return nil
 }
@@ -1,9 +1,14 @@
 package cart
 
 import (
+	"errors"
 	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/shipping"
+	"github.com/gambarini/flip-shop/internal/model/tax"
+	"github.com/gambarini/flip-shop/internal/model/tier"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestCart_PurchaseItem(t *testing.T) {
@@ -80,3 +85,305 @@ func TestCart_PurchaseItem(t *testing.T) {
 		})
 	}
 }
+
+func TestCart_PurchaseItemPriceBreaks(t *testing.T) {
+	breaks := []tier.Break{{MinQty: 10, Percentage: 0.05}, {MinQty: 50, Percentage: 0.12}}
+	speaker := item.Item{Sku: "A", Name: "Speaker", Price: 4999}
+
+	c := Cart{CartID: "CartID", CartStatus: CartStatusAvailable, Purchases: map[item.Sku]Purchase{}}
+
+	steps := []struct {
+		name      string
+		qty       int
+		wantPrice int64
+	}{
+		{"below every break", 9, 4999},
+		{"reaching a break reprices every unit", 1, 4750},
+		{"the highest break", 40, 4400},
+		{"removing units drops the break", -45, 4999},
+	}
+	for _, st := range steps {
+		if err := c.PurchaseItem(speaker, st.qty, breaks...); err != nil {
+			t.Fatalf("%s: PurchaseItem() error = %v", st.name, err)
+		}
+		if p := c.Purchases["A"]; p.Price != st.wantPrice || p.ListPrice != 4999 {
+			t.Fatalf("%s: Price = %d, ListPrice = %d, want %d, 4999", st.name, p.Price, p.ListPrice, st.wantPrice)
+		}
+	}
+
+	// the line keeps the list price of its first purchase
+	speaker.Price = 5999
+	if err := c.PurchaseItem(speaker, 5, breaks...); err != nil || c.Purchases["A"].Price != 4750 {
+		t.Fatalf("PurchaseItem() = %v, Price = %d, want 4750", err, c.Purchases["A"].Price)
+	}
+
+	// a line stored before price breaks is listed at its price
+	c.Purchases["B"] = Purchase{Sku: "B", Price: 1000, Qty: 3}
+	if err := c.PurchaseItem(item.Item{Sku: "B", Price: 1200}, 7, breaks...); err != nil || c.Purchases["B"].Price != 950 {
+		t.Fatalf("PurchaseItem() = %v, Price = %d, want 950", err, c.Purchases["B"].Price)
+	}
+}
+
+func TestCart_SubmitCartTax(t *testing.T) {
+	rates := map[item.TaxCategory]tax.Rate{item.TaxCategoryStandard: 100000, "food": 50000}
+
+	tests := []struct {
+		name      string
+		taxes     tax.Table
+		wantTaxes map[item.Sku]int64
+		wantTax   int64
+		wantTotal int64
+	}{
+		{"no tax", tax.Table{}, map[item.Sku]int64{"A": 0, "B": 0}, 0, 3499},
+		{"exclusive", tax.Table{Rates: rates}, map[item.Sku]int64{"A": 250, "B": 50}, 300, 3799},
+		{"inclusive", tax.Table{Inclusive: true, Rates: rates}, map[item.Sku]int64{"A": 227, "B": 48}, 275, 3499},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Cart{
+				CartID:     "CartID",
+				CartStatus: CartStatusAvailable,
+				Purchases: map[item.Sku]Purchase{
+					// no category is taxed at the standard rate
+					"A": {Sku: "A", Price: 1000, Qty: 3, Discount: 500},
+					"B": {Sku: "B", Price: 999, Qty: 1, TaxCategory: "food"},
+				},
+			}
+
+			if err := c.SubmitCart(time.Time{}, tt.taxes); err != nil {
+				t.Fatalf("SubmitCart() error = %v", err)
+			}
+
+			for sku, want := range tt.wantTaxes {
+				if got := c.Purchases[sku].Tax; got != want {
+					t.Errorf("%s Tax = %d, want %d", sku, got, want)
+				}
+			}
+			if c.Subtotal != 3999 || c.Discount != 500 || c.Tax != tt.wantTax || c.Total != tt.wantTotal || c.TaxInclusive != tt.taxes.Inclusive {
+				t.Errorf("totals = %d - %d, tax %d (inclusive %v), total %d; want 3999 - 500, tax %d, total %d",
+					c.Subtotal, c.Discount, c.Tax, c.TaxInclusive, c.Total, tt.wantTax, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestCart_SubmitCartOrderDiscount(t *testing.T) {
+	rates := map[item.TaxCategory]tax.Rate{item.TaxCategoryStandard: 100000, "food": 50000}
+	flat := shipping.Method{Code: "flat", Type: shipping.TypeFlat, Cost: 900}
+
+	tests := []struct {
+		name       string
+		discount   int64
+		free       bool
+		wantShares map[item.Sku]int64
+		wantTax    int64
+		wantTotal  int64
+	}{
+		// A is worth 2500 after its discount and B 999: the left over cent goes to B
+		{"shared by line value", 700, false, map[item.Sku]int64{"A": 500, "B": 200}, 240, 3499 - 700 + 240 + 900},
+		{"capped at the merchandise", 99999, false, map[item.Sku]int64{"A": 2500, "B": 999}, 0, 900},
+		{"free shipping", 0, true, map[item.Sku]int64{"A": 0, "B": 0}, 300, 3499 + 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Cart{
+				CartID:     "CartID",
+				CartStatus: CartStatusAvailable,
+				Purchases: map[item.Sku]Purchase{
+					"A": {Sku: "A", Price: 1000, Qty: 3, Discount: 500},
+					"B": {Sku: "B", Price: 999, Qty: 1, TaxCategory: "food"},
+				},
+				Shipping: Shipping{Method: flat},
+			}
+			c.DiscountOrder(tt.discount)
+			if tt.free {
+				c.WaiveShipping()
+			}
+
+			if err := c.SubmitCart(time.Time{}, tax.Table{Rates: rates}); err != nil {
+				t.Fatalf("SubmitCart() error = %v", err)
+			}
+
+			for sku, want := range tt.wantShares {
+				if got := c.Purchases[sku].OrderDiscount; got != want {
+					t.Errorf("%s OrderDiscount = %d, want %d", sku, got, want)
+				}
+			}
+			if c.Discount != 500 || c.Tax != tt.wantTax || c.Total != tt.wantTotal {
+				t.Errorf("discount %d, order discount %d, tax %d, total %d; want 500, tax %d, total %d",
+					c.Discount, c.OrderDiscount, c.Tax, c.Total, tt.wantTax, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestCart_DiscountPurchase(t *testing.T) {
+	tests := []struct {
+		name      string
+		discounts []int64
+		want      int64
+	}{
+		{"within the line value", []int64{500, 1000}, 1500},
+		{"capped at the line value", []int64{2500, 2500}, 3000},
+		{"a single discount beyond the line value", []int64{9999}, 3000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Cart{CartID: "CartID", Purchases: map[item.Sku]Purchase{"A": {Sku: "A", Price: 1000, Qty: 3}}}
+			for _, d := range tt.discounts {
+				if err := c.DiscountPurchase("A", d); err != nil {
+					t.Fatalf("DiscountPurchase() error = %v", err)
+				}
+			}
+			if got := c.Purchases["A"].Discount; got != tt.want {
+				t.Fatalf("Discount = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	c := Cart{Purchases: map[item.Sku]Purchase{}}
+	if err := c.DiscountPurchase("A", 1); err != ErrItemNotInCart {
+		t.Fatalf("DiscountPurchase() missing line error = %v, want %v", err, ErrItemNotInCart)
+	}
+}
+
+func TestCart_ShipWith(t *testing.T) {
+	to := shipping.Address{Line1: "1 Main St", City: "Sydney", PostalCode: "2000", Country: "AU"}
+	byWeight := shipping.Method{Code: "standard", Type: shipping.TypeWeight, Cost: 500, PerKg: 150}
+	freeOver := shipping.Method{Code: "saver", Type: shipping.TypeFreeOver, Cost: 800, Threshold: 3000}
+
+	newCart := func() Cart {
+		c := Cart{CartID: "CartID", CartStatus: CartStatusAvailable, Purchases: map[item.Sku]Purchase{}}
+		// 3 units of 700g weigh 2.1kg
+		if err := c.PurchaseItem(item.Item{Sku: "A", Price: 1000, Weight: 700}, 3); err != nil {
+			t.Fatalf("PurchaseItem() error = %v", err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name      string
+		method    shipping.Method
+		discount  int64
+		taxes     tax.Table
+		wantCost  int64
+		wantTotal int64
+	}{
+		{"not shipped", shipping.Method{}, 0, tax.Table{}, 0, 3000},
+		{"by weight", byWeight, 0, tax.Table{}, 950, 3950},
+		{"free over threshold", freeOver, 0, tax.Table{}, 0, 3000},
+		{"discount below threshold", freeOver, 1, tax.Table{}, 800, 3799},
+		{"shipping is not taxed", byWeight, 0, tax.Table{Rates: map[item.TaxCategory]tax.Rate{item.TaxCategoryStandard: 100000}}, 950, 4250},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCart()
+			if tt.method.Code != "" {
+				if err := c.ShipWith(tt.method, to); err != nil {
+					t.Fatalf("ShipWith() error = %v", err)
+				}
+			}
+			_ = c.DiscountPurchase("A", tt.discount)
+
+			if err := c.SubmitCart(time.Time{}, tt.taxes); err != nil {
+				t.Fatalf("SubmitCart() error = %v", err)
+			}
+			if c.ShippingCost != tt.wantCost || c.Total != tt.wantTotal {
+				t.Errorf("ShippingCost = %d, Total = %d, want %d, %d", c.ShippingCost, c.Total, tt.wantCost, tt.wantTotal)
+			}
+		})
+	}
+
+	c := newCart()
+	if err := c.ShipWith(byWeight, shipping.Address{City: "Sydney"}); !errors.Is(err, shipping.ErrInvalidAddress) {
+		t.Fatalf("ShipWith() invalid address error = %v, want %v", err, shipping.ErrInvalidAddress)
+	}
+	c.CartStatus = CartStatusSubmitted
+	if err := c.ShipWith(byWeight, to); err != ErrCartNotAvailable {
+		t.Fatalf("ShipWith() submitted error = %v, want %v", err, ErrCartNotAvailable)
+	}
+}
+
+func TestCart_ExpirePurchases(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	line := func(sku string, until time.Time) Purchase {
+		return Purchase{Sku: item.Sku(sku), Name: sku, Price: 1000, Qty: 1, ReservedUntil: until}
+	}
+	newCart := func(s Status, lines ...Purchase) Cart {
+		c := Cart{CartID: "CartID", Purchases: map[item.Sku]Purchase{}, CartStatus: s}
+		for _, p := range lines {
+			c.Purchases[p.Sku] = p
+		}
+		return c
+	}
+
+	tests := []struct {
+		name        string
+		cart        Cart
+		wantExpired []item.Sku
+		wantLeft    int
+		wantStatus  Status
+		wantErr     bool
+	}{
+		{"nothing lapsed", newCart(CartStatusAvailable, line("A", now.Add(time.Minute))), nil, 1, CartStatusAvailable, false},
+		{"no deadline is held", newCart(CartStatusAvailable, line("A", time.Time{})), nil, 1, CartStatusAvailable, false},
+		{"some lines lapsed", newCart(CartStatusAvailable, line("B", now), line("A", now.Add(-time.Second)), line("C", now.Add(time.Second))), []item.Sku{"A", "B"}, 1, CartStatusAvailable, false},
+		{"all lines lapsed", newCart(CartStatusAvailable, line("A", now.Add(-time.Minute))), []item.Sku{"A"}, 0, CartStatusExpired, false},
+		{"empty cart stays available", newCart(CartStatusAvailable), nil, 0, CartStatusAvailable, false},
+		{"submitted cart", newCart(CartStatusSubmitted, line("A", now.Add(-time.Minute))), nil, 1, CartStatusSubmitted, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expired, err := tt.cart.ExpirePurchases(now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExpirePurchases() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var gotSkus []item.Sku
+			for _, p := range expired {
+				gotSkus = append(gotSkus, p.Sku)
+			}
+			if !reflect.DeepEqual(gotSkus, tt.wantExpired) {
+				t.Errorf("expired = %v, want %v", gotSkus, tt.wantExpired)
+			}
+			if len(tt.cart.Purchases) != tt.wantLeft {
+				t.Errorf("purchases left = %d, want %d", len(tt.cart.Purchases), tt.wantLeft)
+			}
+			if tt.cart.CartStatus != tt.wantStatus {
+				t.Errorf("status = %s, want %s", tt.cart.CartStatus, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestCart_Cancel(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     Status
+		reason     string
+		wantStatus Status
+		wantErr    error
+	}{
+		{"Available", CartStatusAvailable, "changed mind", CartStatusCancelled, nil},
+		{"Submitted", CartStatusSubmitted, "changed mind", CartStatusCancelled, nil},
+		{"Expired", CartStatusExpired, "changed mind", CartStatusExpired, ErrInvalidTransition},
+		{"Already cancelled", CartStatusCancelled, "again", CartStatusCancelled, ErrInvalidTransition},
+		{"Missing reason", CartStatusAvailable, "", CartStatusAvailable, ErrCancelReasonRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Cart{CartID: "CartID", Purchases: make(map[item.Sku]Purchase), CartStatus: tt.status}
+
+			if err := c.Cancel(tt.reason, time.Time{}); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Cancel() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if c.CartStatus != tt.wantStatus {
+				t.Errorf("Cancel() CartStatus = %v, want %v", c.CartStatus, tt.wantStatus)
+			}
+
+			if tt.wantErr == nil && c.CancelReason != tt.reason {
+				t.Errorf("Cancel() CancelReason = %q, want %q", c.CancelReason, tt.reason)
+			}
+		})
+	}
+}
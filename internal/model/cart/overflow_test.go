@@ -3,8 +3,10 @@ package cart
 import (
 	"math"
 	"testing"
+	"time"
 
 	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/tax"
 )
 
 // Test that SubmitCart guards against integer overflow and saturates at MaxInt64
@@ -18,7 +20,7 @@ func TestSubmitCart_OverflowGuard(t *testing.T) {
 	p := Purchase{Sku: item.Sku("BIG"), Name: "Big", Price: math.MaxInt64 / 2, Qty: 3, Discount: 0}
 	c.Purchases[p.Sku] = p
 
-	if err := c.SubmitCart(); err != nil {
+	if err := c.SubmitCart(time.Time{}, tax.Table{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	if c.Total != math.MaxInt64 {
@@ -0,0 +1,159 @@
+package cart
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidTransition is returned when the transition table does not allow a status change.
+	ErrInvalidTransition = errors.New("invalid cart status transition")
+	// ErrUnknownStatus is returned when parsing a name that is not a cart status.
+	ErrUnknownStatus = errors.New("unknown cart status")
+	// ErrCartEmpty is returned when a transition requires purchases and the cart has none.
+	ErrCartEmpty = errors.New("cart has no purchases")
+	// ErrCartNotEmpty is returned when a transition requires the cart to have no purchases left.
+	ErrCartNotEmpty = errors.New("cart still has purchases")
+	// ErrCartHasNoOrder is returned when a transition requires the order placed from the cart.
+	ErrCartHasNoOrder = errors.New("cart has no order")
+)
+
+type (
+	// Transition is an entry of the cart history: a status change and when it happened.
+	Transition struct {
+		From Status
+		To   Status
+		At   time.Time
+	}
+
+	// Guard checks that a cart meets the conditions of a transition.
+	Guard func(c Cart) error
+)
+
+const (
+	// CartStatusCheckingOut indicates the customer is checking out: purchases are frozen until
+	// the cart is submitted or goes back to Available.
+	CartStatusCheckingOut = Status("CheckingOut")
+
+	// CartStatusPaid indicates the order placed from the cart was paid.
+	CartStatusPaid = Status("Paid")
+
+	// CartStatusFulfilled indicates the order placed from the cart was delivered.
+	CartStatusFulfilled = Status("Fulfilled")
+)
+
+// Statuses lists every cart status.
+var Statuses = []Status{
+	CartStatusAvailable,
+	CartStatusCheckingOut,
+	CartStatusSubmitted,
+	CartStatusPaid,
+	CartStatusFulfilled,
+	CartStatusCancelled,
+	CartStatusExpired,
+}
+
+// transitions is the transition table: for each status, the statuses it can move to
+// and the guard the cart must pass to do so (nil when there is none).
+// Statuses without an entry are final.
+var transitions = map[Status]map[Status]Guard{
+	CartStatusAvailable: {
+		CartStatusCheckingOut: hasPurchases,
+		CartStatusSubmitted:   nil,
+		CartStatusCancelled:   hasCancelReason,
+		CartStatusExpired:     hasNoPurchases,
+	},
+	CartStatusCheckingOut: {
+		CartStatusAvailable: nil,
+		CartStatusSubmitted: nil,
+		CartStatusCancelled: hasCancelReason,
+		CartStatusExpired:   hasNoPurchases,
+	},
+	CartStatusSubmitted: {
+		CartStatusPaid:      hasOrder,
+		CartStatusCancelled: hasCancelReason,
+	},
+	CartStatusPaid: {
+		CartStatusFulfilled: nil,
+		CartStatusCancelled: hasCancelReason,
+	},
+}
+
+// ParseStatus returns the status named s, ignoring case and dashes (e.g. "checking-out").
+func ParseStatus(s string) (Status, error) {
+	name := strings.ReplaceAll(s, "-", "")
+	for _, st := range Statuses {
+		if strings.EqualFold(string(st), name) {
+			return st, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownStatus, s)
+}
+
+// CanTransition reports whether the transition table allows moving from one status to another.
+func CanTransition(from, to Status) bool {
+	_, ok := transitions[from][to]
+	return ok
+}
+
+// Editable reports whether the purchases of a cart in this status can change.
+func (s Status) Editable() bool {
+	return s == CartStatusAvailable
+}
+
+// Holding reports whether a cart in this status holds reserved stock that can lapse.
+func (s Status) Holding() bool {
+	return s == CartStatusAvailable || s == CartStatusCheckingOut
+}
+
+// Transition moves the cart to status to, as allowed by the transition table and its guard,
+// and records the change in the cart history.
+func (c *Cart) Transition(to Status, at time.Time) (err error) {
+
+	guard, ok := transitions[c.CartStatus][to]
+
+	if !ok {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, c.CartStatus, to)
+	}
+
+	if guard != nil {
+		if err := guard(*c); err != nil {
+			return err
+		}
+	}
+
+	c.History = append(c.History, Transition{From: c.CartStatus, To: to, At: at})
+	c.CartStatus = to
+
+	return nil
+}
+
+func hasPurchases(c Cart) error {
+	if len(c.Purchases) == 0 {
+		return ErrCartEmpty
+	}
+	return nil
+}
+
+func hasNoPurchases(c Cart) error {
+	if len(c.Purchases) > 0 {
+		return ErrCartNotEmpty
+	}
+	return nil
+}
+
+func hasCancelReason(c Cart) error {
+	if c.CancelReason == "" {
+		return ErrCancelReasonRequired
+	}
+	return nil
+}
+
+func hasOrder(c Cart) error {
+	if c.OrderID == "" {
+		return ErrCartHasNoOrder
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package cart
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/tax"
+)
+
+func TestCart_Transition(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	withPurchase := map[item.Sku]Purchase{"A": {Sku: "A", Qty: 1}}
+
+	tests := []struct {
+		name      string
+		cart      Cart
+		to        Status
+		wantErr   error
+		wantFinal Status
+	}{
+		{"check out", Cart{CartStatus: CartStatusAvailable, Purchases: withPurchase}, CartStatusCheckingOut, nil, CartStatusCheckingOut},
+		{"check out empty cart", Cart{CartStatus: CartStatusAvailable}, CartStatusCheckingOut, ErrCartEmpty, CartStatusAvailable},
+		{"back to available", Cart{CartStatus: CartStatusCheckingOut, Purchases: withPurchase}, CartStatusAvailable, nil, CartStatusAvailable},
+		{"submit from checkout", Cart{CartStatus: CartStatusCheckingOut, Purchases: withPurchase}, CartStatusSubmitted, nil, CartStatusSubmitted},
+		{"pay", Cart{CartStatus: CartStatusSubmitted, OrderID: "O"}, CartStatusPaid, nil, CartStatusPaid},
+		{"pay without order", Cart{CartStatus: CartStatusSubmitted}, CartStatusPaid, ErrCartHasNoOrder, CartStatusSubmitted},
+		{"fulfil", Cart{CartStatus: CartStatusPaid, OrderID: "O"}, CartStatusFulfilled, nil, CartStatusFulfilled},
+		{"fulfil unpaid", Cart{CartStatus: CartStatusSubmitted, OrderID: "O"}, CartStatusFulfilled, ErrInvalidTransition, CartStatusSubmitted},
+		{"expire with purchases", Cart{CartStatus: CartStatusAvailable, Purchases: withPurchase}, CartStatusExpired, ErrCartNotEmpty, CartStatusAvailable},
+		{"cancel without reason", Cart{CartStatus: CartStatusPaid}, CartStatusCancelled, ErrCancelReasonRequired, CartStatusPaid},
+		{"purchase after submit", Cart{CartStatus: CartStatusSubmitted}, CartStatusAvailable, ErrInvalidTransition, CartStatusSubmitted},
+		{"fulfilled is final", Cart{CartStatus: CartStatusFulfilled}, CartStatusCancelled, ErrInvalidTransition, CartStatusFulfilled},
+		{"expired is final", Cart{CartStatus: CartStatusExpired}, CartStatusAvailable, ErrInvalidTransition, CartStatusExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.cart
+			from := c.CartStatus
+
+			err := c.Transition(tt.to, at)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+				t.Fatalf("Transition() error = %v, want %v", err, tt.wantErr)
+			}
+			if c.CartStatus != tt.wantFinal {
+				t.Errorf("CartStatus = %v, want %v", c.CartStatus, tt.wantFinal)
+			}
+
+			var wantHistory []Transition
+			if err == nil {
+				wantHistory = []Transition{{From: from, To: tt.to, At: at}}
+			}
+			if !reflect.DeepEqual(c.History, wantHistory) {
+				t.Errorf("History = %+v, want %+v", c.History, wantHistory)
+			}
+		})
+	}
+}
+
+func TestCart_SubmitAndPromotionItems(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	i := item.Item{Sku: "A", Name: "A", Price: 100}
+
+	c := NewAvailableCart()
+	if err := c.PurchaseItem(i, 2); err != nil {
+		t.Fatalf("PurchaseItem() error = %v", err)
+	}
+	if err := c.Transition(CartStatusCheckingOut, at); err != nil {
+		t.Fatalf("Transition() error = %v", err)
+	}
+
+	// purchases are frozen while checking out, promotions can still add items
+	if err := c.PurchaseItem(i, 1); err != ErrCartNotAvailable {
+		t.Fatalf("PurchaseItem() while checking out error = %v, want %v", err, ErrCartNotAvailable)
+	}
+	if err := c.AddPromotionItem(i, 1); err != nil {
+		t.Fatalf("AddPromotionItem() error = %v", err)
+	}
+
+	if err := c.SubmitCart(at.Add(time.Minute), tax.Table{}); err != nil {
+		t.Fatalf("SubmitCart() error = %v", err)
+	}
+	if c.Total != 300 || len(c.History) != 2 || c.History[1] != (Transition{From: CartStatusCheckingOut, To: CartStatusSubmitted, At: at.Add(time.Minute)}) {
+		t.Fatalf("unexpected submitted cart: %+v", c)
+	}
+
+	if err := c.AddPromotionItem(i, 1); err != ErrCartNotAvailable {
+		t.Fatalf("AddPromotionItem() after submit error = %v, want %v", err, ErrCartNotAvailable)
+	}
+	if err := c.SubmitCart(at, tax.Table{}); !errors.Is(err, ErrInvalidTransition) || c.Total != 300 {
+		t.Fatalf("second SubmitCart() error = %v, total %d", err, c.Total)
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Status
+		wantErr bool
+	}{
+		{"submitted", CartStatusSubmitted, false},
+		{"Cancelled", CartStatusCancelled, false},
+		{"checking-out", CartStatusCheckingOut, false},
+		{"checkingout", CartStatusCheckingOut, false},
+		{"shipped", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseStatus(tt.in)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Fatalf("ParseStatus(%q) = %q (err %v), want %q", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package coupon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/promotion"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrInvalidCoupon is returned when loading a coupon with missing or out of range fields.
+	ErrInvalidCoupon = errors.New("invalid coupon")
+)
+
+type (
+	// Definition is the declarative form of a coupon, as written in a coupons file.
+	// Promotion is the promotion the code gives access to, in the form of a promotions file; the coupon
+	// decides when it applies, so the promotion must not have a validity window, schedule, priority,
+	// group or stop of its own. A missing ValidFrom or ValidUntil leaves that side of the window open.
+	Definition struct {
+		Code           string               `json:"code" yaml:"code"`
+		Promotion      promotion.Definition `json:"promotion" yaml:"promotion"`
+		ValidFrom      *time.Time           `json:"valid_from,omitempty" yaml:"valid_from,omitempty"`
+		ValidUntil     *time.Time           `json:"valid_until,omitempty" yaml:"valid_until,omitempty"`
+		MaxRedemptions int64                `json:"max_redemptions,omitempty" yaml:"max_redemptions,omitempty"`
+		PerCartLimit   int                  `json:"per_cart_limit,omitempty" yaml:"per_cart_limit,omitempty"`
+	}
+)
+
+// Build validates a Definition and builds its Coupon.
+func (d Definition) Build() (Coupon, error) {
+
+	p := d.Promotion
+
+	switch {
+	case NormalizeCode(d.Code) == "":
+		return Coupon{}, fmt.Errorf("%w: code is required", ErrInvalidCoupon)
+	case d.MaxRedemptions < 0:
+		return Coupon{}, fmt.Errorf("%w: max_redemptions must not be negative", ErrInvalidCoupon)
+	case d.PerCartLimit < 0:
+		return Coupon{}, fmt.Errorf("%w: per_cart_limit must not be negative", ErrInvalidCoupon)
+	case d.ValidFrom != nil && d.ValidUntil != nil && !d.ValidUntil.After(*d.ValidFrom):
+		return Coupon{}, fmt.Errorf("%w: valid_until must be after valid_from", ErrInvalidCoupon)
+	case p.ValidFrom != nil || p.ValidUntil != nil || p.Schedule != nil || p.Priority != 0 || p.Group != "" || p.Stop:
+		return Coupon{}, fmt.Errorf("%w: the promotion of a coupon applies when the coupon does", ErrInvalidCoupon)
+	}
+
+	promo, err := p.Build()
+
+	if err != nil {
+		return Coupon{}, err
+	}
+
+	c := Coupon{Code: d.Code, Promotion: promo, MaxRedemptions: d.MaxRedemptions, PerCartLimit: d.PerCartLimit}
+
+	if d.ValidFrom != nil {
+		c.ValidFrom = *d.ValidFrom
+	}
+	if d.ValidUntil != nil {
+		c.ValidUntil = *d.ValidUntil
+	}
+
+	return c, nil
+}
+
+// Build validates definitions and indexes their coupons by code. When exists is not nil, every item
+// the promotion of a coupon refers to must exist. Errors name the position and code of the failing definition.
+func Build(defs []Definition, exists promotion.SkuExists) (Catalog, error) {
+
+	coupons := make([]Coupon, 0, len(defs))
+	seen := make(map[string]bool, len(defs))
+
+	for i, d := range defs {
+
+		c, err := d.Build()
+
+		if err == nil && seen[NormalizeCode(d.Code)] {
+			err = fmt.Errorf("%w: code is already used", ErrInvalidCoupon)
+		}
+
+		if err == nil && exists != nil {
+			for _, sku := range d.Promotion.Skus() {
+				if !exists(sku) {
+					err = fmt.Errorf("%w: %q", promotion.ErrUnknownSku, sku)
+					break
+				}
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("coupon %d (%s): %w", i, d.Code, err)
+		}
+
+		seen[NormalizeCode(d.Code)] = true
+		coupons = append(coupons, c)
+	}
+
+	return NewCatalog(coupons...), nil
+}
+
+// Load decodes a list of coupon definitions in the given format and builds their catalog:
+//
+//	[{"code": "HOME10", "promotion": {"type": "qty_percentage", "sku": "120P90", "percentage": 0.1},
+//	  "max_redemptions": 100}]
+func Load(r io.Reader, format promotion.Format, exists promotion.SkuExists) (Catalog, error) {
+
+	var defs []Definition
+
+	switch format {
+	case promotion.FormatJSON:
+		dec := json.NewDecoder(r)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&defs); err != nil {
+			return nil, fmt.Errorf("invalid coupons: %w", err)
+		}
+	case promotion.FormatYAML:
+		dec := yaml.NewDecoder(r)
+		dec.KnownFields(true)
+		if err := dec.Decode(&defs); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("invalid coupons: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown coupons format %q", format)
+	}
+
+	return Build(defs, exists)
+}
+
+// LoadFile reads the coupons file at path, in YAML when its extension is .yaml or .yml and in JSON otherwise.
+func LoadFile(path string, exists promotion.SkuExists) (Catalog, error) {
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	format := promotion.FormatJSON
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		format = promotion.FormatYAML
+	}
+
+	return Load(f, format, exists)
+}
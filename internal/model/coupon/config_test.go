@@ -0,0 +1,72 @@
+package coupon
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/promotion"
+)
+
+func TestLoad(t *testing.T) {
+	exists := func(sku item.Sku) bool { return sku == "A" || sku == "B" }
+
+	from := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+
+	want := NewCatalog(
+		Coupon{Code: "SAVE10", Promotion: promotion.ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: "A", PercentageDiscount: 0.1}, MaxRedemptions: 100},
+		Coupon{Code: "NOV", Promotion: promotion.SpendAmountOffPromotion{Amount: 500}, ValidFrom: from, ValidUntil: until, PerCartLimit: 2},
+	)
+
+	tests := []struct {
+		name    string
+		format  promotion.Format
+		input   string
+		want    Catalog
+		wantErr error
+	}{
+		{"json", promotion.FormatJSON, `[
+			{"code": "save10", "promotion": {"type": "qty_percentage", "sku": "A", "percentage": 0.1}, "max_redemptions": 100},
+			{"code": "NOV", "promotion": {"type": "spend_amount_off", "amount": 500},
+			 "valid_from": "2024-11-01T00:00:00Z", "valid_until": "2024-12-01T00:00:00Z", "per_cart_limit": 2}]`, want, nil},
+		{"yaml", promotion.FormatYAML, `
+- code: save10
+  promotion: {type: qty_percentage, sku: A, percentage: 0.1}
+  max_redemptions: 100
+- code: NOV
+  promotion: {type: spend_amount_off, amount: 500}
+  valid_from: 2024-11-01T00:00:00Z
+  valid_until: 2024-12-01T00:00:00Z
+  per_cart_limit: 2
+`, want, nil},
+		{"empty yaml", promotion.FormatYAML, "", Catalog{}, nil},
+		{"missing code", promotion.FormatJSON, `[{"promotion": {"type": "qty_free", "sku": "A", "qty": 3}}]`, nil, ErrInvalidCoupon},
+		{"duplicate code", promotion.FormatJSON, `[
+			{"code": "X", "promotion": {"type": "qty_free", "sku": "A", "qty": 3}},
+			{"code": "x", "promotion": {"type": "qty_free", "sku": "B", "qty": 3}}]`, nil, ErrInvalidCoupon},
+		{"negative max redemptions", promotion.FormatJSON, `[{"code": "X", "promotion": {"type": "qty_free", "sku": "A", "qty": 3}, "max_redemptions": -1}]`, nil, ErrInvalidCoupon},
+		{"window ends before it starts", promotion.FormatJSON, `[{"code": "X", "promotion": {"type": "qty_free", "sku": "A", "qty": 3},
+			"valid_from": "2024-12-01T00:00:00Z", "valid_until": "2024-11-01T00:00:00Z"}]`, nil, ErrInvalidCoupon},
+		{"promotion with a priority", promotion.FormatJSON, `[{"code": "X", "promotion": {"type": "qty_free", "sku": "A", "qty": 3, "priority": 1}}]`, nil, ErrInvalidCoupon},
+		{"invalid promotion", promotion.FormatJSON, `[{"code": "X", "promotion": {"type": "qty_free", "sku": "A"}}]`, nil, promotion.ErrInvalidPromotion},
+		{"unknown sku", promotion.FormatJSON, `[{"code": "X", "promotion": {"type": "qty_free", "sku": "Z", "qty": 3}}]`, nil, promotion.ErrUnknownSku},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Load(strings.NewReader(tt.input), tt.format, exists)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Load() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Load() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// Package coupon gates promotions behind codes customers attach to their cart.
+//
+// Unlike the automatic promotions, the promotion of a coupon only applies to the
+// carts the code was attached to, within the validity window of the coupon and
+// as long as it has redemptions left.
+package coupon
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/promotion"
+)
+
+var (
+	// ErrUnknownCoupon is returned for a code that does not match any coupon.
+	ErrUnknownCoupon = errors.New("unknown coupon")
+	// ErrCouponNotActive is returned when using a coupon outside its validity window.
+	ErrCouponNotActive = errors.New("coupon is not active")
+	// ErrCouponExhausted is returned when a coupon has no redemptions left.
+	ErrCouponExhausted = errors.New("coupon has no redemptions left")
+	// ErrCartLimitReached is returned when attaching a coupon more times than a cart may use it.
+	ErrCartLimitReached = errors.New("coupon cart limit reached")
+)
+
+type (
+	// Coupon gives access to a promotion with a code.
+	// The coupon is valid from ValidFrom, inclusive, until ValidUntil, exclusive; a zero time leaves that side open.
+	// MaxRedemptions caps how many times the coupon is redeemed across all carts, 0 meaning no cap.
+	// PerCartLimit is how many times one cart may use it, each use applying the promotion once; 0 means once.
+	Coupon struct {
+		Code           string
+		Promotion      promotion.Promotion
+		ValidFrom      time.Time
+		ValidUntil     time.Time
+		MaxRedemptions int64
+		PerCartLimit   int
+	}
+
+	// Catalog holds the coupons of the store by normalized code.
+	Catalog map[string]Coupon
+)
+
+// NormalizeCode returns the form codes are matched in: trimmed and upper case.
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// NewCatalog indexes coupons by their normalized code.
+func NewCatalog(coupons ...Coupon) Catalog {
+	catalog := make(Catalog, len(coupons))
+	for _, c := range coupons {
+		c.Code = NormalizeCode(c.Code)
+		catalog[c.Code] = c
+	}
+	return catalog
+}
+
+// Find returns the coupon of a code.
+func (cat Catalog) Find(code string) (Coupon, error) {
+	c, ok := cat[NormalizeCode(code)]
+	if !ok {
+		return Coupon{}, ErrUnknownCoupon
+	}
+	return c, nil
+}
+
+// ActiveAt tells whether the coupon can be used at the given time.
+func (c Coupon) ActiveAt(at time.Time) bool {
+	if !c.ValidFrom.IsZero() && at.Before(c.ValidFrom) {
+		return false
+	}
+	if !c.ValidUntil.IsZero() && !at.Before(c.ValidUntil) {
+		return false
+	}
+	return true
+}
+
+// AttachTo attaches one use of the coupon to a cart at the given time, given how many
+// times the coupon was already redeemed. Redemptions are only counted when the cart is submitted.
+func (c Coupon) AttachTo(crt *cart.Cart, redeemed int64, at time.Time) (err error) {
+
+	if !c.ActiveAt(at) {
+		return ErrCouponNotActive
+	}
+
+	uses := crt.CouponUses(c.Code) + 1
+
+	if uses > c.cartLimit() {
+		return ErrCartLimitReached
+	}
+
+	if c.MaxRedemptions > 0 && redeemed+int64(uses) > c.MaxRedemptions {
+		return ErrCouponExhausted
+	}
+
+	return crt.AttachCoupon(c.Code)
+}
+
+// Redeem returns the redemption count of the coupon once a cart used it uses times at the given time.
+func (c Coupon) Redeem(redeemed int64, uses int, at time.Time) (int64, error) {
+
+	if !c.ActiveAt(at) {
+		return redeemed, ErrCouponNotActive
+	}
+
+	if uses > c.cartLimit() {
+		return redeemed, ErrCartLimitReached
+	}
+
+	total := redeemed + int64(uses)
+
+	if c.MaxRedemptions > 0 && total > c.MaxRedemptions {
+		return redeemed, ErrCouponExhausted
+	}
+
+	return total, nil
+}
+
+func (c Coupon) cartLimit() int {
+	if c.PerCartLimit <= 0 {
+		return 1
+	}
+	return c.PerCartLimit
+}
@@ -0,0 +1,95 @@
+package coupon
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/promotion"
+)
+
+func TestCatalog_Find(t *testing.T) {
+	catalog := NewCatalog(Coupon{Code: " save10 ", Promotion: promotion.ItemQtyPriceFreePromotion{PurchasedItemSku: "A", PurchasedQty: 2}})
+
+	for _, code := range []string{"SAVE10", "save10", " Save10"} {
+		if c, err := catalog.Find(code); err != nil || c.Code != "SAVE10" {
+			t.Errorf("Find(%q) = %+v, %v", code, c, err)
+		}
+	}
+	if _, err := catalog.Find("SAVE20"); !errors.Is(err, ErrUnknownCoupon) {
+		t.Errorf("Find(SAVE20) error = %v, want %v", err, ErrUnknownCoupon)
+	}
+}
+
+func TestCoupon_ActiveAt(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		coupon Coupon
+		at     time.Time
+		want   bool
+	}{
+		{"open window", Coupon{}, from, true},
+		{"before start", Coupon{ValidFrom: from, ValidUntil: until}, from.Add(-time.Second), false},
+		{"at start", Coupon{ValidFrom: from, ValidUntil: until}, from, true},
+		{"before end", Coupon{ValidFrom: from, ValidUntil: until}, until.Add(-time.Second), true},
+		{"at end", Coupon{ValidFrom: from, ValidUntil: until}, until, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.coupon.ActiveAt(tt.at); got != tt.want {
+				t.Errorf("ActiveAt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoupon_AttachToAndRedeem(t *testing.T) {
+	at := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		coupon   Coupon
+		redeemed int64
+		attach   int
+		wantErr  error
+	}{
+		{"once by default", Coupon{Code: "C"}, 0, 1, nil},
+		{"twice over default limit", Coupon{Code: "C"}, 0, 2, ErrCartLimitReached},
+		{"within cart limit", Coupon{Code: "C", PerCartLimit: 2}, 0, 2, nil},
+		{"last redemption", Coupon{Code: "C", MaxRedemptions: 5}, 4, 1, nil},
+		{"exhausted", Coupon{Code: "C", MaxRedemptions: 5}, 5, 1, ErrCouponExhausted},
+		{"uses beyond redemptions left", Coupon{Code: "C", MaxRedemptions: 5, PerCartLimit: 3}, 3, 3, ErrCouponExhausted},
+		{"expired", Coupon{Code: "C", ValidUntil: at}, 0, 1, ErrCouponNotActive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cart.NewAvailableCart()
+
+			var err error
+			for i := 0; i < tt.attach && err == nil; i++ {
+				err = tt.coupon.AttachTo(&c, tt.redeemed, at)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AttachTo() error = %v, want %v", err, tt.wantErr)
+			}
+
+			total, err := tt.coupon.Redeem(tt.redeemed, tt.attach, at)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Redeem() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && total != tt.redeemed+int64(tt.attach) {
+				t.Fatalf("Redeem() = %d, want %d", total, tt.redeemed+int64(tt.attach))
+			}
+		})
+	}
+
+	c := cart.NewAvailableCart()
+	c.CartStatus = cart.CartStatusSubmitted
+	if err := (Coupon{Code: "C"}).AttachTo(&c, 0, at); !errors.Is(err, cart.ErrCartNotAvailable) {
+		t.Fatalf("AttachTo() a submitted cart error = %v, want %v", err, cart.ErrCartNotAvailable)
+	}
+}
@@ -11,24 +11,49 @@ var (
 	ErrInvalidReleaseQuantity = errors.New("cannot release quantity")
 	// ErrInvalidRemoveQuantity indicates removing more than reserved.
 	ErrInvalidRemoveQuantity = errors.New("cannot remove quantity")
+	// ErrInvalidRestoreQuantity indicates restoring a negative quantity.
+	ErrInvalidRestoreQuantity = errors.New("cannot restore quantity")
 )
 
 type (
 	// Sku identifies a product uniquely.
 	Sku string
 
+	// TaxCategory groups items taxed at the same rate, e.g. "food".
+	TaxCategory string
+
+	// Category groups items merchandised together, e.g. "smart_speaker".
+	Category string
+
+	// Dimensions is the size of a packed unit, in millimetres.
+	Dimensions struct {
+		Length int
+		Width  int
+		Height int
+	}
+
 	// Item represents an item available for purchase.
 	// This model controls the quantities and reservations available
 	// in the same object for the sake of simplicity.
+	// An empty TaxCategory is taxed as TaxCategoryStandard.
+	// Weight is the weight of a unit in grams, used to price weight-based shipping.
+	// Category is optional; promotions may apply to every item of a category.
 	Item struct {
 		Sku          Sku
 		Name         string
 		Price        int64
 		QtyAvailable int
 		QtyReserved  int
+		TaxCategory  TaxCategory
+		Category     Category
+		Weight       int
+		Dimensions   Dimensions
 	}
 )
 
+// TaxCategoryStandard is the category of items without a specific tax rate.
+const TaxCategoryStandard = TaxCategory("standard")
+
 // NewItem is a constructor that creates a new Item with the provided attributes.
 // QtyReserved is initialized to 0; validations (non-negative qty/price, non-empty sku) are responsibility of callers.
 func NewItem(sku Sku, name string, price int64, qty int) Item {
@@ -89,3 +114,16 @@ func (i *Item) RemoveItem(toRemoveQty int) error {
 
 	return nil
 }
+
+// RestoreItem puts back into the available quantity what RemoveItem took out,
+// e.g. when the order that removed it is cancelled.
+func (i *Item) RestoreItem(toRestoreQty int) error {
+
+	if toRestoreQty < 0 {
+		return ErrInvalidRestoreQuantity
+	}
+
+	i.QtyAvailable += toRestoreQty
+
+	return nil
+}
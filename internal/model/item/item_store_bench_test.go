@@ -0,0 +1,90 @@
+package item
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gambarini/flip-shop/utils"
+	"github.com/gambarini/flip-shop/utils/memdb"
+)
+
+const benchStore = utils.StoreName("Items")
+
+// seedCatalog creates a database holding n items.
+func seedCatalog(b *testing.B, opts memdb.Options, n int) *memdb.MemoryKVDatabase {
+	b.Helper()
+	db := memdb.NewMemoryKVDatabaseWithOptions(opts)
+	if err := db.WithTx(func(tx utils.Tx) error {
+		for i := 0; i < n; i++ {
+			sku := fmt.Sprintf("SKU-%06d", i)
+			tx.Write(benchStore, sku, NewItem(Sku(sku), "Bench", 1000, 1_000_000))
+		}
+		return nil
+	}); err != nil {
+		b.Fatalf("seed: %v", err)
+	}
+	return db
+}
+
+// BenchmarkItemReserveTx measures a one item reservation transaction against
+// catalogs of growing size. With copy-on-write snapshots the cost should stay
+// flat as the catalog grows instead of scaling with the total data.
+func BenchmarkItemReserveTx(b *testing.B) {
+	modes := []struct {
+		name string
+		opts memdb.Options
+	}{
+		{"pessimistic", memdb.Options{}},
+		{"optimistic", memdb.Options{Concurrency: memdb.Optimistic}},
+	}
+	for _, mode := range modes {
+		for _, n := range []int{1_000, 10_000, 100_000} {
+			b.Run(fmt.Sprintf("%s/items=%d", mode.name, n), func(b *testing.B) {
+				db := seedCatalog(b, mode.opts, n)
+				sku := fmt.Sprintf("SKU-%06d", n/2)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					err := db.WithTx(func(tx utils.Tx) error {
+						v, err := tx.Read(benchStore, sku)
+						if err != nil {
+							return err
+						}
+						it := v.(Item)
+						if err := it.ReserveItem(1); err != nil {
+							return err
+						}
+						_ = it.ReleaseItem(1)
+						tx.Write(benchStore, sku, it)
+						return nil
+					})
+					if err != nil {
+						b.Fatalf("tx: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkItemReadOnlyTx measures starting a transaction and reading one item.
+func BenchmarkItemReadOnlyTx(b *testing.B) {
+	for _, n := range []int{1_000, 10_000, 100_000} {
+		b.Run(fmt.Sprintf("items=%d", n), func(b *testing.B) {
+			db := seedCatalog(b, memdb.Options{}, n)
+			sku := fmt.Sprintf("SKU-%06d", n/2)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := db.WithTx(func(tx utils.Tx) error {
+					_, err := tx.Read(benchStore, sku)
+					return err
+				}); err != nil {
+					b.Fatalf("tx: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -135,3 +135,29 @@ func TestItem_ReserveItem(t *testing.T) {
 		})
 	}
 }
+
+func TestItem_RestoreItem(t *testing.T) {
+	tests := []struct {
+		name             string
+		toRestoreQty     int
+		wantQtyAvailable int
+		wantErr          bool
+	}{
+		{"Restore 0", 0, 5, false},
+		{"Restore 3", 3, 8, false},
+		{"Restore negative with error", -1, 5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := Item{Sku: Sku("TEST"), Name: "Test", Price: 1000, QtyAvailable: 5, QtyReserved: 2}
+
+			if err := i.RestoreItem(tt.toRestoreQty); (err != nil) != tt.wantErr {
+				t.Errorf("RestoreItem() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if i.QtyAvailable != tt.wantQtyAvailable || i.QtyReserved != 2 {
+				t.Errorf("RestoreItem() QtyAvailable = %v, QtyReserved = %v, want %v, 2", i.QtyAvailable, i.QtyReserved, tt.wantQtyAvailable)
+			}
+		})
+	}
+}
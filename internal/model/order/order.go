@@ -0,0 +1,232 @@
+package order
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/tier"
+	"github.com/gambarini/flip-shop/utils"
+	"github.com/gofrs/uuid"
+)
+
+var (
+	// ErrCartNotSubmitted is returned when placing an order for a cart that was not submitted.
+	ErrCartNotSubmitted = errors.New("cart is not submitted")
+	// ErrOrderNotPlaced is returned when changing an order that is no longer placed.
+	ErrOrderNotPlaced = errors.New("order is not placed")
+	// ErrPaymentPending is returned when changing an order while gateway calls for another change are pending.
+	ErrPaymentPending = errors.New("a payment of the order is pending")
+)
+
+type (
+	// Status represents the state of an order.
+	Status string
+
+	// Order is the record of a submitted cart.
+	// Its lines and totals are a snapshot taken at submission and are not
+	// affected by later changes to items, prices or promotions.
+	// Amounts are expressed in integer cents (int64).
+	Order struct {
+		OrderID  string
+		Number   int64
+		CartID   string
+		PlacedAt time.Time
+		Status   Status
+		Lines    []Line
+		Totals   Totals
+		Shipping cart.Shipping
+
+		// Promotions records the promotions applied to the order as a whole, e.g. its OrderDiscount
+		Promotions []AppliedPromotion
+		// CouponUses records how many uses of each coupon code the order redeemed
+		CouponUses map[string]int
+
+		CancelReason string
+		CancelledAt  time.Time
+
+		Payment Payment
+	}
+
+	// Payment tracks the money of an order through the payment gateway: the amount
+	// authorized on submission, then captured, refunded or voided.
+	// AuthorizationID is empty when there was nothing to pay.
+	// Pending lists the gateway calls recorded before they were made and whose outcome
+	// is not stored yet, so money moved by a call is known even when storing its outcome failed.
+	Payment struct {
+		AuthorizationID string
+		Authorized      int64
+		Captured        int64
+		Refunded        int64
+		Voided          bool
+		Pending         []PaymentCall
+	}
+
+	// PaymentCall is a call to the payment gateway moving the money of an order:
+	// a "capture", "void" or "refund" of Amount under the authorization. Reference identifies a refund.
+	PaymentCall struct {
+		Operation       string
+		AuthorizationID string
+		Amount          int64
+		Reference       string
+	}
+
+	// Line is a purchased item of an order with the promotions applied to it.
+	// UnitPrice is ListPrice lowered by the price break the line quantity got among PriceBreaks.
+	// OrderDiscount is the share of the order discount allocated to the line.
+	// Total is UnitPrice * Qty - Discount - OrderDiscount, plus Tax when prices exclude it.
+	// ReturnedQty and Refunded accumulate the units given back and the amount refunded for them.
+	// BundleCharged is the discount the line lost when units of lines sold in bundles with it, or earning
+	// its free units, were returned, taken from their refund; BundleWithheld is what was taken from the
+	// refunds of the line so.
+	Line struct {
+		Sku            item.Sku
+		Name           string
+		ListPrice      int64
+		PriceBreaks    tier.Breaks
+		UnitPrice      int64
+		Qty            int
+		Discount       int64
+		OrderDiscount  int64
+		Tax            int64
+		Total          int64
+		Promotions     []AppliedPromotion
+		ReturnedQty    int
+		Refunded       int64
+		BundleCharged  int64
+		BundleWithheld int64
+	}
+
+	// AppliedPromotion records what a promotion did to a line:
+	// the free units it added and the discount it granted.
+	// Threshold is the purchased quantity the promotion was conditioned on, 0 when none;
+	// for a bundle or mix_match it is the number of units a bundle is made of.
+	// Bundles is how many bundles the line was sold in and BundledQty how many of its units they took.
+	// Coupon is the code that unlocked the promotion, empty for automatic promotions.
+	// PurchasedSku is the SKU whose purchased units each earned one of the free units, empty when none.
+	AppliedPromotion struct {
+		Promotion    string
+		Coupon       string
+		Threshold    int
+		FreeQty      int
+		Bundles      int
+		BundledQty   int
+		PurchasedSku item.Sku
+		Discount     int64
+	}
+
+	// Totals is the breakdown of the order amount.
+	// Total is Subtotal - Discount - OrderDiscount + Shipping, plus Tax unless TaxInclusive tells the prices already contain it.
+	// Discount is the total of the line discounts; OrderDiscount is the discount on the order as a whole.
+	// Refunded is the amount given back by returns; shipping is not refunded by returns.
+	Totals struct {
+		Subtotal      int64
+		Discount      int64
+		OrderDiscount int64
+		Tax           int64
+		TaxInclusive  bool
+		Shipping      int64
+		Total         int64
+		Refunded      int64
+	}
+)
+
+const (
+	// StatusPlaced indicates the order was created from a submitted cart.
+	StatusPlaced = Status("Placed")
+
+	// StatusCancelled indicates the order was cancelled and its stock restored.
+	StatusCancelled = Status("Cancelled")
+)
+
+// NewOrder creates a placed order from a submitted cart.
+// applied holds, by SKU, the promotions applied to the cart lines during submission.
+func NewOrder(number int64, c cart.Cart, placedAt time.Time, applied map[item.Sku][]AppliedPromotion) (o Order, err error) {
+
+	if c.CartStatus != cart.CartStatusSubmitted {
+		return o, ErrCartNotSubmitted
+	}
+
+	id, _ := uuid.NewV4()
+
+	o = Order{
+		OrderID:  id.String(),
+		Number:   number,
+		CartID:   c.CartID,
+		PlacedAt: placedAt,
+		Status:   StatusPlaced,
+		Lines:    make([]Line, 0, len(c.Purchases)),
+		Totals:   Totals{TaxInclusive: c.TaxInclusive, Shipping: c.ShippingCost, OrderDiscount: c.OrderDiscount},
+		Shipping: c.Shipping,
+	}
+
+	for _, p := range c.Purchases {
+		gross := utils.SaturatingMulInt64Int(p.Price, p.Qty)
+		total := utils.SaturatingSubInt64(utils.SaturatingSubInt64(gross, p.Discount), p.OrderDiscount)
+
+		if !c.TaxInclusive {
+			total = utils.SaturatingAddInt64(total, p.Tax)
+		}
+
+		o.Lines = append(o.Lines, Line{
+			Sku:           p.Sku,
+			Name:          p.Name,
+			ListPrice:     p.ListPrice,
+			PriceBreaks:   p.PriceBreaks,
+			UnitPrice:     p.Price,
+			Qty:           p.Qty,
+			Discount:      p.Discount,
+			OrderDiscount: p.OrderDiscount,
+			Tax:           p.Tax,
+			Total:         total,
+			Promotions:    applied[p.Sku],
+		})
+
+		o.Totals.Subtotal = utils.SaturatingAddInt64(o.Totals.Subtotal, gross)
+		o.Totals.Discount = utils.SaturatingAddInt64(o.Totals.Discount, p.Discount)
+		o.Totals.Tax = utils.SaturatingAddInt64(o.Totals.Tax, p.Tax)
+	}
+
+	sort.Slice(o.Lines, func(i, j int) bool { return o.Lines[i].Sku < o.Lines[j].Sku })
+
+	o.Totals.Total = utils.SaturatingSubInt64(utils.SaturatingSubInt64(o.Totals.Subtotal, o.Totals.Discount), o.Totals.OrderDiscount)
+
+	if !o.Totals.TaxInclusive {
+		o.Totals.Total = utils.SaturatingAddInt64(o.Totals.Total, o.Totals.Tax)
+	}
+
+	o.Totals.Total = utils.SaturatingAddInt64(o.Totals.Total, o.Totals.Shipping)
+
+	return o, nil
+}
+
+// Line returns the order line for a SKU.
+func (o Order) Line(sku item.Sku) (Line, bool) {
+	for _, l := range o.Lines {
+		if l.Sku == sku {
+			return l, true
+		}
+	}
+	return Line{}, false
+}
+
+// Cancel moves a placed order to Cancelled status, recording why and when.
+// Restoring the stock of its lines is up to the caller.
+func (o *Order) Cancel(reason string, at time.Time) (err error) {
+
+	if reason == "" {
+		return cart.ErrCancelReasonRequired
+	}
+
+	if o.Status != StatusPlaced {
+		return ErrOrderNotPlaced
+	}
+
+	o.Status = StatusCancelled
+	o.CancelReason = reason
+	o.CancelledAt = at
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package order
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/tier"
+)
+
+func TestNewOrder(t *testing.T) {
+	placedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	submitted := func() cart.Cart {
+		return cart.Cart{
+			CartID: "CartID",
+			Purchases: map[item.Sku]cart.Purchase{
+				"B": {Sku: "B", Name: "Pi", Price: 3000, Qty: 1, Discount: 3000},
+				"A": {Sku: "A", Name: "Home", Price: 4999, Qty: 3, Discount: 4999},
+			},
+			CartStatus: cart.CartStatusSubmitted,
+		}
+	}
+	available := submitted()
+	available.CartStatus = cart.CartStatusAvailable
+
+	taxed := func(inclusive bool) cart.Cart {
+		c := submitted()
+		c.TaxInclusive = inclusive
+		a := c.Purchases["A"]
+		a.Tax = 1000
+		c.Purchases["A"] = a
+		return c
+	}
+
+	applied := map[item.Sku][]AppliedPromotion{
+		"A": {{Promotion: "qty_free", Discount: 4999}},
+		"B": {{Promotion: "free_item", FreeQty: 1, Discount: 3000}},
+	}
+
+	tests := []struct {
+		name       string
+		cart       cart.Cart
+		wantLines  []Line
+		wantTotals Totals
+		wantErr    error
+	}{
+		{
+			name: "lines ordered by sku with promotions",
+			cart: submitted(),
+			wantLines: []Line{
+				{Sku: "A", Name: "Home", UnitPrice: 4999, Qty: 3, Discount: 4999, Total: 9998, Promotions: applied["A"]},
+				{Sku: "B", Name: "Pi", UnitPrice: 3000, Qty: 1, Discount: 3000, Total: 0, Promotions: applied["B"]},
+			},
+			wantTotals: Totals{Subtotal: 17997, Discount: 7999, Total: 9998},
+		},
+		{
+			name: "tax added to exclusive prices",
+			cart: taxed(false),
+			wantLines: []Line{
+				{Sku: "A", Name: "Home", UnitPrice: 4999, Qty: 3, Discount: 4999, Tax: 1000, Total: 10998, Promotions: applied["A"]},
+				{Sku: "B", Name: "Pi", UnitPrice: 3000, Qty: 1, Discount: 3000, Total: 0, Promotions: applied["B"]},
+			},
+			wantTotals: Totals{Subtotal: 17997, Discount: 7999, Tax: 1000, Total: 10998},
+		},
+		{
+			name: "tax contained in inclusive prices",
+			cart: taxed(true),
+			wantLines: []Line{
+				{Sku: "A", Name: "Home", UnitPrice: 4999, Qty: 3, Discount: 4999, Tax: 1000, Total: 9998, Promotions: applied["A"]},
+				{Sku: "B", Name: "Pi", UnitPrice: 3000, Qty: 1, Discount: 3000, Total: 0, Promotions: applied["B"]},
+			},
+			wantTotals: Totals{Subtotal: 17997, Discount: 7999, Tax: 1000, TaxInclusive: true, Total: 9998},
+		},
+		{
+			name: "shipping added to the total",
+			cart: func() cart.Cart {
+				c := submitted()
+				c.ShippingCost = 950
+				return c
+			}(),
+			wantLines: []Line{
+				{Sku: "A", Name: "Home", UnitPrice: 4999, Qty: 3, Discount: 4999, Total: 9998, Promotions: applied["A"]},
+				{Sku: "B", Name: "Pi", UnitPrice: 3000, Qty: 1, Discount: 3000, Total: 0, Promotions: applied["B"]},
+			},
+			wantTotals: Totals{Subtotal: 17997, Discount: 7999, Shipping: 950, Total: 10948},
+		},
+		{
+			name: "order discount shared by lines",
+			cart: func() cart.Cart {
+				c := submitted()
+				c.OrderDiscount = 1000
+				a := c.Purchases["A"]
+				a.OrderDiscount = 1000
+				c.Purchases["A"] = a
+				return c
+			}(),
+			wantLines: []Line{
+				{Sku: "A", Name: "Home", UnitPrice: 4999, Qty: 3, Discount: 4999, OrderDiscount: 1000, Total: 8998, Promotions: applied["A"]},
+				{Sku: "B", Name: "Pi", UnitPrice: 3000, Qty: 1, Discount: 3000, Total: 0, Promotions: applied["B"]},
+			},
+			wantTotals: Totals{Subtotal: 17997, Discount: 7999, OrderDiscount: 1000, Total: 8998},
+		},
+		{
+			name: "list price and price breaks kept",
+			cart: func() cart.Cart {
+				c := submitted()
+				a := c.Purchases["A"]
+				a.ListPrice, a.PriceBreaks = 5999, tier.Breaks{{MinQty: 3, Percentage: 0.1}}
+				c.Purchases["A"] = a
+				return c
+			}(),
+			wantLines: []Line{
+				{Sku: "A", Name: "Home", ListPrice: 5999, PriceBreaks: tier.Breaks{{MinQty: 3, Percentage: 0.1}}, UnitPrice: 4999, Qty: 3, Discount: 4999, Total: 9998, Promotions: applied["A"]},
+				{Sku: "B", Name: "Pi", UnitPrice: 3000, Qty: 1, Discount: 3000, Total: 0, Promotions: applied["B"]},
+			},
+			wantTotals: Totals{Subtotal: 17997, Discount: 7999, Total: 9998},
+		},
+		{name: "cart not submitted", cart: available, wantErr: ErrCartNotSubmitted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := NewOrder(7, tt.cart, placedAt, applied)
+			if err != tt.wantErr {
+				t.Fatalf("NewOrder() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if o.OrderID == "" || o.Number != 7 || o.CartID != "CartID" || o.Status != StatusPlaced || !o.PlacedAt.Equal(placedAt) {
+				t.Errorf("NewOrder() header = %+v", o)
+			}
+			if !reflect.DeepEqual(o.Lines, tt.wantLines) {
+				t.Errorf("Lines = %+v, want %+v", o.Lines, tt.wantLines)
+			}
+			if o.Totals != tt.wantTotals {
+				t.Errorf("Totals = %+v, want %+v", o.Totals, tt.wantTotals)
+			}
+		})
+	}
+}
+
+func TestOrder_Cancel(t *testing.T) {
+	at := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		status     Status
+		reason     string
+		wantStatus Status
+		wantErr    error
+	}{
+		{"placed", StatusPlaced, "out of stock", StatusCancelled, nil},
+		{"already cancelled", StatusCancelled, "again", StatusCancelled, ErrOrderNotPlaced},
+		{"missing reason", StatusPlaced, "", StatusPlaced, cart.ErrCancelReasonRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := Order{OrderID: "OrderID", Status: tt.status}
+
+			if err := o.Cancel(tt.reason, at); err != tt.wantErr {
+				t.Fatalf("Cancel() error = %v, want %v", err, tt.wantErr)
+			}
+			if o.Status != tt.wantStatus {
+				t.Errorf("Status = %v, want %v", o.Status, tt.wantStatus)
+			}
+			if tt.wantErr == nil && (o.CancelReason != tt.reason || !o.CancelledAt.Equal(at)) {
+				t.Errorf("Cancel() recorded %q at %v, want %q at %v", o.CancelReason, o.CancelledAt, tt.reason, at)
+			}
+		})
+	}
+}
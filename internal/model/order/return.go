@@ -0,0 +1,373 @@
+package order
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/promotion"
+	"github.com/gambarini/flip-shop/utils"
+	"github.com/gofrs/uuid"
+)
+
+var (
+	// ErrReturnEmpty is returned when a return does not give back any unit.
+	ErrReturnEmpty = errors.New("return has no units")
+	// ErrItemNotInOrder is returned when returning an item the order does not have.
+	ErrItemNotInOrder = errors.New("item is not in the order")
+	// ErrInvalidReturnQuantity is returned when returning a non-positive quantity or more units than kept.
+	ErrInvalidReturnQuantity = errors.New("invalid return quantity")
+)
+
+type (
+	// Return records units of an order given back by the customer and the amount refunded for them.
+	// Restock tells whether the returned units were put back in stock.
+	Return struct {
+		ReturnID  string
+		OrderID   string
+		CreatedAt time.Time
+		Restock   bool
+		Lines     []ReturnLine
+		Refund    int64
+	}
+
+	// ReturnLine is the refund of the units returned from an order line.
+	// ClawedBack is how much less than a proportional share of the line was refunded,
+	// because the units kept, of the line or of lines sold in bundles with it, no longer
+	// qualify for a promotion they were granted.
+	ReturnLine struct {
+		Sku        item.Sku
+		Qty        int
+		Refund     int64
+		ClawedBack int64
+	}
+)
+
+// StatusReturned indicates every unit of the order was returned.
+const StatusReturned = Status("Returned")
+
+// ReturnUnits gives back units of a placed order, by SKU, and computes their refund.
+//
+// The refund of a line is what was paid for the units held before the return minus what
+// the units still kept cost once the line promotions are re-applied to them. Units kept
+// below the Threshold of a qty_free promotion lose their free units, so that benefit is
+// clawed back from the refund; free units added by a promotion are considered given back last.
+// Free units of a free_item are only kept free for as many units of the purchased SKU as are
+// kept; what they lose when the purchased units are returned is clawed back from the refund of
+// those units first.
+// A bundle or mix_match keeps its discount only for the whole bundles the units held still make
+// up, whichever of its lines the returned units come from: what the lines kept whole lose is
+// clawed back from the refund too, in SKU order.
+// Units kept below the quantity of the price break of their line sell at the price of the break
+// they still get, and the difference is clawed back as well.
+// The units kept keep a proportional share of the order discount allocated to the line and,
+// when prices exclude tax, of the line tax.
+//
+// Restocking the returned units is up to the caller.
+func (o *Order) ReturnUnits(units map[item.Sku]int, restock bool, at time.Time) (r Return, err error) {
+
+	if o.Status != StatusPlaced {
+		return r, ErrOrderNotPlaced
+	}
+
+	if len(units) == 0 {
+		return r, ErrReturnEmpty
+	}
+
+	skus := make([]item.Sku, 0, len(units))
+
+	for sku, qty := range units {
+		i, ok := o.lineIndex(sku)
+
+		if !ok {
+			return r, ErrItemNotInOrder
+		}
+
+		if qty <= 0 || qty > o.Lines[i].KeptQty() {
+			return r, ErrInvalidReturnQuantity
+		}
+
+		skus = append(skus, sku)
+	}
+
+	sort.Slice(skus, func(i, j int) bool { return skus[i] < skus[j] })
+
+	// the units of every line held before and after the return
+	held := make(map[item.Sku]int, len(o.Lines))
+	kept := make(map[item.Sku]int, len(o.Lines))
+
+	for _, l := range o.Lines {
+		held[l.Sku] = l.KeptQty()
+		kept[l.Sku] = l.KeptQty() - units[l.Sku]
+	}
+
+	id, _ := uuid.NewV4()
+
+	r = Return{
+		ReturnID:  id.String(),
+		OrderID:   o.OrderID,
+		CreatedAt: at,
+		Restock:   restock,
+		Lines:     make([]ReturnLine, 0, len(units)),
+	}
+
+	for _, sku := range skus {
+		i, _ := o.lineIndex(sku)
+		l := o.Lines[i]
+
+		paid := l.paid()
+
+		refund := utils.SaturatingSubInt64(paid, o.keptCost(i, kept))
+		if refund < 0 {
+			refund = 0
+		}
+
+		clawedBack := utils.SaturatingSubInt64(proportion(paid, units[sku], held[sku]), refund)
+		if clawedBack < 0 {
+			clawedBack = 0
+		}
+
+		r.Lines = append(r.Lines, ReturnLine{Sku: sku, Qty: units[sku], Refund: refund, ClawedBack: clawedBack})
+	}
+
+	// lines kept whole lose the discount of the bundles broken and the free units no longer earned
+	for i := range o.Lines {
+		l := &o.Lines[i]
+
+		if units[l.Sku] > 0 || !l.linked() {
+			continue
+		}
+
+		lost := utils.SaturatingSubInt64(o.keptCost(i, kept), o.keptCost(i, held))
+
+		// the returned units that earned free units of the line pay for them first
+		payers := make([]int, 0, len(r.Lines))
+		for j := range r.Lines {
+			if l.earnedBy(r.Lines[j].Sku) {
+				payers = append(payers, j)
+			}
+		}
+		for j := range r.Lines {
+			if !l.earnedBy(r.Lines[j].Sku) {
+				payers = append(payers, j)
+			}
+		}
+
+		for _, j := range payers {
+			if lost <= 0 {
+				break
+			}
+
+			taken := min(lost, r.Lines[j].Refund)
+			r.Lines[j].Refund -= taken
+			r.Lines[j].ClawedBack = utils.SaturatingAddInt64(r.Lines[j].ClawedBack, taken)
+			l.BundleCharged = utils.SaturatingAddInt64(l.BundleCharged, taken)
+			lost -= taken
+
+			k, _ := o.lineIndex(r.Lines[j].Sku)
+			o.Lines[k].BundleWithheld = utils.SaturatingAddInt64(o.Lines[k].BundleWithheld, taken)
+		}
+	}
+
+	for _, rl := range r.Lines {
+		i, _ := o.lineIndex(rl.Sku)
+		l := &o.Lines[i]
+
+		l.ReturnedQty += rl.Qty
+		l.Refunded = utils.SaturatingAddInt64(l.Refunded, rl.Refund)
+
+		r.Refund = utils.SaturatingAddInt64(r.Refund, rl.Refund)
+	}
+
+	o.Totals.Refunded = utils.SaturatingAddInt64(o.Totals.Refunded, r.Refund)
+
+	if o.fullyReturned() {
+		o.Status = StatusReturned
+	}
+
+	return r, nil
+}
+
+// KeptQty is the number of units of the line that were not returned.
+func (l Line) KeptQty() int {
+	return l.Qty - l.ReturnedQty
+}
+
+// paid is what was paid for the units of the line not returned yet.
+func (l Line) paid() int64 {
+	paid := utils.SaturatingAddInt64(l.Total, l.BundleCharged)
+	return utils.SaturatingSubInt64(utils.SaturatingSubInt64(paid, l.Refunded), l.BundleWithheld)
+}
+
+// linked tells whether the discount of the line depends on the units held of other lines:
+// it was sold in bundles or got free units for the purchase of another SKU.
+func (l Line) linked() bool {
+	for _, ap := range l.Promotions {
+		if ap.Bundles > 0 || (ap.PurchasedSku != "" && ap.PurchasedSku != l.Sku) {
+			return true
+		}
+	}
+	return false
+}
+
+// earnedBy tells whether purchases of sku earned free units of the line.
+func (l Line) earnedBy(sku item.Sku) bool {
+	for _, ap := range l.Promotions {
+		if ap.FreeQty > 0 && ap.PurchasedSku == sku {
+			return true
+		}
+	}
+	return false
+}
+
+// freeQty is the number of units added to the line by promotions.
+func (l Line) freeQty() int {
+	free := 0
+	for _, ap := range l.Promotions {
+		free += ap.FreeQty
+	}
+	return free
+}
+
+// keptCost returns what the units held of the line i cost, by SKU the units held of every line.
+func (o Order) keptCost(i int, held map[item.Sku]int) int64 {
+
+	l := o.Lines[i]
+	n := held[l.Sku]
+
+	cost := utils.SaturatingSubInt64(utils.SaturatingMulInt64Int(l.unitPriceFor(n), n), o.discountFor(i, held))
+	cost = utils.SaturatingSubInt64(cost, proportion(l.OrderDiscount, n, l.Qty))
+
+	if !o.Totals.TaxInclusive {
+		cost = utils.SaturatingAddInt64(cost, l.taxFor(cost))
+	}
+
+	return cost
+}
+
+// unitPriceFor returns the unit price of n units of the line: that of the price break the units purchased
+// among them still get. Free units added by promotions are kept first and do not count for the breaks.
+// A line placed without its list price or price breaks keeps its unit price.
+func (l Line) unitPriceFor(n int) int64 {
+
+	if l.ListPrice == 0 || len(l.PriceBreaks) == 0 {
+		return l.UnitPrice
+	}
+
+	purchased := n - min(n, l.freeQty())
+
+	if purchased == 0 {
+		return l.UnitPrice
+	}
+
+	return max(l.UnitPrice, l.PriceBreaks.UnitPrice(l.ListPrice, purchased))
+}
+
+// discountFor returns the discount the line i gets for the units it holds, re-applying what
+// was recorded about its promotions. Discount not attributed to a promotion stays proportional.
+func (o Order) discountFor(i int, held map[item.Sku]int) int64 {
+
+	l := o.Lines[i]
+	n := held[l.Sku]
+
+	var discount, attributed int64
+
+	for _, ap := range l.Promotions {
+		attributed = utils.SaturatingAddInt64(attributed, ap.Discount)
+
+		switch {
+		case ap.FreeQty > 0:
+			free := min(n, ap.FreeQty)
+			if ap.PurchasedSku != "" {
+				free = min(free, o.purchasedHeld(ap.PurchasedSku, held))
+			}
+			discount = utils.SaturatingAddInt64(discount, proportion(ap.Discount, free, ap.FreeQty))
+		case ap.Bundles > 0:
+			discount = utils.SaturatingAddInt64(discount, proportion(ap.Discount, o.keptBundles(ap, held), ap.Bundles))
+		case ap.Promotion == promotion.NameQtyFree && ap.Threshold > 0:
+			discount = utils.SaturatingAddInt64(discount, min(ap.Discount, utils.SaturatingMulInt64Int(l.UnitPrice, n/ap.Threshold)))
+		case ap.Promotion == promotion.NameQtyPercentage && n <= ap.Threshold:
+			// the percentage only applies above the threshold
+		default:
+			discount = utils.SaturatingAddInt64(discount, proportion(ap.Discount, n, l.Qty))
+		}
+	}
+
+	return utils.SaturatingAddInt64(discount, proportion(utils.SaturatingSubInt64(l.Discount, attributed), n, l.Qty))
+}
+
+// purchasedHeld returns how many of the units held of a SKU were purchased rather than added free.
+func (o Order) purchasedHeld(sku item.Sku, held map[item.Sku]int) int {
+	i, ok := o.lineIndex(sku)
+	if !ok {
+		return 0
+	}
+	n := held[sku]
+	return n - min(n, o.Lines[i].freeQty())
+}
+
+// keptBundles returns how many of the bundles a promotion was applied in the units held still make up.
+// Each line counts the units held up to those it had in bundles. A bundle takes its share of units from
+// every one of its lines, a mix_match takes its Threshold of units from any of them.
+func (o Order) keptBundles(ap AppliedPromotion, held map[item.Sku]int) int {
+
+	bundles, units := ap.Bundles, 0
+
+	for _, l := range o.Lines {
+		for _, lp := range l.Promotions {
+			if lp.Promotion != ap.Promotion || lp.Coupon != ap.Coupon || lp.Bundles == 0 || lp.BundledQty == 0 {
+				continue
+			}
+
+			n := min(held[l.Sku], lp.BundledQty)
+
+			if ap.Promotion == promotion.NameBundle {
+				bundles = min(bundles, n*lp.Bundles/lp.BundledQty)
+			} else {
+				units += n
+			}
+		}
+	}
+
+	if ap.Promotion == promotion.NameBundle || ap.Threshold <= 0 {
+		return bundles
+	}
+
+	return min(bundles, units/ap.Threshold)
+}
+
+// taxFor returns the share of the line tax charged on amount of its value after discount.
+func (l Line) taxFor(amount int64) int64 {
+	net := utils.SaturatingSubInt64(utils.SaturatingSubInt64(utils.SaturatingMulInt64Int(l.UnitPrice, l.Qty), l.Discount), l.OrderDiscount)
+	if net <= 0 {
+		return 0
+	}
+	return min(l.Tax, utils.SaturatingMulInt64(l.Tax, amount)/net)
+}
+
+func (o Order) lineIndex(sku item.Sku) (int, bool) {
+	for i, l := range o.Lines {
+		if l.Sku == sku {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (o Order) fullyReturned() bool {
+	for _, l := range o.Lines {
+		if l.KeptQty() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// proportion returns amount * n / of, truncated; 0 when of is 0.
+func proportion(amount int64, n, of int) int64 {
+	if of == 0 {
+		return 0
+	}
+	return utils.SaturatingMulInt64Int(amount, n) / int64(of)
+}
@@ -0,0 +1,316 @@
+package order
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/tier"
+)
+
+func TestOrder_ReturnUnits(t *testing.T) {
+	at := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+
+	// a placed order: 6 Google Homes with 2 free, 1 MacBook with a free Pi plus 1 paid Pi,
+	// 5 Alexa Speakers with 10% off above 3 units
+	placed := func() Order {
+		return Order{
+			OrderID: "OrderID",
+			Status:  StatusPlaced,
+			Lines: []Line{
+				{Sku: "120P90", UnitPrice: 4999, Qty: 6, Discount: 9998, Total: 19996,
+					Promotions: []AppliedPromotion{{Promotion: "qty_free", Threshold: 3, Discount: 9998}}},
+				{Sku: "234234", UnitPrice: 3000, Qty: 2, Discount: 3000, Total: 3000,
+					Promotions: []AppliedPromotion{{Promotion: "free_item", FreeQty: 1, PurchasedSku: "43N23P", Discount: 3000}}},
+				{Sku: "43N23P", UnitPrice: 539999, Qty: 1, Total: 539999},
+				{Sku: "A304SD", UnitPrice: 10950, Qty: 5, Discount: 5470, Total: 49280,
+					Promotions: []AppliedPromotion{{Promotion: "qty_percentage", Threshold: 3, Discount: 5470}}},
+			},
+			Totals: Totals{Subtotal: 618245, Discount: 18468, Total: 612275},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		returns   []map[item.Sku]int
+		wantLines []ReturnLine // lines of the last return
+		wantErr   error
+	}{
+		{
+			name:      "unit without promotion refunds its price",
+			returns:   []map[item.Sku]int{{"43N23P": 1, "234234": 2}},
+			wantLines: []ReturnLine{{Sku: "234234", Qty: 2, Refund: 3000}, {Sku: "43N23P", Qty: 1, Refund: 539999}},
+		},
+		{
+			name:      "free unit kept without its purchased unit is clawed back",
+			returns:   []map[item.Sku]int{{"43N23P": 1}},
+			wantLines: []ReturnLine{{Sku: "43N23P", Qty: 1, Refund: 536999, ClawedBack: 3000}},
+		},
+		{
+			name:      "kept units still reaching the threshold keep their free unit",
+			returns:   []map[item.Sku]int{{"120P90": 1}},
+			wantLines: []ReturnLine{{Sku: "120P90", Qty: 1, Refund: 0, ClawedBack: 3332}},
+		},
+		{
+			name:      "kept units below the threshold lose a free unit",
+			returns:   []map[item.Sku]int{{"120P90": 1}, {"120P90": 3}},
+			wantLines: []ReturnLine{{Sku: "120P90", Qty: 3, Refund: 9998, ClawedBack: 1999}},
+		},
+		{
+			name:      "returning every unit refunds what was paid",
+			returns:   []map[item.Sku]int{{"120P90": 4}, {"120P90": 2}},
+			wantLines: []ReturnLine{{Sku: "120P90", Qty: 2, Refund: 9998}},
+		},
+		{
+			name:      "free units are given back last",
+			returns:   []map[item.Sku]int{{"234234": 1}},
+			wantLines: []ReturnLine{{Sku: "234234", Qty: 1, Refund: 3000}},
+		},
+		{
+			name:      "percentage is lost at the threshold",
+			returns:   []map[item.Sku]int{{"A304SD": 2}},
+			wantLines: []ReturnLine{{Sku: "A304SD", Qty: 2, Refund: 16430, ClawedBack: 3282}},
+		},
+		{
+			name:      "several lines sorted by sku",
+			returns:   []map[item.Sku]int{{"43N23P": 1, "120P90": 3}},
+			wantLines: []ReturnLine{{Sku: "120P90", Qty: 3, Refund: 9998}, {Sku: "43N23P", Qty: 1, Refund: 536999, ClawedBack: 3000}},
+		},
+		{name: "no units", returns: []map[item.Sku]int{{}}, wantErr: ErrReturnEmpty},
+		{name: "unknown sku", returns: []map[item.Sku]int{{"NOPE": 1}}, wantErr: ErrItemNotInOrder},
+		{name: "zero quantity", returns: []map[item.Sku]int{{"120P90": 0}}, wantErr: ErrInvalidReturnQuantity},
+		{name: "more than kept", returns: []map[item.Sku]int{{"120P90": 5}, {"120P90": 2}}, wantErr: ErrInvalidReturnQuantity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := placed()
+
+			var (
+				r        Return
+				err      error
+				refunded int64
+			)
+			for _, units := range tt.returns {
+				if r, err = o.ReturnUnits(units, true, at); err != nil {
+					break
+				}
+				refunded += r.Refund
+			}
+			if err != tt.wantErr {
+				t.Fatalf("ReturnUnits() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(r.Lines, tt.wantLines) {
+				t.Errorf("Lines = %+v, want %+v", r.Lines, tt.wantLines)
+			}
+			if r.ReturnID == "" || r.OrderID != o.OrderID || !r.CreatedAt.Equal(at) || !r.Restock {
+				t.Errorf("ReturnUnits() header = %+v", r)
+			}
+			if o.Totals.Refunded != refunded {
+				t.Errorf("Totals.Refunded = %d, want %d", o.Totals.Refunded, refunded)
+			}
+			for _, l := range o.Lines {
+				if l.Refunded > l.Total {
+					t.Errorf("line %s refunded %d, more than its total %d", l.Sku, l.Refunded, l.Total)
+				}
+			}
+		})
+	}
+}
+
+func TestOrder_ReturnUnits_OrderDiscount(t *testing.T) {
+	o := Order{
+		OrderID: "OrderID",
+		Status:  StatusPlaced,
+		Lines:   []Line{{Sku: "A", UnitPrice: 1000, Qty: 4, OrderDiscount: 400, Total: 3600}},
+		Totals:  Totals{Subtotal: 4000, OrderDiscount: 400, Total: 3600},
+	}
+
+	// the units kept keep their share of the order discount
+	r, err := o.ReturnUnits(map[item.Sku]int{"A": 1}, false, time.Time{})
+	if err != nil || r.Refund != 900 || r.Lines[0].ClawedBack != 0 {
+		t.Fatalf("ReturnUnits() = %+v, %v, want a refund of 900", r, err)
+	}
+
+	r, err = o.ReturnUnits(map[item.Sku]int{"A": 3}, false, time.Time{})
+	if err != nil || r.Refund != 2700 || o.Status != StatusReturned {
+		t.Fatalf("ReturnUnits() = %+v, %v, want a refund of 2700", r, err)
+	}
+}
+
+func TestOrder_ReturnUnits_Status(t *testing.T) {
+	at := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+	o := Order{OrderID: "OrderID", Status: StatusPlaced, Lines: []Line{{Sku: "A", UnitPrice: 100, Qty: 2, Total: 200}}}
+
+	if _, err := o.ReturnUnits(map[item.Sku]int{"A": 1}, false, at); err != nil || o.Status != StatusPlaced {
+		t.Fatalf("partial return: status %v (err %v), want %v", o.Status, err, StatusPlaced)
+	}
+	if _, err := o.ReturnUnits(map[item.Sku]int{"A": 1}, false, at); err != nil || o.Status != StatusReturned {
+		t.Fatalf("full return: status %v (err %v), want %v", o.Status, err, StatusReturned)
+	}
+	if _, err := o.ReturnUnits(map[item.Sku]int{"A": 1}, false, at); err != ErrOrderNotPlaced {
+		t.Fatalf("returning from a returned order: error %v, want %v", err, ErrOrderNotPlaced)
+	}
+	if o.Totals.Refunded != 200 || o.Lines[0].KeptQty() != 0 {
+		t.Fatalf("unexpected order after returns: %+v", o)
+	}
+}
+
+func TestOrder_ReturnUnits_Tax(t *testing.T) {
+	at := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		inclusive bool
+		line      Line
+		want      []int64
+	}{
+		{"exclusive refunds the tax", false, Line{Sku: "A", UnitPrice: 1000, Qty: 2, Tax: 200, Total: 2200}, []int64{1100, 1100}},
+		{"inclusive prices contain the tax", true, Line{Sku: "A", UnitPrice: 1000, Qty: 2, Tax: 182, Total: 2000}, []int64{1000, 1000}},
+		{"exclusive with uneven tax", false, Line{Sku: "A", UnitPrice: 999, Qty: 3, Tax: 300, Total: 3297}, []int64{1099, 1099, 1099}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := Order{OrderID: "OrderID", Status: StatusPlaced, Lines: []Line{tt.line}, Totals: Totals{TaxInclusive: tt.inclusive, Total: tt.line.Total}}
+
+			for i, want := range tt.want {
+				r, err := o.ReturnUnits(map[item.Sku]int{"A": 1}, false, at)
+				if err != nil || r.Refund != want {
+					t.Fatalf("return %d: refund %d (err %v), want %d", i+1, r.Refund, err, want)
+				}
+			}
+			if o.Totals.Refunded != tt.line.Total {
+				t.Fatalf("Refunded = %d, want the whole %d", o.Totals.Refunded, tt.line.Total)
+			}
+		})
+	}
+}
+
+func TestOrder_ReturnUnits_Bundles(t *testing.T) {
+	at := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		lines     []Line
+		returns   []map[item.Sku]int
+		wantLines [][]ReturnLine // lines of each return
+	}{
+		{
+			// any 3 speakers for 25000
+			name: "mix_match lost below a whole bundle",
+			lines: []Line{
+				{Sku: "A304SD", UnitPrice: 10950, Qty: 3, Discount: 7850, Total: 25000,
+					Promotions: []AppliedPromotion{{Promotion: "mix_match", Threshold: 3, Bundles: 1, BundledQty: 3, Discount: 7850}}},
+			},
+			returns: []map[item.Sku]int{{"A304SD": 2}, {"A304SD": 1}},
+			wantLines: [][]ReturnLine{
+				{{Sku: "A304SD", Qty: 2, Refund: 14050, ClawedBack: 2616}},
+				{{Sku: "A304SD", Qty: 1, Refund: 10950}},
+			},
+		},
+		{
+			// any 2 for 1500, twice
+			name: "mix_match kept for the bundles still held",
+			lines: []Line{
+				{Sku: "A", UnitPrice: 1000, Qty: 2, Discount: 500, Total: 1500,
+					Promotions: []AppliedPromotion{{Promotion: "mix_match", Threshold: 2, Bundles: 2, BundledQty: 2, Discount: 500}}},
+				{Sku: "B", UnitPrice: 1000, Qty: 2, Discount: 500, Total: 1500,
+					Promotions: []AppliedPromotion{{Promotion: "mix_match", Threshold: 2, Bundles: 2, BundledQty: 2, Discount: 500}}},
+			},
+			returns: []map[item.Sku]int{{"A": 1}, {"B": 2}, {"A": 1}},
+			wantLines: [][]ReturnLine{
+				{{Sku: "A", Qty: 1, Refund: 500, ClawedBack: 250}},
+				{{Sku: "B", Qty: 2, Refund: 1500, ClawedBack: 250}},
+				{{Sku: "A", Qty: 1, Refund: 1000}},
+			},
+		},
+		{
+			// a MacBook and a Pi for 500000
+			name: "bundle lost by the line kept is taken from the refund",
+			lines: []Line{
+				{Sku: "234234", UnitPrice: 3000, Qty: 1, Discount: 238, Total: 2762,
+					Promotions: []AppliedPromotion{{Promotion: "bundle", Threshold: 2, Bundles: 1, BundledQty: 1, Discount: 238}}},
+				{Sku: "43N23P", UnitPrice: 539999, Qty: 1, Discount: 42761, Total: 497238,
+					Promotions: []AppliedPromotion{{Promotion: "bundle", Threshold: 2, Bundles: 1, BundledQty: 1, Discount: 42761}}},
+			},
+			returns: []map[item.Sku]int{{"234234": 1}, {"43N23P": 1}},
+			wantLines: [][]ReturnLine{
+				{{Sku: "234234", Qty: 1, Refund: 0, ClawedBack: 2762}},
+				{{Sku: "43N23P", Qty: 1, Refund: 500000}},
+			},
+		},
+		{
+			name: "bundle returned whole refunds what was paid",
+			lines: []Line{
+				{Sku: "234234", UnitPrice: 3000, Qty: 1, Discount: 238, Total: 2762,
+					Promotions: []AppliedPromotion{{Promotion: "bundle", Threshold: 2, Bundles: 1, BundledQty: 1, Discount: 238}}},
+				{Sku: "43N23P", UnitPrice: 539999, Qty: 1, Discount: 42761, Total: 497238,
+					Promotions: []AppliedPromotion{{Promotion: "bundle", Threshold: 2, Bundles: 1, BundledQty: 1, Discount: 42761}}},
+			},
+			returns: []map[item.Sku]int{{"234234": 1, "43N23P": 1}},
+			wantLines: [][]ReturnLine{
+				{{Sku: "234234", Qty: 1, Refund: 2762}, {Sku: "43N23P", Qty: 1, Refund: 497238}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := Order{OrderID: "OrderID", Status: StatusPlaced, Lines: tt.lines, Totals: Totals{TaxInclusive: true}}
+
+			var paid int64
+			for _, l := range tt.lines {
+				paid += l.Total
+			}
+
+			for i, units := range tt.returns {
+				r, err := o.ReturnUnits(units, false, at)
+				if err != nil {
+					t.Fatalf("return %d: error = %v", i+1, err)
+				}
+				if !reflect.DeepEqual(r.Lines, tt.wantLines[i]) {
+					t.Errorf("return %d: Lines = %+v, want %+v", i+1, r.Lines, tt.wantLines[i])
+				}
+			}
+			if o.Status != StatusReturned || o.Totals.Refunded != paid {
+				t.Fatalf("status %s, refunded %d, want every unit returned and %d refunded", o.Status, o.Totals.Refunded, paid)
+			}
+		})
+	}
+}
+
+func TestOrder_ReturnUnits_PriceBreaks(t *testing.T) {
+	at := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+
+	// 10 units at 10% off a list price of 1000, 5% off from 5 units
+	breaks := tier.Breaks{{MinQty: 5, Percentage: 0.05}, {MinQty: 10, Percentage: 0.1}}
+	o := Order{
+		OrderID: "OrderID",
+		Status:  StatusPlaced,
+		Lines:   []Line{{Sku: "A", ListPrice: 1000, PriceBreaks: breaks, UnitPrice: 900, Qty: 10, Total: 9000}},
+		Totals:  Totals{Subtotal: 9000, Total: 9000},
+	}
+
+	tests := []struct {
+		units map[item.Sku]int
+		want  ReturnLine
+	}{
+		// the 9 units kept sell at 950
+		{map[item.Sku]int{"A": 1}, ReturnLine{Sku: "A", Qty: 1, Refund: 450, ClawedBack: 450}},
+		// the 4 units kept sell at list price
+		{map[item.Sku]int{"A": 5}, ReturnLine{Sku: "A", Qty: 5, Refund: 4550, ClawedBack: 200}},
+		{map[item.Sku]int{"A": 4}, ReturnLine{Sku: "A", Qty: 4, Refund: 4000}},
+	}
+	for i, tt := range tests {
+		r, err := o.ReturnUnits(tt.units, false, at)
+		if err != nil || len(r.Lines) != 1 || r.Lines[0] != tt.want {
+			t.Fatalf("return %d: Lines = %+v (err %v), want %+v", i+1, r.Lines, err, tt.want)
+		}
+	}
+	if o.Totals.Refunded != 9000 {
+		t.Fatalf("Refunded = %d, want 9000", o.Totals.Refunded)
+	}
+}
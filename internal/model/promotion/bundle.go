@@ -0,0 +1,156 @@
+package promotion
+
+import (
+	"sort"
+
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/utils"
+)
+
+type (
+
+	// BundlePromotion
+	// Describes a promotion where buying one unit of each of some items
+	// sells them together for a bundle price
+	BundlePromotion struct {
+		BundleSkus  []item.Sku
+		BundlePrice int64
+	}
+
+	// MixMatchPromotion
+	// Describes a promotion where buying a number of units of any of some items,
+	// or of any item of a category, sells them together for a bundle price
+	MixMatchPromotion struct {
+		Skus        []item.Sku
+		Category    item.Category
+		BundleQty   int
+		BundlePrice int64
+	}
+
+	// bundled is a purchase and how many of its units are in bundles.
+	bundled struct {
+		purchase PurchasedItem
+		units    int
+	}
+)
+
+// Apply does nothing: the promotion applies to the whole cart, through ApplyToCart.
+func (bP BundlePromotion) Apply(GetPurchasedItemHandler, AddPromoItemToCartHandler, AddDiscountToCartHandler) (err error) {
+	return nil
+}
+
+func (bP BundlePromotion) ApplyToCart(cart CartHandlers) (err error) {
+
+	if len(bP.BundleSkus) == 0 {
+		return nil
+	}
+
+	bySku := make(map[item.Sku]PurchasedItem)
+	for _, p := range cart.GetPurchases() {
+		bySku[p.Sku] = p
+	}
+
+	// as many bundles as the least bought item of the bundle allows
+	bundles := -1
+	for _, sku := range bP.BundleSkus {
+		if p, ok := bySku[sku]; !ok {
+			bundles = 0
+		} else if bundles < 0 || p.Qty < bundles {
+			bundles = p.Qty
+		}
+	}
+
+	if bundles <= 0 {
+		return nil
+	}
+
+	lines := make([]bundled, 0, len(bP.BundleSkus))
+	for _, sku := range bP.BundleSkus {
+		lines = append(lines, bundled{purchase: bySku[sku], units: bundles})
+	}
+
+	return discountBundles(lines, len(bP.BundleSkus), bundles, bP.BundlePrice, cart.AddBundleDiscount)
+}
+
+// Apply does nothing: the promotion applies to the whole cart, through ApplyToCart.
+func (mP MixMatchPromotion) Apply(GetPurchasedItemHandler, AddPromoItemToCartHandler, AddDiscountToCartHandler) (err error) {
+	return nil
+}
+
+func (mP MixMatchPromotion) ApplyToCart(cart CartHandlers) (err error) {
+
+	if mP.BundleQty <= 0 {
+		return nil
+	}
+
+	var eligible []PurchasedItem
+	units := 0
+	for _, p := range cart.GetPurchases() {
+		if mP.matches(p) {
+			eligible = append(eligible, p)
+			units += p.Qty
+		}
+	}
+
+	bundles := units / mP.BundleQty
+
+	if bundles == 0 {
+		return nil
+	}
+
+	// the dearest units go in the bundles first, so the buyer saves the most
+	sort.SliceStable(eligible, func(i, j int) bool { return eligible[i].Price > eligible[j].Price })
+
+	lines := make([]bundled, 0, len(eligible))
+	for left, i := bundles*mP.BundleQty, 0; left > 0; i++ {
+		n := min(eligible[i].Qty, left)
+		lines = append(lines, bundled{purchase: eligible[i], units: n})
+		left -= n
+	}
+
+	return discountBundles(lines, mP.BundleQty, bundles, mP.BundlePrice, cart.AddBundleDiscount)
+}
+
+// matches tells whether a purchase can go in the bundles, by its SKU or its category.
+func (mP MixMatchPromotion) matches(p PurchasedItem) bool {
+	if mP.Category != "" && p.Category == mP.Category {
+		return true
+	}
+	for _, sku := range mP.Skus {
+		if p.Sku == sku {
+			return true
+		}
+	}
+	return false
+}
+
+// discountBundles discounts the bundled units down to the bundle price, spreading the discount over
+// their lines in proportion to what their units cost. Units are valued after the discounts their line
+// already has, so no line is discounted beyond its value; bundles already cheaper are not discounted.
+// Every line of the bundles gets a share, even an empty one, so the bundles are discounted as a whole.
+func discountBundles(lines []bundled, size, bundles int, price int64, addBundleDiscount AddBundleDiscountHandler) error {
+
+	weights := make([]int64, len(lines))
+	var value int64
+
+	for i, l := range lines {
+		net := utils.SaturatingSubInt64(utils.SaturatingMulInt64Int(l.purchase.Price, l.purchase.Qty), l.purchase.Discount)
+		weights[i] = utils.SaturatingMulInt64Int(net, l.units) / int64(l.purchase.Qty)
+		value = utils.SaturatingAddInt64(value, weights[i])
+	}
+
+	discount := utils.SaturatingSubInt64(value, utils.SaturatingMulInt64Int(price, bundles))
+
+	if discount <= 0 {
+		return nil
+	}
+
+	allocated := utils.AllocateInt64(discount, weights)
+	shares := make([]BundleShare, len(lines))
+
+	for i, l := range lines {
+		shares[i] = BundleShare{Sku: l.purchase.Sku, Units: l.units, Discount: allocated[i]}
+	}
+
+	return addBundleDiscount(size, bundles, shares)
+}
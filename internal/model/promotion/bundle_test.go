@@ -0,0 +1,56 @@
+package promotion
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gambarini/flip-shop/internal/model/item"
+)
+
+func TestBundlePromotions_ApplyToCart(t *testing.T) {
+	purchases := []PurchasedItem{
+		{Sku: "A", Category: "c", Price: 4000, Qty: 2, Discount: 1000},
+		{Sku: "B", Category: "c", Price: 3000, Qty: 1},
+		{Sku: "C", Price: 1000, Qty: 3},
+	}
+
+	tests := []struct {
+		name          string
+		promotion     CartPromotion
+		wantDiscounts map[item.Sku]int64
+	}{
+		{"bundle spread over its lines", BundlePromotion{BundleSkus: []item.Sku{"A", "B"}, BundlePrice: 5000}, map[item.Sku]int64{"A": 808, "B": 692}},
+		{"as many bundles as the least bought item", BundlePromotion{BundleSkus: []item.Sku{"A", "C"}, BundlePrice: 4000}, map[item.Sku]int64{"A": 778, "C": 222}},
+		{"bundle missing an item", BundlePromotion{BundleSkus: []item.Sku{"A", "B", "D"}, BundlePrice: 100}, map[item.Sku]int64{}},
+		{"bundle already cheaper", BundlePromotion{BundleSkus: []item.Sku{"A", "B"}, BundlePrice: 7000}, map[item.Sku]int64{}},
+		{"dearest units of a category first", MixMatchPromotion{Category: "c", BundleQty: 2, BundlePrice: 5000}, map[item.Sku]int64{"A": 2000}},
+		{"mix of skus", MixMatchPromotion{Skus: []item.Sku{"B", "C"}, BundleQty: 2, BundlePrice: 1500}, map[item.Sku]int64{"B": 1500, "C": 1500}},
+		{"mix of skus and a category", MixMatchPromotion{Skus: []item.Sku{"C"}, Category: "c", BundleQty: 3, BundlePrice: 6000}, map[item.Sku]int64{"A": 538, "B": 231, "C": 231}},
+		{"too few units to mix", MixMatchPromotion{Category: "c", BundleQty: 4, BundlePrice: 100}, map[item.Sku]int64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discounts := map[item.Sku]int64{}
+			err := tt.promotion.ApplyToCart(CartHandlers{
+				GetPurchases: func() []PurchasedItem { return purchases },
+				AddBundleDiscount: func(_, _ int, shares []BundleShare) error {
+					for _, s := range shares {
+						if s.Discount != 0 {
+							discounts[s.Sku] += s.Discount
+						}
+					}
+					return nil
+				},
+			})
+			if err != nil {
+				t.Fatalf("ApplyToCart() error = %v", err)
+			}
+			if !reflect.DeepEqual(discounts, tt.wantDiscounts) {
+				t.Fatalf("discounts = %v, want %v", discounts, tt.wantDiscounts)
+			}
+			if err := tt.promotion.Apply(nil, nil, nil); err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+		})
+	}
+}
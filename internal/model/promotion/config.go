@@ -0,0 +1,320 @@
+package promotion
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrUnknownType is returned when loading a promotion of a type no builder is registered for.
+	ErrUnknownType = errors.New("unknown promotion type")
+	// ErrInvalidPromotion is returned when loading a promotion with missing or out of range fields.
+	ErrInvalidPromotion = errors.New("invalid promotion")
+	// ErrUnknownSku is returned when a promotion refers to an item the store does not sell.
+	ErrUnknownSku = errors.New("unknown sku")
+)
+
+type (
+	// Definition is the declarative form of a promotion, as written in a promotions file.
+	// Type selects the kind of promotion, and so which of the other fields it reads:
+	//
+	//	free_item:      buying Sku gives FreeSku, discounted by FreePrice
+	//	qty_free:       every Qty units of Sku bought, one is free
+	//	qty_percentage: buying more than Qty units of Sku discounts them by Percentage, a fraction
+	//	spend_percentage:    spending at least MinSpend discounts the order by Percentage, a fraction
+	//	spend_free_shipping: spending at least MinSpend makes shipping free
+	//	spend_amount_off:    spending at least MinSpend takes Amount off the order
+	//	bundle:    buying one unit of each of SkuSet sells them together for Price
+	//	mix_match: every Qty units bought of any of SkuSet, or of any item of Category, sell together for Price
+	//
+	// What a cart spends is the value of its purchases after their discounts; amounts are in cents.
+	// A bundle discount is spread over the lines of its units, in proportion to what they cost.
+	//
+	// Any promotion applies from ValidFrom, inclusive, until ValidUntil, exclusive, a missing (or zero)
+	// time leaving that side open, and only within its Schedule when it has one.
+	//
+	// Promotions of higher Priority apply first. At most one promotion of an exclusivity Group applies
+	// to a cart, and once a promotion flagged to Stop applies, no other applies to the lines it changed.
+	Definition struct {
+		Type       string        `json:"type" yaml:"type"`
+		Sku        item.Sku      `json:"sku,omitempty" yaml:"sku,omitempty"`
+		Qty        int           `json:"qty,omitempty" yaml:"qty,omitempty"`
+		FreeSku    item.Sku      `json:"free_sku,omitempty" yaml:"free_sku,omitempty"`
+		FreePrice  int64         `json:"free_price,omitempty" yaml:"free_price,omitempty"`
+		Percentage float64       `json:"percentage,omitempty" yaml:"percentage,omitempty"`
+		MinSpend   int64         `json:"min_spend,omitempty" yaml:"min_spend,omitempty"`
+		Amount     int64         `json:"amount,omitempty" yaml:"amount,omitempty"`
+		SkuSet     []item.Sku    `json:"skus,omitempty" yaml:"skus,omitempty"`
+		Category   item.Category `json:"category,omitempty" yaml:"category,omitempty"`
+		Price      int64         `json:"price,omitempty" yaml:"price,omitempty"`
+		ValidFrom  *time.Time    `json:"valid_from,omitempty" yaml:"valid_from,omitempty"`
+		ValidUntil *time.Time    `json:"valid_until,omitempty" yaml:"valid_until,omitempty"`
+		Schedule   *Schedule     `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+		Priority   int           `json:"priority,omitempty" yaml:"priority,omitempty"`
+		Group      string        `json:"group,omitempty" yaml:"group,omitempty"`
+		Stop       bool          `json:"stop,omitempty" yaml:"stop,omitempty"`
+	}
+
+	// Builder validates a Definition and builds its Promotion.
+	Builder func(d Definition) (Promotion, error)
+
+	// Format is the encoding of a promotions file.
+	Format string
+
+	// SkuExists tells whether the store sells an item.
+	SkuExists func(sku item.Sku) bool
+)
+
+// Formats of a promotions file.
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+)
+
+// builders holds the Builder of each promotion type, by name.
+var builders = map[string]Builder{
+	NameFreeItem:      buildFreeItem,
+	NameQtyFree:       buildQtyFree,
+	NameQtyPercentage: buildQtyPercentage,
+
+	NameSpendPercentage:   buildSpendPercentage,
+	NameSpendFreeShipping: buildSpendFreeShipping,
+	NameSpendAmountOff:    buildSpendAmountOff,
+
+	NameBundle:   buildBundle,
+	NameMixMatch: buildMixMatch,
+}
+
+// RegisterType makes promotions of a new type loadable. It panics when the type is already registered.
+func RegisterType(name string, b Builder) {
+	if _, ok := builders[name]; ok {
+		panic(fmt.Sprintf("promotion type %q is already registered", name))
+	}
+	builders[name] = b
+}
+
+// Build validates a Definition and builds its Promotion.
+func (d Definition) Build() (Promotion, error) {
+	b, ok := builders[d.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownType, d.Type)
+	}
+	if err := d.validateWindow(); err != nil {
+		return nil, err
+	}
+	return b(d)
+}
+
+// Skus returns the items a Definition refers to.
+func (d Definition) Skus() []item.Sku {
+	var skus []item.Sku
+	for _, sku := range append([]item.Sku{d.Sku, d.FreeSku}, d.SkuSet...) {
+		if sku != "" {
+			skus = append(skus, sku)
+		}
+	}
+	return skus
+}
+
+// Build validates definitions and builds their promotions, in order. When exists is not nil,
+// every item a promotion refers to must exist. Errors name the position and type of the failing definition.
+func Build(defs []Definition, exists SkuExists) ([]Promotion, error) {
+
+	promotions := make([]Promotion, 0, len(defs))
+
+	for i, d := range defs {
+
+		p, err := d.Build()
+
+		if err == nil && exists != nil {
+			for _, sku := range d.Skus() {
+				if !exists(sku) {
+					err = fmt.Errorf("%w: %q", ErrUnknownSku, sku)
+					break
+				}
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("promotion %d (%s): %w", i, d.Type, err)
+		}
+
+		promotions = append(promotions, p)
+	}
+
+	return promotions, nil
+}
+
+// Validate checks that definitions build into promotions, using Build.
+func Validate(defs []Definition, exists SkuExists) error {
+	_, err := Build(defs, exists)
+	return err
+}
+
+// Load decodes and validates a list of promotion definitions in the given format:
+//
+//	[{"type": "free_item", "sku": "43N23P", "free_sku": "234234", "free_price": 3000},
+//	 {"type": "qty_free", "sku": "120P90", "qty": 3},
+//	 {"type": "qty_percentage", "sku": "A304SD", "qty": 3, "percentage": 0.1}]
+func Load(r io.Reader, format Format, exists SkuExists) ([]Definition, error) {
+
+	var defs []Definition
+
+	switch format {
+	case FormatJSON:
+		dec := json.NewDecoder(r)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&defs); err != nil {
+			return nil, fmt.Errorf("invalid promotions: %w", err)
+		}
+	case FormatYAML:
+		dec := yaml.NewDecoder(r)
+		dec.KnownFields(true)
+		if err := dec.Decode(&defs); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("invalid promotions: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown promotions format %q", format)
+	}
+
+	if err := Validate(defs, exists); err != nil {
+		return nil, err
+	}
+
+	return defs, nil
+}
+
+// LoadFile reads the promotions file at path, in YAML when its extension is .yaml or .yml and in JSON otherwise.
+func LoadFile(path string, exists SkuExists) ([]Definition, error) {
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	format := FormatJSON
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		format = FormatYAML
+	}
+
+	return Load(f, format, exists)
+}
+
+func buildFreeItem(d Definition) (Promotion, error) {
+	switch {
+	case d.Sku == "":
+		return nil, fmt.Errorf("%w: sku is required", ErrInvalidPromotion)
+	case d.FreeSku == "":
+		return nil, fmt.Errorf("%w: free_sku is required", ErrInvalidPromotion)
+	case d.FreePrice < 0:
+		return nil, fmt.Errorf("%w: free_price must not be negative", ErrInvalidPromotion)
+	}
+	return FreeItemPromotion{PurchasedItemSku: d.Sku, FreeItemSku: d.FreeSku, FreeItemPrice: d.FreePrice}, nil
+}
+
+func buildQtyFree(d Definition) (Promotion, error) {
+	switch {
+	case d.Sku == "":
+		return nil, fmt.Errorf("%w: sku is required", ErrInvalidPromotion)
+	case d.Qty <= 0:
+		return nil, fmt.Errorf("%w: qty must be positive", ErrInvalidPromotion)
+	}
+	return ItemQtyPriceFreePromotion{PurchasedItemSku: d.Sku, PurchasedQty: d.Qty}, nil
+}
+
+func buildQtyPercentage(d Definition) (Promotion, error) {
+	switch {
+	case d.Sku == "":
+		return nil, fmt.Errorf("%w: sku is required", ErrInvalidPromotion)
+	case d.Qty < 0:
+		return nil, fmt.Errorf("%w: qty must not be negative", ErrInvalidPromotion)
+	case d.Percentage <= 0 || d.Percentage > 1:
+		return nil, fmt.Errorf("%w: percentage must be a fraction in (0, 1]", ErrInvalidPromotion)
+	}
+	return ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: d.Sku, PurchasedQty: d.Qty, PercentageDiscount: float32(d.Percentage)}, nil
+}
+
+func buildSpendPercentage(d Definition) (Promotion, error) {
+	switch {
+	case d.MinSpend < 0:
+		return nil, fmt.Errorf("%w: min_spend must not be negative", ErrInvalidPromotion)
+	case d.Percentage <= 0 || d.Percentage > 1:
+		return nil, fmt.Errorf("%w: percentage must be a fraction in (0, 1]", ErrInvalidPromotion)
+	}
+	return SpendPercentagePromotion{MinSpend: d.MinSpend, PercentageDiscount: d.Percentage}, nil
+}
+
+func buildSpendFreeShipping(d Definition) (Promotion, error) {
+	if d.MinSpend < 0 {
+		return nil, fmt.Errorf("%w: min_spend must not be negative", ErrInvalidPromotion)
+	}
+	return SpendFreeShippingPromotion{MinSpend: d.MinSpend}, nil
+}
+
+func buildSpendAmountOff(d Definition) (Promotion, error) {
+	switch {
+	case d.MinSpend < 0:
+		return nil, fmt.Errorf("%w: min_spend must not be negative", ErrInvalidPromotion)
+	case d.Amount <= 0:
+		return nil, fmt.Errorf("%w: amount must be positive", ErrInvalidPromotion)
+	}
+	return SpendAmountOffPromotion{MinSpend: d.MinSpend, Amount: d.Amount}, nil
+}
+
+func buildBundle(d Definition) (Promotion, error) {
+	if len(d.SkuSet) < 2 {
+		return nil, fmt.Errorf("%w: skus must list at least 2 items", ErrInvalidPromotion)
+	}
+	if err := validateSkus(d.SkuSet); err != nil {
+		return nil, err
+	}
+	if d.Price < 0 {
+		return nil, fmt.Errorf("%w: price must not be negative", ErrInvalidPromotion)
+	}
+	return BundlePromotion{BundleSkus: append([]item.Sku(nil), d.SkuSet...), BundlePrice: d.Price}, nil
+}
+
+func buildMixMatch(d Definition) (Promotion, error) {
+	if len(d.SkuSet) == 0 && d.Category == "" {
+		return nil, fmt.Errorf("%w: skus or category is required", ErrInvalidPromotion)
+	}
+	if err := validateSkus(d.SkuSet); err != nil {
+		return nil, err
+	}
+	switch {
+	case d.Qty <= 0:
+		return nil, fmt.Errorf("%w: qty must be positive", ErrInvalidPromotion)
+	case d.Price < 0:
+		return nil, fmt.Errorf("%w: price must not be negative", ErrInvalidPromotion)
+	}
+	return MixMatchPromotion{Skus: append([]item.Sku(nil), d.SkuSet...), Category: d.Category, BundleQty: d.Qty, BundlePrice: d.Price}, nil
+}
+
+// validateSkus checks that a list of items has no blank or repeated SKU.
+func validateSkus(skus []item.Sku) error {
+	seen := make(map[item.Sku]bool, len(skus))
+	for _, sku := range skus {
+		switch {
+		case sku == "":
+			return fmt.Errorf("%w: skus must not be blank", ErrInvalidPromotion)
+		case seen[sku]:
+			return fmt.Errorf("%w: sku %q is listed twice", ErrInvalidPromotion, sku)
+		}
+		seen[sku] = true
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package promotion
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gambarini/flip-shop/internal/model/item"
+)
+
+func TestLoad(t *testing.T) {
+	exists := func(sku item.Sku) bool { return sku == "A" || sku == "B" }
+
+	want := []Promotion{
+		FreeItemPromotion{PurchasedItemSku: "A", FreeItemSku: "B", FreeItemPrice: 300},
+		ItemQtyPriceFreePromotion{PurchasedItemSku: "A", PurchasedQty: 3},
+		ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: "B", PurchasedQty: 2, PercentageDiscount: 0.1},
+	}
+
+	tests := []struct {
+		name    string
+		format  Format
+		input   string
+		want    []Promotion
+		wantErr error
+	}{
+		{"json", FormatJSON, `[
+			{"type": "free_item", "sku": "A", "free_sku": "B", "free_price": 300},
+			{"type": "qty_free", "sku": "A", "qty": 3},
+			{"type": "qty_percentage", "sku": "B", "qty": 2, "percentage": 0.1}]`, want, nil},
+		{"yaml", FormatYAML, `
+- type: free_item
+  sku: A
+  free_sku: B
+  free_price: 300
+- {type: qty_free, sku: A, qty: 3}
+- {type: qty_percentage, sku: B, qty: 2, percentage: 0.1}
+`, want, nil},
+		{"empty yaml", FormatYAML, "", []Promotion{}, nil},
+		{"unknown type", FormatJSON, `[{"type": "bogo", "sku": "A"}]`, nil, ErrUnknownType},
+		{"unknown sku", FormatJSON, `[{"type": "qty_free", "sku": "A", "qty": 3}, {"type": "free_item", "sku": "A", "free_sku": "Z"}]`, nil, ErrUnknownSku},
+		{"missing sku", FormatYAML, `[{type: qty_free, qty: 3}]`, nil, ErrInvalidPromotion},
+		{"zero qty", FormatJSON, `[{"type": "qty_free", "sku": "A"}]`, nil, ErrInvalidPromotion},
+		{"percentage over 1", FormatJSON, `[{"type": "qty_percentage", "sku": "A", "percentage": 10}]`, nil, ErrInvalidPromotion},
+		{"negative free price", FormatJSON, `[{"type": "free_item", "sku": "A", "free_sku": "B", "free_price": -1}]`, nil, ErrInvalidPromotion},
+		{"spend promotions", FormatYAML, `
+- {type: spend_percentage, min_spend: 10000, percentage: 0.1}
+- {type: spend_free_shipping, min_spend: 50000}
+- {type: spend_amount_off, amount: 500}
+`, []Promotion{
+			SpendPercentagePromotion{MinSpend: 10000, PercentageDiscount: 0.1},
+			SpendFreeShippingPromotion{MinSpend: 50000},
+			SpendAmountOffPromotion{Amount: 500},
+		}, nil},
+		{"negative min spend", FormatJSON, `[{"type": "spend_free_shipping", "min_spend": -1}]`, nil, ErrInvalidPromotion},
+		{"no amount off", FormatJSON, `[{"type": "spend_amount_off", "min_spend": 100}]`, nil, ErrInvalidPromotion},
+		{"bundle promotions", FormatYAML, `
+- {type: bundle, skus: [A, B], price: 2500}
+- {type: mix_match, category: smart_speaker, qty: 3, price: 25000}
+- {type: mix_match, skus: [B], qty: 2}
+`, []Promotion{
+			BundlePromotion{BundleSkus: []item.Sku{"A", "B"}, BundlePrice: 2500},
+			MixMatchPromotion{Category: "smart_speaker", BundleQty: 3, BundlePrice: 25000},
+			MixMatchPromotion{Skus: []item.Sku{"B"}, BundleQty: 2},
+		}, nil},
+		{"bundle of one item", FormatJSON, `[{"type": "bundle", "skus": ["A"], "price": 100}]`, nil, ErrInvalidPromotion},
+		{"bundle listing an item twice", FormatJSON, `[{"type": "bundle", "skus": ["A", "A"], "price": 100}]`, nil, ErrInvalidPromotion},
+		{"bundle of an unknown sku", FormatJSON, `[{"type": "bundle", "skus": ["A", "Z"], "price": 100}]`, nil, ErrUnknownSku},
+		{"mix and match of nothing", FormatJSON, `[{"type": "mix_match", "qty": 3, "price": 100}]`, nil, ErrInvalidPromotion},
+		{"mix and match without qty", FormatJSON, `[{"type": "mix_match", "category": "c", "price": 100}]`, nil, ErrInvalidPromotion},
+		{"negative bundle price", FormatJSON, `[{"type": "mix_match", "category": "c", "qty": 2, "price": -1}]`, nil, ErrInvalidPromotion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defs, err := Load(strings.NewReader(tt.input), tt.format, exists)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Load() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if got, err := Build(defs, nil); err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Build() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+
+	// errors point at the failing definition
+	_, err := Load(strings.NewReader(`[{"type": "qty_free", "sku": "A", "qty": 3}, {"type": "free_item", "sku": "A", "free_sku": "Z"}]`), FormatJSON, exists)
+	if err == nil || err.Error() != `promotion 1 (free_item): unknown sku: "Z"` {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// unknown fields and formats are rejected
+	if _, err := Load(strings.NewReader(`[{"type": "qty_free", "sku": "A", "qty": 3, "limit": 1}]`), FormatJSON, nil); err == nil {
+		t.Fatal("Load() accepted an unknown field")
+	}
+	if _, err := Load(strings.NewReader(`[]`), "toml", nil); err == nil {
+		t.Fatal("Load() accepted an unknown format")
+	}
+}
+
+func TestRegisterType(t *testing.T) {
+	RegisterType("test_noop", func(d Definition) (Promotion, error) {
+		return ItemQtyPriceFreePromotion{PurchasedItemSku: d.Sku}, nil
+	})
+	defer delete(builders, "test_noop")
+
+	got, err := Build([]Definition{{Type: "test_noop", Sku: "A"}}, nil)
+	if err != nil || len(got) != 1 {
+		t.Fatalf("Build() = %v, %v", got, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("RegisterType() of a registered type did not panic")
+		}
+	}()
+	RegisterType(NameQtyFree, buildQtyFree)
+}
@@ -0,0 +1,186 @@
+package promotion
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/gambarini/flip-shop/internal/model/item"
+)
+
+type (
+	// Shortfall is how far purchases are from a promotion: Qty more units, one of each item of Skus or,
+	// when Skus is empty, of any item the promotion counts, or Spend more cents.
+	Shortfall struct {
+		Qty   int
+		Skus  []item.Sku
+		Spend int64
+	}
+
+	// NameOf names an item in words.
+	NameOf func(sku item.Sku) string
+)
+
+// Describe returns what a promotion offers in words, naming items with name, or by SKU when name is nil.
+// Amounts are written in whole currency units with cents. Unknown implementations are described by their Name.
+func Describe(p Promotion, name NameOf) string {
+
+	if name == nil {
+		name = func(sku item.Sku) string { return string(sku) }
+	}
+
+	switch v := p.(type) {
+	case FreeItemPromotion:
+		return fmt.Sprintf("every %s bought adds a %s, %s off", name(v.PurchasedItemSku), name(v.FreeItemSku), formatCents(v.FreeItemPrice))
+	case ItemQtyPriceFreePromotion:
+		return fmt.Sprintf("every %d %s bought, one is free", v.PurchasedQty, name(v.PurchasedItemSku))
+	case ItemQtyPriceDiscountPercentagePromotion:
+		return fmt.Sprintf("buying more than %d %s takes %s off them", v.PurchasedQty, name(v.PurchasedItemSku), formatPercentage(float64(v.PercentageDiscount)))
+	case SpendPercentagePromotion:
+		return fmt.Sprintf("spending at least %s takes %s off the order", formatCents(v.MinSpend), formatPercentage(v.PercentageDiscount))
+	case SpendFreeShippingPromotion:
+		return fmt.Sprintf("spending at least %s makes shipping free", formatCents(v.MinSpend))
+	case SpendAmountOffPromotion:
+		return fmt.Sprintf("spending at least %s takes %s off the order", formatCents(v.MinSpend), formatCents(v.Amount))
+	case BundlePromotion:
+		return fmt.Sprintf("%s together for %s", joinNames(v.BundleSkus, name, "and"), formatCents(v.BundlePrice))
+	case MixMatchPromotion:
+		of := joinNames(v.Skus, name, "or")
+		switch {
+		case v.Category != "" && of != "":
+			of = fmt.Sprintf("%s items or %s", v.Category, of)
+		case v.Category != "":
+			of = fmt.Sprintf("%s items", v.Category)
+		}
+		return fmt.Sprintf("any %d %s for %s", v.BundleQty, of, formatCents(v.BundlePrice))
+	default:
+		return Name(p)
+	}
+}
+
+// Skus returns the items a promotion refers to, those Describe names, in the order it names them.
+func Skus(p Promotion) []item.Sku {
+	switch v := p.(type) {
+	case FreeItemPromotion:
+		return []item.Sku{v.PurchasedItemSku, v.FreeItemSku}
+	case ItemQtyPriceFreePromotion:
+		return []item.Sku{v.PurchasedItemSku}
+	case ItemQtyPriceDiscountPercentagePromotion:
+		return []item.Sku{v.PurchasedItemSku}
+	case BundlePromotion:
+		return v.BundleSkus
+	case MixMatchPromotion:
+		return v.Skus
+	default:
+		return nil
+	}
+}
+
+// ShortfallOf returns how far purchases are from a promotion they do not qualify for yet. ok is false
+// when they already qualify, when they hold nothing the promotion counts, or for kinds of promotions
+// whose conditions are unknown. A free item promotion qualifies with any purchase of its item, so it
+// never falls short.
+func ShortfallOf(p Promotion, purchases []PurchasedItem) (s Shortfall, ok bool) {
+
+	bySku := make(map[item.Sku]PurchasedItem, len(purchases))
+	for _, pu := range purchases {
+		bySku[pu.Sku] = pu
+	}
+
+	switch v := p.(type) {
+	case ItemQtyPriceFreePromotion:
+		if qty := bySku[v.PurchasedItemSku].Qty; qty > 0 && qty < v.PurchasedQty {
+			return Shortfall{Qty: v.PurchasedQty - qty, Skus: []item.Sku{v.PurchasedItemSku}}, true
+		}
+	case ItemQtyPriceDiscountPercentagePromotion:
+		if qty := bySku[v.PurchasedItemSku].Qty; qty > 0 && qty <= v.PurchasedQty {
+			return Shortfall{Qty: v.PurchasedQty + 1 - qty, Skus: []item.Sku{v.PurchasedItemSku}}, true
+		}
+	case SpendPercentagePromotion:
+		return spendShortfall(v.MinSpend, purchases)
+	case SpendFreeShippingPromotion:
+		return spendShortfall(v.MinSpend, purchases)
+	case SpendAmountOffPromotion:
+		return spendShortfall(v.MinSpend, purchases)
+	case BundlePromotion:
+		var missing []item.Sku
+		for _, sku := range v.BundleSkus {
+			if bySku[sku].Qty == 0 {
+				missing = append(missing, sku)
+			}
+		}
+		if len(missing) > 0 && len(missing) < len(v.BundleSkus) {
+			return Shortfall{Qty: len(missing), Skus: missing}, true
+		}
+	case MixMatchPromotion:
+		units := 0
+		for _, pu := range purchases {
+			if v.matches(pu) {
+				units += pu.Qty
+			}
+		}
+		if units > 0 && units < v.BundleQty {
+			return Shortfall{Qty: v.BundleQty - units}, true
+		}
+	}
+
+	return Shortfall{}, false
+}
+
+// Describe writes how far purchases are from what a promotion offers, naming items with name, e.g.
+// "You are 1 item away from: every 3 Google Home bought, one is free (add Google Home)".
+func (s Shortfall) Describe(offer string, name NameOf) string {
+
+	if name == nil {
+		name = func(sku item.Sku) string { return string(sku) }
+	}
+
+	if s.Spend > 0 {
+		return fmt.Sprintf("You are %s away from: %s", formatCents(s.Spend), offer)
+	}
+
+	items := "items"
+	if s.Qty == 1 {
+		items = "item"
+	}
+
+	if len(s.Skus) == 0 {
+		return fmt.Sprintf("You are %d %s away from: %s", s.Qty, items, offer)
+	}
+
+	return fmt.Sprintf("You are %d %s away from: %s (add %s)", s.Qty, items, offer, joinNames(s.Skus, name, "and"))
+}
+
+func spendShortfall(minSpend int64, purchases []PurchasedItem) (Shortfall, bool) {
+	if spend := Spend(purchases); spend > 0 && spend < minSpend {
+		return Shortfall{Spend: minSpend - spend}, true
+	}
+	return Shortfall{}, false
+}
+
+// formatCents writes an amount of cents in whole currency units, e.g. 4999 as "49.99".
+func formatCents(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// formatPercentage writes a fraction as a percentage, e.g. 0.125 as "12.5%".
+func formatPercentage(f float64) string {
+	return strconv.FormatFloat(math.Round(f*10000)/100, 'f', -1, 64) + "%"
+}
+
+// joinNames names items in a list, the last one joined with conjunction.
+func joinNames(skus []item.Sku, name NameOf, conjunction string) string {
+	names := make([]string, 0, len(skus))
+	for _, sku := range skus {
+		names = append(names, name(sku))
+	}
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " " + conjunction + " " + names[len(names)-1]
+}
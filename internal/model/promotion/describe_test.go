@@ -0,0 +1,114 @@
+package promotion
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gambarini/flip-shop/internal/model/item"
+)
+
+func TestDescribe(t *testing.T) {
+	names := map[item.Sku]string{"A": "Google Home", "B": "Alexa Speaker", "C": "Raspberry Pi"}
+	name := func(sku item.Sku) string { return names[sku] }
+
+	tests := []struct {
+		name      string
+		promotion Promotion
+		want      string
+	}{
+		{"free item", FreeItemPromotion{PurchasedItemSku: "A", FreeItemSku: "C", FreeItemPrice: 3000}, "every Google Home bought adds a Raspberry Pi, 30.00 off"},
+		{"qty free", ItemQtyPriceFreePromotion{PurchasedItemSku: "A", PurchasedQty: 3}, "every 3 Google Home bought, one is free"},
+		{"qty percentage", ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: "B", PurchasedQty: 3, PercentageDiscount: 0.1}, "buying more than 3 Alexa Speaker takes 10% off them"},
+		{"spend percentage", SpendPercentagePromotion{MinSpend: 15000, PercentageDiscount: 0.125}, "spending at least 150.00 takes 12.5% off the order"},
+		{"spend free shipping", SpendFreeShippingPromotion{MinSpend: 5000}, "spending at least 50.00 makes shipping free"},
+		{"spend amount off", SpendAmountOffPromotion{MinSpend: 10000, Amount: 1005}, "spending at least 100.00 takes 10.05 off the order"},
+		{"bundle", BundlePromotion{BundleSkus: []item.Sku{"A", "B", "C"}, BundlePrice: 15000}, "Google Home, Alexa Speaker and Raspberry Pi together for 150.00"},
+		{"mix and match of a category", MixMatchPromotion{Category: "smart_speaker", BundleQty: 3, BundlePrice: 25000}, "any 3 smart_speaker items for 250.00"},
+		{"mix and match of items", MixMatchPromotion{Skus: []item.Sku{"A", "B"}, Category: "toy", BundleQty: 2, BundlePrice: 9000}, "any 2 toy items or Google Home or Alexa Speaker for 90.00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Describe(tt.promotion, name); got != tt.want {
+				t.Errorf("Describe() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := Describe(ItemQtyPriceFreePromotion{PurchasedItemSku: "A", PurchasedQty: 2}, nil); got != "every 2 A bought, one is free" {
+		t.Errorf("Describe() without names = %q", got)
+	}
+}
+
+func TestSkus(t *testing.T) {
+	tests := []struct {
+		name      string
+		promotion Promotion
+		want      []item.Sku
+	}{
+		{"free item", FreeItemPromotion{PurchasedItemSku: "A", FreeItemSku: "C"}, []item.Sku{"A", "C"}},
+		{"qty percentage", ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: "B"}, []item.Sku{"B"}},
+		{"bundle", BundlePromotion{BundleSkus: []item.Sku{"A", "B"}}, []item.Sku{"A", "B"}},
+		{"mix and match of a category", MixMatchPromotion{Category: "smart_speaker", BundleQty: 3}, nil},
+		{"spend", SpendAmountOffPromotion{MinSpend: 100, Amount: 10}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Skus(tt.promotion); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Skus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShortfallOf(t *testing.T) {
+	purchases := []PurchasedItem{
+		{Sku: "A", Category: "smart_speaker", Price: 5000, Qty: 2},
+		{Sku: "C", Price: 3000, Qty: 1, Discount: 1000},
+	}
+
+	tests := []struct {
+		name      string
+		promotion Promotion
+		want      Shortfall
+		wantOk    bool
+	}{
+		{"qty free short", ItemQtyPriceFreePromotion{PurchasedItemSku: "A", PurchasedQty: 3}, Shortfall{Qty: 1, Skus: []item.Sku{"A"}}, true},
+		{"qty free reached", ItemQtyPriceFreePromotion{PurchasedItemSku: "A", PurchasedQty: 2}, Shortfall{}, false},
+		{"qty free of an item not bought", ItemQtyPriceFreePromotion{PurchasedItemSku: "B", PurchasedQty: 3}, Shortfall{}, false},
+		{"qty percentage needs more than its qty", ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: "A", PurchasedQty: 2}, Shortfall{Qty: 1, Skus: []item.Sku{"A"}}, true},
+		{"spend after discounts", SpendAmountOffPromotion{MinSpend: 15000, Amount: 500}, Shortfall{Spend: 3000}, true},
+		{"spend reached", SpendFreeShippingPromotion{MinSpend: 12000}, Shortfall{}, false},
+		{"bundle missing an item", BundlePromotion{BundleSkus: []item.Sku{"A", "B", "C"}}, Shortfall{Qty: 1, Skus: []item.Sku{"B"}}, true},
+		{"bundle of items not bought", BundlePromotion{BundleSkus: []item.Sku{"B", "D"}}, Shortfall{}, false},
+		{"mix and match short", MixMatchPromotion{Category: "smart_speaker", BundleQty: 3}, Shortfall{Qty: 1}, true},
+		{"free item never falls short", FreeItemPromotion{PurchasedItemSku: "B", FreeItemSku: "C"}, Shortfall{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ShortfallOf(tt.promotion, purchases)
+			if ok != tt.wantOk || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ShortfallOf() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestShortfall_Describe(t *testing.T) {
+	tests := []struct {
+		name      string
+		shortfall Shortfall
+		want      string
+	}{
+		{"one item", Shortfall{Qty: 1, Skus: []item.Sku{"A"}}, "You are 1 item away from: the deal (add A)"},
+		{"items of a bundle", Shortfall{Qty: 2, Skus: []item.Sku{"A", "B"}}, "You are 2 items away from: the deal (add A and B)"},
+		{"any items", Shortfall{Qty: 2}, "You are 2 items away from: the deal"},
+		{"spend", Shortfall{Spend: 1234}, "You are 12.34 away from: the deal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.shortfall.Describe("the deal", nil); got != tt.want {
+				t.Errorf("Describe() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
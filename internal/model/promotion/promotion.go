@@ -1,6 +1,8 @@
 package promotion
 
 import (
+	"fmt"
+
 	"github.com/gambarini/flip-shop/internal/model/item"
 )
 
@@ -15,6 +17,7 @@ type (
 	PurchasedItem struct {
 		Sku      item.Sku
 		Name     string
+		Category item.Category
 		Price    int64
 		Qty      int
 		Discount int64
@@ -31,4 +34,132 @@ type (
 	// AddDiscountToCartHandler
 	// Delegates the ability to add discounts to a cart
 	AddDiscountToCartHandler func(discountItemSku item.Sku, discount int64) error
+
+	// CartPromotion
+	// Defines a promotion that sees the whole cart rather than the purchases of some SKUs.
+	// Besides changing purchases, it can discount the order as a whole or waive its shipping.
+	// Apply is not called on a CartPromotion; ApplyToCart is.
+	CartPromotion interface {
+		Promotion
+		ApplyToCart(cart CartHandlers) (err error)
+	}
+
+	// CartHandlers
+	// Delegates the ability to see every purchase of a cart and to change the cart
+	CartHandlers struct {
+		GetPurchases      GetPurchasesHandler
+		AddPromoItem      AddPromoItemToCartHandler
+		AddDiscount       AddDiscountToCartHandler
+		AddBundleDiscount AddBundleDiscountHandler
+		AddOrderDiscount  AddOrderDiscountHandler
+		WaiveShipping     WaiveShippingHandler
+	}
+
+	// BundleShare
+	// Describes the units of a purchase sold in bundles and the part of the bundle discount they get
+	BundleShare struct {
+		Sku      item.Sku
+		Units    int
+		Discount int64
+	}
+
+	// AddBundleDiscountHandler
+	// Delegates the ability to discount purchases sold together in bundles of size units, all of them or none
+	AddBundleDiscountHandler func(size, bundles int, shares []BundleShare) error
+
+	// GetPurchasesHandler
+	// Delegates the ability to find every purchased item in the cart, in SKU order
+	GetPurchasesHandler func() []PurchasedItem
+
+	// AddOrderDiscountHandler
+	// Delegates the ability to discount the order as a whole rather than some of its purchases
+	AddOrderDiscountHandler func(discount int64) error
+
+	// WaiveShippingHandler
+	// Delegates the ability to make the shipping of the order free
+	WaiveShippingHandler func() error
 )
+
+const (
+	// NameFreeItem identifies FreeItemPromotion.
+	NameFreeItem = "free_item"
+	// NameQtyFree identifies ItemQtyPriceFreePromotion.
+	NameQtyFree = "qty_free"
+	// NameQtyPercentage identifies ItemQtyPriceDiscountPercentagePromotion.
+	NameQtyPercentage = "qty_percentage"
+	// NameSpendPercentage identifies SpendPercentagePromotion.
+	NameSpendPercentage = "spend_percentage"
+	// NameSpendFreeShipping identifies SpendFreeShippingPromotion.
+	NameSpendFreeShipping = "spend_free_shipping"
+	// NameSpendAmountOff identifies SpendAmountOffPromotion.
+	NameSpendAmountOff = "spend_amount_off"
+	// NameBundle identifies BundlePromotion.
+	NameBundle = "bundle"
+	// NameMixMatch identifies MixMatchPromotion.
+	NameMixMatch = "mix_match"
+)
+
+// Name returns a stable identifier of the promotion kind, used to record which
+// promotions were applied to an order. Unknown implementations are named after their type.
+func Name(p Promotion) string {
+	switch p.(type) {
+	case FreeItemPromotion, *FreeItemPromotion:
+		return NameFreeItem
+	case ItemQtyPriceFreePromotion, *ItemQtyPriceFreePromotion:
+		return NameQtyFree
+	case ItemQtyPriceDiscountPercentagePromotion, *ItemQtyPriceDiscountPercentagePromotion:
+		return NameQtyPercentage
+	case SpendPercentagePromotion, *SpendPercentagePromotion:
+		return NameSpendPercentage
+	case SpendFreeShippingPromotion, *SpendFreeShippingPromotion:
+		return NameSpendFreeShipping
+	case SpendAmountOffPromotion, *SpendAmountOffPromotion:
+		return NameSpendAmountOff
+	case BundlePromotion, *BundlePromotion:
+		return NameBundle
+	case MixMatchPromotion, *MixMatchPromotion:
+		return NameMixMatch
+	default:
+		return fmt.Sprintf("%T", p)
+	}
+}
+
+// Threshold returns the purchased quantity a promotion is conditioned on, or 0 when it has none;
+// for bundles it is the number of units a bundle is made of.
+// It is recorded on orders so returns can tell when kept units no longer qualify.
+func Threshold(p Promotion) int {
+	switch v := p.(type) {
+	case ItemQtyPriceFreePromotion:
+		return v.PurchasedQty
+	case *ItemQtyPriceFreePromotion:
+		return v.PurchasedQty
+	case ItemQtyPriceDiscountPercentagePromotion:
+		return v.PurchasedQty
+	case *ItemQtyPriceDiscountPercentagePromotion:
+		return v.PurchasedQty
+	case BundlePromotion:
+		return len(v.BundleSkus)
+	case *BundlePromotion:
+		return len(v.BundleSkus)
+	case MixMatchPromotion:
+		return v.BundleQty
+	case *MixMatchPromotion:
+		return v.BundleQty
+	default:
+		return 0
+	}
+}
+
+// Purchased returns the SKU whose purchased units each earn a free unit of a free_item promotion,
+// or an empty SKU for other promotions.
+// It is recorded on orders so returns can tell when the free units are no longer earned.
+func Purchased(p Promotion) item.Sku {
+	switch v := p.(type) {
+	case FreeItemPromotion:
+		return v.PurchasedItemSku
+	case *FreeItemPromotion:
+		return v.PurchasedItemSku
+	default:
+		return ""
+	}
+}
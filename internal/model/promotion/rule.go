@@ -0,0 +1,60 @@
+package promotion
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+type (
+	// Rule is a promotion managed at runtime: its Definition, numbered in creation order.
+	// Only active rules apply to carts; a disabled rule is kept so it can be listed.
+	Rule struct {
+		ID         string
+		Number     int64
+		Definition Definition
+		Active     bool
+		CreatedAt  time.Time
+		UpdatedAt  time.Time
+	}
+)
+
+// NewRule creates an active rule from a definition, which must build into a promotion.
+func NewRule(number int64, d Definition, at time.Time) (r Rule, err error) {
+
+	if _, err = d.Build(); err != nil {
+		return r, err
+	}
+
+	id, _ := uuid.NewV4()
+
+	return Rule{
+		ID:         id.String(),
+		Number:     number,
+		Definition: d,
+		Active:     true,
+		CreatedAt:  at,
+		UpdatedAt:  at,
+	}, nil
+}
+
+// Update replaces the definition of the rule, which must build into a promotion.
+func (r *Rule) Update(d Definition, at time.Time) error {
+
+	if _, err := d.Build(); err != nil {
+		return err
+	}
+
+	r.Definition = d
+	r.UpdatedAt = at
+
+	return nil
+}
+
+// Disable stops the rule from applying to carts.
+func (r *Rule) Disable(at time.Time) {
+	if r.Active {
+		r.Active = false
+		r.UpdatedAt = at
+	}
+}
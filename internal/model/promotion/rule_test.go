@@ -0,0 +1,33 @@
+package promotion
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRule_Lifecycle(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	updated := created.Add(time.Hour)
+
+	if _, err := NewRule(1, Definition{Type: NameQtyFree, Sku: "A"}, created); !errors.Is(err, ErrInvalidPromotion) {
+		t.Fatalf("NewRule() of an invalid definition error = %v, want %v", err, ErrInvalidPromotion)
+	}
+
+	r, err := NewRule(1, Definition{Type: NameQtyFree, Sku: "A", Qty: 3}, created)
+	if err != nil || r.ID == "" || !r.Active || !r.UpdatedAt.Equal(created) {
+		t.Fatalf("NewRule() = %+v, %v", r, err)
+	}
+
+	if err := r.Update(Definition{Type: "bogo", Sku: "A"}, updated); !errors.Is(err, ErrUnknownType) || r.Definition.Qty != 3 {
+		t.Fatalf("Update() of an invalid definition error = %v, definition %+v", err, r.Definition)
+	}
+	if err := r.Update(Definition{Type: NameQtyFree, Sku: "A", Qty: 2}, updated); err != nil || r.Definition.Qty != 2 || !r.UpdatedAt.Equal(updated) {
+		t.Fatalf("Update() = %+v, %v", r, err)
+	}
+
+	r.Disable(updated.Add(time.Hour))
+	if r.Active || !r.UpdatedAt.Equal(updated.Add(time.Hour)) {
+		t.Fatalf("Disable() = %+v", r)
+	}
+}
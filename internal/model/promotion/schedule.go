@@ -0,0 +1,153 @@
+package promotion
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Reasons a promotion is not in effect at some time.
+const (
+	// SkipNotStarted is the reason of a promotion before the start of its validity window.
+	SkipNotStarted = "not_started"
+	// SkipEnded is the reason of a promotion after the end of its validity window.
+	SkipEnded = "ended"
+	// SkipOffSchedule is the reason of a promotion outside the days and hours of its schedule.
+	SkipOffSchedule = "off_schedule"
+)
+
+type (
+	// Schedule restricts a promotion to some days of the week and hours of the day, in a time zone.
+	// Days are "mon" to "sun", every day when empty. Hours run from FromHour, inclusive, until UntilHour,
+	// exclusive, 0 meaning midnight; a window ending before it starts runs overnight, e.g. 22 to 2.
+	// Days are those of the time zone, and of the start of an overnight window.
+	Schedule struct {
+		TimeZone  string   `json:"time_zone,omitempty" yaml:"time_zone,omitempty"`
+		Days      []string `json:"days,omitempty" yaml:"days,omitempty"`
+		FromHour  int      `json:"from_hour,omitempty" yaml:"from_hour,omitempty"`
+		UntilHour int      `json:"until_hour,omitempty" yaml:"until_hour,omitempty"`
+	}
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// InEffectAt tells whether the promotion of a definition applies at the given time
+// and, when it does not, the reason why.
+func (d Definition) InEffectAt(at time.Time) (ok bool, reason string) {
+
+	from, hasFrom := bound(d.ValidFrom)
+	until, hasUntil := bound(d.ValidUntil)
+
+	switch {
+	case hasFrom && at.Before(from):
+		return false, SkipNotStarted
+	case hasUntil && !at.Before(until):
+		return false, SkipEnded
+	case d.Schedule != nil && !d.Schedule.includes(at):
+		return false, SkipOffSchedule
+	}
+
+	return true, ""
+}
+
+// validateWindow checks the validity window and schedule of a definition.
+func (d Definition) validateWindow() error {
+
+	from, hasFrom := bound(d.ValidFrom)
+	until, hasUntil := bound(d.ValidUntil)
+
+	if hasFrom && hasUntil && !until.After(from) {
+		return fmt.Errorf("%w: valid_until must be after valid_from", ErrInvalidPromotion)
+	}
+
+	if d.Schedule != nil {
+		return d.Schedule.validate()
+	}
+
+	return nil
+}
+
+// bound returns a side of a validity window and whether it is set; a zero time leaves it open,
+// as it did for definitions stored before the window was optional.
+func bound(t *time.Time) (time.Time, bool) {
+	if t == nil || t.IsZero() {
+		return time.Time{}, false
+	}
+	return *t, true
+}
+
+func (s Schedule) validate() error {
+
+	if _, err := time.LoadLocation(s.TimeZone); err != nil {
+		return fmt.Errorf("%w: unknown time_zone %q", ErrInvalidPromotion, s.TimeZone)
+	}
+
+	for _, day := range s.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("%w: unknown day %q", ErrInvalidPromotion, day)
+		}
+	}
+
+	if s.FromHour < 0 || s.FromHour > 23 || s.UntilHour < 0 || s.UntilHour > 23 {
+		return fmt.Errorf("%w: hours must be between 0 and 23", ErrInvalidPromotion)
+	}
+
+	if s.FromHour != 0 && s.FromHour == s.UntilHour {
+		return fmt.Errorf("%w: from_hour and until_hour make an empty window", ErrInvalidPromotion)
+	}
+
+	return nil
+}
+
+// includes tells whether a time falls on the days and hours of the schedule.
+// A schedule that does not validate includes no time.
+func (s Schedule) includes(at time.Time) bool {
+
+	loc, err := time.LoadLocation(s.TimeZone)
+
+	if err != nil {
+		return false
+	}
+
+	local := at.In(loc)
+	hour := local.Hour()
+	day := local.Weekday()
+
+	switch {
+	case s.FromHour < s.UntilHour:
+		if hour < s.FromHour || hour >= s.UntilHour {
+			return false
+		}
+	case s.UntilHour == 0 || s.FromHour > s.UntilHour:
+		// the window runs until midnight, all day from 0 to 0, or overnight; early hours belong to the previous day
+		if hour < s.FromHour && hour >= s.UntilHour {
+			return false
+		}
+		if hour < s.FromHour {
+			day = local.AddDate(0, 0, -1).Weekday()
+		}
+	default:
+		// an empty window, rejected by validate
+		return false
+	}
+
+	if len(s.Days) == 0 {
+		return true
+	}
+
+	for _, d := range s.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,98 @@
+package promotion
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefinition_InEffectAt(t *testing.T) {
+	from := time.Date(2024, 11, 29, 0, 0, 0, 0, time.UTC) // a Friday
+	until := time.Date(2024, 12, 2, 0, 0, 0, 0, time.UTC)
+	var zero time.Time
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+
+	weekend := &Schedule{TimeZone: "Australia/Sydney", Days: []string{"Sat", "sun"}, FromHour: 9, UntilHour: 17}
+	overnight := &Schedule{Days: []string{"fri"}, FromHour: 22, UntilHour: 2}
+
+	tests := []struct {
+		name       string
+		def        Definition
+		at         time.Time
+		wantReason string
+	}{
+		{"no window", Definition{}, from, ""},
+		{"zero window", Definition{ValidFrom: &zero, ValidUntil: &zero}, from, ""},
+		{"before start", Definition{ValidFrom: &from, ValidUntil: &until}, from.Add(-time.Second), SkipNotStarted},
+		{"at start", Definition{ValidFrom: &from, ValidUntil: &until}, from, ""},
+		{"at end", Definition{ValidFrom: &from, ValidUntil: &until}, until, SkipEnded},
+		{"saturday morning in Sydney", Definition{Schedule: weekend}, time.Date(2024, 11, 30, 9, 0, 0, 0, sydney), ""},
+		{"saturday evening in Sydney", Definition{Schedule: weekend}, time.Date(2024, 11, 30, 17, 0, 0, 0, sydney), SkipOffSchedule},
+		{"saturday in Sydney, friday in UTC", Definition{Schedule: weekend}, time.Date(2024, 11, 29, 22, 30, 0, 0, time.UTC), ""},
+		{"friday in Sydney", Definition{Schedule: weekend}, time.Date(2024, 11, 29, 12, 0, 0, 0, sydney), SkipOffSchedule},
+		{"friday night", Definition{Schedule: overnight}, time.Date(2024, 11, 29, 23, 0, 0, 0, time.UTC), ""},
+		{"early saturday belongs to friday night", Definition{Schedule: overnight}, time.Date(2024, 11, 30, 1, 59, 0, 0, time.UTC), ""},
+		{"after friday night", Definition{Schedule: overnight}, time.Date(2024, 11, 30, 2, 0, 0, 0, time.UTC), SkipOffSchedule},
+		{"early friday belongs to thursday", Definition{Schedule: overnight}, time.Date(2024, 11, 29, 1, 0, 0, 0, time.UTC), SkipOffSchedule},
+		{"all day", Definition{Schedule: &Schedule{Days: []string{"fri"}}}, time.Date(2024, 11, 29, 0, 0, 0, 0, time.UTC), ""},
+		{"until midnight", Definition{Schedule: &Schedule{FromHour: 18}}, time.Date(2024, 11, 29, 23, 59, 0, 0, time.UTC), ""},
+		{"ended before schedule", Definition{ValidUntil: &until, Schedule: weekend}, until.Add(time.Hour), SkipEnded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, reason := tt.def.InEffectAt(tt.at)
+			if ok != (tt.wantReason == "") || reason != tt.wantReason {
+				t.Fatalf("InEffectAt() = %v, %q, want %q", ok, reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestDefinition_BuildSchedule(t *testing.T) {
+	from := time.Date(2024, 11, 29, 0, 0, 0, 0, time.UTC)
+	hourLater := from.Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		def     Definition
+		wantErr error
+	}{
+		{"valid", Definition{ValidFrom: &from, ValidUntil: &hourLater, Schedule: &Schedule{TimeZone: "UTC", Days: []string{"mon"}, FromHour: 8, UntilHour: 12}}, nil},
+		{"window ends before it starts", Definition{ValidFrom: &from, ValidUntil: &from}, ErrInvalidPromotion},
+		{"unknown time zone", Definition{Schedule: &Schedule{TimeZone: "Mars/Olympus"}}, ErrInvalidPromotion},
+		{"unknown day", Definition{Schedule: &Schedule{Days: []string{"funday"}}}, ErrInvalidPromotion},
+		{"hour out of range", Definition{Schedule: &Schedule{UntilHour: 24}}, ErrInvalidPromotion},
+		{"empty hours", Definition{Schedule: &Schedule{FromHour: 9, UntilHour: 9}}, ErrInvalidPromotion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.def.Type, tt.def.Sku, tt.def.Qty = NameQtyFree, "A", 2
+			if _, err := tt.def.Build(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Build() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// windows and schedules load from a promotions file
+	defs, err := Load(strings.NewReader(`
+- type: qty_free
+  sku: A
+  qty: 2
+  valid_from: 2024-11-29T00:00:00Z
+  schedule: {time_zone: UTC, days: [sat, sun], from_hour: 9, until_hour: 17}
+`), FormatYAML, nil)
+	if err != nil || len(defs) != 1 || defs[0].ValidFrom == nil || !defs[0].ValidFrom.Equal(from) || defs[0].ValidUntil != nil || defs[0].Schedule == nil || len(defs[0].Schedule.Days) != 2 {
+		t.Fatalf("Load() = %+v, %v", defs, err)
+	}
+
+	// a definition without a window encodes without one
+	b, err := json.Marshal(Definition{Type: NameQtyFree, Sku: "A", Qty: 2})
+	if err != nil || strings.Contains(string(b), "valid_") {
+		t.Fatalf("Marshal() = %s, %v", b, err)
+	}
+}
@@ -0,0 +1,93 @@
+package promotion
+
+import (
+	"math"
+
+	"github.com/gambarini/flip-shop/utils"
+)
+
+type (
+
+	// SpendPercentagePromotion
+	// Describes a promotion where spending at least some amount
+	// gives a percentage discount on the order
+	SpendPercentagePromotion struct {
+		MinSpend           int64
+		PercentageDiscount float64
+	}
+
+	// SpendFreeShippingPromotion
+	// Describes a promotion where spending at least some amount
+	// makes the shipping of the order free
+	SpendFreeShippingPromotion struct {
+		MinSpend int64
+	}
+
+	// SpendAmountOffPromotion
+	// Describes a promotion where spending at least some amount
+	// takes a fixed amount off the order
+	SpendAmountOffPromotion struct {
+		MinSpend int64
+		Amount   int64
+	}
+)
+
+// Spend returns what the purchases cost after their discounts, which spend promotions are conditioned on.
+func Spend(purchases []PurchasedItem) (spend int64) {
+	for _, p := range purchases {
+		spend = utils.SaturatingAddInt64(spend, utils.SaturatingSubInt64(utils.SaturatingMulInt64Int(p.Price, p.Qty), p.Discount))
+	}
+	return spend
+}
+
+// Apply does nothing: the promotion applies to the whole cart, through ApplyToCart.
+func (sP SpendPercentagePromotion) Apply(GetPurchasedItemHandler, AddPromoItemToCartHandler, AddDiscountToCartHandler) (err error) {
+	return nil
+}
+
+func (sP SpendPercentagePromotion) ApplyToCart(cart CartHandlers) (err error) {
+
+	spend := Spend(cart.GetPurchases())
+
+	if spend == 0 || spend < sP.MinSpend {
+		return nil
+	}
+
+	// the percentage is taken in basis points so the discount is computed in integer cents
+	bps := int64(math.Round(math.Min(math.Max(sP.PercentageDiscount, 0), 1) * 10000))
+	discount := utils.SaturatingMulInt64(spend, bps) / 10000
+
+	if discount == 0 {
+		return nil
+	}
+
+	return cart.AddOrderDiscount(discount)
+}
+
+// Apply does nothing: the promotion applies to the whole cart, through ApplyToCart.
+func (sF SpendFreeShippingPromotion) Apply(GetPurchasedItemHandler, AddPromoItemToCartHandler, AddDiscountToCartHandler) (err error) {
+	return nil
+}
+
+func (sF SpendFreeShippingPromotion) ApplyToCart(cart CartHandlers) (err error) {
+
+	if spend := Spend(cart.GetPurchases()); spend == 0 || spend < sF.MinSpend {
+		return nil
+	}
+
+	return cart.WaiveShipping()
+}
+
+// Apply does nothing: the promotion applies to the whole cart, through ApplyToCart.
+func (sA SpendAmountOffPromotion) Apply(GetPurchasedItemHandler, AddPromoItemToCartHandler, AddDiscountToCartHandler) (err error) {
+	return nil
+}
+
+func (sA SpendAmountOffPromotion) ApplyToCart(cart CartHandlers) (err error) {
+
+	if spend := Spend(cart.GetPurchases()); spend == 0 || spend < sA.MinSpend || sA.Amount <= 0 {
+		return nil
+	}
+
+	return cart.AddOrderDiscount(sA.Amount)
+}
@@ -0,0 +1,57 @@
+package promotion
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpendPromotions_ApplyToCart(t *testing.T) {
+	purchases := []PurchasedItem{
+		{Sku: "A", Price: 4000, Qty: 2, Discount: 1000},
+		{Sku: "B", Price: 3000, Qty: 1},
+	}
+
+	tests := []struct {
+		name          string
+		promotion     CartPromotion
+		wantDiscounts []int64
+		wantWaived    bool
+	}{
+		{"percentage at the threshold", SpendPercentagePromotion{MinSpend: 10000, PercentageDiscount: 0.1}, []int64{1000}, false},
+		{"percentage below the threshold", SpendPercentagePromotion{MinSpend: 10001, PercentageDiscount: 0.1}, nil, false},
+		{"percentage in integer cents", SpendPercentagePromotion{PercentageDiscount: 0.3333}, []int64{3333}, false},
+		{"free shipping", SpendFreeShippingPromotion{MinSpend: 5000}, nil, true},
+		{"free shipping below the threshold", SpendFreeShippingPromotion{MinSpend: 50000}, nil, false},
+		{"amount off", SpendAmountOffPromotion{MinSpend: 10000, Amount: 1500}, []int64{1500}, false},
+		{"amount off below the threshold", SpendAmountOffPromotion{MinSpend: 20000, Amount: 1500}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var discounts []int64
+			var waived bool
+			err := tt.promotion.ApplyToCart(CartHandlers{
+				GetPurchases:     func() []PurchasedItem { return purchases },
+				AddOrderDiscount: func(discount int64) error { discounts = append(discounts, discount); return nil },
+				WaiveShipping:    func() error { waived = true; return nil },
+			})
+			if err != nil {
+				t.Fatalf("ApplyToCart() error = %v", err)
+			}
+			if !reflect.DeepEqual(discounts, tt.wantDiscounts) || waived != tt.wantWaived {
+				t.Fatalf("discounts = %v, waived = %v, want %v, %v", discounts, waived, tt.wantDiscounts, tt.wantWaived)
+			}
+			if err := tt.promotion.Apply(nil, nil, nil); err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+		})
+	}
+
+	// an empty cart spends nothing, even without a threshold
+	err := SpendAmountOffPromotion{Amount: 500}.ApplyToCart(CartHandlers{
+		GetPurchases:     func() []PurchasedItem { return nil },
+		AddOrderDiscount: func(int64) error { t.Fatal("unexpected order discount"); return nil },
+	})
+	if err != nil {
+		t.Fatalf("ApplyToCart() error = %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+// Package shipping prices the delivery of a cart with the shipping methods of the store.
+//
+// A method is one of:
+//   - flat: the same Cost for every cart;
+//   - weight: Cost plus PerKg for every started kilogram the purchases weigh;
+//   - free_over: Cost, waived when the merchandise reaches Threshold.
+package shipping
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/gambarini/flip-shop/utils"
+)
+
+var (
+	// ErrUnknownMethod is returned when choosing a method the store does not offer.
+	ErrUnknownMethod = errors.New("unknown shipping method")
+	// ErrInvalidMethod is returned when loading a method with a missing code, unknown type or negative amount.
+	ErrInvalidMethod = errors.New("invalid shipping method")
+	// ErrInvalidAddress is returned for an address missing the street, city, postal code or country.
+	ErrInvalidAddress = errors.New("invalid shipping address")
+)
+
+type (
+	// Type tells how a Method computes its cost.
+	Type string
+
+	// Method is a way of shipping a cart and how much it costs.
+	// Amounts are expressed in integer cents (int64).
+	Method struct {
+		Code      string
+		Name      string
+		Type      Type
+		Cost      int64
+		PerKg     int64
+		Threshold int64
+	}
+
+	// Methods are the shipping methods of the store, by code.
+	Methods map[string]Method
+
+	// Address is where a cart is shipped to.
+	Address struct {
+		Name       string
+		Line1      string
+		Line2      string
+		City       string
+		Region     string
+		PostalCode string
+		Country    string
+	}
+
+	// methodConfig is a Method as written in a shipping methods file.
+	methodConfig struct {
+		Code      string `json:"code"`
+		Name      string `json:"name"`
+		Type      Type   `json:"type"`
+		Cost      int64  `json:"cost"`
+		PerKg     int64  `json:"per_kg"`
+		Threshold int64  `json:"threshold"`
+	}
+)
+
+// Types of Method.
+const (
+	TypeFlat     = Type("flat")
+	TypeWeight   = Type("weight")
+	TypeFreeOver = Type("free_over")
+)
+
+// Price returns the shipping cost of a cart whose merchandise, after discount, amounts
+// to merchandise and whose purchases weigh weight grams.
+func (m Method) Price(merchandise int64, weight int64) int64 {
+	switch m.Type {
+	case TypeWeight:
+		kgs := (weight + 999) / 1000
+		if weight <= 0 {
+			kgs = 0
+		}
+		return utils.SaturatingAddInt64(m.Cost, utils.SaturatingMulInt64(m.PerKg, kgs))
+	case TypeFreeOver:
+		if merchandise >= m.Threshold {
+			return 0
+		}
+		return m.Cost
+	default:
+		return m.Cost
+	}
+}
+
+// Validate checks the method has a code, a known type and no negative amount.
+func (m Method) Validate() error {
+	switch {
+	case m.Code == "":
+		return fmt.Errorf("%w: code is required", ErrInvalidMethod)
+	case m.Type != TypeFlat && m.Type != TypeWeight && m.Type != TypeFreeOver:
+		return fmt.Errorf("%w: %s has unknown type %q", ErrInvalidMethod, m.Code, m.Type)
+	case m.Cost < 0 || m.PerKg < 0 || m.Threshold < 0:
+		return fmt.Errorf("%w: %s has a negative amount", ErrInvalidMethod, m.Code)
+	}
+	return nil
+}
+
+// Validate checks the address has a street, a city, a postal code and a country.
+func (a Address) Validate() error {
+	switch {
+	case a.Line1 == "":
+		return fmt.Errorf("%w: line1 is required", ErrInvalidAddress)
+	case a.City == "":
+		return fmt.Errorf("%w: city is required", ErrInvalidAddress)
+	case a.PostalCode == "":
+		return fmt.Errorf("%w: postal_code is required", ErrInvalidAddress)
+	case a.Country == "":
+		return fmt.Errorf("%w: country is required", ErrInvalidAddress)
+	}
+	return nil
+}
+
+// Find returns the method with the given code.
+func (ms Methods) Find(code string) (Method, error) {
+	m, ok := ms[code]
+	if !ok {
+		return Method{}, fmt.Errorf("%w: %q", ErrUnknownMethod, code)
+	}
+	return m, nil
+}
+
+// List returns the methods ordered by code.
+func (ms Methods) List() []Method {
+	list := make([]Method, 0, len(ms))
+	for _, m := range ms {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Code < list[j].Code })
+	return list
+}
+
+// Load decodes and validates a JSON list of shipping methods:
+//
+//	[{"code": "standard", "name": "Standard", "type": "weight", "cost": 500, "per_kg": 150},
+//	 {"code": "express", "name": "Express", "type": "flat", "cost": 1500},
+//	 {"code": "saver", "name": "Saver", "type": "free_over", "cost": 800, "threshold": 10000}]
+func Load(r io.Reader) (Methods, error) {
+
+	var list []methodConfig
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&list); err != nil {
+		return nil, fmt.Errorf("invalid shipping methods: %w", err)
+	}
+
+	ms := make(Methods, len(list))
+
+	for _, mc := range list {
+		m := Method(mc)
+		if err := m.Validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := ms[m.Code]; ok {
+			return nil, fmt.Errorf("%w: %s is defined twice", ErrInvalidMethod, m.Code)
+		}
+		ms[m.Code] = m
+	}
+
+	return ms, nil
+}
+
+// LoadFile reads the shipping methods file at path.
+func LoadFile(path string) (Methods, error) {
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return Load(f)
+}
@@ -0,0 +1,89 @@
+package shipping
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMethod_Price(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      Method
+		merchandise int64
+		weight      int64
+		want        int64
+	}{
+		{"flat", Method{Code: "f", Type: TypeFlat, Cost: 1500}, 100000, 5000, 1500},
+		{"weight without weight", Method{Code: "w", Type: TypeWeight, Cost: 500, PerKg: 150}, 1000, 0, 500},
+		{"weight started kilogram", Method{Code: "w", Type: TypeWeight, Cost: 500, PerKg: 150}, 1000, 1, 650},
+		{"weight whole kilograms", Method{Code: "w", Type: TypeWeight, Cost: 500, PerKg: 150}, 1000, 2000, 800},
+		{"weight next kilogram", Method{Code: "w", Type: TypeWeight, Cost: 500, PerKg: 150}, 1000, 2001, 950},
+		{"free over below threshold", Method{Code: "s", Type: TypeFreeOver, Cost: 800, Threshold: 10000}, 9999, 0, 800},
+		{"free over at threshold", Method{Code: "s", Type: TypeFreeOver, Cost: 800, Threshold: 10000}, 10000, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.method.Price(tt.merchandise, tt.weight); got != tt.want {
+				t.Errorf("Price(%d, %d) = %d, want %d", tt.merchandise, tt.weight, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantErr error
+	}{
+		{"valid", `[{"code":"standard","name":"Standard","type":"weight","cost":500,"per_kg":150},{"code":"express","type":"flat","cost":1500}]`, nil},
+		{"missing code", `[{"type":"flat","cost":1500}]`, ErrInvalidMethod},
+		{"unknown type", `[{"code":"drone","type":"air","cost":1500}]`, ErrInvalidMethod},
+		{"negative cost", `[{"code":"express","type":"flat","cost":-1}]`, ErrInvalidMethod},
+		{"duplicated code", `[{"code":"express","type":"flat","cost":1},{"code":"express","type":"flat","cost":2}]`, ErrInvalidMethod},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Load(strings.NewReader(tt.in)); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Load() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	ms, _ := Load(strings.NewReader(tests[0].in))
+	if m, err := ms.Find("standard"); err != nil || m.PerKg != 150 || m.Name != "Standard" {
+		t.Fatalf("Find(standard) = %+v, %v", m, err)
+	}
+	if _, err := ms.Find("drone"); !errors.Is(err, ErrUnknownMethod) {
+		t.Fatalf("Find(drone) error = %v, want %v", err, ErrUnknownMethod)
+	}
+	if list := ms.List(); len(list) != 2 || list[0].Code != "express" || list[1].Code != "standard" {
+		t.Fatalf("List() = %+v", list)
+	}
+}
+
+func TestAddress_Validate(t *testing.T) {
+	valid := Address{Line1: "1 Main St", City: "Sydney", PostalCode: "2000", Country: "AU"}
+
+	tests := []struct {
+		name    string
+		change  func(a *Address)
+		wantErr error
+	}{
+		{"valid", func(a *Address) {}, nil},
+		{"missing line1", func(a *Address) { a.Line1 = "" }, ErrInvalidAddress},
+		{"missing city", func(a *Address) { a.City = "" }, ErrInvalidAddress},
+		{"missing postal code", func(a *Address) { a.PostalCode = "" }, ErrInvalidAddress},
+		{"missing country", func(a *Address) { a.Country = "" }, ErrInvalidAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := valid
+			tt.change(&a)
+			if err := a.Validate(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package tax
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gambarini/flip-shop/internal/model/item"
+)
+
+type (
+	// Config is the content of a tax rates file: the rate tables of every jurisdiction, by name.
+	//
+	//	{"jurisdictions": {
+	//	    "AU": {"pricing": "inclusive", "rates": {"standard": 0.1, "food": 0}},
+	//	    "US-NY": {"pricing": "exclusive", "rates": {"standard": 0.08875, "clothing": 0.045}}
+	//	}}
+	Config struct {
+		Jurisdictions map[string]JurisdictionConfig `json:"jurisdictions"`
+	}
+
+	// JurisdictionConfig holds the pricing, "inclusive" or "exclusive" (the default), and the
+	// rate of each tax category of a jurisdiction, as a fraction. The standard rate is required.
+	JurisdictionConfig struct {
+		Pricing string                       `json:"pricing"`
+		Rates   map[item.TaxCategory]float64 `json:"rates"`
+	}
+)
+
+// Pricing values of a JurisdictionConfig.
+const (
+	PricingExclusive = "exclusive"
+	PricingInclusive = "inclusive"
+)
+
+// Load decodes and validates tax rates, returning the Table of each jurisdiction by name.
+func Load(r io.Reader) (map[string]Table, error) {
+
+	var cfg Config
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid tax rates: %w", err)
+	}
+
+	tables := make(map[string]Table, len(cfg.Jurisdictions))
+
+	for name, jc := range cfg.Jurisdictions {
+		t, err := jc.table(name)
+		if err != nil {
+			return nil, fmt.Errorf("jurisdiction %s: %w", name, err)
+		}
+		tables[name] = t
+	}
+
+	return tables, nil
+}
+
+// LoadFile reads the tax rates file at path and returns the Table of a jurisdiction.
+func LoadFile(path, jurisdiction string) (Table, error) {
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return Table{}, err
+	}
+
+	defer f.Close()
+
+	tables, err := Load(f)
+
+	if err != nil {
+		return Table{}, err
+	}
+
+	t, ok := tables[jurisdiction]
+
+	if !ok {
+		return Table{}, fmt.Errorf("%w: %q", ErrUnknownJurisdiction, jurisdiction)
+	}
+
+	return t, nil
+}
+
+func (jc JurisdictionConfig) table(name string) (t Table, err error) {
+
+	t = Table{Jurisdiction: name, Rates: make(map[item.TaxCategory]Rate, len(jc.Rates))}
+
+	switch jc.Pricing {
+	case "", PricingExclusive:
+	case PricingInclusive:
+		t.Inclusive = true
+	default:
+		return t, fmt.Errorf("%w: %q", ErrUnknownPricing, jc.Pricing)
+	}
+
+	if _, ok := jc.Rates[item.TaxCategoryStandard]; !ok {
+		return t, ErrStandardRateRequired
+	}
+
+	for c, f := range jc.Rates {
+		r, err := RateFromFraction(f)
+		if err != nil {
+			return t, fmt.Errorf("%w: %s %v", err, c, f)
+		}
+		t.Rates[c] = r
+	}
+
+	return t, nil
+}
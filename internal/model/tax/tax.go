@@ -0,0 +1,77 @@
+// Package tax computes the tax of cart lines from the rates of a jurisdiction.
+//
+// Every item belongs to a tax category; a jurisdiction Table gives the rate of each
+// category, falling back to the standard rate for categories it does not list.
+// With exclusive pricing the tax is added on top of the item prices; with inclusive
+// pricing the prices already contain it and the tax is the share of the price it represents.
+package tax
+
+import (
+	"errors"
+	"math"
+
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/utils"
+)
+
+var (
+	// ErrInvalidRate is returned for a rate below 0 or above 1.
+	ErrInvalidRate = errors.New("invalid tax rate")
+	// ErrStandardRateRequired is returned for a jurisdiction without a standard rate.
+	ErrStandardRateRequired = errors.New("standard tax rate is required")
+	// ErrUnknownJurisdiction is returned when selecting a jurisdiction the rates do not define.
+	ErrUnknownJurisdiction = errors.New("unknown tax jurisdiction")
+	// ErrUnknownPricing is returned for a pricing other than inclusive or exclusive.
+	ErrUnknownPricing = errors.New("unknown tax pricing")
+)
+
+type (
+	// Rate is a tax rate expressed in parts per million of the taxed amount, e.g. 100000 is 10%.
+	Rate int64
+
+	// Table holds the tax rates of a jurisdiction, by item tax category.
+	// The zero Table charges no tax.
+	Table struct {
+		Jurisdiction string
+		Inclusive    bool
+		Rates        map[item.TaxCategory]Rate
+	}
+)
+
+// rateScale is the number of parts of a Rate making up the whole amount.
+const rateScale = 1000000
+
+// RateFromFraction converts a fraction such as 0.0825 to a Rate.
+func RateFromFraction(f float64) (Rate, error) {
+	if math.IsNaN(f) || f < 0 || f > 1 {
+		return 0, ErrInvalidRate
+	}
+	return Rate(math.Round(f * rateScale)), nil
+}
+
+// Rate returns the rate of a category, or the standard rate when the table does not list it.
+func (t Table) Rate(c item.TaxCategory) Rate {
+	if r, ok := t.Rates[c]; ok {
+		return r
+	}
+	return t.Rates[item.TaxCategoryStandard]
+}
+
+// Tax returns the tax of amount for a category, rounded half up to the cent.
+// With exclusive pricing it is the tax to add to amount; with inclusive pricing it is the
+// part of amount that is tax. A non-positive amount has no tax.
+func (t Table) Tax(c item.TaxCategory, amount int64) int64 {
+
+	r := int64(t.Rate(c))
+
+	if amount <= 0 || r <= 0 {
+		return 0
+	}
+
+	if t.Inclusive {
+		net := utils.SaturatingAddInt64(utils.SaturatingMulInt64(amount, rateScale), (rateScale+r)/2) / (rateScale + r)
+		return amount - net
+	}
+
+	return utils.SaturatingAddInt64(utils.SaturatingMulInt64(amount, r), rateScale/2) / rateScale
+}
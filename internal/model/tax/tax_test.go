@@ -0,0 +1,100 @@
+package tax
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gambarini/flip-shop/internal/model/item"
+)
+
+func TestTable_Tax(t *testing.T) {
+	rates := map[item.TaxCategory]Rate{item.TaxCategoryStandard: 100000, "food": 0, "reduced": 88750}
+
+	tests := []struct {
+		name      string
+		inclusive bool
+		category  item.TaxCategory
+		amount    int64
+		want      int64
+	}{
+		{"exclusive standard", false, item.TaxCategoryStandard, 2500, 250},
+		{"exclusive rounds half up", false, item.TaxCategoryStandard, 995, 100},
+		{"exclusive empty category is standard", false, "", 1000, 100},
+		{"exclusive unlisted category is standard", false, "toys", 1000, 100},
+		{"exclusive zero rate", false, "food", 1000, 0},
+		{"exclusive fractional rate", false, "reduced", 10000, 888},
+		{"inclusive standard", true, item.TaxCategoryStandard, 1100, 100},
+		{"inclusive rounds", true, item.TaxCategoryStandard, 2500, 227},
+		{"no amount", false, item.TaxCategoryStandard, 0, 0},
+		{"negative amount", true, item.TaxCategoryStandard, -100, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := Table{Inclusive: tt.inclusive, Rates: rates}
+			if got := table.Tax(tt.category, tt.amount); got != tt.want {
+				t.Errorf("Tax(%q, %d) = %d, want %d", tt.category, tt.amount, got, tt.want)
+			}
+		})
+	}
+
+	if got := (Table{}).Tax(item.TaxCategoryStandard, 1000); got != 0 {
+		t.Errorf("zero Table Tax = %d, want 0", got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantErr error
+	}{
+		{"valid", `{"jurisdictions":{"AU":{"pricing":"inclusive","rates":{"standard":0.1,"food":0}},"US-NY":{"rates":{"standard":0.08875}}}}`, nil},
+		{"missing standard", `{"jurisdictions":{"AU":{"rates":{"food":0}}}}`, ErrStandardRateRequired},
+		{"negative rate", `{"jurisdictions":{"AU":{"rates":{"standard":-0.1}}}}`, ErrInvalidRate},
+		{"rate above 1", `{"jurisdictions":{"AU":{"rates":{"standard":10}}}}`, ErrInvalidRate},
+		{"unknown pricing", `{"jurisdictions":{"AU":{"pricing":"gross","rates":{"standard":0.1}}}}`, ErrUnknownPricing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(strings.NewReader(tt.in))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Load() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := Load(strings.NewReader(`{"jurisdictions":{},"vat":1}`)); err == nil {
+		t.Fatalf("Load() accepted an unknown field")
+	}
+
+	tables, _ := Load(strings.NewReader(tests[0].in))
+	au, ny := tables["AU"], tables["US-NY"]
+	if !au.Inclusive || au.Jurisdiction != "AU" || au.Rate(item.TaxCategoryStandard) != 100000 || au.Rate("food") != 0 {
+		t.Errorf("unexpected AU table: %+v", au)
+	}
+	if ny.Inclusive || ny.Rate("food") != 88750 {
+		t.Errorf("unexpected US-NY table: %+v", ny)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "taxes.json")
+	if err := os.WriteFile(path, []byte(`{"jurisdictions":{"AU":{"pricing":"inclusive","rates":{"standard":0.1}}}}`), 0o600); err != nil {
+		t.Fatalf("write rates: %v", err)
+	}
+
+	au, err := LoadFile(path, "AU")
+	if err != nil || !au.Inclusive || au.Rate(item.TaxCategoryStandard) != 100000 {
+		t.Fatalf("LoadFile() = %+v, %v", au, err)
+	}
+
+	if _, err := LoadFile(path, "NZ"); !errors.Is(err, ErrUnknownJurisdiction) {
+		t.Fatalf("LoadFile() unknown jurisdiction error = %v, want %v", err, ErrUnknownJurisdiction)
+	}
+	if _, err := LoadFile(filepath.Join(t.TempDir(), "missing.json"), "AU"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("LoadFile() missing file error = %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package tier
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/gambarini/flip-shop/internal/model/item"
+)
+
+type (
+	// Config is the content of a price breaks file: the breaks of items, by SKU, and of item categories.
+	//
+	//	{"items": {"120P90": [{"min_qty": 5, "percentage": 0.08}]},
+	//	 "categories": {"smart_speaker": [{"min_qty": 10, "percentage": 0.05}, {"min_qty": 50, "percentage": 0.12}]}}
+	Config struct {
+		Items      map[item.Sku][]BreakConfig      `json:"items"`
+		Categories map[item.Category][]BreakConfig `json:"categories"`
+	}
+
+	// BreakConfig is a price break: Percentage, a fraction, off a line of at least MinQty units.
+	BreakConfig struct {
+		MinQty     int     `json:"min_qty"`
+		Percentage float64 `json:"percentage"`
+	}
+)
+
+// Load decodes and validates price breaks, returning them as a Table.
+func Load(r io.Reader) (Table, error) {
+
+	var cfg Config
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&cfg); err != nil {
+		return Table{}, fmt.Errorf("invalid price breaks: %w", err)
+	}
+
+	t := Table{Items: make(map[item.Sku]Breaks, len(cfg.Items)), Categories: make(map[item.Category]Breaks, len(cfg.Categories))}
+
+	for sku, bcs := range cfg.Items {
+		bs, err := breaks(bcs)
+		if err != nil {
+			return Table{}, fmt.Errorf("item %s: %w", sku, err)
+		}
+		t.Items[sku] = bs
+	}
+
+	for c, bcs := range cfg.Categories {
+		bs, err := breaks(bcs)
+		if err != nil {
+			return Table{}, fmt.Errorf("category %s: %w", c, err)
+		}
+		t.Categories[c] = bs
+	}
+
+	return t, nil
+}
+
+// LoadFile reads the price breaks file at path.
+func LoadFile(path string) (Table, error) {
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return Table{}, err
+	}
+
+	defer f.Close()
+
+	return Load(f)
+}
+
+func breaks(bcs []BreakConfig) (Breaks, error) {
+
+	bs := make(Breaks, 0, len(bcs))
+	for _, bc := range bcs {
+		bs = append(bs, Break(bc))
+	}
+
+	if err := bs.Validate(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(bs, func(i, j int) bool { return bs[i].MinQty < bs[j].MinQty })
+
+	return bs, nil
+}
@@ -0,0 +1,93 @@
+// Package tier prices cart lines by volume: the more units of an item a line holds, the lower its unit price.
+//
+// A price break takes a percentage off the list price of every unit of a line holding at least its
+// quantity; a line below every break sells at list price. Breaks are set per item or per item
+// category, those of an item replacing those of its category.
+package tier
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/utils"
+)
+
+// ErrInvalidBreak is returned for a price break without a positive quantity, with a percentage
+// outside (0, 1], or with the quantity of another break of the same item or category.
+var ErrInvalidBreak = errors.New("invalid price break")
+
+type (
+	// Break takes Percentage, a fraction, off the list price of every unit of a line of at least MinQty units.
+	Break struct {
+		MinQty     int
+		Percentage float64
+	}
+
+	// Breaks are the price breaks of an item, by increasing MinQty.
+	Breaks []Break
+
+	// Table holds the price breaks of items, by SKU and by category.
+	// The zero Table sells every item at its list price.
+	Table struct {
+		Items      map[item.Sku]Breaks
+		Categories map[item.Category]Breaks
+	}
+)
+
+// For returns the price breaks of an item: its own, or else those of its category.
+func (t Table) For(i item.Item) Breaks {
+	if bs, ok := t.Items[i.Sku]; ok {
+		return bs
+	}
+	if i.Category == "" {
+		return nil
+	}
+	return t.Categories[i.Category]
+}
+
+// Break returns the break a line of qty units gets, the one of the highest MinQty not above qty.
+func (bs Breaks) Break(qty int) (b Break, ok bool) {
+	for _, candidate := range bs {
+		if candidate.MinQty <= qty && (!ok || candidate.MinQty > b.MinQty) {
+			b, ok = candidate, true
+		}
+	}
+	return b, ok
+}
+
+// UnitPrice returns the price of a unit listed at list in a line of qty units.
+// The percentage is taken in basis points and the discount truncated to the cent.
+func (bs Breaks) UnitPrice(list int64, qty int) int64 {
+
+	b, ok := bs.Break(qty)
+
+	if !ok {
+		return list
+	}
+
+	bps := int64(math.Round(math.Min(math.Max(b.Percentage, 0), 1) * 10000))
+
+	return utils.SaturatingSubInt64(list, utils.SaturatingMulInt64(list, bps)/10000)
+}
+
+// Validate checks that every break has a positive quantity of its own and a percentage in (0, 1].
+func (bs Breaks) Validate() error {
+
+	seen := make(map[int]bool, len(bs))
+
+	for _, b := range bs {
+		switch {
+		case b.MinQty <= 0:
+			return fmt.Errorf("%w: min_qty must be positive", ErrInvalidBreak)
+		case seen[b.MinQty]:
+			return fmt.Errorf("%w: min_qty %d is repeated", ErrInvalidBreak, b.MinQty)
+		case math.IsNaN(b.Percentage) || b.Percentage <= 0 || b.Percentage > 1:
+			return fmt.Errorf("%w: percentage must be a fraction in (0, 1]", ErrInvalidBreak)
+		}
+		seen[b.MinQty] = true
+	}
+
+	return nil
+}
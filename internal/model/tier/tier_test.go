@@ -0,0 +1,122 @@
+package tier
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gambarini/flip-shop/internal/model/item"
+)
+
+func TestBreaks_UnitPrice(t *testing.T) {
+	bs := Breaks{{MinQty: 10, Percentage: 0.05}, {MinQty: 50, Percentage: 0.12}}
+
+	tests := []struct {
+		name string
+		qty  int
+		want int64
+	}{
+		{"below every break", 9, 4999},
+		{"at a break", 10, 4750},
+		{"between breaks", 49, 4750},
+		{"the highest break", 50, 4400},
+		{"beyond the highest break", 500, 4400},
+		{"no units", 0, 4999},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bs.UnitPrice(4999, tt.qty); got != tt.want {
+				t.Errorf("UnitPrice(4999, %d) = %d, want %d", tt.qty, got, tt.want)
+			}
+		})
+	}
+
+	if got := Breaks(nil).UnitPrice(4999, 100); got != 4999 {
+		t.Errorf("no breaks UnitPrice = %d, want 4999", got)
+	}
+	if got := (Breaks{{MinQty: 1, Percentage: 1}}).UnitPrice(4999, 1); got != 0 {
+		t.Errorf("whole percentage UnitPrice = %d, want 0", got)
+	}
+}
+
+func TestTable_For(t *testing.T) {
+	own := Breaks{{MinQty: 5, Percentage: 0.08}}
+	speakers := Breaks{{MinQty: 10, Percentage: 0.05}}
+	table := Table{
+		Items:      map[item.Sku]Breaks{"A": own},
+		Categories: map[item.Category]Breaks{"smart_speaker": speakers},
+	}
+
+	tests := []struct {
+		name string
+		item item.Item
+		want Breaks
+	}{
+		{"the item breaks replace those of its category", item.Item{Sku: "A", Category: "smart_speaker"}, own},
+		{"the breaks of the category", item.Item{Sku: "B", Category: "smart_speaker"}, speakers},
+		{"no breaks", item.Item{Sku: "C", Category: "computer"}, nil},
+		{"no category", item.Item{Sku: "C"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := table.For(tt.item); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("For(%s) = %v, want %v", tt.item.Sku, got, tt.want)
+			}
+		})
+	}
+
+	if got := (Table{}).For(item.Item{Sku: "A", Category: "smart_speaker"}); got != nil {
+		t.Errorf("zero Table For = %v, want none", got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantErr error
+	}{
+		{"valid", `{"items":{"A":[{"min_qty":5,"percentage":0.08}]},"categories":{"smart_speaker":[{"min_qty":50,"percentage":0.12},{"min_qty":10,"percentage":0.05}]}}`, nil},
+		{"no quantity", `{"categories":{"c":[{"percentage":0.1}]}}`, ErrInvalidBreak},
+		{"repeated quantity", `{"items":{"A":[{"min_qty":5,"percentage":0.1},{"min_qty":5,"percentage":0.2}]}}`, ErrInvalidBreak},
+		{"no percentage", `{"items":{"A":[{"min_qty":5}]}}`, ErrInvalidBreak},
+		{"percentage above 1", `{"items":{"A":[{"min_qty":5,"percentage":5}]}}`, ErrInvalidBreak},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(strings.NewReader(tt.in))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Load() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// breaks are kept by increasing quantity
+	table, err := Load(strings.NewReader(`{"categories":{"c":[{"min_qty":50,"percentage":0.12},{"min_qty":10,"percentage":0.05}]}}`))
+	if want := (Breaks{{MinQty: 10, Percentage: 0.05}, {MinQty: 50, Percentage: 0.12}}); err != nil || !reflect.DeepEqual(table.Categories["c"], want) {
+		t.Fatalf("Load() = %+v, %v, want breaks %v", table, err, want)
+	}
+
+	if _, err := Load(strings.NewReader(`{"items":{"A":[{"min_qty":5,"percentage":0.1,"price":100}]}}`)); err == nil {
+		t.Fatal("Load() accepted an unknown field")
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tiers.json")
+	if err := os.WriteFile(path, []byte(`{"items":{"A":[{"min_qty":5,"percentage":0.1}]}}`), 0o600); err != nil {
+		t.Fatalf("write breaks: %v", err)
+	}
+
+	table, err := LoadFile(path)
+	if err != nil || table.For(item.Item{Sku: "A"}).UnitPrice(1000, 5) != 900 {
+		t.Fatalf("LoadFile() = %+v, %v", table, err)
+	}
+
+	if _, err := LoadFile(filepath.Join(t.TempDir(), "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("LoadFile() missing file error = %v", err)
+	}
+}
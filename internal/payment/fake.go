@@ -0,0 +1,223 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+type (
+	// Operation names a Gateway method.
+	Operation string
+
+	// Outcome is the scripted result of a gateway call.
+	Outcome int
+
+	// Fake is an in-process Gateway. It approves every call unless outcomes are
+	// scripted for an operation, in which case each call consumes the next one.
+	// It is safe for concurrent use.
+	Fake struct {
+		lock   sync.Mutex
+		script map[Operation][]Outcome
+		auths  map[string]*fakeAuthorization
+		seq    int
+		calls  map[Operation]int
+	}
+
+	// AuthorizationState is what the Fake knows about an authorization.
+	AuthorizationState struct {
+		Authorization
+		Captured int64
+		Refunded int64
+		Voided   bool
+	}
+
+	fakeAuthorization struct {
+		AuthorizationState
+		refunds map[string]bool
+	}
+)
+
+// Operations of the Gateway, used to script the Fake.
+const (
+	OperationAuthorize = Operation("authorize")
+	OperationCapture   = Operation("capture")
+	OperationVoid      = Operation("void")
+	OperationRefund    = Operation("refund")
+)
+
+const (
+	// Approve lets the call go through.
+	Approve Outcome = iota
+	// Decline makes the call fail with ErrDeclined.
+	Decline
+	// Timeout makes the call fail with ErrTimeout, without any effect.
+	Timeout
+)
+
+// NewFake creates a Fake gateway approving every call.
+func NewFake() *Fake {
+	return &Fake{
+		script: make(map[Operation][]Outcome),
+		auths:  make(map[string]*fakeAuthorization),
+		calls:  make(map[Operation]int),
+	}
+}
+
+// Script queues outcomes for the next calls of an operation.
+func (f *Fake) Script(op Operation, outcomes ...Outcome) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.script[op] = append(f.script[op], outcomes...)
+}
+
+// Calls returns how many times an operation was called, whatever its outcome.
+func (f *Fake) Calls(op Operation) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.calls[op]
+}
+
+// State returns the state of an authorization.
+func (f *Fake) State(authorizationID string) (AuthorizationState, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	a, ok := f.auths[authorizationID]
+	if !ok {
+		return AuthorizationState{}, false
+	}
+	return a.AuthorizationState, true
+}
+
+// Authorize implements Gateway.
+func (f *Fake) Authorize(ctx context.Context, reference string, amount int64) (Authorization, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.outcome(ctx, OperationAuthorize); err != nil {
+		return Authorization{}, err
+	}
+	if amount <= 0 {
+		return Authorization{}, ErrInvalidAmount
+	}
+
+	for _, a := range f.auths {
+		if a.Reference == reference && a.Amount == amount && !a.Voided {
+			return a.Authorization, nil
+		}
+	}
+
+	f.seq++
+	a := &fakeAuthorization{
+		AuthorizationState: AuthorizationState{
+			Authorization: Authorization{ID: fmt.Sprintf("auth-%d", f.seq), Reference: reference, Amount: amount},
+		},
+		refunds: make(map[string]bool),
+	}
+	f.auths[a.ID] = a
+
+	return a.Authorization, nil
+}
+
+// Capture implements Gateway.
+func (f *Fake) Capture(ctx context.Context, authorizationID string, amount int64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.outcome(ctx, OperationCapture); err != nil {
+		return err
+	}
+
+	a, ok := f.auths[authorizationID]
+
+	switch {
+	case !ok:
+		return ErrUnknownAuthorization
+	case a.Voided:
+		return ErrInvalidState
+	case a.Captured > 0 && a.Captured == amount:
+		return nil
+	case a.Captured > 0:
+		return ErrInvalidState
+	case amount <= 0 || amount > a.Amount:
+		return ErrInvalidAmount
+	}
+
+	a.Captured = amount
+
+	return nil
+}
+
+// Void implements Gateway.
+func (f *Fake) Void(ctx context.Context, authorizationID string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.outcome(ctx, OperationVoid); err != nil {
+		return err
+	}
+
+	a, ok := f.auths[authorizationID]
+
+	switch {
+	case !ok:
+		return ErrUnknownAuthorization
+	case a.Captured > 0:
+		return ErrInvalidState
+	}
+
+	a.Voided = true
+
+	return nil
+}
+
+// Refund implements Gateway.
+func (f *Fake) Refund(ctx context.Context, authorizationID string, amount int64, reference string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.outcome(ctx, OperationRefund); err != nil {
+		return err
+	}
+
+	a, ok := f.auths[authorizationID]
+
+	switch {
+	case !ok:
+		return ErrUnknownAuthorization
+	case a.Captured == 0:
+		return ErrInvalidState
+	case a.refunds[reference]:
+		return nil
+	case amount <= 0 || a.Refunded+amount > a.Captured:
+		return ErrInvalidAmount
+	}
+
+	a.Refunded += amount
+	a.refunds[reference] = true
+
+	return nil
+}
+
+// outcome counts a call and consumes its next scripted outcome. Callers must hold the lock.
+func (f *Fake) outcome(ctx context.Context, op Operation) error {
+	f.calls[op]++
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	}
+
+	next := Approve
+	if queued := f.script[op]; len(queued) > 0 {
+		next, f.script[op] = queued[0], queued[1:]
+	}
+
+	switch next {
+	case Decline:
+		return ErrDeclined
+	case Timeout:
+		return ErrTimeout
+	default:
+		return nil
+	}
+}
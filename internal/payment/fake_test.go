@@ -0,0 +1,126 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFake_Script(t *testing.T) {
+	f := NewFake()
+	f.Script(OperationAuthorize, Decline, Timeout)
+
+	for _, want := range []error{ErrDeclined, ErrTimeout, nil} {
+		if _, err := f.Authorize(context.Background(), "cart-1", 100); !errors.Is(err, want) {
+			t.Fatalf("Authorize error = %v, want %v", err, want)
+		}
+	}
+	if got := f.Calls(OperationAuthorize); got != 3 {
+		t.Fatalf("Calls = %d, want 3", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := f.Authorize(ctx, "cart-2", 100); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Authorize with a done context error = %v, want %v", err, ErrTimeout)
+	}
+}
+
+func TestFake_Authorize(t *testing.T) {
+	f := NewFake()
+	ctx := context.Background()
+
+	a, err := f.Authorize(ctx, "cart-1", 100)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+
+	// the same reference and amount is the same authorization
+	again, _ := f.Authorize(ctx, "cart-1", 100)
+	if again != a {
+		t.Fatalf("Authorize not idempotent: %+v != %+v", again, a)
+	}
+	if other, _ := f.Authorize(ctx, "cart-1", 200); other.ID == a.ID {
+		t.Fatalf("another amount reused authorization %s", a.ID)
+	}
+
+	// a voided authorization is not reused
+	if err := f.Void(ctx, a.ID); err != nil {
+		t.Fatalf("Void: %v", err)
+	}
+	if fresh, _ := f.Authorize(ctx, "cart-1", 100); fresh.ID == a.ID {
+		t.Fatalf("voided authorization %s reused", a.ID)
+	}
+
+	if _, err := f.Authorize(ctx, "cart-2", 0); !errors.Is(err, ErrInvalidAmount) {
+		t.Fatalf("Authorize 0 error = %v, want %v", err, ErrInvalidAmount)
+	}
+}
+
+func TestFake_CaptureVoidRefund(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		run  func(f *Fake, id string) error
+		want error
+	}{
+		{"capture", func(f *Fake, id string) error { return f.Capture(ctx, id, 100) }, nil},
+		{"capture less", func(f *Fake, id string) error { return f.Capture(ctx, id, 60) }, nil},
+		{"capture twice", func(f *Fake, id string) error {
+			_ = f.Capture(ctx, id, 100)
+			return f.Capture(ctx, id, 100)
+		}, nil},
+		{"capture another amount", func(f *Fake, id string) error {
+			_ = f.Capture(ctx, id, 100)
+			return f.Capture(ctx, id, 60)
+		}, ErrInvalidState},
+		{"capture more", func(f *Fake, id string) error { return f.Capture(ctx, id, 101) }, ErrInvalidAmount},
+		{"capture voided", func(f *Fake, id string) error {
+			_ = f.Void(ctx, id)
+			return f.Capture(ctx, id, 100)
+		}, ErrInvalidState},
+		{"capture unknown", func(f *Fake, id string) error { return f.Capture(ctx, "auth-x", 100) }, ErrUnknownAuthorization},
+		{"void twice", func(f *Fake, id string) error {
+			_ = f.Void(ctx, id)
+			return f.Void(ctx, id)
+		}, nil},
+		{"void captured", func(f *Fake, id string) error {
+			_ = f.Capture(ctx, id, 100)
+			return f.Void(ctx, id)
+		}, ErrInvalidState},
+		{"refund not captured", func(f *Fake, id string) error { return f.Refund(ctx, id, 10, "r1") }, ErrInvalidState},
+		{"refund reference once", func(f *Fake, id string) error {
+			_ = f.Capture(ctx, id, 100)
+			_ = f.Refund(ctx, id, 100, "r1")
+			return f.Refund(ctx, id, 100, "r1")
+		}, nil},
+		{"refund more than captured", func(f *Fake, id string) error {
+			_ = f.Capture(ctx, id, 100)
+			_ = f.Refund(ctx, id, 60, "r1")
+			return f.Refund(ctx, id, 60, "r2")
+		}, ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFake()
+			a, err := f.Authorize(ctx, "cart-1", 100)
+			if err != nil {
+				t.Fatalf("Authorize: %v", err)
+			}
+			if err := tt.run(f, a.ID); !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	f := NewFake()
+	a, _ := f.Authorize(ctx, "cart-1", 100)
+	_ = f.Capture(ctx, a.ID, 100)
+	_ = f.Refund(ctx, a.ID, 30, "r1")
+	_ = f.Refund(ctx, a.ID, 30, "r1")
+	if state, _ := f.State(a.ID); state.Captured != 100 || state.Refunded != 30 || state.Voided {
+		t.Fatalf("unexpected state: %+v", state)
+	}
+}
@@ -0,0 +1,53 @@
+// Package payment defines how the shop moves money through a payment gateway.
+//
+// An order is paid in two steps: the amount is authorized when the cart is
+// submitted and captured when the cart is paid. An authorization that is not
+// captured can be voided; a captured one can be refunded, in full or in parts.
+//
+// Authorizations are requested from inside database transactions, which may run
+// more than once; captures, voids and refunds are made between transactions and
+// made again when their outcome could not be stored. So every operation is
+// idempotent: authorizing the same reference and amount again returns the same
+// authorization, capturing or voiding twice is a no-op, and a refund reference is
+// only refunded once.
+package payment
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrDeclined is returned when the gateway refuses an operation.
+	ErrDeclined = errors.New("payment declined")
+	// ErrTimeout is returned when the gateway does not answer in time.
+	ErrTimeout = errors.New("payment gateway timeout")
+	// ErrUnknownAuthorization is returned for an authorization the gateway does not know.
+	ErrUnknownAuthorization = errors.New("unknown payment authorization")
+	// ErrInvalidState is returned when an authorization cannot go through an operation,
+	// e.g. capturing a voided authorization.
+	ErrInvalidState = errors.New("invalid payment authorization state")
+	// ErrInvalidAmount is returned for a non-positive amount or one above what can be moved.
+	ErrInvalidAmount = errors.New("invalid payment amount")
+)
+
+type (
+	// Gateway moves the money of orders. Amounts are expressed in integer cents (int64).
+	Gateway interface {
+		// Authorize holds amount for reference, usually the cart being submitted.
+		Authorize(ctx context.Context, reference string, amount int64) (Authorization, error)
+		// Capture collects amount from an authorization; it cannot exceed the authorized amount.
+		Capture(ctx context.Context, authorizationID string, amount int64) error
+		// Void releases an authorization that was not captured.
+		Void(ctx context.Context, authorizationID string) error
+		// Refund gives back amount of what was captured; reference identifies the refund.
+		Refund(ctx context.Context, authorizationID string, amount int64, reference string) error
+	}
+
+	// Authorization is an amount held by the gateway for a reference.
+	Authorization struct {
+		ID        string
+		Reference string
+		Amount    int64
+	}
+)
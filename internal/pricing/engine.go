@@ -0,0 +1,333 @@
+// Package pricing resolves which promotions apply to a cart and applies them.
+//
+// Promotions are considered by decreasing priority. A promotion of an exclusivity
+// group is skipped once another promotion of the group applied, and a promotion
+// flagged to stop further processing keeps the lower priority ones off the lines
+// it changed. Neither a line nor the order is ever discounted beyond its value.
+//
+// BestDeal instead searches which of the promotions to apply for the lowest cart total.
+package pricing
+
+import (
+	"sort"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/order"
+	"github.com/gambarini/flip-shop/internal/model/promotion"
+)
+
+// Reasons a promotion in effect was not applied.
+const (
+	// SkipExcluded is the reason of a promotion whose exclusivity group already has a promotion applied.
+	SkipExcluded = "excluded"
+	// SkipStopped is the reason of a promotion kept off every line it would change by a promotion that stops further processing.
+	SkipStopped = "stopped"
+)
+
+type (
+	// Candidate is a promotion considered for a cart: that of an automatic promotion rule,
+	// identified by RuleID, or that of a Coupon code.
+	// Candidates of higher Priority are considered first. Only one candidate of a non-empty Group
+	// applies; once a Stop candidate applied, no other applies to the lines it changed.
+	// Skipped is the reason a candidate is not in effect; it is reported but not applied.
+	Candidate struct {
+		promotion.Promotion
+		RuleID   string
+		Coupon   string
+		Priority int
+		Group    string
+		Stop     bool
+		Skipped  string
+	}
+
+	// Result is what applying promotions did to a cart: the check of every candidate, in the order
+	// they were considered, by SKU the promotions applied to each line and those applied to the
+	// order as a whole. CouponUses counts, by code, the uses of a coupon that applied.
+	Result struct {
+		Checks     []cart.PromotionCheck
+		Applied    map[item.Sku][]order.AppliedPromotion
+		Order      []order.AppliedPromotion
+		CouponUses map[string]int
+	}
+)
+
+// Apply resolves the candidates against a cart and applies the winning ones. A promotion.CartPromotion
+// is applied through ApplyToCart, seeing the purchases as the candidates considered before it left them.
+// addItem adds the free units a promotion gives to the cart, e.g. once their stock is reserved.
+// An error of a promotion or of addItem aborts the application, leaving the cart partially changed.
+func Apply(c *cart.Cart, candidates []Candidate, addItem promotion.AddPromoItemToCartHandler) (Result, error) {
+
+	ordered := make([]Candidate, len(candidates))
+	copy(ordered, candidates)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Priority > ordered[j].Priority })
+
+	res := Result{Checks: make([]cart.PromotionCheck, 0, len(ordered)), Applied: map[item.Sku][]order.AppliedPromotion{}, CouponUses: map[string]int{}}
+	groups := map[string]bool{}
+	stopped := map[item.Sku]bool{}
+	orderStopped := false
+
+	for _, cd := range ordered {
+
+		check := cart.PromotionCheck{PromotionID: cd.RuleID, Promotion: promotion.Name(cd.Promotion), Coupon: cd.Coupon, Skipped: cd.Skipped}
+
+		switch {
+		case cd.Skipped != "":
+		case cd.Group != "" && groups[cd.Group]:
+			check.Skipped = SkipExcluded
+		default:
+			run := application{cart: c, candidate: cd, stopped: stopped, orderStopped: orderStopped, addItem: addItem, result: &res}
+
+			var err error
+
+			if cp, ok := cd.Promotion.(promotion.CartPromotion); ok {
+				err = cp.ApplyToCart(promotion.CartHandlers{
+					GetPurchases:      run.purchases,
+					AddPromoItem:      run.addPromo,
+					AddDiscount:       run.addDiscount,
+					AddBundleDiscount: run.addBundleDiscount,
+					AddOrderDiscount:  run.addOrderDiscount,
+					WaiveShipping:     run.waiveShipping,
+				})
+			} else {
+				err = cd.Apply(run.get, run.addPromo, run.addDiscount)
+			}
+
+			if err != nil {
+				return res, err
+			}
+
+			check.Applied = len(run.changed) > 0 || run.orderChanged
+
+			if !check.Applied && run.blocked {
+				check.Skipped = SkipStopped
+			}
+
+			if check.Applied && cd.Group != "" {
+				groups[cd.Group] = true
+			}
+
+			if check.Applied && cd.Coupon != "" {
+				res.CouponUses[cd.Coupon]++
+			}
+
+			if check.Applied && cd.Stop {
+				for _, sku := range run.changed {
+					stopped[sku] = true
+				}
+				orderStopped = orderStopped || run.orderChanged
+			}
+		}
+
+		res.Checks = appendCheck(res.Checks, check)
+	}
+
+	return res, nil
+}
+
+// application tracks what one candidate changes on the cart, through the promotion handlers.
+// Discounting the order or waiving its shipping changes the order as a whole, which a previous
+// promotion that stops further processing may have kept other promotions off.
+type application struct {
+	cart         *cart.Cart
+	candidate    Candidate
+	stopped      map[item.Sku]bool
+	orderStopped bool
+	addItem      promotion.AddPromoItemToCartHandler
+	result       *Result
+
+	changed      []item.Sku
+	orderChanged bool
+	blocked      bool
+}
+
+func (a *application) get(sku item.Sku) (promotion.PurchasedItem, bool) {
+
+	pu, ok := a.cart.Purchases[sku]
+
+	if !ok {
+		return promotion.PurchasedItem{}, false
+	}
+
+	return promotion.PurchasedItem{
+		Sku:      pu.Sku,
+		Name:     pu.Name,
+		Category: pu.Category,
+		Price:    pu.Price,
+		Qty:      pu.Qty,
+		Discount: pu.Discount,
+	}, true
+}
+
+func (a *application) purchases() []promotion.PurchasedItem {
+
+	skus := make([]item.Sku, 0, len(a.cart.Purchases))
+	for sku := range a.cart.Purchases {
+		skus = append(skus, sku)
+	}
+	sort.Slice(skus, func(i, j int) bool { return skus[i] < skus[j] })
+
+	purchases := make([]promotion.PurchasedItem, 0, len(skus))
+	for _, sku := range skus {
+		pu, _ := a.get(sku)
+		purchases = append(purchases, pu)
+	}
+
+	return purchases
+}
+
+func (a *application) addPromo(sku item.Sku, qty int) error {
+
+	if a.stopped[sku] {
+		a.blocked = true
+		return nil
+	}
+
+	if err := a.addItem(sku, qty); err != nil {
+		return err
+	}
+
+	a.record(sku, qty, 0)
+
+	return nil
+}
+
+// addDiscount discounts a line up to its value; the discount beyond is dropped.
+func (a *application) addDiscount(sku item.Sku, discount int64) error {
+
+	if a.stopped[sku] {
+		a.blocked = true
+		return nil
+	}
+
+	before := a.cart.Purchases[sku].Discount
+
+	if err := a.cart.DiscountPurchase(sku, discount); err != nil {
+		return err
+	}
+
+	if granted := a.cart.Purchases[sku].Discount - before; granted > 0 {
+		a.record(sku, 0, granted)
+	}
+
+	return nil
+}
+
+// addBundleDiscount discounts the lines of bundles all together: when a previous promotion that
+// stops further processing changed any of them, the bundles are not sold and no line is discounted.
+// Each line records the bundles it was sold in, even when its share of the discount is empty.
+func (a *application) addBundleDiscount(size, bundles int, shares []promotion.BundleShare) error {
+
+	for _, s := range shares {
+		if a.stopped[s.Sku] {
+			a.blocked = true
+			return nil
+		}
+	}
+
+	for _, s := range shares {
+		before := a.cart.Purchases[s.Sku].Discount
+
+		if err := a.cart.DiscountPurchase(s.Sku, s.Discount); err != nil {
+			return err
+		}
+
+		granted := a.cart.Purchases[s.Sku].Discount - before
+
+		a.changed = appendSku(a.changed, s.Sku)
+		a.result.Applied[s.Sku] = a.appendApplied(a.result.Applied[s.Sku], order.AppliedPromotion{Bundles: bundles, BundledQty: s.Units, Discount: granted})
+	}
+
+	return nil
+}
+
+// addOrderDiscount discounts the order up to the value of its purchases; the discount beyond is dropped.
+func (a *application) addOrderDiscount(discount int64) error {
+
+	if a.orderStopped {
+		a.blocked = true
+		return nil
+	}
+
+	before := a.cart.OrderDiscount
+
+	a.cart.DiscountOrder(discount)
+
+	if granted := a.cart.OrderDiscount - before; granted > 0 {
+		a.orderChanged = true
+		a.result.Order = a.appendApplied(a.result.Order, order.AppliedPromotion{Discount: granted})
+	}
+
+	return nil
+}
+
+func (a *application) waiveShipping() error {
+
+	if a.orderStopped {
+		a.blocked = true
+		return nil
+	}
+
+	if a.cart.FreeShipping {
+		return nil
+	}
+
+	a.cart.WaiveShipping()
+	a.orderChanged = true
+	a.result.Order = a.appendApplied(a.result.Order, order.AppliedPromotion{})
+
+	return nil
+}
+
+// record adds free units and discount granted to a line.
+func (a *application) record(sku item.Sku, freeQty int, discount int64) {
+	a.changed = appendSku(a.changed, sku)
+	a.result.Applied[sku] = a.appendApplied(a.result.Applied[sku], order.AppliedPromotion{FreeQty: freeQty, Discount: discount})
+}
+
+// appendApplied adds free units, bundles and discount under the promotion name, the coupon code
+// that unlocked it and the SKU earning its free units; promotions sharing them share one record.
+func (a *application) appendApplied(list []order.AppliedPromotion, applied order.AppliedPromotion) []order.AppliedPromotion {
+
+	name, code, purchased := promotion.Name(a.candidate.Promotion), a.candidate.Coupon, promotion.Purchased(a.candidate.Promotion)
+
+	for i := range list {
+		if list[i].Promotion == name && list[i].Coupon == code && list[i].PurchasedSku == purchased {
+			list[i].FreeQty += applied.FreeQty
+			list[i].Bundles += applied.Bundles
+			list[i].BundledQty += applied.BundledQty
+			list[i].Discount += applied.Discount
+			return list
+		}
+	}
+
+	applied.Promotion, applied.Coupon, applied.PurchasedSku = name, code, purchased
+	applied.Threshold = promotion.Threshold(a.candidate.Promotion)
+
+	return append(list, applied)
+}
+
+func appendSku(skus []item.Sku, sku item.Sku) []item.Sku {
+	for _, s := range skus {
+		if s == sku {
+			return skus
+		}
+	}
+	return append(skus, sku)
+}
+
+// appendCheck adds the check of a candidate, merging the uses of a coupon into one check.
+func appendCheck(checks []cart.PromotionCheck, check cart.PromotionCheck) []cart.PromotionCheck {
+	if check.Coupon != "" {
+		for i := range checks {
+			if checks[i].Coupon == check.Coupon {
+				checks[i].Applied = checks[i].Applied || check.Applied
+				if checks[i].Applied {
+					checks[i].Skipped = ""
+				}
+				return checks
+			}
+		}
+	}
+	return append(checks, check)
+}
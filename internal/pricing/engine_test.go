@@ -0,0 +1,225 @@
+package pricing
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/order"
+	"github.com/gambarini/flip-shop/internal/model/promotion"
+)
+
+func TestApply(t *testing.T) {
+	half := promotion.ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: "A", PercentageDiscount: 0.5}
+	tenth := promotion.ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: "A", PercentageDiscount: 0.1}
+	most := promotion.ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: "A", PercentageDiscount: 0.8}
+	threeForTwo := promotion.ItemQtyPriceFreePromotion{PurchasedItemSku: "A", PurchasedQty: 3}
+	freeA := promotion.FreeItemPromotion{PurchasedItemSku: "B", FreeItemSku: "A", FreeItemPrice: 1000}
+	halfB := promotion.ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: "B", PercentageDiscount: 0.5}
+	halfC := promotion.ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: "C", PercentageDiscount: 0.5}
+	pairAB := promotion.BundlePromotion{BundleSkus: []item.Sku{"A", "B"}, BundlePrice: 1000}
+
+	tests := []struct {
+		name         string
+		candidates   []Candidate
+		wantDiscount map[item.Sku]int64
+		wantChecks   []cart.PromotionCheck
+		wantApplied  map[item.Sku][]order.AppliedPromotion
+	}{
+		{
+			name:         "higher priority first within a group",
+			candidates:   []Candidate{{Promotion: tenth, RuleID: "tenth", Group: "a"}, {Promotion: half, RuleID: "half", Group: "a", Priority: 5}},
+			wantDiscount: map[item.Sku]int64{"A": 1500, "B": 0},
+			wantChecks: []cart.PromotionCheck{
+				{PromotionID: "half", Promotion: promotion.NameQtyPercentage, Applied: true},
+				{PromotionID: "tenth", Promotion: promotion.NameQtyPercentage, Skipped: SkipExcluded},
+			},
+			wantApplied: map[item.Sku][]order.AppliedPromotion{"A": {{Promotion: promotion.NameQtyPercentage, Discount: 1500}}},
+		},
+		{
+			name:         "a group is only taken by a promotion that applied",
+			candidates:   []Candidate{{Promotion: halfC, RuleID: "halfC", Group: "a", Priority: 5}, {Promotion: tenth, RuleID: "tenth", Group: "a"}},
+			wantDiscount: map[item.Sku]int64{"A": 300, "B": 0},
+			wantChecks: []cart.PromotionCheck{
+				{PromotionID: "halfC", Promotion: promotion.NameQtyPercentage},
+				{PromotionID: "tenth", Promotion: promotion.NameQtyPercentage, Applied: true},
+			},
+			wantApplied: map[item.Sku][]order.AppliedPromotion{"A": {{Promotion: promotion.NameQtyPercentage, Discount: 300}}},
+		},
+		{
+			name:         "stop keeps later promotions off its lines",
+			candidates:   []Candidate{{Promotion: half, RuleID: "half"}, {Promotion: threeForTwo, RuleID: "3for2", Priority: 1, Stop: true}},
+			wantDiscount: map[item.Sku]int64{"A": 1000, "B": 0},
+			wantChecks: []cart.PromotionCheck{
+				{PromotionID: "3for2", Promotion: promotion.NameQtyFree, Applied: true},
+				{PromotionID: "half", Promotion: promotion.NameQtyPercentage, Skipped: SkipStopped},
+			},
+			wantApplied: map[item.Sku][]order.AppliedPromotion{"A": {{Promotion: promotion.NameQtyFree, Threshold: 3, Discount: 1000}}},
+		},
+		{
+			name:         "stop only covers the lines it changed",
+			candidates:   []Candidate{{Promotion: freeA, RuleID: "freeA", Stop: true}, {Promotion: halfB, RuleID: "halfB"}, {Promotion: tenth, RuleID: "tenth"}},
+			wantDiscount: map[item.Sku]int64{"A": 1000, "B": 500},
+			wantChecks: []cart.PromotionCheck{
+				{PromotionID: "freeA", Promotion: promotion.NameFreeItem, Applied: true},
+				{PromotionID: "halfB", Promotion: promotion.NameQtyPercentage, Applied: true},
+				{PromotionID: "tenth", Promotion: promotion.NameQtyPercentage, Skipped: SkipStopped},
+			},
+			wantApplied: map[item.Sku][]order.AppliedPromotion{
+				"A": {{Promotion: promotion.NameFreeItem, FreeQty: 1, PurchasedSku: "B", Discount: 1000}},
+				"B": {{Promotion: promotion.NameQtyPercentage, Discount: 500}},
+			},
+		},
+		{
+			name:         "a bundle with a stopped line is not sold",
+			candidates:   []Candidate{{Promotion: halfB, RuleID: "halfB", Priority: 1, Stop: true}, {Promotion: pairAB, RuleID: "pair"}},
+			wantDiscount: map[item.Sku]int64{"A": 0, "B": 500},
+			wantChecks: []cart.PromotionCheck{
+				{PromotionID: "halfB", Promotion: promotion.NameQtyPercentage, Applied: true},
+				{PromotionID: "pair", Promotion: promotion.NameBundle, Skipped: SkipStopped},
+			},
+			wantApplied: map[item.Sku][]order.AppliedPromotion{"B": {{Promotion: promotion.NameQtyPercentage, Discount: 500}}},
+		},
+		{
+			name:         "a bundle spreads its discount over its lines",
+			candidates:   []Candidate{{Promotion: pairAB, RuleID: "pair"}},
+			wantDiscount: map[item.Sku]int64{"A": 500, "B": 500},
+			wantChecks:   []cart.PromotionCheck{{PromotionID: "pair", Promotion: promotion.NameBundle, Applied: true}},
+			wantApplied: map[item.Sku][]order.AppliedPromotion{
+				"A": {{Promotion: promotion.NameBundle, Threshold: 2, Bundles: 1, BundledQty: 1, Discount: 500}},
+				"B": {{Promotion: promotion.NameBundle, Threshold: 2, Bundles: 1, BundledQty: 1, Discount: 500}},
+			},
+		},
+		{
+			name:         "discount capped at the line value",
+			candidates:   []Candidate{{Promotion: most, RuleID: "first"}, {Promotion: most, RuleID: "second"}, {Promotion: half, Coupon: "HALF"}},
+			wantDiscount: map[item.Sku]int64{"A": 3000, "B": 0},
+			wantChecks: []cart.PromotionCheck{
+				{PromotionID: "first", Promotion: promotion.NameQtyPercentage, Applied: true},
+				{PromotionID: "second", Promotion: promotion.NameQtyPercentage, Applied: true},
+				{Promotion: promotion.NameQtyPercentage, Coupon: "HALF"},
+			},
+			wantApplied: map[item.Sku][]order.AppliedPromotion{"A": {{Promotion: promotion.NameQtyPercentage, Discount: 3000}}},
+		},
+		{
+			name:         "skipped candidates are reported only",
+			candidates:   []Candidate{{Promotion: half, RuleID: "half", Skipped: promotion.SkipEnded, Priority: 9}, {Promotion: tenth, RuleID: "tenth"}},
+			wantDiscount: map[item.Sku]int64{"A": 300, "B": 0},
+			wantChecks: []cart.PromotionCheck{
+				{PromotionID: "half", Promotion: promotion.NameQtyPercentage, Skipped: promotion.SkipEnded},
+				{PromotionID: "tenth", Promotion: promotion.NameQtyPercentage, Applied: true},
+			},
+			wantApplied: map[item.Sku][]order.AppliedPromotion{"A": {{Promotion: promotion.NameQtyPercentage, Discount: 300}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cart.NewAvailableCart()
+			if err := c.PurchaseItem(item.Item{Sku: "A", Name: "A", Price: 1000, QtyAvailable: 10}, 3); err != nil {
+				t.Fatalf("PurchaseItem() error = %v", err)
+			}
+			if err := c.PurchaseItem(item.Item{Sku: "B", Name: "B", Price: 1000, QtyAvailable: 10}, 1); err != nil {
+				t.Fatalf("PurchaseItem() error = %v", err)
+			}
+			addItem := func(sku item.Sku, qty int) error {
+				return c.AddPromotionItem(item.Item{Sku: sku, Name: string(sku), Price: 1000, QtyAvailable: 10}, qty)
+			}
+
+			res, err := Apply(&c, tt.candidates, addItem)
+
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			for sku, want := range tt.wantDiscount {
+				if got := c.Purchases[sku].Discount; got != want {
+					t.Errorf("Discount of %s = %d, want %d", sku, got, want)
+				}
+			}
+			if !reflect.DeepEqual(res.Checks, tt.wantChecks) {
+				t.Errorf("Checks = %+v, want %+v", res.Checks, tt.wantChecks)
+			}
+			if !reflect.DeepEqual(res.Applied, tt.wantApplied) {
+				t.Errorf("Applied = %+v, want %+v", res.Applied, tt.wantApplied)
+			}
+		})
+	}
+}
+
+func TestApply_CartPromotions(t *testing.T) {
+	tenth := promotion.ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: "A", PercentageDiscount: 0.1}
+	freeShipping := promotion.SpendFreeShippingPromotion{MinSpend: 3000}
+	spendTenth := promotion.SpendPercentagePromotion{MinSpend: 3000, PercentageDiscount: 0.1}
+	bigAmountOff := promotion.SpendAmountOffPromotion{Amount: 99999}
+
+	tests := []struct {
+		name              string
+		candidates        []Candidate
+		wantOrderDiscount int64
+		wantFreeShipping  bool
+		wantChecks        []cart.PromotionCheck
+		wantOrder         []order.AppliedPromotion
+		wantCouponUses    map[string]int
+	}{
+		{
+			name: "spend counted after line discounts, stop keeps later order discounts off",
+			candidates: []Candidate{
+				{Promotion: bigAmountOff, RuleID: "off"},
+				{Promotion: spendTenth, RuleID: "spend", Priority: 1, Stop: true},
+				{Promotion: freeShipping, RuleID: "ship", Priority: 2},
+				{Promotion: tenth, RuleID: "tenth", Priority: 3},
+			},
+			wantOrderDiscount: 370,
+			wantFreeShipping:  true,
+			wantChecks: []cart.PromotionCheck{
+				{PromotionID: "tenth", Promotion: promotion.NameQtyPercentage, Applied: true},
+				{PromotionID: "ship", Promotion: promotion.NameSpendFreeShipping, Applied: true},
+				{PromotionID: "spend", Promotion: promotion.NameSpendPercentage, Applied: true},
+				{PromotionID: "off", Promotion: promotion.NameSpendAmountOff, Skipped: SkipStopped},
+			},
+			wantOrder:      []order.AppliedPromotion{{Promotion: promotion.NameSpendFreeShipping}, {Promotion: promotion.NameSpendPercentage, Discount: 370}},
+			wantCouponUses: map[string]int{},
+		},
+		{
+			name:              "order discount capped at the merchandise",
+			candidates:        []Candidate{{Promotion: tenth, RuleID: "tenth"}, {Promotion: bigAmountOff, Coupon: "OFF"}, {Promotion: bigAmountOff, Coupon: "OFF"}},
+			wantOrderDiscount: 3700,
+			wantChecks: []cart.PromotionCheck{
+				{PromotionID: "tenth", Promotion: promotion.NameQtyPercentage, Applied: true},
+				{Promotion: promotion.NameSpendAmountOff, Coupon: "OFF", Applied: true},
+			},
+			wantOrder: []order.AppliedPromotion{{Promotion: promotion.NameSpendAmountOff, Coupon: "OFF", Discount: 3700}},
+			// the second use found nothing left to discount
+			wantCouponUses: map[string]int{"OFF": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cart.NewAvailableCart()
+			if err := c.PurchaseItem(item.Item{Sku: "A", Name: "A", Price: 1000, QtyAvailable: 10}, 3); err != nil {
+				t.Fatalf("PurchaseItem() error = %v", err)
+			}
+			if err := c.PurchaseItem(item.Item{Sku: "B", Name: "B", Price: 1000, QtyAvailable: 10}, 1); err != nil {
+				t.Fatalf("PurchaseItem() error = %v", err)
+			}
+
+			res, err := Apply(&c, tt.candidates, nil)
+
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if c.OrderDiscount != tt.wantOrderDiscount || c.FreeShipping != tt.wantFreeShipping {
+				t.Errorf("OrderDiscount = %d, FreeShipping = %v, want %d, %v", c.OrderDiscount, c.FreeShipping, tt.wantOrderDiscount, tt.wantFreeShipping)
+			}
+			if !reflect.DeepEqual(res.Checks, tt.wantChecks) {
+				t.Errorf("Checks = %+v, want %+v", res.Checks, tt.wantChecks)
+			}
+			if !reflect.DeepEqual(res.Order, tt.wantOrder) {
+				t.Errorf("Order = %+v, want %+v", res.Order, tt.wantOrder)
+			}
+			if !reflect.DeepEqual(res.CouponUses, tt.wantCouponUses) {
+				t.Errorf("CouponUses = %v, want %v", res.CouponUses, tt.wantCouponUses)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package pricing
+
+import (
+	"sort"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/promotion"
+)
+
+// Hint tells how far a cart is from a promotion in effect that did not apply to it:
+// that of an automatic promotion rule, identified by PromotionID, or that of a Coupon code.
+type Hint struct {
+	PromotionID string
+	Promotion   string
+	Coupon      string
+	promotion.Shortfall
+}
+
+// Hints returns how far a cart, as Apply left it, is from each of the candidates that did not apply to it
+// although in effect, by the checks Apply reported; candidates kept off by another are left out, since
+// qualifying would not apply them either. Hints come in resolution order, once per coupon code.
+func Hints(c cart.Cart, candidates []Candidate, checks []cart.PromotionCheck) []Hint {
+
+	ordered := make([]Candidate, len(candidates))
+	copy(ordered, candidates)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Priority > ordered[j].Priority })
+
+	run := application{cart: &c}
+	purchases := run.purchases()
+
+	var hints []Hint
+	seen := map[string]bool{}
+
+	for _, cd := range ordered {
+
+		if cd.Skipped != "" || (cd.Coupon != "" && seen[cd.Coupon]) {
+			continue
+		}
+		seen[cd.Coupon] = cd.Coupon != ""
+
+		check, found := checkOf(checks, cd)
+
+		if !found || check.Applied || check.Skipped != "" {
+			continue
+		}
+
+		if s, ok := promotion.ShortfallOf(cd.Promotion, purchases); ok {
+			hints = append(hints, Hint{PromotionID: cd.RuleID, Promotion: check.Promotion, Coupon: cd.Coupon, Shortfall: s})
+		}
+	}
+
+	return hints
+}
+
+// checkOf finds the check of a candidate: that of its rule, or that of its coupon code.
+func checkOf(checks []cart.PromotionCheck, cd Candidate) (cart.PromotionCheck, bool) {
+	for _, check := range checks {
+		if (cd.Coupon != "" && check.Coupon == cd.Coupon) || (cd.Coupon == "" && check.PromotionID == cd.RuleID) {
+			return check, true
+		}
+	}
+	return cart.PromotionCheck{}, false
+}
@@ -0,0 +1,75 @@
+package pricing
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/promotion"
+)
+
+func TestHints(t *testing.T) {
+	fourForThree := promotion.ItemQtyPriceFreePromotion{PurchasedItemSku: "A", PurchasedQty: 4}
+	threeForTwo := promotion.ItemQtyPriceFreePromotion{PurchasedItemSku: "A", PurchasedQty: 3}
+	halfB := promotion.ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: "B", PercentageDiscount: 0.5}
+	spend := promotion.SpendAmountOffPromotion{MinSpend: 5000, Amount: 500}
+	bundle := promotion.BundlePromotion{BundleSkus: []item.Sku{"A", "C"}, BundlePrice: 1500}
+
+	tests := []struct {
+		name       string
+		candidates []Candidate
+		want       []Hint
+	}{
+		{
+			name:       "promotions the cart falls short of, in resolution order",
+			candidates: []Candidate{{Promotion: spend, RuleID: "spend"}, {Promotion: halfB, RuleID: "halfB", Priority: 5}, {Promotion: fourForThree, RuleID: "4for3"}},
+			want: []Hint{
+				{PromotionID: "spend", Promotion: promotion.NameSpendAmountOff, Shortfall: promotion.Shortfall{Spend: 1500}},
+				{PromotionID: "4for3", Promotion: promotion.NameQtyFree, Shortfall: promotion.Shortfall{Qty: 1, Skus: []item.Sku{"A"}}},
+			},
+		},
+		{
+			name:       "applied promotions give no hint",
+			candidates: []Candidate{{Promotion: threeForTwo, RuleID: "3for2"}},
+		},
+		{
+			name:       "promotions kept off by another give no hint",
+			candidates: []Candidate{{Promotion: threeForTwo, RuleID: "3for2", Group: "a", Priority: 1}, {Promotion: fourForThree, RuleID: "4for3", Group: "a"}},
+		},
+		{
+			name:       "promotions left out of the best deal give no hint",
+			candidates: []Candidate{{Promotion: fourForThree, RuleID: "4for3", Skipped: SkipOutdone}},
+		},
+		{
+			name:       "a coupon code hints once",
+			candidates: []Candidate{{Promotion: bundle, Coupon: "PAIR"}, {Promotion: bundle, Coupon: "PAIR"}},
+			want: []Hint{
+				{Promotion: promotion.NameBundle, Coupon: "PAIR", Shortfall: promotion.Shortfall{Qty: 1, Skus: []item.Sku{"C"}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cart.NewAvailableCart()
+			if err := c.PurchaseItem(item.Item{Sku: "A", Name: "A", Price: 1000, QtyAvailable: 10}, 3); err != nil {
+				t.Fatalf("PurchaseItem() error = %v", err)
+			}
+			if err := c.PurchaseItem(item.Item{Sku: "B", Name: "B", Price: 1000, QtyAvailable: 10}, 1); err != nil {
+				t.Fatalf("PurchaseItem() error = %v", err)
+			}
+			addItem := func(sku item.Sku, qty int) error {
+				return c.AddPromotionItem(item.Item{Sku: sku, Name: string(sku), Price: 1000, QtyAvailable: 10}, qty)
+			}
+
+			res, err := Apply(&c, tt.candidates, addItem)
+
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if got := Hints(c, tt.candidates, res.Checks); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Hints() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package pricing
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/utils"
+)
+
+// SkipOutdone is the reason of a promotion left out of the best deal of a cart.
+const SkipOutdone = "outdone"
+
+// DefaultSearchLimit is how many combinations of promotions BestDeal evaluates when no limit is given.
+const DefaultSearchLimit = 1024
+
+// FindItemHandler finds an item a promotion may give for free.
+type FindItemHandler func(sku item.Sku) (item.Item, error)
+
+// BestDeal picks the combination of candidates giving the lowest cart total before tax, shipping included,
+// and returns the candidates with those left out marked SkipOutdone. Combinations are resolved as Apply
+// does, on a copy of the cart; those giving free units beyond the stock available are not feasible.
+//
+// When the combinations of candidates fit in limit evaluations, they are all evaluated. Otherwise, from
+// every candidate applying, one candidate at a time is left out or brought back as long as it lowers the
+// total, until no change does or limit evaluations are spent. A limit of 0 or less means DefaultSearchLimit.
+// Between combinations giving the same total, the one keeping the first candidates in resolution order wins.
+func BestDeal(c cart.Cart, candidates []Candidate, find FindItemHandler, limit int) ([]Candidate, error) {
+
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	// eligible holds the index of the candidates in effect, in resolution order
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return candidates[order[i]].Priority > candidates[order[j]].Priority })
+
+	var eligible []int
+	for _, i := range order {
+		if candidates[i].Skipped == "" {
+			eligible = append(eligible, i)
+		}
+	}
+
+	s := search{cart: c, candidates: candidates, eligible: eligible, find: find, items: map[item.Sku]item.Item{}}
+
+	keep := make([]bool, len(eligible))
+	for i := range keep {
+		keep[i] = true
+	}
+
+	var err error
+
+	if len(eligible) < 63 && 1<<uint(len(eligible)) <= limit {
+		keep, err = s.exhaustive()
+	} else {
+		keep, err = s.local(keep, limit)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	picked := make([]Candidate, len(candidates))
+	copy(picked, candidates)
+
+	for k, i := range eligible {
+		if !keep[k] {
+			picked[i].Skipped = SkipOutdone
+		}
+	}
+
+	return picked, nil
+}
+
+// search evaluates combinations of the eligible candidates on copies of a cart.
+type search struct {
+	cart       cart.Cart
+	candidates []Candidate
+	eligible   []int
+	find       FindItemHandler
+	items      map[item.Sku]item.Item
+}
+
+// exhaustive evaluates every combination, from keeping every candidate to keeping none,
+// in the order of preference between combinations giving the same total.
+func (s *search) exhaustive() ([]bool, error) {
+
+	n := len(s.eligible)
+	best, bestTotal := make([]bool, n), int64(math.MaxInt64)
+
+	for mask := 1<<uint(n) - 1; mask >= 0; mask-- {
+
+		keep := make([]bool, n)
+		for k := range keep {
+			keep[k] = mask&(1<<uint(n-1-k)) != 0
+		}
+
+		total, ok, err := s.total(keep)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if ok && total < bestTotal {
+			best, bestTotal = keep, total
+		}
+	}
+
+	return best, nil
+}
+
+// local improves a combination one candidate at a time, within limit evaluations.
+func (s *search) local(keep []bool, limit int) ([]bool, error) {
+
+	total, ok, err := s.total(keep)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		total = math.MaxInt64
+	}
+
+	for evaluated, improved := 1, true; improved && evaluated < limit; {
+
+		improved = false
+
+		for k := 0; k < len(keep) && evaluated < limit; k++ {
+
+			keep[k] = !keep[k]
+			t, ok, err := s.total(keep)
+			evaluated++
+
+			if err != nil {
+				return nil, err
+			}
+
+			if ok && t < total {
+				total, improved = t, true
+				continue
+			}
+
+			keep[k] = !keep[k]
+		}
+	}
+
+	if total == math.MaxInt64 {
+		// not even the starting combination is feasible; keeping no candidate always is
+		return make([]bool, len(keep)), nil
+	}
+
+	return keep, nil
+}
+
+// total applies a combination of candidates to a copy of the cart and returns what it costs before tax.
+// ok is false when the combination gives more free units of an item than it has available.
+func (s *search) total(keep []bool) (total int64, ok bool, err error) {
+
+	c := s.cart
+	c.Purchases = make(map[item.Sku]cart.Purchase, len(s.cart.Purchases))
+	for sku, p := range s.cart.Purchases {
+		c.Purchases[sku] = p
+	}
+
+	kept := make([]Candidate, 0, len(keep))
+	for k, i := range s.eligible {
+		if keep[k] {
+			kept = append(kept, s.candidates[i])
+		}
+	}
+
+	reserved := map[item.Sku]int{}
+
+	addItem := func(sku item.Sku, qty int) error {
+
+		i, found := s.items[sku]
+
+		if !found {
+			var err error
+			if i, err = s.find(sku); err != nil {
+				return err
+			}
+			s.items[sku] = i
+		}
+
+		// i is a copy: the stock is only checked, never reserved
+		if err := i.ReserveItem(reserved[sku] + qty); err != nil {
+			return err
+		}
+
+		reserved[sku] += qty
+
+		return c.AddPromotionItem(i, qty)
+	}
+
+	if _, err = Apply(&c, kept, addItem); err != nil {
+		if errors.Is(err, item.ErrItemNotAvailableReservation) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	total = utils.SaturatingSubInt64(c.Merchandise(), c.OrderDiscount)
+
+	if c.Shipping.Method.Code != "" && !c.FreeShipping {
+		var weight int64
+		for _, p := range c.Purchases {
+			weight = utils.SaturatingAddInt64(weight, utils.SaturatingMulInt64Int(int64(p.Weight), p.Qty))
+		}
+		total = utils.SaturatingAddInt64(total, c.Shipping.Method.Price(total, weight))
+	}
+
+	return total, true, nil
+}
@@ -0,0 +1,133 @@
+package pricing
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/promotion"
+)
+
+func TestBestDeal(t *testing.T) {
+	tenth := promotion.ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: "A", PercentageDiscount: 0.1}
+	threeForTwo := promotion.ItemQtyPriceFreePromotion{PurchasedItemSku: "A", PurchasedQty: 3}
+	freeC := promotion.FreeItemPromotion{PurchasedItemSku: "B", FreeItemSku: "C", FreeItemPrice: 1000}
+	halfB := promotion.ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: "B", PercentageDiscount: 0.5}
+	errFind := errors.New("find failed")
+
+	tests := []struct {
+		name       string
+		candidates []Candidate
+		stockOfC   int
+		limit      int
+		findErr    error
+		wantKept   []bool
+		wantTotal  int64
+		wantErr    error
+	}{
+		{
+			name:       "the better deal of a group",
+			candidates: []Candidate{{Promotion: tenth, Group: "a", Priority: 1}, {Promotion: threeForTwo, Group: "a"}},
+			wantKept:   []bool{false, true},
+			wantTotal:  6000 - 2000 + 1000,
+		},
+		{
+			name:       "a stop promotion left out for a better deal",
+			candidates: []Candidate{{Promotion: tenth, Priority: 1, Stop: true}, {Promotion: threeForTwo}},
+			wantKept:   []bool{false, true},
+			wantTotal:  6000 - 2000 + 1000,
+		},
+		{
+			name:       "promotions that add up are all kept",
+			candidates: []Candidate{{Promotion: tenth}, {Promotion: threeForTwo}, {Promotion: halfB}},
+			wantKept:   []bool{true, true, true},
+			wantTotal:  6000 - 600 - 2000 + 500,
+		},
+		{
+			name:       "equal deals keep the first candidates",
+			candidates: []Candidate{{Promotion: tenth, Group: "a"}, {Promotion: tenth, Group: "a"}, {Promotion: halfB, Coupon: "HALF", Skipped: promotion.SkipEnded}},
+			wantKept:   []bool{true, true, true},
+			wantTotal:  6000 - 600 + 1000,
+		},
+		{
+			name:       "free units beyond the stock are not feasible",
+			candidates: []Candidate{{Promotion: freeC, Group: "b", Priority: 1}, {Promotion: halfB, Group: "b"}},
+			stockOfC:   0,
+			wantKept:   []bool{false, true},
+			wantTotal:  6000 + 500,
+		},
+		{
+			name:       "free units within the stock",
+			candidates: []Candidate{{Promotion: freeC}, {Promotion: halfB}},
+			stockOfC:   1,
+			wantKept:   []bool{true, true},
+			wantTotal:  6000 + 500 + 1000 - 1000,
+		},
+		{
+			name:       "a limit too low to search keeps every candidate",
+			candidates: []Candidate{{Promotion: tenth, Group: "a", Priority: 1}, {Promotion: threeForTwo, Group: "a"}},
+			limit:      1,
+			wantKept:   []bool{true, true},
+			wantTotal:  6000 - 600 + 1000,
+		},
+		{
+			name:       "a bounded search improves one candidate at a time",
+			candidates: []Candidate{{Promotion: tenth, Group: "a", Priority: 1}, {Promotion: threeForTwo, Group: "a"}, {Promotion: halfB}},
+			limit:      4,
+			wantKept:   []bool{false, true, true},
+			wantTotal:  6000 - 2000 + 500,
+		},
+		{
+			name:       "an error finding an item",
+			candidates: []Candidate{{Promotion: freeC}},
+			findErr:    errFind,
+			wantErr:    errFind,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cart.NewAvailableCart()
+			if err := c.PurchaseItem(item.Item{Sku: "A", Name: "A", Price: 1000, QtyAvailable: 10}, 6); err != nil {
+				t.Fatalf("PurchaseItem() error = %v", err)
+			}
+			if err := c.PurchaseItem(item.Item{Sku: "B", Name: "B", Price: 1000, QtyAvailable: 10}, 1); err != nil {
+				t.Fatalf("PurchaseItem() error = %v", err)
+			}
+			find := func(sku item.Sku) (item.Item, error) {
+				return item.Item{Sku: sku, Name: string(sku), Price: 1000, QtyAvailable: tt.stockOfC}, tt.findErr
+			}
+
+			picked, err := BestDeal(c, tt.candidates, find, tt.limit)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("BestDeal() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			kept := make([]bool, len(picked))
+			for i, p := range picked {
+				kept[i] = p.Skipped != SkipOutdone
+			}
+			if !reflect.DeepEqual(kept, tt.wantKept) {
+				t.Fatalf("kept = %v, want %v", kept, tt.wantKept)
+			}
+
+			if _, err := Apply(&c, picked, func(sku item.Sku, qty int) error {
+				i, _ := find(sku)
+				return c.AddPromotionItem(i, qty)
+			}); err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			var total int64
+			for _, p := range c.Purchases {
+				total += p.Price*int64(p.Qty) - p.Discount
+			}
+			if total != tt.wantTotal {
+				t.Fatalf("total = %d, want %d", total, tt.wantTotal)
+			}
+		})
+	}
+}
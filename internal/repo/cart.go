@@ -10,6 +10,8 @@ import (
 const (
 	// CartStoreName is the store name for carts in the KV database.
 	CartStoreName = utils.StoreName("Cart")
+	// CartStatusIndex indexes carts by their status.
+	CartStatusIndex = "status"
 )
 
 type (
@@ -18,8 +20,14 @@ type (
 		utils.KVRepository
 		// FindCartByID loads a cart by its identifier.
 		FindCartByID(id string) (c cart.Cart, err error)
+		// FindCartByIDTx loads a cart by its identifier using the provided transaction.
+		FindCartByIDTx(tx utils.Tx, id string) (c cart.Cart, err error)
 		// Store persists the given cart within the provided transaction.
 		Store(tx utils.Tx, c cart.Cart) (err error)
+		// Delete removes a cart within the provided transaction.
+		Delete(tx utils.Tx, id string) (err error)
+		// FindCartsByStatus returns the carts in the given status ordered by ID.
+		FindCartsByStatus(tx utils.Tx, status cart.Status) ([]cart.Cart, error)
 	}
 
 	// CartRepository is a concrete implementation of ICartRepository backed by a KVDatabase.
@@ -34,7 +42,9 @@ var (
 )
 
 // NewCartRepository creates a new CartRepository using the provided KV database.
+// It registers the cart indexes on the database.
 func NewCartRepository(kvDb utils.KVDatabase) *CartRepository {
+	kvDb.RegisterIndex(CartStoreName, CartStatusIndex, cartStatusIndex)
 	return &CartRepository{
 		kvDb,
 	}
@@ -51,15 +61,81 @@ func (repo CartRepository) FindCartByID(id string) (c cart.Cart, err error) {
 	case err != nil:
 		return c, err
 	default:
-		return v.(cart.Cart), nil
+		return decodeCart(v)
+	}
+}
+
+// FindCartByIDTx reads a cart within the given transaction, so the read is part of its isolation.
+// Handlers changing a cart re-read it this way inside their transaction, rather than reusing a cart
+// read before it, so concurrent updates are not lost.
+func (repo CartRepository) FindCartByIDTx(tx utils.Tx, id string) (c cart.Cart, err error) {
+
+	v, err := tx.Read(CartStoreName, id)
+
+	switch {
+	case errors.Is(err, utils.ErrValueNotFound):
+		return c, ErrCartNotFound
+	case err != nil:
+		return c, err
+	default:
+		return decodeCart(v)
 	}
 }
 
 // Store writes a cart into the KV database within the given transaction.
 func (repo CartRepository) Store(tx utils.Tx, c cart.Cart) (err error) {
 
-	tx.Write(CartStoreName, string(c.CartID), c)
+	b, err := Codecs.Encode(CartStoreName, c)
+
+	if err != nil {
+		return err
+	}
+
+	tx.Write(CartStoreName, string(c.CartID), b)
 
 	return nil
 
 }
+
+// Delete removes a cart from the KV database within the given transaction.
+// return ErrCartNotFound if the cart does not exist
+func (repo CartRepository) Delete(tx utils.Tx, id string) (err error) {
+
+	if _, err := tx.Read(CartStoreName, id); err != nil {
+		if errors.Is(err, utils.ErrValueNotFound) {
+			return ErrCartNotFound
+		}
+		return err
+	}
+
+	tx.Delete(CartStoreName, id)
+
+	return nil
+}
+
+// FindCartsByStatus looks carts up by status through the status index.
+func (repo CartRepository) FindCartsByStatus(tx utils.Tx, status cart.Status) ([]cart.Cart, error) {
+	kvs, err := tx.Lookup(CartStoreName, CartStatusIndex, string(status))
+	if err != nil {
+		return nil, err
+	}
+	carts := make([]cart.Cart, 0, len(kvs))
+	for _, kv := range kvs {
+		c, err := decodeCart(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		carts = append(carts, c)
+	}
+	return carts, nil
+}
+
+// cartStatusIndex extracts the status of a stored cart.
+// Values that cannot be decoded are left out of the index.
+func cartStatusIndex(v interface{}) []string {
+	c, err := decodeCart(v)
+	if err != nil {
+		return nil
+	}
+	return []string{string(c.CartStatus)}
+}
@@ -0,0 +1,156 @@
+package repo
+
+import (
+	"fmt"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/order"
+	"github.com/gambarini/flip-shop/internal/model/promotion"
+	"github.com/gambarini/flip-shop/utils"
+)
+
+// Codecs holds the value codecs of the stores managed by the repositories.
+// Repositories write encoded bytes into the KV database, so any backend able to
+// store []byte can persist them. JSON is the default format.
+var Codecs = utils.NewCodecRegistry()
+
+func init() {
+	RegisterCodecs(Codecs, utils.JSONFormat{})
+}
+
+// RegisterCodecs registers the codecs for every repository store using the given format.
+// Records previously written with another format can still be decoded.
+//
+// When a model struct evolves in an incompatible way, keep the previous layout as a
+// versioned struct, add a Schema for it that upgrades to the current model, and bump
+// the version of the current schema.
+func RegisterCodecs(registry *utils.CodecRegistry, format utils.Format) {
+	registry.Register(ItemStoreName, utils.NewVersionedCodec(format, itemSchemaV1))
+	registry.Register(CartStoreName, utils.NewVersionedCodec(format, cartSchemaV1))
+	registry.Register(OrderStoreName, utils.NewVersionedCodec(format, orderSchemaV1))
+	registry.Register(ReturnStoreName, utils.NewVersionedCodec(format, returnSchemaV1))
+	registry.Register(OrderSequenceStoreName, utils.NewVersionedCodec(format, sequenceSchemaV1))
+	registry.Register(CouponRedemptionStoreName, utils.NewVersionedCodec(format, sequenceSchemaV1))
+	registry.Register(PromotionStoreName, utils.NewVersionedCodec(format, promotionSchemaV1))
+	registry.Register(PromotionSequenceStoreName, utils.NewVersionedCodec(format, sequenceSchemaV1))
+}
+
+var (
+	itemSchemaV1 = utils.Schema{
+		Version: 1,
+		New:     func() interface{} { return &item.Item{} },
+		Upgrade: func(decoded interface{}) (interface{}, error) {
+			return *decoded.(*item.Item), nil
+		},
+	}
+
+	cartSchemaV1 = utils.Schema{
+		Version: 1,
+		New:     func() interface{} { return &cart.Cart{} },
+		Upgrade: func(decoded interface{}) (interface{}, error) {
+			c := *decoded.(*cart.Cart)
+			// formats may drop empty maps; carts always carry a usable Purchases map
+			if c.Purchases == nil {
+				c.Purchases = make(map[item.Sku]cart.Purchase)
+			}
+			return c, nil
+		},
+	}
+
+	orderSchemaV1 = utils.Schema{
+		Version: 1,
+		New:     func() interface{} { return &order.Order{} },
+		Upgrade: func(decoded interface{}) (interface{}, error) {
+			return *decoded.(*order.Order), nil
+		},
+	}
+
+	returnSchemaV1 = utils.Schema{
+		Version: 1,
+		New:     func() interface{} { return &order.Return{} },
+		Upgrade: func(decoded interface{}) (interface{}, error) {
+			return *decoded.(*order.Return), nil
+		},
+	}
+
+	promotionSchemaV1 = utils.Schema{
+		Version: 1,
+		New:     func() interface{} { return &promotion.Rule{} },
+		Upgrade: func(decoded interface{}) (interface{}, error) {
+			return *decoded.(*promotion.Rule), nil
+		},
+	}
+
+	sequenceSchemaV1 = utils.Schema{
+		Version: 1,
+		New:     func() interface{} { return new(int64) },
+		Upgrade: func(decoded interface{}) (interface{}, error) {
+			return *decoded.(*int64), nil
+		},
+	}
+)
+
+// decodeItem converts a raw value read from the item store into an Item.
+func decodeItem(v interface{}) (i item.Item, err error) {
+	decoded, err := Codecs.Decode(ItemStoreName, v)
+	if err != nil {
+		return i, err
+	}
+	i, ok := decoded.(item.Item)
+	if !ok {
+		return i, fmt.Errorf("%w: unexpected item value %T", utils.ErrInvalidRecord, decoded)
+	}
+	return i, nil
+}
+
+// decodeCart converts a raw value read from the cart store into a Cart.
+func decodeCart(v interface{}) (c cart.Cart, err error) {
+	decoded, err := Codecs.Decode(CartStoreName, v)
+	if err != nil {
+		return c, err
+	}
+	c, ok := decoded.(cart.Cart)
+	if !ok {
+		return c, fmt.Errorf("%w: unexpected cart value %T", utils.ErrInvalidRecord, decoded)
+	}
+	return c, nil
+}
+
+// decodeOrder converts a raw value read from the order store into an Order.
+func decodeOrder(v interface{}) (o order.Order, err error) {
+	decoded, err := Codecs.Decode(OrderStoreName, v)
+	if err != nil {
+		return o, err
+	}
+	o, ok := decoded.(order.Order)
+	if !ok {
+		return o, fmt.Errorf("%w: unexpected order value %T", utils.ErrInvalidRecord, decoded)
+	}
+	return o, nil
+}
+
+func decodeReturn(v interface{}) (r order.Return, err error) {
+	decoded, err := Codecs.Decode(ReturnStoreName, v)
+	if err != nil {
+		return r, err
+	}
+	r, ok := decoded.(order.Return)
+	if !ok {
+		return r, fmt.Errorf("%w: unexpected return value %T", utils.ErrInvalidRecord, decoded)
+	}
+	return r, nil
+}
+
+// decodePromotion converts a raw value read from the promotion store into a Rule.
+func decodePromotion(v interface{}) (r promotion.Rule, err error) {
+	decoded, err := Codecs.Decode(PromotionStoreName, v)
+	if err != nil {
+		return r, err
+	}
+	r, ok := decoded.(promotion.Rule)
+	if !ok {
+		return r, fmt.Errorf("%w: unexpected promotion value %T", utils.ErrInvalidRecord, decoded)
+	}
+	return r, nil
+}
@@ -0,0 +1,68 @@
+package repo
+
+import (
+	"errors"
+
+	"github.com/gambarini/flip-shop/utils"
+)
+
+// CouponRedemptionStoreName is the store name for the redemption count of every coupon code.
+const CouponRedemptionStoreName = utils.StoreName("CouponRedemptions")
+
+type (
+	// ICouponRepository exposes coupon redemption persistence operations against a KV database.
+	ICouponRepository interface {
+		utils.KVRepository
+		// Redemptions returns how many times a coupon code was redeemed using the provided transaction.
+		Redemptions(tx utils.Tx, code string) (int64, error)
+		// StoreRedemptions persists the redemption count of a coupon code within the provided transaction.
+		StoreRedemptions(tx utils.Tx, code string, count int64) error
+	}
+
+	// CouponRepository is a concrete implementation of ICouponRepository backed by a KVDatabase.
+	CouponRepository struct {
+		utils.KVDatabase
+	}
+)
+
+// NewCouponRepository creates a new CouponRepository using the provided KV database.
+func NewCouponRepository(kvDb utils.KVDatabase) *CouponRepository {
+	return &CouponRepository{
+		kvDb,
+	}
+}
+
+// Redemptions reads the redemption count of a coupon code; a code never redeemed counts 0.
+func (repo CouponRepository) Redemptions(tx utils.Tx, code string) (int64, error) {
+
+	v, err := tx.Read(CouponRedemptionStoreName, code)
+
+	switch {
+	case errors.Is(err, utils.ErrValueNotFound):
+		return 0, nil
+	case err != nil:
+		return 0, err
+	}
+
+	decoded, err := Codecs.Decode(CouponRedemptionStoreName, v)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return decoded.(int64), nil
+}
+
+// StoreRedemptions writes the redemption count of a coupon code within the given transaction.
+func (repo CouponRepository) StoreRedemptions(tx utils.Tx, code string, count int64) error {
+
+	b, err := Codecs.Encode(CouponRedemptionStoreName, count)
+
+	if err != nil {
+		return err
+	}
+
+	tx.Write(CouponRedemptionStoreName, code, b)
+
+	return nil
+}
@@ -2,6 +2,7 @@ package repo
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/gambarini/flip-shop/internal/model/item"
 	"github.com/gambarini/flip-shop/utils"
@@ -10,6 +11,8 @@ import (
 const (
 	// ItemStoreName is the store name for items in the KV database.
 	ItemStoreName = utils.StoreName("Items")
+	// ItemNameIndex indexes items by their lower cased name.
+	ItemNameIndex = "name"
 )
 
 type (
@@ -20,8 +23,10 @@ type (
 		FindItemBySku(tx utils.Tx, sku item.Sku) (item item.Item, err error)
 		// Store persists the given item within the provided transaction.
 		Store(tx utils.Tx, item item.Item) (err error)
-		// ListItems returns all items currently stored.
-		ListItems() ([]item.Item, error)
+		// ListItems returns all items ordered by SKU using the provided transaction.
+		ListItems(tx utils.Tx) ([]item.Item, error)
+		// FindItemsByName returns the items with the given name, ignoring case, ordered by SKU.
+		FindItemsByName(tx utils.Tx, name string) ([]item.Item, error)
 	}
 
 	// ItemRepository is a concrete implementation of IItemRepository backed by a KVDatabase.
@@ -36,7 +41,9 @@ var (
 )
 
 // NewItemRepository creates a new ItemRepository using the provided KV database.
+// It registers the item indexes on the database.
 func NewItemRepository(kvDb utils.KVDatabase) *ItemRepository {
+	kvDb.RegisterIndex(ItemStoreName, ItemNameIndex, itemNameIndex)
 	return &ItemRepository{
 		kvDb,
 	}
@@ -53,30 +60,65 @@ func (repo ItemRepository) FindItemBySku(tx utils.Tx, sku item.Sku) (i item.Item
 	case err != nil:
 		return i, err
 	default:
-		return v.(item.Item), nil
+		return decodeItem(v)
 	}
 }
 
 // Store writes an item into the KV database within the given transaction.
 func (repo ItemRepository) Store(tx utils.Tx, i item.Item) (err error) {
 
-	tx.Write(ItemStoreName, string(i.Sku), i)
+	b, err := Codecs.Encode(ItemStoreName, i)
+
+	if err != nil {
+		return err
+	}
+
+	tx.Write(ItemStoreName, string(i.Sku), b)
 
 	return nil
 
 }
 
-// ListItems returns all items from the underlying store.
-func (repo ItemRepository) ListItems() ([]item.Item, error) {
-	vals, err := repo.KVDatabase.List(ItemStoreName)
+// ListItems returns all items ordered by SKU, read within the given transaction.
+func (repo ItemRepository) ListItems(tx utils.Tx) ([]item.Item, error) {
+	kvs, err := tx.Scan(ItemStoreName, "")
+	if err != nil {
+		return nil, err
+	}
+	items := make([]item.Item, 0, len(kvs))
+	for _, kv := range kvs {
+		it, err := decodeItem(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+// FindItemsByName looks items up by name through the name index, ignoring case.
+func (repo ItemRepository) FindItemsByName(tx utils.Tx, name string) ([]item.Item, error) {
+	kvs, err := tx.Lookup(ItemStoreName, ItemNameIndex, strings.ToLower(name))
 	if err != nil {
 		return nil, err
 	}
-	items := make([]item.Item, 0, len(vals))
-	for _, v := range vals {
-		if it, ok := v.(item.Item); ok {
-			items = append(items, it)
+	items := make([]item.Item, 0, len(kvs))
+	for _, kv := range kvs {
+		it, err := decodeItem(kv.Value)
+		if err != nil {
+			return nil, err
 		}
+		items = append(items, it)
 	}
 	return items, nil
 }
+
+// itemNameIndex extracts the lower cased name of a stored item.
+// Values that cannot be decoded are left out of the index.
+func itemNameIndex(v interface{}) []string {
+	it, err := decodeItem(v)
+	if err != nil {
+		return nil
+	}
+	return []string{strings.ToLower(it.Name)}
+}
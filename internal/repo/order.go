@@ -0,0 +1,229 @@
+package repo
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/gambarini/flip-shop/internal/model/order"
+	"github.com/gambarini/flip-shop/utils"
+)
+
+const (
+	// OrderStoreName is the store name for orders in the KV database.
+	OrderStoreName = utils.StoreName("Orders")
+	// OrderSequenceStoreName is the store name for the order number sequence.
+	OrderSequenceStoreName = utils.StoreName("OrderSequence")
+	// OrderCartIndex indexes orders by the ID of the cart they were placed from.
+	OrderCartIndex = "cart"
+	// ReturnStoreName is the store name for order returns in the KV database.
+	ReturnStoreName = utils.StoreName("Returns")
+	// ReturnOrderIndex indexes returns by the ID of their order.
+	ReturnOrderIndex = "order"
+
+	orderSequenceKey = "number"
+)
+
+type (
+	// IOrderRepository exposes order persistence operations against a KV database.
+	IOrderRepository interface {
+		utils.KVRepository
+		// FindOrderByID loads an order by its identifier using the provided transaction.
+		FindOrderByID(tx utils.Tx, id string) (o order.Order, err error)
+		// FindOrderByCartID loads the order placed from a cart using the provided transaction.
+		FindOrderByCartID(tx utils.Tx, cartID string) (o order.Order, err error)
+		// ListOrders returns all orders ordered by number using the provided transaction.
+		ListOrders(tx utils.Tx) ([]order.Order, error)
+		// NextNumber allocates the next order number within the provided transaction.
+		NextNumber(tx utils.Tx) (int64, error)
+		// Store persists the given order within the provided transaction.
+		Store(tx utils.Tx, o order.Order) (err error)
+		// FindReturnByID loads a return by its identifier using the provided transaction.
+		FindReturnByID(tx utils.Tx, id string) (r order.Return, err error)
+		// ListReturns returns the returns of an order ordered by creation using the provided transaction.
+		ListReturns(tx utils.Tx, orderID string) ([]order.Return, error)
+		// StoreReturn persists the given return within the provided transaction.
+		StoreReturn(tx utils.Tx, r order.Return) (err error)
+	}
+
+	// OrderRepository is a concrete implementation of IOrderRepository backed by a KVDatabase.
+	OrderRepository struct {
+		utils.KVDatabase
+	}
+)
+
+var (
+	// ErrOrderNotFound is returned when an order cannot be found in the store.
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrReturnNotFound is returned when a return cannot be found in the store.
+	ErrReturnNotFound = errors.New("return not found")
+)
+
+// NewOrderRepository creates a new OrderRepository using the provided KV database.
+// It registers the order indexes on the database.
+func NewOrderRepository(kvDb utils.KVDatabase) *OrderRepository {
+	kvDb.RegisterIndex(OrderStoreName, OrderCartIndex, orderCartIndex)
+	kvDb.RegisterIndex(ReturnStoreName, ReturnOrderIndex, returnOrderIndex)
+	return &OrderRepository{
+		kvDb,
+	}
+}
+
+// FindOrderByID reads an order by ID from the underlying KV database using the transaction.
+func (repo OrderRepository) FindOrderByID(tx utils.Tx, id string) (o order.Order, err error) {
+
+	v, err := tx.Read(OrderStoreName, id)
+
+	switch {
+	case errors.Is(err, utils.ErrValueNotFound):
+		return o, ErrOrderNotFound
+	case err != nil:
+		return o, err
+	default:
+		return decodeOrder(v)
+	}
+}
+
+// FindOrderByCartID looks the order of a cart up through the cart index.
+func (repo OrderRepository) FindOrderByCartID(tx utils.Tx, cartID string) (o order.Order, err error) {
+
+	kvs, err := tx.Lookup(OrderStoreName, OrderCartIndex, cartID)
+
+	switch {
+	case err != nil:
+		return o, err
+	case len(kvs) == 0:
+		return o, ErrOrderNotFound
+	default:
+		return decodeOrder(kvs[0].Value)
+	}
+}
+
+// ListOrders returns all orders ordered by number, read within the given transaction.
+func (repo OrderRepository) ListOrders(tx utils.Tx) ([]order.Order, error) {
+	kvs, err := tx.Scan(OrderStoreName, "")
+	if err != nil {
+		return nil, err
+	}
+	orders := make([]order.Order, 0, len(kvs))
+	for _, kv := range kvs {
+		o, err := decodeOrder(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].Number < orders[j].Number })
+	return orders, nil
+}
+
+// NextNumber increments the order number sequence and returns the new value.
+// Numbers start at 1; a rolled back transaction does not consume a number.
+func (repo OrderRepository) NextNumber(tx utils.Tx) (int64, error) {
+
+	var current int64
+
+	v, err := tx.Read(OrderSequenceStoreName, orderSequenceKey)
+
+	switch {
+	case errors.Is(err, utils.ErrValueNotFound):
+	case err != nil:
+		return 0, err
+	default:
+		decoded, err := Codecs.Decode(OrderSequenceStoreName, v)
+		if err != nil {
+			return 0, err
+		}
+		current = decoded.(int64)
+	}
+
+	b, err := Codecs.Encode(OrderSequenceStoreName, current+1)
+
+	if err != nil {
+		return 0, err
+	}
+
+	tx.Write(OrderSequenceStoreName, orderSequenceKey, b)
+
+	return current + 1, nil
+}
+
+// Store writes an order into the KV database within the given transaction.
+func (repo OrderRepository) Store(tx utils.Tx, o order.Order) (err error) {
+
+	b, err := Codecs.Encode(OrderStoreName, o)
+
+	if err != nil {
+		return err
+	}
+
+	tx.Write(OrderStoreName, o.OrderID, b)
+
+	return nil
+}
+
+// orderCartIndex extracts the cart ID of a stored order.
+// Values that cannot be decoded are left out of the index.
+func orderCartIndex(v interface{}) []string {
+	o, err := decodeOrder(v)
+	if err != nil {
+		return nil
+	}
+	return []string{o.CartID}
+}
+
+// FindReturnByID reads a return by ID from the underlying KV database using the transaction.
+func (repo OrderRepository) FindReturnByID(tx utils.Tx, id string) (r order.Return, err error) {
+
+	v, err := tx.Read(ReturnStoreName, id)
+
+	switch {
+	case errors.Is(err, utils.ErrValueNotFound):
+		return r, ErrReturnNotFound
+	case err != nil:
+		return r, err
+	default:
+		return decodeReturn(v)
+	}
+}
+
+// ListReturns looks the returns of an order up through the order index, oldest first.
+func (repo OrderRepository) ListReturns(tx utils.Tx, orderID string) ([]order.Return, error) {
+	kvs, err := tx.Lookup(ReturnStoreName, ReturnOrderIndex, orderID)
+	if err != nil {
+		return nil, err
+	}
+	returns := make([]order.Return, 0, len(kvs))
+	for _, kv := range kvs {
+		r, err := decodeReturn(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		returns = append(returns, r)
+	}
+	sort.SliceStable(returns, func(i, j int) bool { return returns[i].CreatedAt.Before(returns[j].CreatedAt) })
+	return returns, nil
+}
+
+// StoreReturn writes a return into the KV database within the given transaction.
+func (repo OrderRepository) StoreReturn(tx utils.Tx, r order.Return) (err error) {
+
+	b, err := Codecs.Encode(ReturnStoreName, r)
+
+	if err != nil {
+		return err
+	}
+
+	tx.Write(ReturnStoreName, r.ReturnID, b)
+
+	return nil
+}
+
+// returnOrderIndex extracts the order ID of a stored return.
+// Values that cannot be decoded are left out of the index.
+func returnOrderIndex(v interface{}) []string {
+	r, err := decodeReturn(v)
+	if err != nil {
+		return nil
+	}
+	return []string{r.OrderID}
+}
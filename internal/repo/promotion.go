@@ -0,0 +1,126 @@
+package repo
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/gambarini/flip-shop/internal/model/promotion"
+	"github.com/gambarini/flip-shop/utils"
+)
+
+const (
+	// PromotionStoreName is the store name for the promotion rules in the KV database.
+	PromotionStoreName = utils.StoreName("Promotions")
+	// PromotionSequenceStoreName is the store name for the promotion number sequence.
+	PromotionSequenceStoreName = utils.StoreName("PromotionSequence")
+
+	promotionSequenceKey = "number"
+)
+
+type (
+	// IPromotionRepository exposes promotion rule persistence operations against a KV database.
+	IPromotionRepository interface {
+		utils.KVRepository
+		// FindPromotionByID loads a promotion rule by its identifier using the provided transaction.
+		FindPromotionByID(tx utils.Tx, id string) (r promotion.Rule, err error)
+		// ListPromotions returns all promotion rules ordered by number using the provided transaction.
+		ListPromotions(tx utils.Tx) ([]promotion.Rule, error)
+		// NextNumber allocates the next promotion number within the provided transaction.
+		NextNumber(tx utils.Tx) (int64, error)
+		// Store persists the given promotion rule within the provided transaction.
+		Store(tx utils.Tx, r promotion.Rule) (err error)
+	}
+
+	// PromotionRepository is a concrete implementation of IPromotionRepository backed by a KVDatabase.
+	PromotionRepository struct {
+		utils.KVDatabase
+	}
+)
+
+// ErrPromotionNotFound is returned when a promotion rule cannot be found in the store.
+var ErrPromotionNotFound = errors.New("promotion not found")
+
+// NewPromotionRepository creates a new PromotionRepository using the provided KV database.
+func NewPromotionRepository(kvDb utils.KVDatabase) *PromotionRepository {
+	return &PromotionRepository{
+		kvDb,
+	}
+}
+
+// FindPromotionByID reads a promotion rule by ID from the underlying KV database using the transaction.
+func (repo PromotionRepository) FindPromotionByID(tx utils.Tx, id string) (r promotion.Rule, err error) {
+
+	v, err := tx.Read(PromotionStoreName, id)
+
+	switch {
+	case errors.Is(err, utils.ErrValueNotFound):
+		return r, ErrPromotionNotFound
+	case err != nil:
+		return r, err
+	default:
+		return decodePromotion(v)
+	}
+}
+
+// ListPromotions returns all promotion rules ordered by number, read within the given transaction.
+func (repo PromotionRepository) ListPromotions(tx utils.Tx) ([]promotion.Rule, error) {
+	kvs, err := tx.Scan(PromotionStoreName, "")
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]promotion.Rule, 0, len(kvs))
+	for _, kv := range kvs {
+		r, err := decodePromotion(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Number < rules[j].Number })
+	return rules, nil
+}
+
+// NextNumber increments the promotion number sequence and returns the new value.
+// Numbers start at 1; a rolled back transaction does not consume a number.
+func (repo PromotionRepository) NextNumber(tx utils.Tx) (int64, error) {
+
+	var current int64
+
+	v, err := tx.Read(PromotionSequenceStoreName, promotionSequenceKey)
+
+	switch {
+	case errors.Is(err, utils.ErrValueNotFound):
+	case err != nil:
+		return 0, err
+	default:
+		decoded, err := Codecs.Decode(PromotionSequenceStoreName, v)
+		if err != nil {
+			return 0, err
+		}
+		current = decoded.(int64)
+	}
+
+	b, err := Codecs.Encode(PromotionSequenceStoreName, current+1)
+
+	if err != nil {
+		return 0, err
+	}
+
+	tx.Write(PromotionSequenceStoreName, promotionSequenceKey, b)
+
+	return current + 1, nil
+}
+
+// Store writes a promotion rule into the KV database within the given transaction.
+func (repo PromotionRepository) Store(tx utils.Tx, r promotion.Rule) (err error) {
+
+	b, err := Codecs.Encode(PromotionStoreName, r)
+
+	if err != nil {
+		return err
+	}
+
+	tx.Write(PromotionStoreName, r.ID, b)
+
+	return nil
+}
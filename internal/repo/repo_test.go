@@ -4,15 +4,37 @@ import (
 	"errors"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/gambarini/flip-shop/internal/model/cart"
 	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/order"
+	"github.com/gambarini/flip-shop/internal/model/promotion"
 	"github.com/gambarini/flip-shop/utils"
+	"github.com/gambarini/flip-shop/utils/filedb"
 	"github.com/gambarini/flip-shop/utils/memdb"
 )
 
+// forEachBackend runs the test against every KVDatabase implementation.
+func forEachBackend(t *testing.T, test func(t *testing.T, kv utils.KVDatabase)) {
+	t.Run("memdb", func(t *testing.T) {
+		test(t, memdb.NewMemoryKVDatabase())
+	})
+	t.Run("filedb", func(t *testing.T) {
+		kv, err := filedb.NewFileKVDatabase(t.TempDir(), filedb.Options{NoSync: true})
+		if err != nil {
+			t.Fatalf("open filedb: %v", err)
+		}
+		t.Cleanup(func() { _ = kv.Close() })
+		test(t, kv)
+	})
+}
+
 func TestItemRepository_WithTx_ReadStoreAndErrors(t *testing.T) {
-	kv := memdb.NewMemoryKVDatabase()
+	forEachBackend(t, testItemRepositoryWithTx)
+}
+
+func testItemRepositoryWithTx(t *testing.T, kv utils.KVDatabase) {
 	repo := NewItemRepository(kv)
 
 	// not found case
@@ -65,7 +87,10 @@ func TestItemRepository_WithTx_ReadStoreAndErrors(t *testing.T) {
 }
 
 func TestCartRepository_WithTx_ReadStoreAndErrors(t *testing.T) {
-	kv := memdb.NewMemoryKVDatabase()
+	forEachBackend(t, testCartRepositoryWithTx)
+}
+
+func testCartRepositoryWithTx(t *testing.T, kv utils.KVDatabase) {
 	repoC := NewCartRepository(kv)
 
 	// missing cart -> ErrCartNotFound
@@ -102,4 +127,343 @@ func TestCartRepository_WithTx_ReadStoreAndErrors(t *testing.T) {
 	if _, err := repoC.FindCartByID(c.CartID); err != nil {
 		t.Fatalf("expected existing cart still present after unrelated rollback, err=%v", err)
 	}
+
+	// delete: removes the cart, and a missing cart maps to ErrCartNotFound
+	if err := repoC.WithTx(func(tx utils.Tx) error {
+		return repoC.Delete(tx, c.CartID)
+	}); err != nil {
+		t.Fatalf("delete tx failed: %v", err)
+	}
+	if _, err := repoC.FindCartByID(c.CartID); err != ErrCartNotFound {
+		t.Fatalf("expected deleted cart to be absent, got %v", err)
+	}
+	if err := repoC.WithTx(func(tx utils.Tx) error {
+		return repoC.Delete(tx, c.CartID)
+	}); err != ErrCartNotFound {
+		t.Fatalf("expected ErrCartNotFound deleting missing cart, got %v", err)
+	}
+}
+
+func TestItemRepository_ListItems_OrderedBySku(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, kv utils.KVDatabase) {
+		repo := NewItemRepository(kv)
+		skus := []item.Sku{"B1", "A2", "C3", "A1"}
+
+		if err := repo.WithTx(func(tx utils.Tx) error {
+			for _, sku := range skus {
+				if err := repo.Store(tx, item.Item{Sku: sku, Name: string(sku), Price: 1}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("store tx failed: %v", err)
+		}
+
+		var got []item.Sku
+		if err := repo.WithTx(func(tx utils.Tx) error {
+			items, err := repo.ListItems(tx)
+			for _, it := range items {
+				got = append(got, it.Sku)
+			}
+			return err
+		}); err != nil {
+			t.Fatalf("list tx failed: %v", err)
+		}
+
+		want := []item.Sku{"A1", "A2", "B1", "C3"}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("ListItems() = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestCodecs_RoundTripAllFormats(t *testing.T) {
+	c := cart.NewAvailableCart()
+	c.Purchases[item.Sku("120P90")] = cart.Purchase{Sku: "120P90", Name: "Google Home", Price: 4999, Qty: 2, Discount: 100}
+	it := item.Item{Sku: item.Sku("120P90"), Name: "Google Home", Price: 4999, QtyAvailable: 10, QtyReserved: 2}
+	c.CartStatus = cart.CartStatusSubmitted
+	o, err := order.NewOrder(1, c, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), map[item.Sku][]order.AppliedPromotion{
+		"120P90": {{Promotion: "qty_free", Discount: 100}},
+	})
+	if err != nil {
+		t.Fatalf("new order: %v", err)
+	}
+	c.CartStatus = cart.CartStatusAvailable
+
+	for name, format := range map[string]utils.Format{"json": utils.JSONFormat{}, "binary": utils.BinaryFormat{}} {
+		t.Run(name, func(t *testing.T) {
+			codecs := utils.NewCodecRegistry()
+			RegisterCodecs(codecs, format)
+
+			data, err := codecs.Encode(CartStoreName, c)
+			if err != nil {
+				t.Fatalf("encode cart: %v", err)
+			}
+			gotCart, err := codecs.Decode(CartStoreName, data)
+			if err != nil || !reflect.DeepEqual(gotCart, c) {
+				t.Fatalf("cart round trip = %+v (err %v), want %+v", gotCart, err, c)
+			}
+
+			data, err = codecs.Encode(ItemStoreName, it)
+			if err != nil {
+				t.Fatalf("encode item: %v", err)
+			}
+			gotItem, err := codecs.Decode(ItemStoreName, data)
+			if err != nil || gotItem != it {
+				t.Fatalf("item round trip = %+v (err %v), want %+v", gotItem, err, it)
+			}
+
+			data, err = codecs.Encode(OrderStoreName, o)
+			if err != nil {
+				t.Fatalf("encode order: %v", err)
+			}
+			gotOrder, err := codecs.Decode(OrderStoreName, data)
+			if err != nil || !reflect.DeepEqual(gotOrder, o) {
+				t.Fatalf("order round trip = %+v (err %v), want %+v", gotOrder, err, o)
+			}
+		})
+	}
+}
+
+func TestRepositories_FindByIndex(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, kv utils.KVDatabase) {
+		items := NewItemRepository(kv)
+		carts := NewCartRepository(kv)
+
+		available, submitted := cart.NewAvailableCart(), cart.NewAvailableCart()
+		submitted.CartStatus = cart.CartStatusSubmitted
+
+		if err := items.WithTx(func(tx utils.Tx) error {
+			for _, it := range []item.Item{
+				{Sku: "A1", Name: "USB Cable", Price: 1},
+				{Sku: "B2", Name: "Google Home", Price: 2},
+				{Sku: "C3", Name: "usb cable", Price: 3},
+			} {
+				if err := items.Store(tx, it); err != nil {
+					return err
+				}
+			}
+			if err := carts.Store(tx, available); err != nil {
+				return err
+			}
+			return carts.Store(tx, submitted)
+		}); err != nil {
+			t.Fatalf("store tx failed: %v", err)
+		}
+
+		if err := items.WithTx(func(tx utils.Tx) error {
+			found, err := items.FindItemsByName(tx, "USB CABLE")
+			if err != nil {
+				return err
+			}
+			if len(found) != 2 || found[0].Sku != "A1" || found[1].Sku != "C3" {
+				t.Errorf("FindItemsByName() = %+v, want A1 and C3", found)
+			}
+
+			got, err := carts.FindCartsByStatus(tx, cart.CartStatusSubmitted)
+			if err != nil {
+				return err
+			}
+			if len(got) != 1 || got[0].CartID != submitted.CartID {
+				t.Errorf("FindCartsByStatus() = %+v, want %s", got, submitted.CartID)
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("lookup tx failed: %v", err)
+		}
+	})
+}
+
+func TestOrderRepository_NumbersLookupsAndListing(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, kv utils.KVDatabase) {
+		orders := NewOrderRepository(kv)
+		placedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+		var placed []order.Order
+		for i := 0; i < 3; i++ {
+			c := cart.NewAvailableCart()
+			c.CartStatus = cart.CartStatusSubmitted
+			if err := orders.WithTx(func(tx utils.Tx) error {
+				number, err := orders.NextNumber(tx)
+				if err != nil {
+					return err
+				}
+				o, err := order.NewOrder(number, c, placedAt, nil)
+				if err != nil {
+					return err
+				}
+				placed = append(placed, o)
+				return orders.Store(tx, o)
+			}); err != nil {
+				t.Fatalf("place order: %v", err)
+			}
+		}
+
+		// a rolled back transaction does not consume a number
+		_ = orders.WithTx(func(tx utils.Tx) error {
+			_, _ = orders.NextNumber(tx)
+			return errors.New("boom")
+		})
+
+		if err := orders.WithTx(func(tx utils.Tx) error {
+			list, err := orders.ListOrders(tx)
+			if err != nil {
+				return err
+			}
+			var numbers []int64
+			for _, o := range list {
+				numbers = append(numbers, o.Number)
+			}
+			if !reflect.DeepEqual(numbers, []int64{1, 2, 3}) {
+				t.Errorf("ListOrders() numbers = %v, want [1 2 3]", numbers)
+			}
+
+			if next, err := orders.NextNumber(tx); err != nil || next != 4 {
+				t.Errorf("NextNumber() = %d (err %v), want 4", next, err)
+			}
+
+			got, err := orders.FindOrderByCartID(tx, placed[1].CartID)
+			if err != nil || got.OrderID != placed[1].OrderID {
+				t.Errorf("FindOrderByCartID() = %+v (err %v), want %s", got, err, placed[1].OrderID)
+			}
+			if _, err := orders.FindOrderByID(tx, "missing"); err != ErrOrderNotFound {
+				t.Errorf("FindOrderByID() error = %v, want %v", err, ErrOrderNotFound)
+			}
+			if _, err := orders.FindOrderByCartID(tx, "missing"); err != ErrOrderNotFound {
+				t.Errorf("FindOrderByCartID() error = %v, want %v", err, ErrOrderNotFound)
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("verify: %v", err)
+		}
+	})
+}
+
+func TestOrderRepository_Returns(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, kv utils.KVDatabase) {
+		orders := NewOrderRepository(kv)
+		at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+		ret := func(orderID string, after time.Duration) order.Return {
+			return order.Return{ReturnID: orderID + after.String(), OrderID: orderID, CreatedAt: at.Add(after),
+				Lines: []order.ReturnLine{{Sku: "120P90", Qty: 1, Refund: 4999}}, Refund: 4999}
+		}
+		stored := []order.Return{ret("A", 2*time.Hour), ret("B", time.Hour), ret("A", time.Hour)}
+
+		if err := orders.WithTx(func(tx utils.Tx) error {
+			for _, r := range stored {
+				if err := orders.StoreReturn(tx, r); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("store returns: %v", err)
+		}
+
+		if err := orders.WithTx(func(tx utils.Tx) error {
+			list, err := orders.ListReturns(tx, "A")
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(list, []order.Return{stored[2], stored[0]}) {
+				t.Errorf("ListReturns(A) = %+v, want oldest first", list)
+			}
+			if list, err := orders.ListReturns(tx, "missing"); err != nil || len(list) != 0 {
+				t.Errorf("ListReturns(missing) = %+v (err %v), want none", list, err)
+			}
+
+			got, err := orders.FindReturnByID(tx, stored[1].ReturnID)
+			if err != nil || !reflect.DeepEqual(got, stored[1]) {
+				t.Errorf("FindReturnByID() = %+v (err %v), want %+v", got, err, stored[1])
+			}
+			if _, err := orders.FindReturnByID(tx, "missing"); err != ErrReturnNotFound {
+				t.Errorf("FindReturnByID() error = %v, want %v", err, ErrReturnNotFound)
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("verify: %v", err)
+		}
+	})
+}
+
+func TestCouponRepository_Redemptions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, kv utils.KVDatabase) {
+		coupons := NewCouponRepository(kv)
+
+		if err := coupons.WithTx(func(tx utils.Tx) error {
+			if n, err := coupons.Redemptions(tx, "SAVE10"); err != nil || n != 0 {
+				t.Errorf("Redemptions() of a new code = %d (err %v), want 0", n, err)
+			}
+			return coupons.StoreRedemptions(tx, "SAVE10", 3)
+		}); err != nil {
+			t.Fatalf("store redemptions: %v", err)
+		}
+
+		// a rolled back transaction does not count its redemptions
+		_ = coupons.WithTx(func(tx utils.Tx) error {
+			_ = coupons.StoreRedemptions(tx, "SAVE10", 4)
+			return errors.New("boom")
+		})
+
+		if err := coupons.WithTx(func(tx utils.Tx) error {
+			if n, err := coupons.Redemptions(tx, "SAVE10"); err != nil || n != 3 {
+				t.Errorf("Redemptions() = %d (err %v), want 3", n, err)
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("verify: %v", err)
+		}
+	})
+}
+
+func TestPromotionRepository_StoreAndList(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, kv utils.KVDatabase) {
+		promotions := NewPromotionRepository(kv)
+		at := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+
+		var ids []string
+		if err := promotions.WithTx(func(tx utils.Tx) error {
+			for _, d := range []promotion.Definition{
+				{Type: promotion.NameQtyFree, Sku: "B", Qty: 3},
+				{Type: promotion.NameFreeItem, Sku: "A", FreeSku: "B", FreePrice: 100},
+			} {
+				number, err := promotions.NextNumber(tx)
+				if err != nil {
+					return err
+				}
+				r, err := promotion.NewRule(number, d, at)
+				if err != nil {
+					return err
+				}
+				ids = append(ids, r.ID)
+				if err := promotions.Store(tx, r); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("store promotions: %v", err)
+		}
+
+		if err := promotions.WithTx(func(tx utils.Tx) error {
+			rules, err := promotions.ListPromotions(tx)
+			if err != nil {
+				return err
+			}
+			if len(rules) != 2 || rules[0].ID != ids[0] || rules[1].Number != 2 || rules[1].Definition.FreeSku != "B" {
+				t.Errorf("ListPromotions() = %+v", rules)
+			}
+			if r, err := promotions.FindPromotionByID(tx, ids[1]); err != nil || !r.Active || !r.CreatedAt.Equal(at) {
+				t.Errorf("FindPromotionByID() = %+v, %v", r, err)
+			}
+			if _, err := promotions.FindPromotionByID(tx, "missing"); !errors.Is(err, ErrPromotionNotFound) {
+				t.Errorf("FindPromotionByID(missing) error = %v, want %v", err, ErrPromotionNotFound)
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("verify: %v", err)
+		}
+	})
 }
@@ -0,0 +1,184 @@
+// Package reservation releases the stock held by carts whose reservations lapsed.
+//
+// Every purchase line of a cart holding stock (Available or CheckingOut) carries
+// a reservation deadline. The Sweeper periodically looks for lines past their
+// deadline, releases their reserved quantity back to the items and removes them
+// from the cart. A cart left without lines moves to Expired status.
+package reservation
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/repo"
+	"github.com/gambarini/flip-shop/utils"
+)
+
+// Sweeper releases the reservations of cart lines past their deadline.
+type Sweeper struct {
+	itemRepo repo.IItemRepository
+	cartRepo repo.ICartRepository
+	now      func() time.Time
+
+	lock sync.Mutex
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewSweeper creates a Sweeper over the given repositories.
+// now is the clock deadlines are compared with; nil uses time.Now.
+func NewSweeper(itemRepo repo.IItemRepository, cartRepo repo.ICartRepository, now func() time.Time) *Sweeper {
+	if now == nil {
+		now = time.Now
+	}
+	return &Sweeper{
+		itemRepo: itemRepo,
+		cartRepo: cartRepo,
+		now:      now,
+	}
+}
+
+// Sweep releases every lapsed reservation and returns the number of carts changed.
+// Each cart is handled in its own transaction, so a failure only leaves that cart
+// for the next sweep; the errors are returned joined.
+func (s *Sweeper) Sweep() (int, error) {
+	now := s.now()
+
+	var due []string
+	err := s.cartRepo.WithTx(func(tx utils.Tx) error {
+		due = due[:0]
+		for _, st := range cart.Statuses {
+			if !st.Holding() {
+				continue
+			}
+			carts, err := s.cartRepo.FindCartsByStatus(tx, st)
+			if err != nil {
+				return err
+			}
+			for _, c := range carts {
+				if hasLapsed(c, now) {
+					due = append(due, c.CartID)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		swept int
+		errs  []error
+	)
+	for _, cartID := range due {
+		var changed bool
+		err := s.cartRepo.WithTx(func(tx utils.Tx) (err error) {
+			changed, err = s.expireCart(tx, cartID, now)
+			return err
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if changed {
+			swept++
+		}
+	}
+
+	return swept, errors.Join(errs...)
+}
+
+// expireCart removes the lapsed lines of a cart and releases their stock.
+// The cart is read again since it may have changed since it was listed.
+func (s *Sweeper) expireCart(tx utils.Tx, cartID string, now time.Time) (bool, error) {
+	c, err := s.cartRepo.FindCartByIDTx(tx, cartID)
+	if errors.Is(err, repo.ErrCartNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !c.CartStatus.Holding() {
+		return false, nil
+	}
+
+	expired, err := c.ExpirePurchases(now)
+	if err != nil || len(expired) == 0 {
+		return false, err
+	}
+
+	for _, p := range expired {
+		i, err := s.itemRepo.FindItemBySku(tx, p.Sku)
+		if err != nil {
+			return false, err
+		}
+		if err := i.ReleaseItem(p.Qty); err != nil {
+			return false, err
+		}
+		if err := s.itemRepo.Store(tx, i); err != nil {
+			return false, err
+		}
+	}
+
+	return true, s.cartRepo.Store(tx, c)
+}
+
+func hasLapsed(c cart.Cart, now time.Time) bool {
+	for _, p := range c.Purchases {
+		if !p.ReservedUntil.IsZero() && !p.ReservedUntil.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Start runs Sweep every interval in the background until Stop is called.
+// Failures are logged and retried on the next tick.
+func (s *Sweeper) Start(interval time.Duration, logger utils.Logger) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+
+	s.wg.Add(1)
+	go func(stop chan struct{}) {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			swept, err := s.Sweep()
+			if err != nil {
+				logger.Error("reservation_sweep_error", utils.Fields{"error": err.Error(), "carts": swept})
+				continue
+			}
+			if swept > 0 {
+				logger.Info("reservation_sweep", utils.Fields{"carts": swept})
+			}
+		}
+	}(s.stop)
+}
+
+// Stop stops the background sweep started by Start and waits for it to finish.
+func (s *Sweeper) Stop() {
+	s.lock.Lock()
+	stop := s.stop
+	s.stop = nil
+	s.lock.Unlock()
+
+	if stop != nil {
+		close(stop)
+		s.wg.Wait()
+	}
+}
@@ -0,0 +1,91 @@
+package reservation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/repo"
+	"github.com/gambarini/flip-shop/utils"
+	"github.com/gambarini/flip-shop/utils/memdb"
+)
+
+func TestSweeper_ReleasesLapsedReservations(t *testing.T) {
+	kv := memdb.NewMemoryKVDatabase()
+	itemRepo := repo.NewItemRepository(kv)
+	cartRepo := repo.NewCartRepository(kv)
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// partial: one lapsed line and one still held; lapsed: checking out with every line lapsed; held: nothing lapsed
+	partial, lapsed, held := cart.NewAvailableCart(), cart.NewAvailableCart(), cart.NewAvailableCart()
+	lapsed.CartStatus = cart.CartStatusCheckingOut
+	partial.Purchases["A"] = cart.Purchase{Sku: "A", Qty: 2, ReservedUntil: now.Add(-time.Minute)}
+	partial.Purchases["B"] = cart.Purchase{Sku: "B", Qty: 1, ReservedUntil: now.Add(time.Minute)}
+	lapsed.Purchases["A"] = cart.Purchase{Sku: "A", Qty: 3, ReservedUntil: now}
+	held.Purchases["B"] = cart.Purchase{Sku: "B", Qty: 4, ReservedUntil: now.Add(time.Hour)}
+
+	if err := kv.WithTx(func(tx utils.Tx) error {
+		if err := itemRepo.Store(tx, item.Item{Sku: "A", Name: "A", QtyAvailable: 10, QtyReserved: 5}); err != nil {
+			return err
+		}
+		if err := itemRepo.Store(tx, item.Item{Sku: "B", Name: "B", QtyAvailable: 10, QtyReserved: 5}); err != nil {
+			return err
+		}
+		for _, c := range []cart.Cart{partial, lapsed, held} {
+			if err := cartRepo.Store(tx, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	sweeper := NewSweeper(itemRepo, cartRepo, func() time.Time { return now })
+
+	swept, err := sweeper.Sweep()
+	if err != nil || swept != 2 {
+		t.Fatalf("Sweep() = %d (err %v), want 2", swept, err)
+	}
+	// nothing left to release
+	if swept, err := sweeper.Sweep(); err != nil || swept != 0 {
+		t.Fatalf("second Sweep() = %d (err %v), want 0", swept, err)
+	}
+
+	if err := kv.WithTx(func(tx utils.Tx) error {
+		wantReserved := map[item.Sku]int{"A": 0, "B": 5}
+		for sku, want := range wantReserved {
+			i, err := itemRepo.FindItemBySku(tx, sku)
+			if err != nil {
+				return err
+			}
+			if i.QtyReserved != want {
+				t.Errorf("%s reserved = %d, want %d", sku, i.QtyReserved, want)
+			}
+		}
+
+		wantCarts := []struct {
+			id     string
+			status cart.Status
+			lines  int
+		}{
+			{partial.CartID, cart.CartStatusAvailable, 1},
+			{lapsed.CartID, cart.CartStatusExpired, 0},
+			{held.CartID, cart.CartStatusAvailable, 1},
+		}
+		for _, want := range wantCarts {
+			c, err := cartRepo.FindCartByIDTx(tx, want.id)
+			if err != nil {
+				return err
+			}
+			if c.CartStatus != want.status || len(c.Purchases) != want.lines {
+				t.Errorf("cart %s = %s with %d lines, want %s with %d", want.id, c.CartStatus, len(c.Purchases), want.status, want.lines)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package route
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/order"
+	"github.com/gambarini/flip-shop/internal/payment"
+	"github.com/gambarini/flip-shop/internal/repo"
+	"github.com/gambarini/flip-shop/utils"
+	"github.com/gofrs/uuid"
+)
+
+type (
+	// CancelPayload represents the request body to cancel a cart or an order
+	CancelPayload struct {
+		Reason string `json:"reason"`
+	}
+)
+
+// cancelCart handles PUT /cart/{cartID}/status/cancelled.
+// A cart still holding reservations releases them; a Submitted or Paid cart cancels
+// its order, which puts the purchased stock back.
+func cancelCart(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo.IItemRepository, orderRepo repo.IOrderRepository, cfg config) http.HandlerFunc {
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		cartID := srv.Vars(request)["cartID"]
+
+		rPayload, ok := decodeCancelPayload(srv, response, request)
+		if !ok {
+			return
+		}
+
+		var cancelled cart.Cart
+
+		err := withGatewayCalls(request.Context(), cartRepo, cfg.gateway, orderRepo, func(tx utils.Tx, gateway payment.Gateway) (string, error) {
+
+			c, err := cartRepo.FindCartByIDTx(tx, cartID)
+
+			if err != nil {
+				return "", err
+			}
+
+			if c.CartStatus == cart.CartStatusSubmitted || c.CartStatus == cart.CartStatusPaid {
+				o, err := orderRepo.FindOrderByCartID(tx, cartID)
+
+				if err != nil {
+					return "", err
+				}
+
+				c, err = cancelOrderTx(request.Context(), tx, gateway, itemRepo, cartRepo, orderRepo, cfg.redemptions, &o, rPayload.Reason, cfg.now().UTC())
+
+				if err != nil {
+					return "", err
+				}
+
+				cancelled = c
+
+				return o.OrderID, nil
+			}
+
+			if err := c.Cancel(rPayload.Reason, cfg.now().UTC()); err != nil {
+				return "", err
+			}
+
+			for _, p := range c.Purchases {
+
+				i, err := itemRepo.FindItemBySku(tx, p.Sku)
+
+				if err != nil {
+					return "", err
+				}
+
+				if err := i.ReleaseItem(p.Qty); err != nil {
+					return "", err
+				}
+
+				if err := itemRepo.Store(tx, i); err != nil {
+					return "", err
+				}
+			}
+
+			if err := cartRepo.Store(tx, c); err != nil {
+				return "", err
+			}
+
+			cancelled = c
+
+			return "", nil
+		})
+
+		if respondCancelError(srv, response, err) {
+			return
+		}
+
+		srv.RespondJSON(response, http.StatusOK, cancelled)
+	}
+}
+
+// cancelOrder handles POST /orders/{orderID}/cancel.
+func cancelOrder(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo.IItemRepository, orderRepo repo.IOrderRepository, cfg config) http.HandlerFunc {
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		orderID := srv.Vars(request)["orderID"]
+
+		if _, err := uuid.FromString(orderID); err != nil {
+			srv.ResponseErrorEntityUnproc(response, fmt.Errorf("invalid orderID format: %w", err))
+			return
+		}
+
+		rPayload, ok := decodeCancelPayload(srv, response, request)
+		if !ok {
+			return
+		}
+
+		var cancelled order.Order
+
+		err := withGatewayCalls(request.Context(), orderRepo, cfg.gateway, orderRepo, func(tx utils.Tx, gateway payment.Gateway) (string, error) {
+
+			o, err := orderRepo.FindOrderByID(tx, orderID)
+
+			if err != nil {
+				return "", err
+			}
+
+			if _, err := cancelOrderTx(request.Context(), tx, gateway, itemRepo, cartRepo, orderRepo, cfg.redemptions, &o, rPayload.Reason, cfg.now().UTC()); err != nil {
+				return "", err
+			}
+
+			cancelled = o
+
+			return o.OrderID, nil
+		})
+
+		if respondCancelError(srv, response, err) {
+			return
+		}
+
+		srv.RespondJSON(response, http.StatusOK, cancelled)
+	}
+}
+
+// cancelOrderTx cancels an order and the cart it was placed from, putting the
+// kept quantity of every line back in stock. Lines include the free units added by
+// promotions such as FreeItemPromotion, so those are restocked as well; returned
+// units were already dealt with by their return. The coupon uses the order redeemed are
+// given back. The payment of the order is voided, or refunded when it was already captured,
+// through gateway; handlers run it with withGatewayCalls so no money moves inside the transaction.
+// It returns the cancelled cart.
+func cancelOrderTx(ctx context.Context, tx utils.Tx, gateway payment.Gateway, itemRepo repo.IItemRepository, cartRepo repo.ICartRepository, orderRepo repo.IOrderRepository, redemptions repo.ICouponRepository, o *order.Order, reason string, at time.Time) (c cart.Cart, err error) {
+
+	if err := o.Cancel(reason, at); err != nil {
+		return c, err
+	}
+
+	if err := releaseCoupons(tx, redemptions, o.CouponUses); err != nil {
+		return c, err
+	}
+
+	if err := settleCancelledPayment(ctx, gateway, o); err != nil {
+		return c, err
+	}
+
+	for _, l := range o.Lines {
+
+		i, err := itemRepo.FindItemBySku(tx, l.Sku)
+
+		if err != nil {
+			return c, err
+		}
+
+		if err := i.RestoreItem(l.KeptQty()); err != nil {
+			return c, err
+		}
+
+		if err := itemRepo.Store(tx, i); err != nil {
+			return c, err
+		}
+	}
+
+	c, err = cartRepo.FindCartByIDTx(tx, o.CartID)
+
+	if err != nil {
+		return c, err
+	}
+
+	if err := c.Cancel(reason, at); err != nil {
+		return c, err
+	}
+
+	if err := cartRepo.Store(tx, c); err != nil {
+		return c, err
+	}
+
+	return c, orderRepo.Store(tx, *o)
+}
+
+// decodeCancelPayload reads the cancellation reason, responding 422 when it is missing or malformed.
+func decodeCancelPayload(srv *utils.AppServer, response http.ResponseWriter, request *http.Request) (rPayload CancelPayload, ok bool) {
+	dec := json.NewDecoder(request.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rPayload); err != nil {
+		srv.ResponseErrorEntityUnproc(response, fmt.Errorf("invalid JSON payload: %w", err))
+		return rPayload, false
+	}
+	if rPayload.Reason == "" {
+		srv.ResponseErrorEntityUnproc(response, cart.ErrCancelReasonRequired)
+		return rPayload, false
+	}
+	return rPayload, true
+}
+
+// respondCancelError maps cancellation errors to responses and reports whether one was written.
+func respondCancelError(srv *utils.AppServer, response http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case respondPaymentError(srv, response, err):
+	case errors.Is(err, repo.ErrCartNotFound), errors.Is(err, repo.ErrOrderNotFound):
+		srv.ResponseErrorNotfound(response, err)
+	case errors.Is(err, cart.ErrCartNotAvailable),
+		errors.Is(err, cart.ErrInvalidTransition),
+		errors.Is(err, order.ErrOrderNotPlaced),
+		errors.Is(err, item.ErrInvalidReleaseQuantity),
+		errors.Is(err, repo.ErrItemNotFound):
+		srv.ResponseErrorEntityUnproc(response, err)
+	default:
+		srv.ResponseErrorServerErr(response, fmt.Errorf("error cancelling: %w", err))
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/order"
+	"github.com/gambarini/flip-shop/utils"
+	"github.com/gambarini/flip-shop/utils/memdb"
+)
+
+func stockOf(t *testing.T, env testEnv, sku item.Sku) item.Item {
+	t.Helper()
+	var found item.Item
+	if err := env.itemRepo.WithTx(func(tx utils.Tx) (err error) {
+		found, err = env.itemRepo.FindItemBySku(tx, sku)
+		return err
+	}); err != nil {
+		t.Fatalf("find item %s: %v", sku, err)
+	}
+	return found
+}
+
+func TestCancelOrder_RestoresStock(t *testing.T) {
+	cancelledAt := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+	env := setupTestEnvWithDB(t, memdb.NewMemoryKVDatabase(), WithClock(func() time.Time { return cancelledAt }))
+	cid := createCart(t, env.srv)
+
+	// MacBook Pro brings a free Raspberry Pi, which must be restocked too
+	_ = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemMacBookProSku, "qty": 1})
+	_ = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemGoogleHomeSku, "qty": 3})
+
+	rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/submitted", nil)
+	var submitted cart.Cart
+	if err := json.Unmarshal(rr.Body.Bytes(), &submitted); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("submit failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doJSON(t, env.srv, http.MethodPost, "/orders/"+submitted.OrderID+"/cancel", map[string]string{"reason": "customer request"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("cancel failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	var o order.Order
+	if err := json.Unmarshal(rr.Body.Bytes(), &o); err != nil {
+		t.Fatalf("invalid order json: %v", err)
+	}
+	if o.Status != order.StatusCancelled || o.CancelReason != "customer request" || !o.CancelledAt.Equal(cancelledAt) {
+		t.Fatalf("unexpected cancelled order: %+v", o)
+	}
+
+	for sku, want := range map[item.Sku]int{ItemMacBookProSku: 5, ItemGoogleHomeSku: 10, RaspberryPiSku: 2} {
+		if got := stockOf(t, env, sku); got.QtyAvailable != want || got.QtyReserved != 0 {
+			t.Errorf("%s QtyAvailable = %d, QtyReserved = %d, want %d, 0", sku, got.QtyAvailable, got.QtyReserved, want)
+		}
+	}
+
+	rr = doJSON(t, env.srv, http.MethodGet, "/cart/"+cid, nil)
+	var c cart.Cart
+	if err := json.Unmarshal(rr.Body.Bytes(), &c); err != nil || c.CartStatus != cart.CartStatusCancelled || c.CancelReason != "customer request" {
+		t.Fatalf("cart not cancelled: %s (err %v)", rr.Body.String(), err)
+	}
+
+	// an order is cancelled once
+	rr = doJSON(t, env.srv, http.MethodPost, "/orders/"+submitted.OrderID+"/cancel", map[string]string{"reason": "again"})
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 cancelling twice, got %d", rr.Code)
+	}
+	if got := stockOf(t, env, ItemGoogleHomeSku); got.QtyAvailable != 10 {
+		t.Fatalf("stock restored twice: QtyAvailable = %d", got.QtyAvailable)
+	}
+}
+
+func TestCancelCart(t *testing.T) {
+	env := setupTestEnv(t)
+
+	// an Available cart releases its reservations
+	cid := createCart(t, env.srv)
+	_ = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemAlexaSpeakerSku, "qty": 4})
+
+	rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/cancelled", map[string]string{"reason": "abandoned"})
+	var c cart.Cart
+	if err := json.Unmarshal(rr.Body.Bytes(), &c); err != nil || rr.Code != http.StatusOK || c.CartStatus != cart.CartStatusCancelled {
+		t.Fatalf("cancel available cart: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := stockOf(t, env, ItemAlexaSpeakerSku); got.QtyAvailable != 10 || got.QtyReserved != 0 {
+		t.Fatalf("reservation not released: %+v", got)
+	}
+
+	// a Submitted cart cancels its order
+	cid = createCart(t, env.srv)
+	_ = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemAlexaSpeakerSku, "qty": 2})
+	rr = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/submitted", nil)
+	var submitted cart.Cart
+	if err := json.Unmarshal(rr.Body.Bytes(), &submitted); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("submit failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	if rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/cancelled", map[string]string{"reason": "too slow"}); rr.Code != http.StatusOK {
+		t.Fatalf("cancel submitted cart: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := stockOf(t, env, ItemAlexaSpeakerSku); got.QtyAvailable != 10 {
+		t.Fatalf("stock not restored: %+v", got)
+	}
+	rr = doJSON(t, env.srv, http.MethodGet, "/orders/"+submitted.OrderID, nil)
+	var o order.Order
+	if err := json.Unmarshal(rr.Body.Bytes(), &o); err != nil || o.Status != order.StatusCancelled || o.CancelReason != "too slow" {
+		t.Fatalf("order not cancelled: %s (err %v)", rr.Body.String(), err)
+	}
+}
+
+func TestCancel_Errors(t *testing.T) {
+	env := setupTestEnv(t)
+	cid := createCart(t, env.srv)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		want   int
+	}{
+		{"cart missing reason", http.MethodPut, "/cart/" + cid + "/status/cancelled", map[string]string{}, http.StatusUnprocessableEntity},
+		{"cart unknown field", http.MethodPut, "/cart/" + cid + "/status/cancelled", map[string]string{"reason": "x", "why": "y"}, http.StatusUnprocessableEntity},
+		{"cart not found", http.MethodPut, "/cart/0b7c4a8e-8d5e-4d4b-9b59-0e2b8f0b7a11/status/cancelled", map[string]string{"reason": "x"}, http.StatusNotFound},
+		{"order invalid id", http.MethodPost, "/orders/not-a-uuid/cancel", map[string]string{"reason": "x"}, http.StatusUnprocessableEntity},
+		{"order not found", http.MethodPost, "/orders/0b7c4a8e-8d5e-4d4b-9b59-0e2b8f0b7a11/cancel", map[string]string{"reason": "x"}, http.StatusNotFound},
+		{"cart cancelled", http.MethodPut, "/cart/" + cid + "/status/cancelled", map[string]string{"reason": "x"}, http.StatusOK},
+		{"cart cancelled twice", http.MethodPut, "/cart/" + cid + "/status/cancelled", map[string]string{"reason": "x"}, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doJSON(t, env.srv, tt.method, tt.path, tt.body); rr.Code != tt.want {
+				t.Fatalf("%s %s = %d, want %d (body=%s)", tt.method, tt.path, rr.Code, tt.want, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/coupon"
+	"github.com/gambarini/flip-shop/internal/pricing"
+	"github.com/gambarini/flip-shop/internal/repo"
+	"github.com/gambarini/flip-shop/utils"
+)
+
+// CouponPayload represents the request body to attach a coupon code to a cart.
+type CouponPayload struct {
+	Code string `json:"code"`
+}
+
+// attachCoupon handles POST /cart/{cartID}/coupons, attaching one use of a coupon code to a cart.
+// The coupon promotion applies, and its redemption is counted, when the cart is submitted.
+func attachCoupon(srv *utils.AppServer, cartRepo repo.ICartRepository, cfg config) http.HandlerFunc {
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		cartID := srv.Vars(request)["cartID"]
+
+		var rPayload CouponPayload
+		dec := json.NewDecoder(request.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rPayload); err != nil {
+			srv.ResponseErrorEntityUnproc(response, fmt.Errorf("invalid JSON payload: %w", err))
+			return
+		}
+
+		cp, err := cfg.coupons.Find(rPayload.Code)
+
+		if err != nil {
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
+		}
+
+		var attached cart.Cart
+
+		err = cartRepo.WithTx(func(tx utils.Tx) error {
+
+			c, err := cartRepo.FindCartByIDTx(tx, cartID)
+
+			if err != nil {
+				return err
+			}
+
+			redeemed, err := cfg.redemptions.Redemptions(tx, cp.Code)
+
+			if err != nil {
+				return err
+			}
+
+			if err := cp.AttachTo(&c, redeemed, cfg.now().UTC()); err != nil {
+				return err
+			}
+
+			if err := cartRepo.Store(tx, c); err != nil {
+				return err
+			}
+
+			attached = c
+
+			return nil
+		})
+
+		switch {
+		case errors.Is(err, repo.ErrCartNotFound):
+			srv.ResponseErrorNotfound(response, err)
+			return
+		case errors.Is(err, cart.ErrCartNotAvailable),
+			isCouponError(err):
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
+		case err != nil:
+			srv.ResponseErrorServerErr(response, fmt.Errorf("error storing Cart: %w", err))
+			return
+		}
+
+		srv.RespondJSON(response, http.StatusOK, attached)
+	}
+}
+
+// couponCandidates checks that every use of the coupons attached to a cart can be redeemed
+// and returns their promotions, once per use, in the order they were attached. Redemptions
+// are only counted, by redeemCoupons, for the uses that applied.
+func couponCandidates(tx utils.Tx, cfg config, c cart.Cart, at time.Time) ([]pricing.Candidate, error) {
+
+	var promotions []pricing.Candidate
+
+	seen := make(map[string]bool, len(c.Coupons))
+
+	for _, code := range c.Coupons {
+
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+
+		cp, err := cfg.coupons.Find(code)
+
+		if err != nil {
+			return nil, err
+		}
+
+		uses := c.CouponUses(code)
+
+		redeemed, err := cfg.redemptions.Redemptions(tx, cp.Code)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = cp.Redeem(redeemed, uses, at); err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < uses; i++ {
+			promotions = append(promotions, pricing.Candidate{Promotion: cp.Promotion, Coupon: cp.Code})
+		}
+	}
+
+	return promotions, nil
+}
+
+// redeemCoupons counts the redemption of the uses of coupons that applied, by code, within the transaction.
+// A use left out by the best deal, or kept off by another promotion, gave nothing and is not counted.
+func redeemCoupons(tx utils.Tx, cfg config, uses map[string]int, at time.Time) error {
+
+	codes := make([]string, 0, len(uses))
+	for code := range uses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	for _, code := range codes {
+
+		cp, err := cfg.coupons.Find(code)
+
+		if err != nil {
+			return err
+		}
+
+		redeemed, err := cfg.redemptions.Redemptions(tx, cp.Code)
+
+		if err != nil {
+			return err
+		}
+
+		if redeemed, err = cp.Redeem(redeemed, uses[code], at); err != nil {
+			return err
+		}
+
+		if err := cfg.redemptions.StoreRedemptions(tx, cp.Code, redeemed); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// releaseCoupons gives back the redemptions of the uses of coupons an order redeemed, by code,
+// within the transaction. Codes no longer in the catalog are released all the same.
+func releaseCoupons(tx utils.Tx, redemptions repo.ICouponRepository, uses map[string]int) error {
+
+	codes := make([]string, 0, len(uses))
+	for code := range uses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	for _, code := range codes {
+
+		redeemed, err := redemptions.Redemptions(tx, code)
+
+		if err != nil {
+			return err
+		}
+
+		if err := redemptions.StoreRedemptions(tx, code, max(0, redeemed-int64(uses[code]))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isCouponError tells whether err is a coupon that cannot be used.
+func isCouponError(err error) bool {
+	return errors.Is(err, coupon.ErrUnknownCoupon) ||
+		errors.Is(err, coupon.ErrCouponNotActive) ||
+		errors.Is(err, coupon.ErrCouponExhausted) ||
+		errors.Is(err, coupon.ErrCartLimitReached)
+}
@@ -0,0 +1,184 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/coupon"
+	"github.com/gambarini/flip-shop/internal/model/order"
+	"github.com/gambarini/flip-shop/internal/model/promotion"
+	"github.com/gambarini/flip-shop/internal/pricing"
+	"github.com/gambarini/flip-shop/internal/repo"
+	"github.com/gambarini/flip-shop/utils"
+	"github.com/gambarini/flip-shop/utils/memdb"
+)
+
+func TestCoupons(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	kv := memdb.NewMemoryKVDatabase()
+	redemptions := repo.NewCouponRepository(kv)
+	catalog := coupon.NewCatalog(
+		// 20% off a single Raspberry Pi, at most twice per cart and 3 times overall
+		coupon.Coupon{Code: "PI20", Promotion: promotion.ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: RaspberryPiSku, PercentageDiscount: 0.2}, MaxRedemptions: 3, PerCartLimit: 2},
+		coupon.Coupon{Code: "OLD", Promotion: promotion.ItemQtyPriceFreePromotion{PurchasedItemSku: RaspberryPiSku, PurchasedQty: 1}, ValidUntil: now},
+	)
+	env := setupTestEnvWithDB(t, kv, WithClock(func() time.Time { return now }), WithCoupons(catalog, redemptions))
+
+	cid := createCart(t, env.srv)
+	_ = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": RaspberryPiSku, "qty": 1})
+
+	tests := []struct {
+		name   string
+		cartID string
+		code   string
+		want   int
+	}{
+		{"unknown code", cid, "NOPE", http.StatusUnprocessableEntity},
+		{"expired", cid, "OLD", http.StatusUnprocessableEntity},
+		{"cart not found", "00000000-0000-0000-0000-000000000000", "PI20", http.StatusNotFound},
+		{"first use", cid, "pi20", http.StatusOK},
+		{"second use", cid, "PI20", http.StatusOK},
+		{"over cart limit", cid, "PI20", http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doJSON(t, env.srv, http.MethodPost, "/cart/"+tt.cartID+"/coupons", map[string]string{"code": tt.code}); rr.Code != tt.want {
+				t.Fatalf("expected %d, got %d body=%s", tt.want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	// each use applies the promotion once: 2 * 20% of 3000
+	rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/submitted", nil)
+	var submitted cart.Cart
+	if err := json.Unmarshal(rr.Body.Bytes(), &submitted); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("submit failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if submitted.Total != 1800 || len(submitted.Coupons) != 2 {
+		t.Fatalf("unexpected submitted cart: total %d, coupons %v", submitted.Total, submitted.Coupons)
+	}
+	line, _ := getOrderOf(t, env, submitted.OrderID).Line(RaspberryPiSku)
+	if want := []order.AppliedPromotion{{Promotion: promotion.NameQtyPercentage, Coupon: "PI20", Discount: 1200}}; len(line.Promotions) != 1 || line.Promotions[0] != want[0] {
+		t.Fatalf("Promotions = %+v, want %+v", line.Promotions, want)
+	}
+	if n := redeemedCount(t, redemptions, "PI20"); n != 2 {
+		t.Fatalf("redemptions = %d, want 2", n)
+	}
+
+	// one redemption is left: a second cart can attach the code once, but not submit twice
+	other := createCart(t, env.srv)
+	_ = doJSON(t, env.srv, http.MethodPut, "/cart/"+other+"/purchase", map[string]interface{}{"sku": RaspberryPiSku, "qty": 1})
+	if rr := doJSON(t, env.srv, http.MethodPost, "/cart/"+other+"/coupons", map[string]string{"code": "PI20"}); rr.Code != http.StatusOK {
+		t.Fatalf("attach failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := doJSON(t, env.srv, http.MethodPost, "/cart/"+other+"/coupons", map[string]string{"code": "PI20"}); rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 attaching beyond redemptions left, got %d", rr.Code)
+	}
+
+	// redemptions taken meanwhile make the submit fail and roll back
+	if err := redemptions.WithTx(func(tx utils.Tx) error { return redemptions.StoreRedemptions(tx, "PI20", 3) }); err != nil {
+		t.Fatalf("store redemptions: %v", err)
+	}
+	if rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+other+"/status/submitted", nil); rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 submitting an exhausted coupon, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := cartStatusOf(t, env, other); got != cart.CartStatusAvailable {
+		t.Fatalf("cart status = %s, want %s", got, cart.CartStatusAvailable)
+	}
+	if n := redeemedCount(t, redemptions, "PI20"); n != 3 {
+		t.Fatalf("redemptions = %d, want 3", n)
+	}
+}
+
+func TestCoupons_RedeemedOnlyWhenApplied(t *testing.T) {
+	kv := memdb.NewMemoryKVDatabase()
+	redemptions := repo.NewCouponRepository(kv)
+	catalog := coupon.NewCatalog(
+		coupon.Coupon{Code: "HOME10", Promotion: promotion.ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: ItemGoogleHomeSku, PercentageDiscount: 0.1}},
+	)
+	env := setupTestEnvWithDB(t, kv, WithBestDeal(0), WithCoupons(catalog, redemptions))
+	// applied after the coupon, on what the cart spends after its discounts
+	storePromotions(t, env.promotionRepo, promotion.Definition{Type: promotion.NameSpendAmountOff, MinSpend: 9500, Amount: 2000, Priority: -1})
+
+	cid := createCart(t, env.srv)
+	if rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemGoogleHomeSku, "qty": 2}); rr.Code != http.StatusOK {
+		t.Fatalf("purchase failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := doJSON(t, env.srv, http.MethodPost, "/cart/"+cid+"/coupons", map[string]string{"code": "HOME10"}); rr.Code != http.StatusOK {
+		t.Fatalf("attach failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// 10% off the Google Homes would spend less than 9500: the best deal leaves the coupon out for 2000 off
+	rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/submitted", nil)
+	var submitted cart.Cart
+	if err := json.Unmarshal(rr.Body.Bytes(), &submitted); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("submit failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if submitted.Total != 2*4999-2000 {
+		t.Fatalf("expected total %d, got %d", 2*4999-2000, submitted.Total)
+	}
+	if check := submitted.Promotions[len(submitted.Promotions)-2]; check.Coupon != "HOME10" || check.Skipped != pricing.SkipOutdone {
+		t.Fatalf("expected the coupon outdone, got %+v", submitted.Promotions)
+	}
+	if n := redeemedCount(t, redemptions, "HOME10"); n != 0 {
+		t.Fatalf("redemptions = %d, want 0", n)
+	}
+}
+
+func TestCoupons_ReleasedOnCancel(t *testing.T) {
+	kv := memdb.NewMemoryKVDatabase()
+	redemptions := repo.NewCouponRepository(kv)
+	catalog := coupon.NewCatalog(
+		coupon.Coupon{Code: "PI20", Promotion: promotion.ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: RaspberryPiSku, PercentageDiscount: 0.2}, MaxRedemptions: 1},
+	)
+	env := setupTestEnvWithDB(t, kv, WithCoupons(catalog, redemptions))
+
+	// submits a cart using the only redemption of the coupon and returns it with its order
+	submitWithCoupon := func() (cartID, orderID string) {
+		t.Helper()
+		cartID = createCart(t, env.srv)
+		_ = doJSON(t, env.srv, http.MethodPut, "/cart/"+cartID+"/purchase", map[string]interface{}{"sku": RaspberryPiSku, "qty": 1})
+		if rr := doJSON(t, env.srv, http.MethodPost, "/cart/"+cartID+"/coupons", map[string]string{"code": "PI20"}); rr.Code != http.StatusOK {
+			t.Fatalf("attach failed: %d body=%s", rr.Code, rr.Body.String())
+		}
+		rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cartID+"/status/submitted", nil)
+		var submitted cart.Cart
+		if err := json.Unmarshal(rr.Body.Bytes(), &submitted); err != nil || rr.Code != http.StatusOK {
+			t.Fatalf("submit failed: %d body=%s", rr.Code, rr.Body.String())
+		}
+		if n := redeemedCount(t, redemptions, "PI20"); n != 1 {
+			t.Fatalf("redemptions = %d, want 1", n)
+		}
+		return cartID, submitted.OrderID
+	}
+
+	_, orderID := submitWithCoupon()
+	if rr := doJSON(t, env.srv, http.MethodPost, "/orders/"+orderID+"/cancel", map[string]string{"reason": "changed mind"}); rr.Code != http.StatusOK {
+		t.Fatalf("cancel order failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if n := redeemedCount(t, redemptions, "PI20"); n != 0 {
+		t.Fatalf("redemptions after cancelling the order = %d, want 0", n)
+	}
+
+	cartID, _ := submitWithCoupon()
+	if rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cartID+"/status/cancelled", map[string]string{"reason": "changed mind"}); rr.Code != http.StatusOK {
+		t.Fatalf("cancel cart failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if n := redeemedCount(t, redemptions, "PI20"); n != 0 {
+		t.Fatalf("redemptions after cancelling the cart = %d, want 0", n)
+	}
+}
+
+func redeemedCount(t *testing.T, redemptions repo.ICouponRepository, code string) (n int64) {
+	t.Helper()
+	if err := redemptions.WithTx(func(tx utils.Tx) (err error) {
+		n, err = redemptions.Redemptions(tx, code)
+		return err
+	}); err != nil {
+		t.Fatalf("read redemptions: %v", err)
+	}
+	return n
+}
@@ -6,10 +6,14 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/gambarini/flip-shop/internal/model/cart"
 	"github.com/gambarini/flip-shop/internal/model/item"
 	"github.com/gambarini/flip-shop/internal/model/promotion"
+	"github.com/gambarini/flip-shop/internal/model/tier"
 	"github.com/gambarini/flip-shop/internal/repo"
 	"github.com/gambarini/flip-shop/utils"
 	"github.com/gambarini/flip-shop/utils/memdb"
@@ -24,40 +28,78 @@ const (
 )
 
 type testEnv struct {
-	srv      *utils.AppServer
-	itemRepo repo.IItemRepository
-	cartRepo repo.ICartRepository
+	srv           *utils.AppServer
+	itemRepo      repo.IItemRepository
+	cartRepo      repo.ICartRepository
+	orderRepo     repo.IOrderRepository
+	promotionRepo repo.IPromotionRepository
 }
 
 func setupTestEnv(t *testing.T) testEnv {
 	t.Helper()
-	kv := memdb.NewMemoryKVDatabase()
+	return setupTestEnvWithDB(t, memdb.NewMemoryKVDatabase())
+}
+
+func setupTestEnvWithDB(t *testing.T, kv utils.KVDatabase, opts ...Option) testEnv {
+	t.Helper()
+	itemRepo := repo.NewItemRepository(kv)
+	cartRepo := repo.NewCartRepository(kv)
 	// Seed items
-	if err := kv.WithTx(func(tx utils.Tx) error {
-		tx.Write(repo.ItemStoreName, ItemGoogleHomeSku, item.Item{Sku: ItemGoogleHomeSku, Name: "Google Home", QtyAvailable: 10, Price: 4999})
-		tx.Write(repo.ItemStoreName, ItemMacBookProSku, item.Item{Sku: ItemMacBookProSku, Name: "MacBook Pro", QtyAvailable: 5, Price: 539999})
-		tx.Write(repo.ItemStoreName, ItemAlexaSpeakerSku, item.Item{Sku: ItemAlexaSpeakerSku, Name: "Alexa Speaker", QtyAvailable: 10, Price: 10950})
-		tx.Write(repo.ItemStoreName, RaspberryPiSku, item.Item{Sku: RaspberryPiSku, Name: "Raspberry Pi B", QtyAvailable: 2, Price: 3000})
+	if err := itemRepo.WithTx(func(tx utils.Tx) error {
+		if err := itemRepo.Store(tx, item.Item{Sku: ItemGoogleHomeSku, Name: "Google Home", Category: "smart_speaker", QtyAvailable: 10, Price: 4999}); err != nil {
+			return err
+		}
+		if err := itemRepo.Store(tx, item.Item{Sku: ItemMacBookProSku, Name: "MacBook Pro", QtyAvailable: 5, Price: 539999}); err != nil {
+			return err
+		}
+		if err := itemRepo.Store(tx, item.Item{Sku: ItemAlexaSpeakerSku, Name: "Alexa Speaker", Category: "smart_speaker", QtyAvailable: 10, Price: 10950}); err != nil {
+			return err
+		}
+		if err := itemRepo.Store(tx, item.Item{Sku: RaspberryPiSku, Name: "Raspberry Pi B", QtyAvailable: 2, Price: 3000}); err != nil {
+			return err
+		}
 		return nil
 	}); err != nil {
 		t.Fatalf("seed failed: %v", err)
 	}
 
-	itemRepo := repo.NewItemRepository(kv)
-	cartRepo := repo.NewCartRepository(kv)
-
-	promos := []promotion.Promotion{
-		promotion.FreeItemPromotion{PurchasedItemSku: ItemMacBookProSku, FreeItemSku: RaspberryPiSku, FreeItemPrice: 3000},
-		promotion.ItemQtyPriceFreePromotion{PurchasedItemSku: ItemGoogleHomeSku, PurchasedQty: 3},
-		promotion.ItemQtyPriceDiscountPercentagePromotion{PurchasedItemSku: ItemAlexaSpeakerSku, PurchasedQty: 3, PercentageDiscount: 0.1},
-	}
+	promotionRepo := repo.NewPromotionRepository(kv)
+	storePromotions(t, promotionRepo,
+		promotion.Definition{Type: promotion.NameFreeItem, Sku: ItemMacBookProSku, FreeSku: RaspberryPiSku, FreePrice: 3000},
+		promotion.Definition{Type: promotion.NameQtyFree, Sku: ItemGoogleHomeSku, Qty: 3},
+		promotion.Definition{Type: promotion.NameQtyPercentage, Sku: ItemAlexaSpeakerSku, Qty: 3, Percentage: 0.1},
+	)
 
 	srv := utils.NewServer(0) // we won't start the server; we only use its router
-	if err := SetRoutes(srv, itemRepo, cartRepo, promos); err != nil {
+	orderRepo := repo.NewOrderRepository(kv)
+	if err := SetRoutes(srv, itemRepo, cartRepo, orderRepo, promotionRepo, opts...); err != nil {
 		t.Fatalf("set routes: %v", err)
 	}
 
-	return testEnv{srv: srv, itemRepo: itemRepo, cartRepo: cartRepo}
+	return testEnv{srv: srv, itemRepo: itemRepo, cartRepo: cartRepo, orderRepo: orderRepo, promotionRepo: promotionRepo}
+}
+
+// storePromotions stores an active promotion rule for each definition, in order.
+func storePromotions(t *testing.T, promotionRepo repo.IPromotionRepository, defs ...promotion.Definition) {
+	t.Helper()
+	if err := promotionRepo.WithTx(func(tx utils.Tx) error {
+		for _, d := range defs {
+			number, err := promotionRepo.NextNumber(tx)
+			if err != nil {
+				return err
+			}
+			rule, err := promotion.NewRule(number, d, time.Now().UTC())
+			if err != nil {
+				return err
+			}
+			if err := promotionRepo.Store(tx, rule); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("store promotions: %v", err)
+	}
 }
 
 func doJSON(t *testing.T, srv *utils.AppServer, method, path string, body interface{}) *httptest.ResponseRecorder {
@@ -148,6 +190,53 @@ func TestRemove_Happy_AndErrors(t *testing.T) {
 	}
 }
 
+func TestPurchase_PriceTiers(t *testing.T) {
+	tiers := tier.Table{
+		Items:      map[item.Sku]tier.Breaks{ItemGoogleHomeSku: {{MinQty: 2, Percentage: 0.5}}},
+		Categories: map[item.Category]tier.Breaks{"smart_speaker": {{MinQty: 5, Percentage: 0.1}}},
+	}
+	env := setupTestEnvWithDB(t, memdb.NewMemoryKVDatabase(), WithPriceTiers(tiers))
+	cid := createCart(t, env.srv)
+
+	priceOf := func(rr *httptest.ResponseRecorder, sku string) int64 {
+		t.Helper()
+		var c cart.Cart
+		if err := json.Unmarshal(rr.Body.Bytes(), &c); err != nil || rr.Code != http.StatusOK {
+			t.Fatalf("unexpected response: %d body=%s", rr.Code, rr.Body.String())
+		}
+		return c.Purchases[item.Sku(sku)].Price
+	}
+
+	// the price break of the category applies as soon as the line reaches it
+	rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemAlexaSpeakerSku, "qty": 4})
+	if got := priceOf(rr, ItemAlexaSpeakerSku); got != 10950 {
+		t.Fatalf("expected 4 speakers at list price, got %d", got)
+	}
+	rr = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemAlexaSpeakerSku, "qty": 1})
+	if got := priceOf(rr, ItemAlexaSpeakerSku); got != 9855 {
+		t.Fatalf("expected 5 speakers at 10%% off, got %d", got)
+	}
+	rr = doJSON(t, env.srv, http.MethodDelete, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemAlexaSpeakerSku, "qty": 1})
+	if got := priceOf(rr, ItemAlexaSpeakerSku); got != 10950 {
+		t.Fatalf("expected removing a speaker to drop the break, got %d", got)
+	}
+
+	// the breaks of an item replace those of its category
+	rr = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemGoogleHomeSku, "qty": 2})
+	if got := priceOf(rr, ItemGoogleHomeSku); got != 2500 {
+		t.Fatalf("expected 2 Google Home at half price, got %d", got)
+	}
+
+	rr = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/submitted", nil)
+	var submitted cart.Cart
+	if err := json.Unmarshal(rr.Body.Bytes(), &submitted); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("submit failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if submitted.Subtotal != 4*10950+2*2500 {
+		t.Fatalf("expected the cart subtotal at the tier prices, got %d", submitted.Subtotal)
+	}
+}
+
 func TestSubmit_WithPromotions(t *testing.T) {
 	env := setupTestEnv(t)
 	cid := createCart(t, env.srv)
@@ -185,6 +274,18 @@ func (f failingPromotion) Apply(_ promotion.GetPurchasedItemHandler, _ promotion
 // countingPromotion increments counter when Apply is called
 type countingPromotion struct{ calls *int }
 
+// countingCalls counts the calls of the countingPromotion built for the "test_counting" type
+var countingCalls int
+
+func init() {
+	promotion.RegisterType("test_failing", func(promotion.Definition) (promotion.Promotion, error) {
+		return failingPromotion{}, nil
+	})
+	promotion.RegisterType("test_counting", func(promotion.Definition) (promotion.Promotion, error) {
+		return countingPromotion{calls: &countingCalls}, nil
+	})
+}
+
 func (c countingPromotion) Apply(_ promotion.GetPurchasedItemHandler, _ promotion.AddPromoItemToCartHandler, _ promotion.AddDiscountToCartHandler) error {
 	*(c.calls)++
 	return nil
@@ -193,18 +294,21 @@ func (c countingPromotion) Apply(_ promotion.GetPurchasedItemHandler, _ promotio
 func TestSubmit_PromotionErrorShortCircuits(t *testing.T) {
 	// Setup env with custom promotions: first fails, second counts
 	kv := memdb.NewMemoryKVDatabase()
-	if err := kv.WithTx(func(tx utils.Tx) error {
-		tx.Write(repo.ItemStoreName, ItemGoogleHomeSku, item.Item{Sku: ItemGoogleHomeSku, Name: "Google Home", QtyAvailable: 10, Price: 4999})
+	itemRepo := repo.NewItemRepository(kv)
+	cartRepo := repo.NewCartRepository(kv)
+	if err := itemRepo.WithTx(func(tx utils.Tx) error {
+		if err := itemRepo.Store(tx, item.Item{Sku: ItemGoogleHomeSku, Name: "Google Home", QtyAvailable: 10, Price: 4999}); err != nil {
+			return err
+		}
 		return nil
 	}); err != nil {
 		t.Fatalf("seed failed: %v", err)
 	}
-	itemRepo := repo.NewItemRepository(kv)
-	cartRepo := repo.NewCartRepository(kv)
-	calls := 0
-	promos := []promotion.Promotion{failingPromotion{}, countingPromotion{calls: &calls}}
+	countingCalls = 0
+	promotionRepo := repo.NewPromotionRepository(kv)
+	storePromotions(t, promotionRepo, promotion.Definition{Type: "test_failing"}, promotion.Definition{Type: "test_counting"})
 	srv := utils.NewServer(0)
-	if err := SetRoutes(srv, itemRepo, cartRepo, promos); err != nil {
+	if err := SetRoutes(srv, itemRepo, cartRepo, repo.NewOrderRepository(kv), promotionRepo); err != nil {
 		t.Fatalf("set routes: %v", err)
 	}
 	cid := createCart(t, srv)
@@ -218,8 +322,8 @@ func TestSubmit_PromotionErrorShortCircuits(t *testing.T) {
 	if rr.Code != http.StatusInternalServerError { // generic 500 on unexpected promotion error
 		t.Fatalf("expected 500 on failing promotion, got %d", rr.Code)
 	}
-	if calls != 0 {
-		t.Fatalf("expected next promotion not to run, but Apply was called %d times", calls)
+	if countingCalls != 0 {
+		t.Fatalf("expected next promotion not to run, but Apply was called %d times", countingCalls)
 	}
 }
 
@@ -257,3 +361,58 @@ func TestGetCart_InvalidID(t *testing.T) {
 		t.Fatalf("expected 422 for invalid id, got %d", rr.Code)
 	}
 }
+
+func TestPurchase_ConcurrentOptimistic_NoLostReservations(t *testing.T) {
+	env := setupTestEnvWithDB(t, memdb.NewMemoryKVDatabaseWithOptions(memdb.Options{
+		Concurrency: memdb.Optimistic,
+		Retry:       memdb.RetryPolicy{MaxAttempts: 1000},
+	}))
+	cid := createCart(t, env.srv)
+
+	// 20 concurrent purchases of 1 unit against a stock of 10
+	const attempts = 20
+	var wg sync.WaitGroup
+	codes := make(chan int, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemGoogleHomeSku, "qty": 1})
+			codes <- rr.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	ok := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			ok++
+		case http.StatusUnprocessableEntity:
+		default:
+			t.Fatalf("unexpected status %d", code)
+		}
+	}
+	if ok != 10 {
+		t.Fatalf("expected 10 successful purchases, got %d", ok)
+	}
+
+	c, err := env.cartRepo.FindCartByID(cid)
+	if err != nil {
+		t.Fatalf("find cart: %v", err)
+	}
+	if c.Purchases[ItemGoogleHomeSku].Qty != 10 {
+		t.Fatalf("expected 10 units in cart, got %d", c.Purchases[ItemGoogleHomeSku].Qty)
+	}
+	_ = env.itemRepo.WithTx(func(tx utils.Tx) error {
+		it, err := env.itemRepo.FindItemBySku(tx, ItemGoogleHomeSku)
+		if err != nil {
+			t.Fatalf("find item: %v", err)
+		}
+		if it.QtyReserved != 10 {
+			t.Fatalf("expected 10 reserved, got %d", it.QtyReserved)
+		}
+		return nil
+	})
+}
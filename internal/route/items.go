@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"sort"
 
 	"github.com/gambarini/flip-shop/internal/model/item"
 	"github.com/gambarini/flip-shop/internal/repo"
@@ -13,12 +12,25 @@ import (
 )
 
 type (
-	// AddItemPayload represents the request body to add a new item to inventory
+	// AddItemPayload represents the request body to add a new item to inventory.
+	// TaxCategory is optional; items without one are taxed at the standard rate.
+	// Category is optional and lets promotions apply to every item of a category.
+	// Weight is in grams and Dimensions in millimetres, both optional.
 	AddItemPayload struct {
-		Sku   string `json:"sku"`
-		Name  string `json:"name"`
-		Price int64  `json:"price"`
-		Qty   int    `json:"qty"`
+		Sku         string            `json:"sku"`
+		Name        string            `json:"name"`
+		Price       int64             `json:"price"`
+		Qty         int               `json:"qty"`
+		TaxCategory string            `json:"tax_category"`
+		Category    string            `json:"category"`
+		Weight      int               `json:"weight"`
+		Dimensions  DimensionsPayload `json:"dimensions"`
+	}
+	// DimensionsPayload is the size of a packed unit of an item, in millimetres.
+	DimensionsPayload struct {
+		Length int `json:"length"`
+		Width  int `json:"width"`
+		Height int `json:"height"`
 	}
 	// UpdateItemQtyPayload represents the request body to add quantity to an existing item
 	UpdateItemQtyPayload struct {
@@ -30,16 +42,22 @@ type (
 	}
 )
 
-// listItems returns all items in inventory (unordered by default); for stable outputs we sort by SKU.
+// listItems returns all items in inventory ordered by SKU, read from a snapshot without holding up purchases.
 func listItems(srv *utils.AppServer, itemRepo repo.IItemRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		items, err := itemRepo.ListItems()
+		var items []item.Item
+		err := itemRepo.View(func(tx utils.Tx) error {
+			found, err := itemRepo.ListItems(tx)
+			if err != nil {
+				return err
+			}
+			items = found
+			return nil
+		})
 		if err != nil {
 			srv.ResponseErrorServerErr(w, fmt.Errorf("error listing items: %w", err))
 			return
 		}
-		// Sort by SKU for deterministic responses in tests/clients
-		sort.Slice(items, func(i, j int) bool { return string(items[i].Sku) < string(items[j].Sku) })
 		srv.RespondJSON(w, http.StatusOK, items)
 	}
 }
@@ -100,6 +118,10 @@ func postItem(srv *utils.AppServer, itemRepo repo.IItemRepository) http.HandlerF
 			srv.ResponseErrorEntityUnproc(w, fmt.Errorf("qty must be >= 0"))
 			return
 		}
+		if payload.Weight < 0 || payload.Dimensions.Length < 0 || payload.Dimensions.Width < 0 || payload.Dimensions.Height < 0 {
+			srv.ResponseErrorEntityUnproc(w, fmt.Errorf("weight and dimensions must be >= 0"))
+			return
+		}
 
 		var it item.Item
 		if err := itemRepo.WithTx(func(tx utils.Tx) error {
@@ -113,6 +135,10 @@ func postItem(srv *utils.AppServer, itemRepo repo.IItemRepository) http.HandlerF
 			}
 			// Create new item using constructor
 			it = item.NewItem(item.Sku(payload.Sku), payload.Name, payload.Price, payload.Qty)
+			it.TaxCategory = item.TaxCategory(payload.TaxCategory)
+			it.Category = item.Category(payload.Category)
+			it.Weight = payload.Weight
+			it.Dimensions = item.Dimensions(payload.Dimensions)
 			return itemRepo.Store(tx, it)
 		}); err != nil {
 			// Map already exists to 422
@@ -0,0 +1,110 @@
+package route
+
+import (
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/coupon"
+	"github.com/gambarini/flip-shop/internal/model/shipping"
+	"github.com/gambarini/flip-shop/internal/model/tax"
+	"github.com/gambarini/flip-shop/internal/model/tier"
+	"github.com/gambarini/flip-shop/internal/payment"
+	"github.com/gambarini/flip-shop/internal/repo"
+)
+
+// DefaultHoldDuration is how long a purchase keeps its stock reserved when no hold duration is configured.
+const DefaultHoldDuration = 15 * time.Minute
+
+type (
+	// Option customizes the handlers registered by SetRoutes.
+	Option func(*config)
+
+	config struct {
+		holdDuration time.Duration
+		now          func() time.Time
+		gateway      payment.Gateway
+		taxes        tax.Table
+		tiers        tier.Table
+		shipping     shipping.Methods
+		coupons      coupon.Catalog
+		redemptions  repo.ICouponRepository
+		bestDeal     bool
+		searchLimit  int
+	}
+)
+
+func newConfig(opts []Option) config {
+	cfg := config{
+		holdDuration: DefaultHoldDuration,
+		now:          time.Now,
+		gateway:      payment.NewFake(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithHoldDuration sets how long a purchase keeps its stock reserved.
+// Every purchase of an item renews the deadline of its cart line.
+// Zero holds reservations until the cart is submitted.
+func WithHoldDuration(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.holdDuration = d
+	}
+}
+
+// WithClock sets the clock used to compute deadlines. Defaults to time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(cfg *config) {
+		cfg.now = now
+	}
+}
+
+// WithGateway sets the payment gateway orders are paid through.
+// Defaults to a payment.Fake approving every call.
+func WithGateway(g payment.Gateway) Option {
+	return func(cfg *config) {
+		cfg.gateway = g
+	}
+}
+
+// WithTaxes sets the tax rates carts are submitted with. Defaults to no tax.
+func WithTaxes(t tax.Table) Option {
+	return func(cfg *config) {
+		cfg.taxes = t
+	}
+}
+
+// WithPriceTiers sets the price breaks cart lines are priced with as items are purchased.
+// Defaults to none, every item selling at its list price.
+func WithPriceTiers(t tier.Table) Option {
+	return func(cfg *config) {
+		cfg.tiers = t
+	}
+}
+
+// WithShippingMethods sets the shipping methods carts can choose from. Defaults to none.
+func WithShippingMethods(ms shipping.Methods) Option {
+	return func(cfg *config) {
+		cfg.shipping = ms
+	}
+}
+
+// WithCoupons sets the coupons carts can attach and where their redemptions are counted.
+// Defaults to no coupon.
+func WithCoupons(catalog coupon.Catalog, redemptions repo.ICouponRepository) Option {
+	return func(cfg *config) {
+		cfg.coupons = catalog
+		cfg.redemptions = redemptions
+	}
+}
+
+// WithBestDeal applies, on submit, the combination of promotions giving carts their lowest total,
+// evaluating at most limit combinations per cart; 0 means pricing.DefaultSearchLimit.
+// Defaults to applying every promotion in effect, by priority.
+func WithBestDeal(limit int) Option {
+	return func(cfg *config) {
+		cfg.bestDeal = true
+		cfg.searchLimit = limit
+	}
+}
@@ -0,0 +1,65 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gambarini/flip-shop/internal/model/order"
+	"github.com/gambarini/flip-shop/internal/repo"
+	"github.com/gambarini/flip-shop/utils"
+	"github.com/gofrs/uuid"
+)
+
+// listOrders handles GET /orders returning every order ordered by number.
+func listOrders(srv *utils.AppServer, orderRepo repo.IOrderRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var orders []order.Order
+		err := orderRepo.WithTx(func(tx utils.Tx) error {
+			found, err := orderRepo.ListOrders(tx)
+			if err != nil {
+				return err
+			}
+			orders = found
+			return nil
+		})
+		if err != nil {
+			srv.ResponseErrorServerErr(w, fmt.Errorf("error listing orders: %w", err))
+			return
+		}
+		srv.RespondJSON(w, http.StatusOK, orders)
+	}
+}
+
+// getOrder handles GET /orders/{orderID} returning a single order.
+func getOrder(srv *utils.AppServer, orderRepo repo.IOrderRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID := srv.Vars(r)["orderID"]
+
+		if _, err := uuid.FromString(orderID); err != nil {
+			srv.ResponseErrorEntityUnproc(w, fmt.Errorf("invalid orderID format: %w", err))
+			return
+		}
+
+		var found order.Order
+		err := orderRepo.WithTx(func(tx utils.Tx) error {
+			o, err := orderRepo.FindOrderByID(tx, orderID)
+			if err != nil {
+				return err
+			}
+			found = o
+			return nil
+		})
+
+		switch {
+		case errors.Is(err, repo.ErrOrderNotFound):
+			srv.ResponseErrorNotfound(w, err)
+			return
+		case err != nil:
+			srv.ResponseErrorServerErr(w, fmt.Errorf("error fetching order: %w", err))
+			return
+		}
+
+		srv.RespondJSON(w, http.StatusOK, found)
+	}
+}
@@ -0,0 +1,100 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/order"
+	"github.com/gambarini/flip-shop/utils/memdb"
+)
+
+func TestSubmit_PlacesOrder(t *testing.T) {
+	placedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	env := setupTestEnvWithDB(t, memdb.NewMemoryKVDatabase(), WithClock(func() time.Time { return placedAt }))
+	cid := createCart(t, env.srv)
+
+	// MacBook Pro brings a free Raspberry Pi; 3 Google Homes get one free
+	_ = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemMacBookProSku, "qty": 1})
+	_ = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemGoogleHomeSku, "qty": 3})
+
+	rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/submitted", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("submit failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	var submitted struct {
+		OrderID string `json:"OrderID"`
+		Total   int64  `json:"Total"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &submitted); err != nil || submitted.OrderID == "" {
+		t.Fatalf("expected submitted cart to reference its order: %s (err %v)", rr.Body.String(), err)
+	}
+
+	rr = doJSON(t, env.srv, http.MethodGet, "/orders/"+submitted.OrderID, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("get order failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	var o order.Order
+	if err := json.Unmarshal(rr.Body.Bytes(), &o); err != nil {
+		t.Fatalf("invalid order json: %v", err)
+	}
+
+	if o.Number != 1 || o.CartID != cid || o.Status != order.StatusPlaced || !o.PlacedAt.Equal(placedAt) {
+		t.Fatalf("unexpected order header: %+v", o)
+	}
+	if o.Totals.Total != submitted.Total || o.Totals.Subtotal-o.Totals.Discount != o.Totals.Total {
+		t.Fatalf("order totals %+v do not match cart total %d", o.Totals, submitted.Total)
+	}
+
+	wantPromotions := map[string]order.AppliedPromotion{
+		ItemGoogleHomeSku: {Promotion: "qty_free", Threshold: 3, Discount: 4999},
+		RaspberryPiSku:    {Promotion: "free_item", FreeQty: 1, PurchasedSku: ItemMacBookProSku, Discount: 3000},
+	}
+	for sku, want := range wantPromotions {
+		l, ok := o.Line(item.Sku(sku))
+		if !ok || len(l.Promotions) != 1 || l.Promotions[0] != want {
+			t.Errorf("line %s promotions = %+v, want [%+v]", sku, l.Promotions, want)
+		}
+	}
+	if l, _ := o.Line(ItemMacBookProSku); len(l.Promotions) != 0 {
+		t.Errorf("line %s promotions = %+v, want none", ItemMacBookProSku, l.Promotions)
+	}
+
+	// a second order gets the next number and both are listed
+	cid2 := createCart(t, env.srv)
+	_ = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid2+"/purchase", map[string]interface{}{"sku": ItemAlexaSpeakerSku, "qty": 1})
+	if rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid2+"/status/submitted", nil); rr.Code != http.StatusOK {
+		t.Fatalf("second submit failed: %d", rr.Code)
+	}
+
+	rr = doJSON(t, env.srv, http.MethodGet, "/orders", nil)
+	var list []order.Order
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatalf("invalid orders json: %v", err)
+	}
+	if len(list) != 2 || list[0].Number != 1 || list[1].Number != 2 || list[1].CartID != cid2 {
+		t.Fatalf("unexpected orders: %+v", list)
+	}
+}
+
+func TestGetOrder_Errors(t *testing.T) {
+	env := setupTestEnv(t)
+
+	tests := []struct {
+		name string
+		id   string
+		want int
+	}{
+		{"invalid id", "not-a-uuid", http.StatusUnprocessableEntity},
+		{"unknown order", "0b7c4a8e-8d5e-4d4b-9b59-0e2b8f0b7a11", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doJSON(t, env.srv, http.MethodGet, "/orders/"+tt.id, nil); rr.Code != tt.want {
+				t.Fatalf("GET /orders/%s = %d, want %d", tt.id, rr.Code, tt.want)
+			}
+		})
+	}
+}
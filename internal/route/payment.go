@@ -0,0 +1,346 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/order"
+	"github.com/gambarini/flip-shop/internal/payment"
+	"github.com/gambarini/flip-shop/internal/repo"
+	"github.com/gambarini/flip-shop/utils"
+)
+
+// payCart handles PUT /cart/{cartID}/status/paid, capturing the payment of the order placed from the cart.
+// The capture is made between transactions, see withGatewayCalls.
+func payCart(srv *utils.AppServer, cartRepo repo.ICartRepository, orderRepo repo.IOrderRepository, cfg config) http.HandlerFunc {
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		cartID := srv.Vars(request)["cartID"]
+
+		var paid cart.Cart
+
+		err := withGatewayCalls(request.Context(), cartRepo, cfg.gateway, orderRepo, func(tx utils.Tx, gateway payment.Gateway) (string, error) {
+
+			c, err := cartRepo.FindCartByIDTx(tx, cartID)
+
+			if err != nil {
+				return "", err
+			}
+
+			if err := c.Transition(cart.CartStatusPaid, cfg.now().UTC()); err != nil {
+				return "", err
+			}
+
+			o, err := orderRepo.FindOrderByCartID(tx, cartID)
+
+			if err != nil {
+				return "", err
+			}
+
+			if err := capturePayment(request.Context(), gateway, &o); err != nil {
+				return "", err
+			}
+
+			if err := orderRepo.Store(tx, o); err != nil {
+				return "", err
+			}
+
+			if err := cartRepo.Store(tx, c); err != nil {
+				return "", err
+			}
+
+			paid = c
+
+			return o.OrderID, nil
+		})
+
+		if respondPaymentError(srv, response, err) {
+			return
+		}
+
+		switch {
+		case errors.Is(err, repo.ErrCartNotFound):
+			srv.ResponseErrorNotfound(response, err)
+			return
+		case errors.Is(err, cart.ErrInvalidTransition),
+			errors.Is(err, cart.ErrCartHasNoOrder):
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
+		case err != nil:
+			srv.ResponseErrorServerErr(response, fmt.Errorf("error paying cart: %w", err))
+			return
+		}
+
+		srv.RespondJSON(response, http.StatusOK, paid)
+	}
+}
+
+var (
+	// errGatewayRehearsed rolls back the rehearsal of a change making gateway calls.
+	errGatewayRehearsed = errors.New("gateway calls rehearsed")
+	// errGatewayCallsChanged is returned when a change no longer makes the gateway calls made for it.
+	errGatewayCallsChanged = errors.New("gateway calls changed since they were made")
+	// errAuthorizeInChange is returned when a change run by withGatewayCalls asks for an authorization.
+	errAuthorizeInChange = errors.New("authorizations are not made by order changes")
+)
+
+type (
+	// gatewayChange is a transaction changing an order whose money moves through gateway.
+	// It returns the ID of the order, or an empty one when no order is changed.
+	gatewayChange func(tx utils.Tx, gateway payment.Gateway) (orderID string, err error)
+
+	// gatewayCalls is the payment.Gateway a gatewayChange runs with. Recording, it notes the calls
+	// moving money as if they succeeded; replaying, it takes the calls already made, in the order
+	// they were, and refuses any other.
+	gatewayCalls struct {
+		replay bool
+		calls  []order.PaymentCall
+	}
+)
+
+// withGatewayCalls runs change so that no money moves inside a transaction that could still roll back.
+// A first run, rolled back, records the calls change makes. They are stored as pending on the order
+// and made, then a second run clears them and replays them, storing its changes. A declined call
+// moved no money and is cleared; the outcome of other failures is unknown, so their calls stay pending
+// and are made again by the next attempt of a change making the same calls, which the gateway takes as
+// repeats. Meanwhile any other change of the order is refused with order.ErrPaymentPending.
+func withGatewayCalls(ctx context.Context, db utils.KVRepository, gateway payment.Gateway, orderRepo repo.IOrderRepository, change gatewayChange) error {
+
+	rehearsal := &gatewayCalls{}
+	var orderID string
+
+	err := db.WithTx(func(tx utils.Tx) error {
+		rehearsal.calls = nil
+		id, err := change(tx, rehearsal)
+		if err != nil {
+			return err
+		}
+		orderID = id
+		return errGatewayRehearsed
+	})
+
+	if !errors.Is(err, errGatewayRehearsed) {
+		return err
+	}
+
+	calls := rehearsal.calls
+
+	if len(calls) > 0 {
+		if err := setPendingCalls(db, orderRepo, orderID, nil, calls); err != nil {
+			return err
+		}
+
+		for i, call := range calls {
+			if err := makeCall(ctx, gateway, call); err != nil {
+				if i == 0 && errors.Is(err, payment.ErrDeclined) {
+					if cErr := setPendingCalls(db, orderRepo, orderID, calls, nil); cErr != nil {
+						return fmt.Errorf("%w (pending calls kept: %v)", err, cErr)
+					}
+				}
+				return err
+			}
+		}
+	}
+
+	return db.WithTx(func(tx utils.Tx) error {
+
+		if orderID != "" {
+			o, err := orderRepo.FindOrderByID(tx, orderID)
+
+			if err != nil {
+				return err
+			}
+
+			if !sameCalls(o.Payment.Pending, calls) {
+				return order.ErrPaymentPending
+			}
+
+			if len(o.Payment.Pending) > 0 {
+				o.Payment.Pending = nil
+
+				if err := orderRepo.Store(tx, o); err != nil {
+					return err
+				}
+			}
+		}
+
+		replay := &gatewayCalls{replay: true, calls: calls}
+
+		id, err := change(tx, replay)
+
+		if err != nil {
+			return err
+		}
+
+		if len(replay.calls) > 0 || id != orderID {
+			return errGatewayCallsChanged
+		}
+
+		return nil
+	})
+}
+
+// setPendingCalls replaces the pending calls of an order with to, unless the order is pending
+// calls other than from or to.
+func setPendingCalls(db utils.KVRepository, orderRepo repo.IOrderRepository, orderID string, from, to []order.PaymentCall) error {
+	return db.WithTx(func(tx utils.Tx) error {
+
+		o, err := orderRepo.FindOrderByID(tx, orderID)
+
+		if err != nil {
+			return err
+		}
+
+		if !sameCalls(o.Payment.Pending, from) && !sameCalls(o.Payment.Pending, to) {
+			return order.ErrPaymentPending
+		}
+
+		o.Payment.Pending = to
+
+		return orderRepo.Store(tx, o)
+	})
+}
+
+func sameCalls(a, b []order.PaymentCall) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// makeCall makes a recorded call through the gateway.
+func makeCall(ctx context.Context, gateway payment.Gateway, call order.PaymentCall) error {
+	switch payment.Operation(call.Operation) {
+	case payment.OperationCapture:
+		return gateway.Capture(ctx, call.AuthorizationID, call.Amount)
+	case payment.OperationVoid:
+		return gateway.Void(ctx, call.AuthorizationID)
+	default:
+		return gateway.Refund(ctx, call.AuthorizationID, call.Amount, call.Reference)
+	}
+}
+
+func (g *gatewayCalls) call(call order.PaymentCall) error {
+	if !g.replay {
+		g.calls = append(g.calls, call)
+		return nil
+	}
+	if len(g.calls) == 0 || g.calls[0] != call {
+		return errGatewayCallsChanged
+	}
+	g.calls = g.calls[1:]
+	return nil
+}
+
+// Authorize implements payment.Gateway; order changes do not authorize payments.
+func (g *gatewayCalls) Authorize(context.Context, string, int64) (payment.Authorization, error) {
+	return payment.Authorization{}, errAuthorizeInChange
+}
+
+// Capture implements payment.Gateway.
+func (g *gatewayCalls) Capture(_ context.Context, authorizationID string, amount int64) error {
+	return g.call(order.PaymentCall{Operation: string(payment.OperationCapture), AuthorizationID: authorizationID, Amount: amount})
+}
+
+// Void implements payment.Gateway.
+func (g *gatewayCalls) Void(_ context.Context, authorizationID string) error {
+	return g.call(order.PaymentCall{Operation: string(payment.OperationVoid), AuthorizationID: authorizationID})
+}
+
+// Refund implements payment.Gateway.
+func (g *gatewayCalls) Refund(_ context.Context, authorizationID string, amount int64, reference string) error {
+	return g.call(order.PaymentCall{Operation: string(payment.OperationRefund), AuthorizationID: authorizationID, Amount: amount, Reference: reference})
+}
+
+// capturePayment collects what is still due on an order: the authorized amount less
+// what returns already refunded. When nothing is due the authorization is voided.
+func capturePayment(ctx context.Context, gateway payment.Gateway, o *order.Order) error {
+
+	p := &o.Payment
+
+	if p.AuthorizationID == "" {
+		return nil
+	}
+
+	due := utils.SaturatingSubInt64(p.Authorized, o.Totals.Refunded)
+
+	if due <= 0 {
+		if err := gateway.Void(ctx, p.AuthorizationID); err != nil {
+			return err
+		}
+		p.Voided = true
+		return nil
+	}
+
+	if err := gateway.Capture(ctx, p.AuthorizationID, due); err != nil {
+		return err
+	}
+
+	p.Captured = due
+
+	return nil
+}
+
+// refundPayment gives amount back to the customer under reference. Before capture
+// nothing was collected: the refund only lowers what capturePayment will collect.
+func refundPayment(ctx context.Context, gateway payment.Gateway, o *order.Order, amount int64, reference string) error {
+
+	p := &o.Payment
+
+	if amount <= 0 || p.Captured == 0 {
+		return nil
+	}
+
+	if err := gateway.Refund(ctx, p.AuthorizationID, amount, reference); err != nil {
+		return err
+	}
+
+	p.Refunded = utils.SaturatingAddInt64(p.Refunded, amount)
+
+	return nil
+}
+
+// settleCancelledPayment gives back the money of a cancelled order: what was captured
+// and not refunded yet is refunded, an authorization that was not captured is voided.
+func settleCancelledPayment(ctx context.Context, gateway payment.Gateway, o *order.Order) error {
+
+	p := &o.Payment
+
+	switch {
+	case p.AuthorizationID == "" || p.Voided:
+		return nil
+	case p.Captured > 0:
+		return refundPayment(ctx, gateway, o, utils.SaturatingSubInt64(p.Captured, p.Refunded), o.OrderID+"/cancel")
+	default:
+		if err := gateway.Void(ctx, p.AuthorizationID); err != nil {
+			return err
+		}
+		p.Voided = true
+		return nil
+	}
+}
+
+// respondPaymentError maps payment gateway failures to responses and reports whether one was written.
+// A declined payment responds 402, a gateway timeout 504 and an order pending another payment 422.
+func respondPaymentError(srv *utils.AppServer, response http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, order.ErrPaymentPending):
+		srv.ResponseErrorEntityUnproc(response, err)
+	case errors.Is(err, payment.ErrDeclined):
+		srv.ResponseErrorPaymentRequired(response, err)
+	case errors.Is(err, payment.ErrTimeout):
+		srv.ResponseErrorGatewayTimeout(response, err)
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,282 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/order"
+	"github.com/gambarini/flip-shop/internal/payment"
+	"github.com/gambarini/flip-shop/utils"
+	"github.com/gambarini/flip-shop/utils/memdb"
+)
+
+func getOrderOf(t *testing.T, env testEnv, orderID string) order.Order {
+	t.Helper()
+	rr := doJSON(t, env.srv, http.MethodGet, "/orders/"+orderID, nil)
+	var o order.Order
+	if err := json.Unmarshal(rr.Body.Bytes(), &o); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("get order failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	return o
+}
+
+func cartStatusOf(t *testing.T, env testEnv, cartID string) cart.Status {
+	t.Helper()
+	rr := doJSON(t, env.srv, http.MethodGet, "/cart/"+cartID, nil)
+	var c cart.Cart
+	if err := json.Unmarshal(rr.Body.Bytes(), &c); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("get cart failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	return c.CartStatus
+}
+
+func TestSubmit_PaymentFailureRollsBack(t *testing.T) {
+	tests := []struct {
+		name    string
+		outcome payment.Outcome
+		want    int
+	}{
+		{"declined", payment.Decline, http.StatusPaymentRequired},
+		{"timeout", payment.Timeout, http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := payment.NewFake()
+			gateway.Script(payment.OperationAuthorize, tt.outcome)
+			env := setupTestEnvWithDB(t, memdb.NewMemoryKVDatabase(), WithGateway(gateway))
+			cid := createCart(t, env.srv)
+			_ = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemGoogleHomeSku, "qty": 3})
+
+			rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/submitted", nil)
+			if rr.Code != tt.want {
+				t.Fatalf("expected %d, got %d body=%s", tt.want, rr.Code, rr.Body.String())
+			}
+
+			// no stock was taken and the cart can be submitted again
+			if got := stockOf(t, env, ItemGoogleHomeSku); got.QtyAvailable != 10 || got.QtyReserved != 3 {
+				t.Fatalf("stock changed: QtyAvailable = %d, QtyReserved = %d", got.QtyAvailable, got.QtyReserved)
+			}
+			if got := cartStatusOf(t, env, cid); got != cart.CartStatusAvailable {
+				t.Fatalf("cart status = %s, want %s", got, cart.CartStatusAvailable)
+			}
+
+			rr = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/submitted", nil)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("resubmit failed: %d body=%s", rr.Code, rr.Body.String())
+			}
+			if got := stockOf(t, env, ItemGoogleHomeSku); got.QtyAvailable != 7 || got.QtyReserved != 0 {
+				t.Fatalf("QtyAvailable = %d, QtyReserved = %d, want 7, 0", got.QtyAvailable, got.QtyReserved)
+			}
+		})
+	}
+}
+
+func TestSubmit_VoidsAuthorizationWhenSubmitFails(t *testing.T) {
+	gateway := payment.NewFake()
+	kv := memdb.NewMemoryKVDatabase()
+	env := setupTestEnvWithDB(t, kv, WithGateway(gateway))
+	cid := createCart(t, env.srv)
+	_ = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemGoogleHomeSku, "qty": 2})
+
+	// the submission fails to commit after the payment was authorized
+	kv.SetCommitHook(func([]memdb.Mutation) error { return errors.New("disk full") })
+	rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/submitted", nil)
+	kv.SetCommitHook(nil)
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	state, ok := gateway.State("auth-1")
+	if !ok || !state.Voided || state.Amount != 9998 {
+		t.Fatalf("authorization not voided: %+v (found %v)", state, ok)
+	}
+	if got := cartStatusOf(t, env, cid); got != cart.CartStatusAvailable {
+		t.Fatalf("cart status = %s, want %s", got, cart.CartStatusAvailable)
+	}
+}
+
+func TestSubmit_NotAuthorizedWhenRehearsalFails(t *testing.T) {
+	gateway := payment.NewFake()
+	env := setupTestEnvWithDB(t, memdb.NewMemoryKVDatabase(), WithGateway(gateway))
+	cid := createCart(t, env.srv)
+	_ = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemGoogleHomeSku, "qty": 2})
+
+	// the reservation vanished: removing the stock fails before the payment is authorized
+	i := stockOf(t, env, ItemGoogleHomeSku)
+	i.QtyReserved = 0
+	if err := env.itemRepo.WithTx(func(tx utils.Tx) error { return env.itemRepo.Store(tx, i) }); err != nil {
+		t.Fatalf("store item: %v", err)
+	}
+
+	rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/submitted", nil)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := gateway.Calls(payment.OperationAuthorize); got != 0 {
+		t.Fatalf("Authorize called %d times, want 0", got)
+	}
+}
+
+func TestPayment_Lifecycle(t *testing.T) {
+	gateway := payment.NewFake()
+	env := setupTestEnvWithDB(t, memdb.NewMemoryKVDatabase(), WithGateway(gateway))
+	oid := placeOrder(t, env, map[string]int{ItemGoogleHomeSku: 2})
+
+	o := getOrderOf(t, env, oid)
+	if o.Payment.AuthorizationID == "" || o.Payment.Authorized != 9998 || o.Payment.Captured != 0 {
+		t.Fatalf("unexpected payment after submit: %+v", o.Payment)
+	}
+
+	// a declined capture leaves the cart submitted
+	gateway.Script(payment.OperationCapture, payment.Decline)
+	rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+o.CartID+"/status/paid", nil)
+	if rr.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected 402, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := cartStatusOf(t, env, o.CartID); got != cart.CartStatusSubmitted {
+		t.Fatalf("cart status = %s, want %s", got, cart.CartStatusSubmitted)
+	}
+
+	rr = doJSON(t, env.srv, http.MethodPut, "/cart/"+o.CartID+"/status/paid", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("pay failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := getOrderOf(t, env, oid).Payment.Captured; got != 9998 {
+		t.Fatalf("Captured = %d, want 9998", got)
+	}
+
+	// a return after capture is refunded through the gateway
+	rr = doJSON(t, env.srv, http.MethodPost, "/orders/"+oid+"/returns", map[string]interface{}{
+		"lines": []map[string]interface{}{{"sku": ItemGoogleHomeSku, "qty": 1}},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create return failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := getOrderOf(t, env, oid).Payment.Refunded; got != 4999 {
+		t.Fatalf("Refunded = %d, want 4999", got)
+	}
+
+	// cancelling refunds what is left
+	rr = doJSON(t, env.srv, http.MethodPost, "/orders/"+oid+"/cancel", map[string]string{"reason": "changed mind"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("cancel failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	state, _ := gateway.State(o.Payment.AuthorizationID)
+	if state.Captured != 9998 || state.Refunded != 9998 || state.Voided {
+		t.Fatalf("unexpected gateway state: %+v", state)
+	}
+	if got := getOrderOf(t, env, oid).Payment.Refunded; got != 9998 {
+		t.Fatalf("Refunded = %d, want 9998", got)
+	}
+}
+
+func TestPayment_ReturnBeforeCaptureLowersCapture(t *testing.T) {
+	gateway := payment.NewFake()
+	env := setupTestEnvWithDB(t, memdb.NewMemoryKVDatabase(), WithGateway(gateway))
+	oid := placeOrder(t, env, map[string]int{ItemGoogleHomeSku: 2})
+
+	rr := doJSON(t, env.srv, http.MethodPost, "/orders/"+oid+"/returns", map[string]interface{}{
+		"lines": []map[string]interface{}{{"sku": ItemGoogleHomeSku, "qty": 1}},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create return failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if gateway.Calls(payment.OperationRefund) != 0 {
+		t.Fatalf("nothing was captured, nothing should be refunded")
+	}
+
+	o := getOrderOf(t, env, oid)
+	rr = doJSON(t, env.srv, http.MethodPut, "/cart/"+o.CartID+"/status/paid", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("pay failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if state, _ := gateway.State(o.Payment.AuthorizationID); state.Captured != 4999 {
+		t.Fatalf("Captured = %d, want 4999", state.Captured)
+	}
+}
+
+func TestPayment_CancelBeforeCaptureVoids(t *testing.T) {
+	gateway := payment.NewFake()
+	env := setupTestEnvWithDB(t, memdb.NewMemoryKVDatabase(), WithGateway(gateway))
+	oid := placeOrder(t, env, map[string]int{ItemGoogleHomeSku: 2})
+
+	// a gateway timeout leaves the order placed
+	gateway.Script(payment.OperationVoid, payment.Timeout)
+	rr := doJSON(t, env.srv, http.MethodPost, "/orders/"+oid+"/cancel", map[string]string{"reason": "changed mind"})
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := getOrderOf(t, env, oid).Status; got != order.StatusPlaced {
+		t.Fatalf("order status = %s, want %s", got, order.StatusPlaced)
+	}
+
+	rr = doJSON(t, env.srv, http.MethodPost, "/orders/"+oid+"/cancel", map[string]string{"reason": "changed mind"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("cancel failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	o := getOrderOf(t, env, oid)
+	if !o.Payment.Voided {
+		t.Fatalf("payment not voided: %+v", o.Payment)
+	}
+	if state, _ := gateway.State(o.Payment.AuthorizationID); !state.Voided {
+		t.Fatalf("authorization not voided: %+v", state)
+	}
+}
+
+func TestPayment_CaptureKeptPendingWhenItsOutcomeIsNotStored(t *testing.T) {
+	gateway := payment.NewFake()
+	kv := memdb.NewMemoryKVDatabase()
+	env := setupTestEnvWithDB(t, kv, WithGateway(gateway))
+	oid := placeOrder(t, env, map[string]int{ItemGoogleHomeSku: 2})
+	o := getOrderOf(t, env, oid)
+
+	// the pending capture is stored, the commit of the payment after the capture fails
+	commits := 0
+	kv.SetCommitHook(func([]memdb.Mutation) error {
+		if commits++; commits == 2 {
+			return errors.New("disk full")
+		}
+		return nil
+	})
+	rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+o.CartID+"/status/paid", nil)
+	kv.SetCommitHook(nil)
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// the money moved and the order tells so
+	if state, _ := gateway.State(o.Payment.AuthorizationID); state.Captured != 9998 {
+		t.Fatalf("gateway Captured = %d, want 9998", state.Captured)
+	}
+	want := []order.PaymentCall{{Operation: string(payment.OperationCapture), AuthorizationID: o.Payment.AuthorizationID, Amount: 9998}}
+	if got := getOrderOf(t, env, oid).Payment; !reflect.DeepEqual(got.Pending, want) || got.Captured != 0 {
+		t.Fatalf("unexpected payment %+v", got)
+	}
+	if got := cartStatusOf(t, env, o.CartID); got != cart.CartStatusSubmitted {
+		t.Fatalf("cart status = %s, want %s", got, cart.CartStatusSubmitted)
+	}
+
+	// other changes of the order wait for the pending capture
+	rr = doJSON(t, env.srv, http.MethodPost, "/orders/"+oid+"/returns", map[string]interface{}{
+		"lines": []map[string]interface{}{{"sku": ItemGoogleHomeSku, "qty": 1}},
+	})
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 returning while a capture is pending, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// paying again repeats the capture and stores its outcome
+	rr = doJSON(t, env.srv, http.MethodPut, "/cart/"+o.CartID+"/status/paid", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("pay failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := getOrderOf(t, env, oid).Payment; len(got.Pending) != 0 || got.Captured != 9998 {
+		t.Fatalf("unexpected payment %+v", got)
+	}
+	if n := gateway.Calls(payment.OperationCapture); n != 2 {
+		t.Fatalf("capture calls = %d, want 2", n)
+	}
+}
@@ -0,0 +1,281 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/promotion"
+	"github.com/gambarini/flip-shop/internal/pricing"
+	"github.com/gambarini/flip-shop/internal/repo"
+	"github.com/gambarini/flip-shop/utils"
+	"github.com/gofrs/uuid"
+)
+
+// PromotionPayload represents the request body to create or update a promotion,
+// in the format of a promotions file entry.
+type PromotionPayload = promotion.Definition
+
+// listPromotions handles GET /promotions returning every promotion, active or not, ordered by number.
+func listPromotions(srv *utils.AppServer, promotionRepo repo.IPromotionRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rules []promotion.Rule
+		err := promotionRepo.WithTx(func(tx utils.Tx) error {
+			found, err := promotionRepo.ListPromotions(tx)
+			if err != nil {
+				return err
+			}
+			rules = found
+			return nil
+		})
+		if err != nil {
+			srv.ResponseErrorServerErr(w, fmt.Errorf("error listing promotions: %w", err))
+			return
+		}
+		srv.RespondJSON(w, http.StatusOK, rules)
+	}
+}
+
+// getPromotion handles GET /promotions/{promotionID} returning a single promotion.
+func getPromotion(srv *utils.AppServer, promotionRepo repo.IPromotionRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		promotionID := srv.Vars(r)["promotionID"]
+
+		if _, err := uuid.FromString(promotionID); err != nil {
+			srv.ResponseErrorEntityUnproc(w, fmt.Errorf("invalid promotionID format: %w", err))
+			return
+		}
+
+		var found promotion.Rule
+		err := promotionRepo.WithTx(func(tx utils.Tx) error {
+			rule, err := promotionRepo.FindPromotionByID(tx, promotionID)
+			if err != nil {
+				return err
+			}
+			found = rule
+			return nil
+		})
+
+		switch {
+		case errors.Is(err, repo.ErrPromotionNotFound):
+			srv.ResponseErrorNotfound(w, err)
+			return
+		case err != nil:
+			srv.ResponseErrorServerErr(w, fmt.Errorf("error fetching promotion: %w", err))
+			return
+		}
+
+		srv.RespondJSON(w, http.StatusOK, found)
+	}
+}
+
+// postPromotion handles POST /promotions, creating an active promotion.
+// It applies to the carts submitted from then on.
+func postPromotion(srv *utils.AppServer, itemRepo repo.IItemRepository, promotionRepo repo.IPromotionRepository, cfg config) http.HandlerFunc {
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		def, ok := decodePromotionPayload(srv, response, request)
+
+		if !ok {
+			return
+		}
+
+		var created promotion.Rule
+
+		err := promotionRepo.WithTx(func(tx utils.Tx) error {
+
+			number, err := promotionRepo.NextNumber(tx)
+
+			if err != nil {
+				return err
+			}
+
+			rule, err := promotion.NewRule(number, def, cfg.now().UTC())
+
+			if err != nil {
+				return err
+			}
+
+			if err := promotionSkusExist(tx, itemRepo, def); err != nil {
+				return err
+			}
+
+			if err := promotionRepo.Store(tx, rule); err != nil {
+				return err
+			}
+
+			created = rule
+
+			return nil
+		})
+
+		switch {
+		case isPromotionError(err):
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
+		case err != nil:
+			srv.ResponseErrorServerErr(response, fmt.Errorf("error storing promotion: %w", err))
+			return
+		}
+
+		srv.RespondJSON(response, http.StatusCreated, created)
+	}
+}
+
+// putPromotion handles PUT /promotions/{promotionID}, replacing the definition of a promotion.
+func putPromotion(srv *utils.AppServer, itemRepo repo.IItemRepository, promotionRepo repo.IPromotionRepository, cfg config) http.HandlerFunc {
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		def, ok := decodePromotionPayload(srv, response, request)
+
+		if !ok {
+			return
+		}
+
+		updated, err := updatePromotion(srv, promotionRepo, request, func(tx utils.Tx, rule *promotion.Rule) error {
+
+			if err := rule.Update(def, cfg.now().UTC()); err != nil {
+				return err
+			}
+
+			return promotionSkusExist(tx, itemRepo, def)
+		})
+
+		respondPromotion(srv, response, updated, err)
+	}
+}
+
+// disablePromotion handles POST /promotions/{promotionID}/disable. A disabled promotion
+// no longer applies to carts but is still listed; disabling it again is a no-op.
+func disablePromotion(srv *utils.AppServer, promotionRepo repo.IPromotionRepository, cfg config) http.HandlerFunc {
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		updated, err := updatePromotion(srv, promotionRepo, request, func(_ utils.Tx, rule *promotion.Rule) error {
+			rule.Disable(cfg.now().UTC())
+			return nil
+		})
+
+		respondPromotion(srv, response, updated, err)
+	}
+}
+
+// updatePromotion applies change to the promotion of the request and stores it, in one transaction.
+func updatePromotion(srv *utils.AppServer, promotionRepo repo.IPromotionRepository, request *http.Request, change func(tx utils.Tx, rule *promotion.Rule) error) (updated promotion.Rule, err error) {
+
+	promotionID := srv.Vars(request)["promotionID"]
+
+	err = promotionRepo.WithTx(func(tx utils.Tx) error {
+
+		rule, err := promotionRepo.FindPromotionByID(tx, promotionID)
+
+		if err != nil {
+			return err
+		}
+
+		if err := change(tx, &rule); err != nil {
+			return err
+		}
+
+		if err := promotionRepo.Store(tx, rule); err != nil {
+			return err
+		}
+
+		updated = rule
+
+		return nil
+	})
+
+	return updated, err
+}
+
+func respondPromotion(srv *utils.AppServer, response http.ResponseWriter, rule promotion.Rule, err error) {
+
+	switch {
+	case errors.Is(err, repo.ErrPromotionNotFound):
+		srv.ResponseErrorNotfound(response, err)
+		return
+	case isPromotionError(err):
+		srv.ResponseErrorEntityUnproc(response, err)
+		return
+	case err != nil:
+		srv.ResponseErrorServerErr(response, fmt.Errorf("error storing promotion: %w", err))
+		return
+	}
+
+	srv.RespondJSON(response, http.StatusOK, rule)
+}
+
+func decodePromotionPayload(srv *utils.AppServer, response http.ResponseWriter, request *http.Request) (def PromotionPayload, ok bool) {
+
+	dec := json.NewDecoder(request.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&def); err != nil {
+		srv.ResponseErrorEntityUnproc(response, fmt.Errorf("invalid JSON payload: %w", err))
+		return def, false
+	}
+
+	return def, true
+}
+
+// promotionSkusExist checks, within the transaction, that the items a promotion refers to are in the inventory.
+func promotionSkusExist(tx utils.Tx, itemRepo repo.IItemRepository, def promotion.Definition) error {
+
+	for _, sku := range def.Skus() {
+
+		_, err := itemRepo.FindItemBySku(tx, sku)
+
+		switch {
+		case errors.Is(err, repo.ErrItemNotFound):
+			return fmt.Errorf("%w: %q", promotion.ErrUnknownSku, sku)
+		case err != nil:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// promotionsInEffect builds the promotions of the active rules, in order, skipping those not in effect at the given time.
+func promotionsInEffect(rules []promotion.Rule, at time.Time) ([]pricing.Candidate, error) {
+
+	candidates := make([]pricing.Candidate, 0, len(rules))
+
+	for _, r := range rules {
+
+		if !r.Active {
+			continue
+		}
+
+		p, err := r.Definition.Build()
+
+		if err != nil {
+			return nil, fmt.Errorf("promotion %s: %w", r.ID, err)
+		}
+
+		_, skipped := r.Definition.InEffectAt(at)
+
+		candidates = append(candidates, pricing.Candidate{
+			Promotion: p,
+			RuleID:    r.ID,
+			Priority:  r.Definition.Priority,
+			Group:     r.Definition.Group,
+			Stop:      r.Definition.Stop,
+			Skipped:   skipped,
+		})
+	}
+
+	return candidates, nil
+}
+
+// isPromotionError tells whether err is a promotion definition that cannot be used.
+func isPromotionError(err error) bool {
+	return errors.Is(err, promotion.ErrUnknownType) ||
+		errors.Is(err, promotion.ErrInvalidPromotion) ||
+		errors.Is(err, promotion.ErrUnknownSku)
+}
@@ -0,0 +1,288 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/order"
+	"github.com/gambarini/flip-shop/internal/model/promotion"
+	"github.com/gambarini/flip-shop/internal/pricing"
+	"github.com/gambarini/flip-shop/utils/memdb"
+)
+
+func TestPromotionsAdmin(t *testing.T) {
+	env := setupTestEnv(t)
+
+	rr := doJSON(t, env.srv, http.MethodGet, "/promotions", nil)
+	var listed []promotion.Rule
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil || len(listed) != 3 || listed[0].Definition.Type != promotion.NameFreeItem {
+		t.Fatalf("unexpected promotions: %s", rr.Body.String())
+	}
+
+	tests := []struct {
+		name string
+		body interface{}
+		want int
+	}{
+		{"unknown type", map[string]interface{}{"type": "bogo", "sku": RaspberryPiSku}, http.StatusUnprocessableEntity},
+		{"unknown sku", map[string]interface{}{"type": "qty_free", "sku": "NOPE", "qty": 2}, http.StatusUnprocessableEntity},
+		{"invalid qty", map[string]interface{}{"type": "qty_free", "sku": RaspberryPiSku}, http.StatusUnprocessableEntity},
+		{"unknown field", map[string]interface{}{"type": "qty_free", "sku": RaspberryPiSku, "qty": 2, "limit": 1}, http.StatusUnprocessableEntity},
+		{"created", map[string]interface{}{"type": "qty_free", "sku": RaspberryPiSku, "qty": 2}, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doJSON(t, env.srv, http.MethodPost, "/promotions", tt.body); rr.Code != tt.want {
+				t.Fatalf("expected %d, got %d body=%s", tt.want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	rr = doJSON(t, env.srv, http.MethodGet, "/promotions", nil)
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil || len(listed) != 4 {
+		t.Fatalf("unexpected promotions: %s", rr.Body.String())
+	}
+	created := listed[3]
+	if created.Number != 4 || !created.Active {
+		t.Fatalf("unexpected created promotion: %+v", created)
+	}
+
+	// a promotion created at runtime applies to the next submit: 1 of 2 Raspberry Pis free
+	submitted := submitCartWith(t, env, RaspberryPiSku, 2)
+	if submitted.Total != 3000 {
+		t.Fatalf("expected total 3000, got %d", submitted.Total)
+	}
+
+	// updated, it needs 3 units
+	if rr := doJSON(t, env.srv, http.MethodPut, "/promotions/"+created.ID, map[string]interface{}{"type": "qty_free", "sku": RaspberryPiSku, "qty": 3}); rr.Code != http.StatusOK {
+		t.Fatalf("update failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := doJSON(t, env.srv, http.MethodPut, "/promotions/"+created.ID, map[string]interface{}{"type": "qty_free", "sku": "NOPE", "qty": 3}); rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 updating to an unknown sku, got %d", rr.Code)
+	}
+
+	// disabled, the MacBook no longer comes with a free Raspberry Pi
+	if rr := doJSON(t, env.srv, http.MethodPost, "/promotions/"+listed[0].ID+"/disable", nil); rr.Code != http.StatusOK {
+		t.Fatalf("disable failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doJSON(t, env.srv, http.MethodGet, "/promotions/"+listed[0].ID, nil)
+	var disabled promotion.Rule
+	if err := json.Unmarshal(rr.Body.Bytes(), &disabled); err != nil || disabled.Active {
+		t.Fatalf("unexpected disabled promotion: %s", rr.Body.String())
+	}
+	submitted = submitCartWith(t, env, ItemMacBookProSku, 1)
+	if submitted.Total != 539999 || len(submitted.Purchases) != 1 {
+		t.Fatalf("expected the MacBook alone for 539999, got %d with %d lines", submitted.Total, len(submitted.Purchases))
+	}
+
+	for path, want := range map[string]int{
+		"/promotions/00000000-0000-0000-0000-000000000000": http.StatusNotFound,
+		"/promotions/not-a-uuid":                           http.StatusUnprocessableEntity,
+	} {
+		if rr := doJSON(t, env.srv, http.MethodGet, path, nil); rr.Code != want {
+			t.Fatalf("GET %s: expected %d, got %d", path, want, rr.Code)
+		}
+	}
+	if rr := doJSON(t, env.srv, http.MethodPost, "/promotions/00000000-0000-0000-0000-000000000000/disable", nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 disabling an unknown promotion, got %d", rr.Code)
+	}
+}
+
+// submitCartWith submits a new cart purchasing qty units of an item.
+func submitCartWith(t *testing.T, env testEnv, sku string, qty int) cart.Cart {
+	t.Helper()
+	cid := createCart(t, env.srv)
+	if rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": sku, "qty": qty}); rr.Code != http.StatusOK {
+		t.Fatalf("purchase failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/submitted", nil)
+	var submitted cart.Cart
+	if err := json.Unmarshal(rr.Body.Bytes(), &submitted); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("submit failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	return submitted
+}
+
+func TestSubmit_PromotionSchedule(t *testing.T) {
+	// a Saturday
+	now := time.Date(2024, 11, 30, 10, 0, 0, 0, time.UTC)
+	env := setupTestEnvWithDB(t, memdb.NewMemoryKVDatabase(), WithClock(func() time.Time { return now }))
+	storePromotions(t, env.promotionRepo,
+		promotion.Definition{Type: promotion.NameQtyFree, Sku: RaspberryPiSku, Qty: 2, Schedule: &promotion.Schedule{Days: []string{"sat", "sun"}}},
+		promotion.Definition{Type: promotion.NameQtyFree, Sku: RaspberryPiSku, Qty: 1, ValidUntil: &now},
+	)
+	rules := listPromotionRules(t, env)
+	weekend, ended := rules[3].ID, rules[4].ID
+
+	submitted := submitCartWith(t, env, RaspberryPiSku, 2)
+	if submitted.Total != 3000 {
+		t.Fatalf("expected the weekend deal to make one Raspberry Pi free, got total %d", submitted.Total)
+	}
+
+	want := []cart.PromotionCheck{
+		{PromotionID: rules[0].ID, Promotion: promotion.NameFreeItem},
+		{PromotionID: rules[1].ID, Promotion: promotion.NameQtyFree},
+		{PromotionID: rules[2].ID, Promotion: promotion.NameQtyPercentage},
+		{PromotionID: weekend, Promotion: promotion.NameQtyFree, Applied: true},
+		{PromotionID: ended, Promotion: promotion.NameQtyFree, Skipped: promotion.SkipEnded},
+	}
+	if !reflect.DeepEqual(submitted.Promotions, want) {
+		t.Fatalf("Promotions = %+v, want %+v", submitted.Promotions, want)
+	}
+
+	// on Monday the weekend deal is off
+	now = now.Add(48 * time.Hour)
+	if rr := doJSON(t, env.srv, http.MethodPost, "/items", map[string]interface{}{"sku": "PI4", "name": "Raspberry Pi 4", "price": 5000, "qty": 2}); rr.Code != http.StatusCreated {
+		t.Fatalf("create item failed: %d", rr.Code)
+	}
+	if rr := doJSON(t, env.srv, http.MethodPut, "/promotions/"+weekend, map[string]interface{}{"type": "qty_free", "sku": "PI4", "qty": 2, "schedule": map[string]interface{}{"days": []string{"sat", "sun"}}}); rr.Code != http.StatusOK {
+		t.Fatalf("update failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	submitted = submitCartWith(t, env, "PI4", 2)
+	if submitted.Total != 10000 || submitted.Promotions[3].Skipped != promotion.SkipOffSchedule {
+		t.Fatalf("expected no weekend deal on Monday, got total %d, %+v", submitted.Total, submitted.Promotions[3])
+	}
+}
+
+func listPromotionRules(t *testing.T, env testEnv) []promotion.Rule {
+	t.Helper()
+	rr := doJSON(t, env.srv, http.MethodGet, "/promotions", nil)
+	var rules []promotion.Rule
+	if err := json.Unmarshal(rr.Body.Bytes(), &rules); err != nil {
+		t.Fatalf("list promotions: %v body=%s", err, rr.Body.String())
+	}
+	return rules
+}
+
+func TestSubmit_PromotionPriority(t *testing.T) {
+	env := setupTestEnv(t)
+	storePromotions(t, env.promotionRepo,
+		promotion.Definition{Type: promotion.NameQtyPercentage, Sku: ItemGoogleHomeSku, Percentage: 0.9, Group: "google"},
+		promotion.Definition{Type: promotion.NameQtyPercentage, Sku: ItemGoogleHomeSku, Percentage: 0.5, Group: "google", Priority: 10, Stop: true},
+	)
+	rules := listPromotionRules(t, env)
+	deepest, preferred := rules[3].ID, rules[4].ID
+
+	// half price for the 3 Google Homes, the 3 for 2 deal and the deeper discount of the group left out
+	submitted := submitCartWith(t, env, ItemGoogleHomeSku, 3)
+	if submitted.Discount != 7450 || submitted.Total != 14997-7450 {
+		t.Fatalf("expected a discount of 7450, got %d for a total of %d", submitted.Discount, submitted.Total)
+	}
+
+	want := []cart.PromotionCheck{
+		{PromotionID: preferred, Promotion: promotion.NameQtyPercentage, Applied: true},
+		{PromotionID: rules[0].ID, Promotion: promotion.NameFreeItem},
+		{PromotionID: rules[1].ID, Promotion: promotion.NameQtyFree, Skipped: pricing.SkipStopped},
+		{PromotionID: rules[2].ID, Promotion: promotion.NameQtyPercentage},
+		{PromotionID: deepest, Promotion: promotion.NameQtyPercentage, Skipped: pricing.SkipExcluded},
+	}
+	if !reflect.DeepEqual(submitted.Promotions, want) {
+		t.Fatalf("Promotions = %+v, want %+v", submitted.Promotions, want)
+	}
+}
+
+func TestSubmit_BestDeal(t *testing.T) {
+	defs := []promotion.Definition{
+		{Type: promotion.NameQtyPercentage, Sku: ItemAlexaSpeakerSku, Percentage: 0.2, Group: "alexa", Priority: 5},
+		{Type: promotion.NameQtyFree, Sku: ItemAlexaSpeakerSku, Qty: 3, Group: "alexa"},
+	}
+
+	tests := []struct {
+		name      string
+		opts      []Option
+		wantTotal int64
+		wantCheck cart.PromotionCheck
+	}{
+		// 20% off the 6 speakers, then 10% off with the default promotion
+		{"by priority", nil, 65700 - 13140 - 6570, cart.PromotionCheck{Promotion: promotion.NameQtyPercentage, Applied: true}},
+		// 2 of the 6 speakers free, then 10% off
+		{"best deal", []Option{WithBestDeal(0)}, 65700 - 21900 - 6570, cart.PromotionCheck{Promotion: promotion.NameQtyPercentage, Skipped: pricing.SkipOutdone}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := setupTestEnvWithDB(t, memdb.NewMemoryKVDatabase(), tt.opts...)
+			storePromotions(t, env.promotionRepo, defs...)
+			rules := listPromotionRules(t, env)
+
+			submitted := submitCartWith(t, env, ItemAlexaSpeakerSku, 6)
+			if submitted.Total != tt.wantTotal {
+				t.Fatalf("expected total %d, got %d", tt.wantTotal, submitted.Total)
+			}
+
+			tt.wantCheck.PromotionID = rules[3].ID
+			if submitted.Promotions[0] != tt.wantCheck {
+				t.Fatalf("Promotions[0] = %+v, want %+v", submitted.Promotions[0], tt.wantCheck)
+			}
+		})
+	}
+}
+
+func TestSubmit_SpendPromotions(t *testing.T) {
+	env := setupTestEnv(t)
+
+	// spend promotions need no sku
+	if rr := doJSON(t, env.srv, http.MethodPost, "/promotions", map[string]interface{}{"type": "spend_percentage", "min_spend": 15000, "percentage": 0.1}); rr.Code != http.StatusCreated {
+		t.Fatalf("create failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	storePromotions(t, env.promotionRepo, promotion.Definition{Type: promotion.NameSpendAmountOff, MinSpend: 20000, Amount: 500})
+
+	// 2 speakers spend 21900: 10% off the order, then 500 off
+	submitted := submitCartWith(t, env, ItemAlexaSpeakerSku, 2)
+	if submitted.Discount != 0 || submitted.OrderDiscount != 2190+500 || submitted.Total != 21900-2690 {
+		t.Fatalf("expected an order discount of 2690, got discount %d, order discount %d, total %d", submitted.Discount, submitted.OrderDiscount, submitted.Total)
+	}
+
+	o := getOrderOf(t, env, submitted.OrderID)
+	want := []order.AppliedPromotion{{Promotion: promotion.NameSpendPercentage, Discount: 2190}, {Promotion: promotion.NameSpendAmountOff, Discount: 500}}
+	if !reflect.DeepEqual(o.Promotions, want) || o.Totals.OrderDiscount != 2690 || o.Lines[0].OrderDiscount != 2690 || o.Totals.Total != submitted.Total {
+		t.Fatalf("unexpected order: promotions %+v, totals %+v, lines %+v", o.Promotions, o.Totals, o.Lines)
+	}
+
+	// a single speaker does not spend enough
+	submitted = submitCartWith(t, env, ItemAlexaSpeakerSku, 1)
+	if submitted.OrderDiscount != 0 || submitted.Total != 10950 {
+		t.Fatalf("expected no order discount, got %d for a total of %d", submitted.OrderDiscount, submitted.Total)
+	}
+}
+
+func TestSubmit_BundlePromotions(t *testing.T) {
+	env := setupTestEnv(t)
+
+	// any 3 smart speakers for 25000
+	if rr := doJSON(t, env.srv, http.MethodPost, "/promotions", map[string]interface{}{"type": "mix_match", "category": "smart_speaker", "qty": 3, "price": 25000}); rr.Code != http.StatusCreated {
+		t.Fatalf("create failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := doJSON(t, env.srv, http.MethodPost, "/promotions", map[string]interface{}{"type": "bundle", "skus": []string{ItemGoogleHomeSku, "NOPE"}, "price": 1000}); rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected a bundle of an unknown item to be rejected, got %d", rr.Code)
+	}
+
+	cid := createCart(t, env.srv)
+	for sku, qty := range map[string]int{ItemAlexaSpeakerSku: 2, ItemGoogleHomeSku: 1} {
+		if rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": sku, "qty": qty}); rr.Code != http.StatusOK {
+			t.Fatalf("purchase failed: %d body=%s", rr.Code, rr.Body.String())
+		}
+	}
+	rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/submitted", nil)
+	var submitted cart.Cart
+	if err := json.Unmarshal(rr.Body.Bytes(), &submitted); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("submit failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// 21900 + 4999 sell for 25000: 1899 off, spread in proportion to the value of each line
+	if submitted.Discount != 1899 || submitted.Total != 25000 {
+		t.Fatalf("expected the 3 speakers to sell for 25000, got discount %d, total %d", submitted.Discount, submitted.Total)
+	}
+
+	o := getOrderOf(t, env, submitted.OrderID)
+	want := map[item.Sku]int64{ItemAlexaSpeakerSku: 1546, ItemGoogleHomeSku: 353}
+	for _, l := range o.Lines {
+		if len(l.Promotions) != 1 || l.Promotions[0].Promotion != promotion.NameMixMatch || l.Discount != want[l.Sku] || l.Promotions[0].Discount != want[l.Sku] {
+			t.Fatalf("unexpected line %+v", l)
+		}
+	}
+}
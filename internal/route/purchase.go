@@ -18,7 +18,7 @@ type (
 	}
 )
 
-func purchase(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo.IItemRepository) http.HandlerFunc {
+func purchase(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo.IItemRepository, cfg config) http.HandlerFunc {
 
 	return func(response http.ResponseWriter, request *http.Request) {
 
@@ -54,6 +54,14 @@ func purchase(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo
 
 		err = cartRepo.WithTx(func(tx utils.Tx) error {
 
+			c, err := cartRepo.FindCartByIDTx(tx, cartID)
+
+			if err != nil {
+				return err
+			}
+
+			currcart = c
+
 			item, err := itemRepo.FindItemBySku(tx, item.Sku(rPayload.Sku))
 
 			if err != nil {
@@ -66,12 +74,19 @@ func purchase(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo
 				return err
 			}
 
-			err = currcart.PurchaseItem(item, rPayload.Qty)
+			err = currcart.PurchaseItem(item, rPayload.Qty, cfg.tiers.For(item)...)
 
 			if err != nil {
 				return err
 			}
 
+			// Purchasing renews the reservation of the whole line
+			if cfg.holdDuration > 0 {
+				if err := currcart.HoldPurchase(item.Sku, cfg.now().Add(cfg.holdDuration).UTC()); err != nil {
+					return err
+				}
+			}
+
 			if err := cartRepo.Store(tx, currcart); err != nil {
 				return err
 			}
@@ -84,6 +99,9 @@ func purchase(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo
 		})
 
 		switch {
+		case err == repo.ErrCartNotFound:
+			srv.ResponseErrorNotfound(response, err)
+			return
 		case err == repo.ErrItemNotFound:
 			srv.ResponseErrorEntityUnproc(response, err)
 			return
@@ -93,6 +111,9 @@ func purchase(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo
 		case err == cart.ErrItemQtyAddedInvalid:
 			srv.ResponseErrorEntityUnproc(response, err)
 			return
+		case err == cart.ErrCartNotAvailable:
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
 		case err != nil:
 			srv.ResponseErrorServerErr(response, fmt.Errorf("error storing Cart: %w", err))
 			return
@@ -0,0 +1,280 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/order"
+	"github.com/gambarini/flip-shop/internal/model/promotion"
+	"github.com/gambarini/flip-shop/internal/pricing"
+	"github.com/gambarini/flip-shop/internal/repo"
+	"github.com/gambarini/flip-shop/utils"
+)
+
+type (
+	// Quote previews what submitting a cart now would charge, without submitting it. Lines are the purchases
+	// of the cart, by SKU, with the units promotions would add and their discounts; amounts are in cents and
+	// mean what they mean on a submitted cart. Promotions lists every promotion considered, in the order
+	// they were, and Hints how far the cart is from those in effect that would not apply.
+	Quote struct {
+		CartID        string
+		Lines         []QuoteLine
+		Subtotal      int64
+		Discount      int64
+		OrderDiscount int64
+		Tax           int64
+		TaxInclusive  bool
+		ShippingCost  int64
+		FreeShipping  bool
+		Total         int64
+		Promotions    []QuotePromotion
+		Hints         []QuoteHint
+	}
+
+	// QuoteLine is a purchase as it would be submitted. FreeQty of its Qty units would be added by promotions.
+	QuoteLine struct {
+		Sku           item.Sku
+		Name          string
+		Price         int64
+		Qty           int
+		FreeQty       int
+		Discount      int64
+		OrderDiscount int64
+		Tax           int64
+		Promotions    []order.AppliedPromotion
+	}
+
+	// QuotePromotion is the check of a promotion considered for the cart and what it offers, in words.
+	QuotePromotion struct {
+		cart.PromotionCheck
+		Explanation string
+	}
+
+	// QuoteHint tells how far the cart is from a promotion, in words.
+	QuoteHint struct {
+		pricing.Hint
+		Message string
+	}
+)
+
+// quoteCart handles GET /cart/{cartID}/quote. It checks the coupons of the cart, resolves its promotions
+// and computes its totals as submit would, on the copy of the cart read in a view: the writes are discarded,
+// so the cart, the stock of the free items and the coupon redemptions are left as they were, and no
+// transaction is held up. What would fail submit fails the quote.
+func quoteCart(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo.IItemRepository, promotionRepo repo.IPromotionRepository, cfg config) http.HandlerFunc {
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		cartID := srv.Vars(request)["cartID"]
+
+		var quote Quote
+
+		err := cartRepo.View(func(tx utils.Tx) error {
+
+			c, err := cartRepo.FindCartByIDTx(tx, cartID)
+
+			if err != nil {
+				return err
+			}
+
+			rules, err := promotionRepo.ListPromotions(tx)
+
+			if err != nil {
+				return err
+			}
+
+			toApply, err := promotionsInEffect(rules, cfg.now().UTC())
+
+			if err != nil {
+				return err
+			}
+
+			redeemed, err := couponCandidates(tx, cfg, c, cfg.now().UTC())
+
+			if err != nil {
+				return err
+			}
+
+			toApply = append(toApply, redeemed...)
+
+			if cfg.bestDeal {
+				find := func(sku item.Sku) (item.Item, error) { return itemRepo.FindItemBySku(tx, sku) }
+
+				if toApply, err = pricing.BestDeal(c, toApply, find, cfg.searchLimit); err != nil {
+					return err
+				}
+			}
+
+			applied, err := pricing.Apply(&c, toApply, AddPurchaseToCartForPromotion(tx, itemRepo, c))
+
+			if err != nil {
+				return err
+			}
+
+			hints := pricing.Hints(c, toApply, applied.Checks)
+
+			if err := c.SubmitCart(cfg.now().UTC(), cfg.taxes); err != nil {
+				return err
+			}
+
+			names, err := quoteNames(tx, itemRepo, c, toApply)
+
+			if err != nil {
+				return err
+			}
+
+			quote = newQuote(c, toApply, applied, hints, func(sku item.Sku) string {
+				if n, ok := names[sku]; ok && n != "" {
+					return n
+				}
+				return string(sku)
+			})
+
+			return nil
+		})
+
+		switch {
+		case errors.Is(err, repo.ErrCartNotFound):
+			srv.ResponseErrorNotfound(response, err)
+			return
+		case errors.Is(err, repo.ErrItemNotFound):
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
+		case errors.Is(err, item.ErrItemNotAvailableReservation):
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
+		case errors.Is(err, cart.ErrCartNotAvailable):
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
+		case errors.Is(err, cart.ErrInvalidTransition):
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
+		case isCouponError(err):
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
+		case err != nil:
+			srv.ResponseErrorServerErr(response, fmt.Errorf("error quoting cart: %w", err))
+			return
+		}
+
+		srv.RespondJSON(response, http.StatusOK, quote)
+	}
+}
+
+// quoteNames returns the names of the items a quote refers to, by SKU: those of the cart lines
+// and those the candidates' promotions name. Only the items missing from the cart are looked up;
+// an item that no longer exists is left unnamed.
+func quoteNames(tx utils.Tx, itemRepo repo.IItemRepository, c cart.Cart, candidates []pricing.Candidate) (map[item.Sku]string, error) {
+
+	names := make(map[item.Sku]string, len(c.Purchases))
+
+	for sku, pu := range c.Purchases {
+		names[sku] = pu.Name
+	}
+
+	for _, cd := range candidates {
+		for _, sku := range promotion.Skus(cd.Promotion) {
+
+			if _, ok := names[sku]; ok {
+				continue
+			}
+
+			i, err := itemRepo.FindItemBySku(tx, sku)
+
+			switch {
+			case errors.Is(err, repo.ErrItemNotFound):
+				names[sku] = ""
+			case err != nil:
+				return nil, err
+			default:
+				names[sku] = i.Name
+			}
+		}
+	}
+
+	return names, nil
+}
+
+// newQuote builds the quote of a cart as the promotions and SubmitCart left it.
+func newQuote(c cart.Cart, candidates []pricing.Candidate, applied pricing.Result, hints []pricing.Hint, name promotion.NameOf) Quote {
+
+	q := Quote{
+		CartID:        c.CartID,
+		Lines:         make([]QuoteLine, 0, len(c.Purchases)),
+		Subtotal:      c.Subtotal,
+		Discount:      c.Discount,
+		OrderDiscount: c.OrderDiscount,
+		Tax:           c.Tax,
+		TaxInclusive:  c.TaxInclusive,
+		ShippingCost:  c.ShippingCost,
+		FreeShipping:  c.FreeShipping,
+		Total:         c.Total,
+		Promotions:    make([]QuotePromotion, 0, len(applied.Checks)),
+		Hints:         make([]QuoteHint, 0, len(hints)),
+	}
+
+	for _, pu := range c.Purchases {
+		l := QuoteLine{
+			Sku:           pu.Sku,
+			Name:          pu.Name,
+			Price:         pu.Price,
+			Qty:           pu.Qty,
+			Discount:      pu.Discount,
+			OrderDiscount: pu.OrderDiscount,
+			Tax:           pu.Tax,
+			Promotions:    applied.Applied[pu.Sku],
+		}
+		for _, ap := range l.Promotions {
+			l.FreeQty += ap.FreeQty
+		}
+		q.Lines = append(q.Lines, l)
+	}
+	sort.Slice(q.Lines, func(i, j int) bool { return q.Lines[i].Sku < q.Lines[j].Sku })
+
+	for _, check := range applied.Checks {
+		q.Promotions = append(q.Promotions, QuotePromotion{PromotionCheck: check, Explanation: explain(check, candidates, name)})
+	}
+
+	for _, h := range hints {
+		var p promotion.Promotion
+		for _, cd := range candidates {
+			if cd.RuleID == h.PromotionID && cd.Coupon == h.Coupon {
+				p = cd.Promotion
+				break
+			}
+		}
+		q.Hints = append(q.Hints, QuoteHint{Hint: h, Message: h.Shortfall.Describe(promotion.Describe(p, name), name)})
+	}
+
+	return q
+}
+
+// explain writes what the promotion of a check offers and, when it did not apply, why.
+func explain(check cart.PromotionCheck, candidates []pricing.Candidate, name promotion.NameOf) string {
+
+	text := check.Promotion
+
+	for _, cd := range candidates {
+		if cd.RuleID == check.PromotionID && cd.Coupon == check.Coupon {
+			text = promotion.Describe(cd.Promotion, name)
+			break
+		}
+	}
+
+	if check.Coupon != "" {
+		text = fmt.Sprintf("coupon %s: %s", check.Coupon, text)
+	}
+
+	switch {
+	case check.Applied:
+		return text
+	case check.Skipped != "":
+		return fmt.Sprintf("%s (not applied: %s)", text, check.Skipped)
+	default:
+		return fmt.Sprintf("%s (not applied: the cart does not qualify)", text)
+	}
+}
@@ -0,0 +1,163 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/coupon"
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/promotion"
+	"github.com/gambarini/flip-shop/internal/repo"
+	"github.com/gambarini/flip-shop/utils"
+	"github.com/gambarini/flip-shop/utils/memdb"
+)
+
+func TestQuoteCart(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	kv := memdb.NewMemoryKVDatabase()
+	redemptions := repo.NewCouponRepository(kv)
+	catalog := coupon.NewCatalog(
+		coupon.Coupon{Code: "BIG", Promotion: promotion.SpendAmountOffPromotion{MinSpend: 600000, Amount: 5000}},
+	)
+	env := setupTestEnvWithDB(t, kv, WithClock(func() time.Time { return now }), WithCoupons(catalog, redemptions))
+
+	cid := createCart(t, env.srv)
+	for sku, qty := range map[string]int{ItemMacBookProSku: 1, ItemGoogleHomeSku: 2} {
+		if rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": sku, "qty": qty}); rr.Code != http.StatusOK {
+			t.Fatalf("purchase failed: %d body=%s", rr.Code, rr.Body.String())
+		}
+	}
+	if rr := doJSON(t, env.srv, http.MethodPost, "/cart/"+cid+"/coupons", map[string]string{"code": "BIG"}); rr.Code != http.StatusOK {
+		t.Fatalf("attach failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr := doJSON(t, env.srv, http.MethodGet, "/cart/"+cid+"/quote", nil)
+	var quote Quote
+	if err := json.Unmarshal(rr.Body.Bytes(), &quote); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("quote failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// the MacBook adds a free Raspberry Pi
+	if len(quote.Lines) != 3 {
+		t.Fatalf("expected 3 lines, got %+v", quote.Lines)
+	}
+	if pi := quote.Lines[1]; pi.Sku != RaspberryPiSku || pi.Qty != 1 || pi.FreeQty != 1 || pi.Discount != 3000 || len(pi.Promotions) != 1 {
+		t.Fatalf("unexpected free item line %+v", pi)
+	}
+	if quote.Subtotal != 539999+2*4999+3000 || quote.Discount != 3000 || quote.Total != 539999+2*4999 {
+		t.Fatalf("unexpected totals: subtotal %d, discount %d, total %d", quote.Subtotal, quote.Discount, quote.Total)
+	}
+
+	explanations := map[string]string{}
+	for _, p := range quote.Promotions {
+		explanations[p.Promotion] = p.Explanation
+	}
+	wantExplanations := map[string]string{
+		promotion.NameFreeItem:       "every MacBook Pro bought adds a Raspberry Pi B, 30.00 off",
+		promotion.NameQtyFree:        "every 3 Google Home bought, one is free (not applied: the cart does not qualify)",
+		promotion.NameQtyPercentage:  "buying more than 3 Alexa Speaker takes 10% off them (not applied: the cart does not qualify)",
+		promotion.NameSpendAmountOff: "coupon BIG: spending at least 6000.00 takes 50.00 off the order (not applied: the cart does not qualify)",
+	}
+	for name, want := range wantExplanations {
+		if got := explanations[name]; got != want {
+			t.Errorf("explanation of %s = %q, want %q", name, got, want)
+		}
+	}
+
+	wantHints := []string{
+		"You are 1 item away from: every 3 Google Home bought, one is free (add Google Home)",
+		"You are 500.03 away from: spending at least 6000.00 takes 50.00 off the order",
+	}
+	if len(quote.Hints) != len(wantHints) {
+		t.Fatalf("expected %d hints, got %+v", len(wantHints), quote.Hints)
+	}
+	for i, want := range wantHints {
+		if got := quote.Hints[i].Message; got != want {
+			t.Errorf("hint %d = %q, want %q", i, got, want)
+		}
+	}
+	if h := quote.Hints[0]; h.Qty != 1 || len(h.Skus) != 1 || h.Skus[0] != ItemGoogleHomeSku {
+		t.Errorf("unexpected hint %+v", h)
+	}
+
+	// the quote left the cart, the stock and the coupon redemptions as they were
+	rr = doJSON(t, env.srv, http.MethodGet, "/cart/"+cid, nil)
+	var current cart.Cart
+	if err := json.Unmarshal(rr.Body.Bytes(), &current); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("get cart failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if _, ok := current.Purchases[RaspberryPiSku]; ok || current.CartStatus != cart.CartStatusAvailable {
+		t.Fatalf("expected the cart unchanged, got status %s, purchases %+v", current.CartStatus, current.Purchases)
+	}
+	rr = doJSON(t, env.srv, http.MethodGet, "/items/"+RaspberryPiSku, nil)
+	var pi item.Item
+	if err := json.Unmarshal(rr.Body.Bytes(), &pi); err != nil || pi.QtyAvailable != 2 {
+		t.Fatalf("expected the stock of the free item unchanged, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if n := redeemedCount(t, redemptions, "BIG"); n != 0 {
+		t.Fatalf("redemptions = %d, want 0", n)
+	}
+
+	// submitting charges what was quoted
+	rr = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/submitted", nil)
+	var submitted cart.Cart
+	if err := json.Unmarshal(rr.Body.Bytes(), &submitted); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("submit failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if submitted.Total != quote.Total || submitted.Discount != quote.Discount {
+		t.Fatalf("submitted total %d, discount %d, quoted %d, %d", submitted.Total, submitted.Discount, quote.Total, quote.Discount)
+	}
+
+	tests := []struct {
+		name   string
+		cartID string
+		want   int
+	}{
+		{"cart not found", "00000000-0000-0000-0000-000000000000", http.StatusNotFound},
+		{"submitted cart", cid, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doJSON(t, env.srv, http.MethodGet, "/cart/"+tt.cartID+"/quote", nil); rr.Code != tt.want {
+				t.Fatalf("expected %d, got %d body=%s", tt.want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestQuoteCart_DoesNotWaitForTransactions(t *testing.T) {
+	kv := memdb.NewMemoryKVDatabase()
+	env := setupTestEnvWithDB(t, kv)
+
+	cid := createCart(t, env.srv)
+	if rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemMacBookProSku, "qty": 1}); rr.Code != http.StatusOK {
+		t.Fatalf("purchase failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// a transaction holds the write lock while the cart is quoted
+	locked, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		_ = kv.WithTx(func(utils.Tx) error {
+			close(locked)
+			<-release
+			return nil
+		})
+	}()
+	<-locked
+	defer close(release)
+
+	done := make(chan int)
+	go func() { done <- doJSON(t, env.srv, http.MethodGet, "/cart/"+cid+"/quote", nil).Code }()
+
+	select {
+	case code := <-done:
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("quote waited for the write lock")
+	}
+}
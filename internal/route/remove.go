@@ -18,7 +18,7 @@ type (
 	}
 )
 
-func remove(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo.IItemRepository) http.HandlerFunc {
+func remove(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo.IItemRepository, cfg config) http.HandlerFunc {
 
 	return func(response http.ResponseWriter, request *http.Request) {
 
@@ -53,6 +53,14 @@ func remove(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo.I
 
 		err = cartRepo.WithTx(func(tx utils.Tx) error {
 
+			c, err := cartRepo.FindCartByIDTx(tx, cartID)
+
+			if err != nil {
+				return err
+			}
+
+			currCart = c
+
 			item, err := itemRepo.FindItemBySku(tx, item.Sku(rPayload.Sku))
 
 			if err != nil {
@@ -65,7 +73,7 @@ func remove(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo.I
 				return err
 			}
 
-			err = currCart.PurchaseItem(item, -rPayload.Qty)
+			err = currCart.PurchaseItem(item, -rPayload.Qty, cfg.tiers.For(item)...)
 
 			if err != nil {
 				return err
@@ -83,6 +91,9 @@ func remove(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo.I
 		})
 
 		switch {
+		case err == repo.ErrCartNotFound:
+			srv.ResponseErrorNotfound(response, err)
+			return
 		case err == repo.ErrItemNotFound:
 			srv.ResponseErrorEntityUnproc(response, err)
 			return
@@ -92,6 +103,9 @@ func remove(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo.I
 		case err == cart.ErrItemQtyAddedInvalid:
 			srv.ResponseErrorEntityUnproc(response, err)
 			return
+		case err == cart.ErrCartNotAvailable:
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
 		case err != nil:
 			srv.ResponseErrorServerErr(response, fmt.Errorf("error storing Cart: %w", err))
 			return
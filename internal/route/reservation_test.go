@@ -0,0 +1,53 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/reservation"
+	"github.com/gambarini/flip-shop/utils/memdb"
+)
+
+func TestPurchase_HoldDeadlineAndExpiredCart(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	env := setupTestEnvWithDB(t, memdb.NewMemoryKVDatabase(), WithHoldDuration(10*time.Minute), WithClock(clock))
+	cid := createCart(t, env.srv)
+
+	rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemGoogleHomeSku, "qty": 2})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("purchase failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Purchases map[string]struct {
+			ReservedUntil time.Time `json:"ReservedUntil"`
+		} `json:"Purchases"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if got, want := resp.Purchases[ItemGoogleHomeSku].ReservedUntil, now.Add(10*time.Minute); !got.Equal(want) {
+		t.Fatalf("ReservedUntil = %v, want %v", got, want)
+	}
+
+	// the hold lapses and the sweeper releases it
+	now = now.Add(11 * time.Minute)
+	if swept, err := reservation.NewSweeper(env.itemRepo, env.cartRepo, clock).Sweep(); err != nil || swept != 1 {
+		t.Fatalf("Sweep() = %d (err %v), want 1", swept, err)
+	}
+
+	rr = doJSON(t, env.srv, http.MethodGet, "/items/"+ItemGoogleHomeSku, nil)
+	var it struct {
+		QtyReserved int `json:"QtyReserved"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &it); err != nil || it.QtyReserved != 0 {
+		t.Fatalf("QtyReserved = %d (err %v), want 0", it.QtyReserved, err)
+	}
+
+	rr = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemGoogleHomeSku, "qty": 1})
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 purchasing into an expired cart, got %d", rr.Code)
+	}
+}
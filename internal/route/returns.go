@@ -0,0 +1,205 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/order"
+	"github.com/gambarini/flip-shop/internal/payment"
+	"github.com/gambarini/flip-shop/internal/repo"
+	"github.com/gambarini/flip-shop/utils"
+	"github.com/gofrs/uuid"
+)
+
+type (
+	// ReturnPayload represents the request body to return units of an order.
+	// Restock puts the returned units back in stock.
+	ReturnPayload struct {
+		Lines   []ReturnLinePayload `json:"lines"`
+		Restock bool                `json:"restock"`
+	}
+
+	// ReturnLinePayload is a quantity of an order line to return.
+	ReturnLinePayload struct {
+		Sku string `json:"sku"`
+		Qty int    `json:"qty"`
+	}
+)
+
+// createReturn handles POST /orders/{orderID}/returns. In one transaction it records the
+// return and its refund on the order and, when asked, restocks the returned units. The payment
+// is refunded when it was already captured, between transactions, see withGatewayCalls.
+func createReturn(srv *utils.AppServer, itemRepo repo.IItemRepository, orderRepo repo.IOrderRepository, cfg config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID := srv.Vars(r)["orderID"]
+
+		if _, err := uuid.FromString(orderID); err != nil {
+			srv.ResponseErrorEntityUnproc(w, fmt.Errorf("invalid orderID format: %w", err))
+			return
+		}
+
+		var rPayload ReturnPayload
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rPayload); err != nil {
+			srv.ResponseErrorEntityUnproc(w, fmt.Errorf("invalid JSON payload: %w", err))
+			return
+		}
+
+		// the same SKU may be listed more than once; its quantities add up
+		units := make(map[item.Sku]int, len(rPayload.Lines))
+		for _, l := range rPayload.Lines {
+			if l.Qty <= 0 {
+				srv.ResponseErrorEntityUnproc(w, order.ErrInvalidReturnQuantity)
+				return
+			}
+			units[item.Sku(l.Sku)] += l.Qty
+		}
+
+		var created order.Return
+		err := withGatewayCalls(r.Context(), orderRepo, cfg.gateway, orderRepo, func(tx utils.Tx, gateway payment.Gateway) (string, error) {
+			o, err := orderRepo.FindOrderByID(tx, orderID)
+			if err != nil {
+				return "", err
+			}
+
+			ret, err := o.ReturnUnits(units, rPayload.Restock, cfg.now().UTC())
+			if err != nil {
+				return "", err
+			}
+
+			// the reference counts returns rather than using the new return ID so every run of the
+			// change, and a repeated refund, refunds the same return only once
+			previous, err := orderRepo.ListReturns(tx, orderID)
+			if err != nil {
+				return "", err
+			}
+			reference := fmt.Sprintf("%s/returns/%d", orderID, len(previous)+1)
+			if err := refundPayment(r.Context(), gateway, &o, ret.Refund, reference); err != nil {
+				return "", err
+			}
+
+			if ret.Restock {
+				for _, l := range ret.Lines {
+					i, err := itemRepo.FindItemBySku(tx, l.Sku)
+					if err != nil {
+						return "", err
+					}
+					if err := i.RestoreItem(l.Qty); err != nil {
+						return "", err
+					}
+					if err := itemRepo.Store(tx, i); err != nil {
+						return "", err
+					}
+				}
+			}
+
+			if err := orderRepo.StoreReturn(tx, ret); err != nil {
+				return "", err
+			}
+			if err := orderRepo.Store(tx, o); err != nil {
+				return "", err
+			}
+
+			created = ret
+			return o.OrderID, nil
+		})
+
+		if respondPaymentError(srv, w, err) {
+			return
+		}
+
+		switch {
+		case errors.Is(err, repo.ErrOrderNotFound):
+			srv.ResponseErrorNotfound(w, err)
+			return
+		case errors.Is(err, order.ErrOrderNotPlaced),
+			errors.Is(err, order.ErrReturnEmpty),
+			errors.Is(err, order.ErrItemNotInOrder),
+			errors.Is(err, order.ErrInvalidReturnQuantity),
+			errors.Is(err, repo.ErrItemNotFound):
+			srv.ResponseErrorEntityUnproc(w, err)
+			return
+		case err != nil:
+			srv.ResponseErrorServerErr(w, fmt.Errorf("error creating return: %w", err))
+			return
+		}
+
+		srv.RespondJSON(w, http.StatusCreated, created)
+	}
+}
+
+// listReturns handles GET /orders/{orderID}/returns returning the returns of an order, oldest first.
+func listReturns(srv *utils.AppServer, orderRepo repo.IOrderRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID := srv.Vars(r)["orderID"]
+
+		if _, err := uuid.FromString(orderID); err != nil {
+			srv.ResponseErrorEntityUnproc(w, fmt.Errorf("invalid orderID format: %w", err))
+			return
+		}
+
+		var returns []order.Return
+		err := orderRepo.WithTx(func(tx utils.Tx) error {
+			if _, err := orderRepo.FindOrderByID(tx, orderID); err != nil {
+				return err
+			}
+			found, err := orderRepo.ListReturns(tx, orderID)
+			if err != nil {
+				return err
+			}
+			returns = found
+			return nil
+		})
+
+		switch {
+		case errors.Is(err, repo.ErrOrderNotFound):
+			srv.ResponseErrorNotfound(w, err)
+			return
+		case err != nil:
+			srv.ResponseErrorServerErr(w, fmt.Errorf("error listing returns: %w", err))
+			return
+		}
+
+		srv.RespondJSON(w, http.StatusOK, returns)
+	}
+}
+
+// getReturn handles GET /orders/{orderID}/returns/{returnID} returning a single return of an order.
+func getReturn(srv *utils.AppServer, orderRepo repo.IOrderRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID, returnID := srv.Vars(r)["orderID"], srv.Vars(r)["returnID"]
+
+		if _, err := uuid.FromString(returnID); err != nil {
+			srv.ResponseErrorEntityUnproc(w, fmt.Errorf("invalid returnID format: %w", err))
+			return
+		}
+
+		var found order.Return
+		err := orderRepo.WithTx(func(tx utils.Tx) error {
+			ret, err := orderRepo.FindReturnByID(tx, returnID)
+			if err != nil {
+				return err
+			}
+			if ret.OrderID != orderID {
+				return repo.ErrReturnNotFound
+			}
+			found = ret
+			return nil
+		})
+
+		switch {
+		case errors.Is(err, repo.ErrReturnNotFound):
+			srv.ResponseErrorNotfound(w, err)
+			return
+		case err != nil:
+			srv.ResponseErrorServerErr(w, fmt.Errorf("error fetching return: %w", err))
+			return
+		}
+
+		srv.RespondJSON(w, http.StatusOK, found)
+	}
+}
@@ -0,0 +1,218 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/order"
+	"github.com/gambarini/flip-shop/internal/model/tier"
+	"github.com/gambarini/flip-shop/utils/memdb"
+)
+
+// placeOrder submits a cart with the given purchases and returns its order ID.
+func placeOrder(t *testing.T, env testEnv, purchases map[string]int) string {
+	t.Helper()
+	cid := createCart(t, env.srv)
+	for sku, qty := range purchases {
+		if rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": sku, "qty": qty}); rr.Code != http.StatusOK {
+			t.Fatalf("purchase %s failed: %d body=%s", sku, rr.Code, rr.Body.String())
+		}
+	}
+	rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/submitted", nil)
+	var submitted cart.Cart
+	if err := json.Unmarshal(rr.Body.Bytes(), &submitted); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("submit failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	return submitted.OrderID
+}
+
+func TestReturns_RefundClawBackAndRestock(t *testing.T) {
+	env := setupTestEnv(t)
+	oid := placeOrder(t, env, map[string]int{ItemGoogleHomeSku: 3, ItemAlexaSpeakerSku: 1})
+
+	// the 2 Google Homes kept no longer earn the free one: nothing to refund
+	rr := doJSON(t, env.srv, http.MethodPost, "/orders/"+oid+"/returns", map[string]interface{}{
+		"lines":   []map[string]interface{}{{"sku": ItemGoogleHomeSku, "qty": 1}},
+		"restock": true,
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create return failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	var first order.Return
+	if err := json.Unmarshal(rr.Body.Bytes(), &first); err != nil {
+		t.Fatalf("invalid return json: %v", err)
+	}
+	if first.Refund != 0 || len(first.Lines) != 1 || first.Lines[0].ClawedBack != 3332 || !first.Restock {
+		t.Fatalf("unexpected return: %+v", first)
+	}
+	if got := stockOf(t, env, ItemGoogleHomeSku); got.QtyAvailable != 8 {
+		t.Fatalf("Google Home QtyAvailable = %d, want 8", got.QtyAvailable)
+	}
+
+	// a return without restock leaves the stock as is; duplicated lines add up
+	rr = doJSON(t, env.srv, http.MethodPost, "/orders/"+oid+"/returns", map[string]interface{}{
+		"lines": []map[string]interface{}{{"sku": ItemGoogleHomeSku, "qty": 1}, {"sku": ItemAlexaSpeakerSku, "qty": 1}, {"sku": ItemGoogleHomeSku, "qty": 1}},
+	})
+	var second order.Return
+	if err := json.Unmarshal(rr.Body.Bytes(), &second); err != nil || rr.Code != http.StatusCreated {
+		t.Fatalf("second return failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if second.Refund != 9998+10950 {
+		t.Fatalf("second refund = %d, want %d", second.Refund, 9998+10950)
+	}
+	if got := stockOf(t, env, ItemGoogleHomeSku); got.QtyAvailable != 8 {
+		t.Fatalf("Google Home QtyAvailable = %d, want 8", got.QtyAvailable)
+	}
+
+	rr = doJSON(t, env.srv, http.MethodGet, "/orders/"+oid, nil)
+	var o order.Order
+	if err := json.Unmarshal(rr.Body.Bytes(), &o); err != nil {
+		t.Fatalf("invalid order json: %v", err)
+	}
+	if o.Status != order.StatusReturned || o.Totals.Refunded != o.Totals.Total {
+		t.Fatalf("order after returns: status %v, refunded %d of %d", o.Status, o.Totals.Refunded, o.Totals.Total)
+	}
+
+	rr = doJSON(t, env.srv, http.MethodGet, "/orders/"+oid+"/returns", nil)
+	var list []order.Return
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || len(list) != 2 || list[0].ReturnID != first.ReturnID {
+		t.Fatalf("unexpected returns list: %s (err %v)", rr.Body.String(), err)
+	}
+
+	rr = doJSON(t, env.srv, http.MethodGet, "/orders/"+oid+"/returns/"+second.ReturnID, nil)
+	var got order.Return
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil || got.Refund != second.Refund {
+		t.Fatalf("unexpected return: %s (err %v)", rr.Body.String(), err)
+	}
+
+	// the order has nothing left to return
+	rr = doJSON(t, env.srv, http.MethodPost, "/orders/"+oid+"/returns", map[string]interface{}{
+		"lines": []map[string]interface{}{{"sku": ItemGoogleHomeSku, "qty": 1}},
+	})
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 returning from a returned order, got %d", rr.Code)
+	}
+}
+
+func TestReturns_Bundles(t *testing.T) {
+	env := setupTestEnv(t)
+
+	// any 3 smart speakers for 25000
+	if rr := doJSON(t, env.srv, http.MethodPost, "/promotions", map[string]interface{}{"type": "mix_match", "category": "smart_speaker", "qty": 3, "price": 25000}); rr.Code != http.StatusCreated {
+		t.Fatalf("create failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	returnUnits := func(oid string, lines ...map[string]interface{}) order.Return {
+		t.Helper()
+		rr := doJSON(t, env.srv, http.MethodPost, "/orders/"+oid+"/returns", map[string]interface{}{"lines": lines, "restock": true})
+		var r order.Return
+		if err := json.Unmarshal(rr.Body.Bytes(), &r); err != nil || rr.Code != http.StatusCreated {
+			t.Fatalf("return failed: %d body=%s", rr.Code, rr.Body.String())
+		}
+		return r
+	}
+
+	// the speaker kept no longer makes a bundle: it costs its price
+	oid := placeOrder(t, env, map[string]int{ItemAlexaSpeakerSku: 3})
+	r := returnUnits(oid, map[string]interface{}{"sku": ItemAlexaSpeakerSku, "qty": 2})
+	if r.Refund != 25000-10950 || r.Lines[0].ClawedBack != 2616 {
+		t.Fatalf("unexpected return: %+v", r)
+	}
+
+	// returning the Google Home breaks the bundle of the speakers kept: their discount comes off its refund
+	oid = placeOrder(t, env, map[string]int{ItemAlexaSpeakerSku: 2, ItemGoogleHomeSku: 1})
+	r = returnUnits(oid, map[string]interface{}{"sku": ItemGoogleHomeSku, "qty": 1})
+	if r.Refund != 25000-2*10950 {
+		t.Fatalf("refund = %d, want %d", r.Refund, 25000-2*10950)
+	}
+	r = returnUnits(oid, map[string]interface{}{"sku": ItemAlexaSpeakerSku, "qty": 2})
+	if r.Refund != 2*10950 {
+		t.Fatalf("refund = %d, want %d", r.Refund, 2*10950)
+	}
+
+	o := getOrderOf(t, env, oid)
+	if o.Status != order.StatusReturned || o.Totals.Refunded != o.Totals.Total {
+		t.Fatalf("order after returns: status %v, refunded %d of %d", o.Status, o.Totals.Refunded, o.Totals.Total)
+	}
+}
+
+func TestReturns_PriceBreaks(t *testing.T) {
+	env := setupTestEnvWithDB(t, memdb.NewMemoryKVDatabase(), WithPriceTiers(tier.Table{
+		Items: map[item.Sku]tier.Breaks{ItemAlexaSpeakerSku: {{MinQty: 3, Percentage: 0.1}}},
+	}))
+
+	// 3 speakers at 9855: the 2 kept sell at their list price
+	oid := placeOrder(t, env, map[string]int{ItemAlexaSpeakerSku: 3})
+	rr := doJSON(t, env.srv, http.MethodPost, "/orders/"+oid+"/returns", map[string]interface{}{
+		"lines": []map[string]interface{}{{"sku": ItemAlexaSpeakerSku, "qty": 1}},
+	})
+	var r order.Return
+	if err := json.Unmarshal(rr.Body.Bytes(), &r); err != nil || rr.Code != http.StatusCreated {
+		t.Fatalf("return failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if r.Refund != 3*9855-2*10950 || r.Lines[0].ClawedBack != 9855-r.Refund {
+		t.Fatalf("unexpected return: %+v", r)
+	}
+
+	if l, _ := getOrderOf(t, env, oid).Line(ItemAlexaSpeakerSku); l.ListPrice != 10950 || l.UnitPrice != 9855 || len(l.PriceBreaks) != 1 {
+		t.Fatalf("unexpected order line %+v", l)
+	}
+}
+
+func TestCancelOrder_AfterReturnRestoresKeptUnits(t *testing.T) {
+	env := setupTestEnv(t)
+	oid := placeOrder(t, env, map[string]int{ItemAlexaSpeakerSku: 4})
+
+	rr := doJSON(t, env.srv, http.MethodPost, "/orders/"+oid+"/returns", map[string]interface{}{
+		"lines":   []map[string]interface{}{{"sku": ItemAlexaSpeakerSku, "qty": 1}},
+		"restock": true,
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create return failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	if rr := doJSON(t, env.srv, http.MethodPost, "/orders/"+oid+"/cancel", map[string]string{"reason": "x"}); rr.Code != http.StatusOK {
+		t.Fatalf("cancel failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := stockOf(t, env, ItemAlexaSpeakerSku); got.QtyAvailable != 10 {
+		t.Fatalf("Alexa Speaker QtyAvailable = %d, want 10", got.QtyAvailable)
+	}
+}
+
+func TestReturns_Errors(t *testing.T) {
+	env := setupTestEnv(t)
+	oid := placeOrder(t, env, map[string]int{ItemGoogleHomeSku: 1})
+	unknown := "0b7c4a8e-8d5e-4d4b-9b59-0e2b8f0b7a11"
+	line := func(sku string, qty int) map[string]interface{} {
+		return map[string]interface{}{"lines": []map[string]interface{}{{"sku": sku, "qty": qty}}}
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		want   int
+	}{
+		{"invalid order id", http.MethodPost, "/orders/not-a-uuid/returns", line(ItemGoogleHomeSku, 1), http.StatusUnprocessableEntity},
+		{"unknown order", http.MethodPost, "/orders/" + unknown + "/returns", line(ItemGoogleHomeSku, 1), http.StatusNotFound},
+		{"unknown field", http.MethodPost, "/orders/" + oid + "/returns", map[string]interface{}{"lines": nil, "refund": 1}, http.StatusUnprocessableEntity},
+		{"no lines", http.MethodPost, "/orders/" + oid + "/returns", map[string]interface{}{"lines": nil}, http.StatusUnprocessableEntity},
+		{"item not in order", http.MethodPost, "/orders/" + oid + "/returns", line(ItemMacBookProSku, 1), http.StatusUnprocessableEntity},
+		{"negative qty", http.MethodPost, "/orders/" + oid + "/returns", line(ItemGoogleHomeSku, -1), http.StatusUnprocessableEntity},
+		{"more than purchased", http.MethodPost, "/orders/" + oid + "/returns", line(ItemGoogleHomeSku, 2), http.StatusUnprocessableEntity},
+		{"list unknown order", http.MethodGet, "/orders/" + unknown + "/returns", nil, http.StatusNotFound},
+		{"get invalid return id", http.MethodGet, "/orders/" + oid + "/returns/nope", nil, http.StatusUnprocessableEntity},
+		{"get unknown return", http.MethodGet, "/orders/" + oid + "/returns/" + unknown, nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doJSON(t, env.srv, tt.method, tt.path, tt.body); rr.Code != tt.want {
+				t.Fatalf("%s %s = %d, want %d (body=%s)", tt.method, tt.path, rr.Code, tt.want, rr.Body.String())
+			}
+		})
+	}
+}
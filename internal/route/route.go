@@ -3,14 +3,17 @@ package route
 import (
 	"net/http"
 
-	"github.com/gambarini/flip-shop/internal/model/promotion"
+	"github.com/gambarini/flip-shop/internal/model/cart"
 	"github.com/gambarini/flip-shop/internal/repo"
 	"github.com/gambarini/flip-shop/utils"
 )
 
 // SetRoutes registers all HTTP routes for the application on the provided AppServer.
-// It wires handlers with the necessary repositories and promotions.
-func SetRoutes(srv *utils.AppServer, itemRepo repo.IItemRepository, cartRepo repo.ICartRepository, promotions []promotion.Promotion) error {
+// It wires handlers with the necessary repositories; carts are submitted with the active promotions of
+// promotionRepo at the time. opts customize the handlers.
+func SetRoutes(srv *utils.AppServer, itemRepo repo.IItemRepository, cartRepo repo.ICartRepository, orderRepo repo.IOrderRepository, promotionRepo repo.IPromotionRepository, opts ...Option) error {
+
+	cfg := newConfig(opts)
 
 	// Items endpoints
 	if err := srv.AddRoute("/items", "GET", listItems(srv, itemRepo)); err != nil {
@@ -32,19 +35,68 @@ func SetRoutes(srv *utils.AppServer, itemRepo repo.IItemRepository, cartRepo rep
 	if err := srv.AddRoute("/cart", "POST", postCart(srv, cartRepo)); err != nil {
 		return err
 	}
-	if err := srv.AddRoute("/cart/{cartID}/purchase", "PUT", purchase(srv, cartRepo, itemRepo)); err != nil {
+	if err := srv.AddRoute("/cart/{cartID}/purchase", "PUT", purchase(srv, cartRepo, itemRepo, cfg)); err != nil {
+		return err
+	}
+	if err := srv.AddRoute("/cart/{cartID}/purchase", "DELETE", remove(srv, cartRepo, itemRepo, cfg)); err != nil {
+		return err
+	}
+	if err := srv.AddRoute("/cart/{cartID}/coupons", "POST", attachCoupon(srv, cartRepo, cfg)); err != nil {
+		return err
+	}
+	if err := srv.AddRoute("/cart/{cartID}/quote", "GET", quoteCart(srv, cartRepo, itemRepo, promotionRepo, cfg)); err != nil {
+		return err
+	}
+	if err := srv.AddRoute("/cart/{cartID}/shipping", "PUT", putShipping(srv, cartRepo, cfg)); err != nil {
 		return err
 	}
-	if err := srv.AddRoute("/cart/{cartID}/purchase", "DELETE", remove(srv, cartRepo, itemRepo)); err != nil {
+	if err := srv.AddRoute("/shipping/methods", "GET", listShippingMethods(srv, cfg)); err != nil {
 		return err
 	}
-	if err := srv.AddRoute("/cart/{cartID}/status/submitted", "PUT", submit(srv, cartRepo, itemRepo, promotions)); err != nil {
+	if err := srv.AddRoute("/cart/{cartID}/status/{status}", "PUT", changeCartStatus(srv, cartRepo, cfg, map[cart.Status]http.HandlerFunc{
+		cart.CartStatusSubmitted: submit(srv, cartRepo, itemRepo, orderRepo, promotionRepo, cfg),
+		cart.CartStatusCancelled: cancelCart(srv, cartRepo, itemRepo, orderRepo, cfg),
+		cart.CartStatusPaid:      payCart(srv, cartRepo, orderRepo, cfg),
+	})); err != nil {
 		return err
 	}
 	// New read endpoint for fetching cart by ID
 	if err := srv.AddRoute("/cart/{cartID}", "GET", getCart(srv, cartRepo)); err != nil {
 		return err
 	}
+	if err := srv.AddRoute("/orders", "GET", listOrders(srv, orderRepo)); err != nil {
+		return err
+	}
+	if err := srv.AddRoute("/orders/{orderID}", "GET", getOrder(srv, orderRepo)); err != nil {
+		return err
+	}
+	if err := srv.AddRoute("/orders/{orderID}/cancel", "POST", cancelOrder(srv, cartRepo, itemRepo, orderRepo, cfg)); err != nil {
+		return err
+	}
+	if err := srv.AddRoute("/orders/{orderID}/returns", "POST", createReturn(srv, itemRepo, orderRepo, cfg)); err != nil {
+		return err
+	}
+	if err := srv.AddRoute("/orders/{orderID}/returns", "GET", listReturns(srv, orderRepo)); err != nil {
+		return err
+	}
+	if err := srv.AddRoute("/orders/{orderID}/returns/{returnID}", "GET", getReturn(srv, orderRepo)); err != nil {
+		return err
+	}
+	if err := srv.AddRoute("/promotions", "GET", listPromotions(srv, promotionRepo)); err != nil {
+		return err
+	}
+	if err := srv.AddRoute("/promotions", "POST", postPromotion(srv, itemRepo, promotionRepo, cfg)); err != nil {
+		return err
+	}
+	if err := srv.AddRoute("/promotions/{promotionID}", "GET", getPromotion(srv, promotionRepo)); err != nil {
+		return err
+	}
+	if err := srv.AddRoute("/promotions/{promotionID}", "PUT", putPromotion(srv, itemRepo, promotionRepo, cfg)); err != nil {
+		return err
+	}
+	if err := srv.AddRoute("/promotions/{promotionID}/disable", "POST", disablePromotion(srv, promotionRepo, cfg)); err != nil {
+		return err
+	}
 	if err := srv.AddRoute("/health", "GET", health(srv)); err != nil {
 		return err
 	}
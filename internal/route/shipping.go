@@ -0,0 +1,102 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/shipping"
+	"github.com/gambarini/flip-shop/internal/repo"
+	"github.com/gambarini/flip-shop/utils"
+)
+
+type (
+	// ShippingPayload represents the request body to choose how and where a cart is shipped.
+	ShippingPayload struct {
+		Method  string         `json:"method"`
+		Address AddressPayload `json:"address"`
+	}
+
+	// AddressPayload is a shipping address; line1, city, postal_code and country are required.
+	AddressPayload struct {
+		Name       string `json:"name"`
+		Line1      string `json:"line1"`
+		Line2      string `json:"line2"`
+		City       string `json:"city"`
+		Region     string `json:"region"`
+		PostalCode string `json:"postal_code"`
+		Country    string `json:"country"`
+	}
+)
+
+// listShippingMethods handles GET /shipping/methods returning the shipping methods of the store, ordered by code.
+func listShippingMethods(srv *utils.AppServer, cfg config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		srv.RespondJSON(w, http.StatusOK, cfg.shipping.List())
+	}
+}
+
+// putShipping handles PUT /cart/{cartID}/shipping, choosing the shipping method and address of a cart.
+// The shipping cost is added to the cart total when it is submitted.
+func putShipping(srv *utils.AppServer, cartRepo repo.ICartRepository, cfg config) http.HandlerFunc {
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		cartID := srv.Vars(request)["cartID"]
+
+		var rPayload ShippingPayload
+		dec := json.NewDecoder(request.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rPayload); err != nil {
+			srv.ResponseErrorEntityUnproc(response, fmt.Errorf("invalid JSON payload: %w", err))
+			return
+		}
+
+		m, err := cfg.shipping.Find(rPayload.Method)
+
+		if err != nil {
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
+		}
+
+		var shipped cart.Cart
+
+		err = cartRepo.WithTx(func(tx utils.Tx) error {
+
+			c, err := cartRepo.FindCartByIDTx(tx, cartID)
+
+			if err != nil {
+				return err
+			}
+
+			if err := c.ShipWith(m, shipping.Address(rPayload.Address)); err != nil {
+				return err
+			}
+
+			if err := cartRepo.Store(tx, c); err != nil {
+				return err
+			}
+
+			shipped = c
+
+			return nil
+		})
+
+		switch {
+		case errors.Is(err, repo.ErrCartNotFound):
+			srv.ResponseErrorNotfound(response, err)
+			return
+		case errors.Is(err, cart.ErrCartNotAvailable),
+			errors.Is(err, shipping.ErrInvalidAddress):
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
+		case err != nil:
+			srv.ResponseErrorServerErr(response, fmt.Errorf("error storing Cart: %w", err))
+			return
+		}
+
+		srv.RespondJSON(response, http.StatusOK, shipped)
+	}
+}
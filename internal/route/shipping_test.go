@@ -0,0 +1,75 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/shipping"
+	"github.com/gambarini/flip-shop/utils/memdb"
+)
+
+func TestPutShipping(t *testing.T) {
+	methods := shipping.Methods{
+		"standard": {Code: "standard", Name: "Standard", Type: shipping.TypeWeight, Cost: 500, PerKg: 150},
+		"saver":    {Code: "saver", Name: "Saver", Type: shipping.TypeFreeOver, Cost: 800, Threshold: 10000},
+	}
+	env := setupTestEnvWithDB(t, memdb.NewMemoryKVDatabase(), WithShippingMethods(methods))
+	address := map[string]string{"name": "Jo", "line1": "1 Main St", "city": "Sydney", "postal_code": "2000", "country": "AU"}
+
+	rr := doJSON(t, env.srv, http.MethodGet, "/shipping/methods", nil)
+	var listed []shipping.Method
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil || len(listed) != 2 || listed[0].Code != "saver" {
+		t.Fatalf("unexpected shipping methods: %s", rr.Body.String())
+	}
+
+	rr = doJSON(t, env.srv, http.MethodPost, "/items", map[string]interface{}{"sku": "K3TTL", "name": "Kettle", "price": 2500, "qty": 5, "weight": 1200, "dimensions": map[string]int{"length": 250, "width": 200, "height": 300}})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create item failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := stockOf(t, env, "K3TTL"); got.Weight != 1200 || got.Dimensions.Height != 300 {
+		t.Fatalf("unexpected item: %+v", got)
+	}
+
+	cid := createCart(t, env.srv)
+	_ = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": "K3TTL", "qty": 2})
+
+	tests := []struct {
+		name   string
+		cartID string
+		body   interface{}
+		want   int
+	}{
+		{"unknown method", cid, map[string]interface{}{"method": "drone", "address": address}, http.StatusUnprocessableEntity},
+		{"invalid address", cid, map[string]interface{}{"method": "standard", "address": map[string]string{"city": "Sydney"}}, http.StatusUnprocessableEntity},
+		{"unknown field", cid, map[string]interface{}{"method": "standard", "address": address, "carrier": "x"}, http.StatusUnprocessableEntity},
+		{"cart not found", "00000000-0000-0000-0000-000000000000", map[string]interface{}{"method": "standard", "address": address}, http.StatusNotFound},
+		{"standard", cid, map[string]interface{}{"method": "standard", "address": address}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+tt.cartID+"/shipping", tt.body); rr.Code != tt.want {
+				t.Fatalf("expected %d, got %d body=%s", tt.want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	// 2 kettles weigh 2.4kg: 500 + 3 * 150
+	rr = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/submitted", nil)
+	var submitted cart.Cart
+	if err := json.Unmarshal(rr.Body.Bytes(), &submitted); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("submit failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if submitted.ShippingCost != 950 || submitted.Total != 5950 || submitted.Shipping.Address.City != "Sydney" {
+		t.Fatalf("unexpected submitted cart: shipping %d, total %d, %+v", submitted.ShippingCost, submitted.Total, submitted.Shipping)
+	}
+	if o := getOrderOf(t, env, submitted.OrderID); o.Totals.Shipping != 950 || o.Totals.Total != 5950 || o.Shipping.Method.Code != "standard" {
+		t.Fatalf("unexpected order: %+v, shipping %+v", o.Totals, o.Shipping)
+	}
+
+	// a submitted cart keeps its shipping
+	if rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/shipping", map[string]interface{}{"method": "saver", "address": address}); rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 shipping a submitted cart, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,75 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/repo"
+	"github.com/gambarini/flip-shop/utils"
+)
+
+// changeCartStatus handles PUT /cart/{cartID}/status/{status}. The status is parsed from the path
+// and the cart transition table decides whether the cart can move to it.
+// Statuses with side effects, such as submitted or cancelled, are delegated to their own handler;
+// the others only record the transition.
+func changeCartStatus(srv *utils.AppServer, cartRepo repo.ICartRepository, cfg config, handlers map[cart.Status]http.HandlerFunc) http.HandlerFunc {
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		to, err := cart.ParseStatus(srv.Vars(request)["status"])
+
+		if err != nil {
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
+		}
+
+		if h, ok := handlers[to]; ok {
+			h(response, request)
+			return
+		}
+
+		cartID := srv.Vars(request)["cartID"]
+
+		var changed cart.Cart
+
+		err = cartRepo.WithTx(func(tx utils.Tx) error {
+
+			c, err := cartRepo.FindCartByIDTx(tx, cartID)
+
+			if err != nil {
+				return err
+			}
+
+			if err := c.Transition(to, cfg.now().UTC()); err != nil {
+				return err
+			}
+
+			if err := cartRepo.Store(tx, c); err != nil {
+				return err
+			}
+
+			changed = c
+
+			return nil
+		})
+
+		switch {
+		case errors.Is(err, repo.ErrCartNotFound):
+			srv.ResponseErrorNotfound(response, err)
+			return
+		case errors.Is(err, cart.ErrInvalidTransition),
+			errors.Is(err, cart.ErrCartEmpty),
+			errors.Is(err, cart.ErrCartNotEmpty),
+			errors.Is(err, cart.ErrCartHasNoOrder):
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
+		case err != nil:
+			srv.ResponseErrorServerErr(response, fmt.Errorf("error changing cart status: %w", err))
+			return
+		}
+
+		srv.RespondJSON(response, http.StatusOK, changed)
+	}
+}
@@ -0,0 +1,98 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/utils/memdb"
+)
+
+func TestChangeCartStatus_Lifecycle(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	env := setupTestEnvWithDB(t, memdb.NewMemoryKVDatabase(), WithClock(func() time.Time { return now }))
+	cid := createCart(t, env.srv)
+
+	status := func(name string, want int) cart.Cart {
+		t.Helper()
+		rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/"+name, nil)
+		if rr.Code != want {
+			t.Fatalf("PUT status/%s = %d, want %d (body=%s)", name, rr.Code, want, rr.Body.String())
+		}
+		var c cart.Cart
+		if want == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &c); err != nil {
+				t.Fatalf("invalid cart json: %v", err)
+			}
+		}
+		return c
+	}
+
+	// an empty cart cannot check out
+	status("checking-out", http.StatusUnprocessableEntity)
+
+	_ = doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemMacBookProSku, "qty": 1})
+	status("checking-out", http.StatusOK)
+
+	// purchases are frozen while checking out
+	if rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/purchase", map[string]interface{}{"sku": ItemGoogleHomeSku, "qty": 1}); rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 purchasing while checking out, got %d", rr.Code)
+	}
+
+	// paying or fulfilling before submit is not in the table
+	status("paid", http.StatusUnprocessableEntity)
+	status("fulfilled", http.StatusUnprocessableEntity)
+
+	// the free Raspberry Pi is still added when submitting from checkout
+	c := status("submitted", http.StatusOK)
+	if _, ok := c.Purchases[RaspberryPiSku]; !ok || c.OrderID == "" {
+		t.Fatalf("unexpected submitted cart: %+v", c)
+	}
+
+	status("paid", http.StatusOK)
+	c = status("fulfilled", http.StatusOK)
+
+	want := []cart.Transition{
+		{From: cart.CartStatusAvailable, To: cart.CartStatusCheckingOut, At: now},
+		{From: cart.CartStatusCheckingOut, To: cart.CartStatusSubmitted, At: now},
+		{From: cart.CartStatusSubmitted, To: cart.CartStatusPaid, At: now},
+		{From: cart.CartStatusPaid, To: cart.CartStatusFulfilled, At: now},
+	}
+	if len(c.History) != len(want) {
+		t.Fatalf("History = %+v, want %+v", c.History, want)
+	}
+	for i := range want {
+		if c.History[i].From != want[i].From || c.History[i].To != want[i].To || !c.History[i].At.Equal(want[i].At) {
+			t.Fatalf("History[%d] = %+v, want %+v", i, c.History[i], want[i])
+		}
+	}
+
+	// fulfilled is final
+	if rr := doJSON(t, env.srv, http.MethodPut, "/cart/"+cid+"/status/cancelled", map[string]string{"reason": "late"}); rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 cancelling a fulfilled cart, got %d", rr.Code)
+	}
+}
+
+func TestChangeCartStatus_Errors(t *testing.T) {
+	env := setupTestEnv(t)
+	cid := createCart(t, env.srv)
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"unknown status", "/cart/" + cid + "/status/shipped", http.StatusUnprocessableEntity},
+		{"unknown cart", "/cart/0b7c4a8e-8d5e-4d4b-9b59-0e2b8f0b7a11/status/checking-out", http.StatusNotFound},
+		{"not in the table", "/cart/" + cid + "/status/available", http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doJSON(t, env.srv, http.MethodPut, tt.path, nil); rr.Code != tt.want {
+				t.Fatalf("PUT %s = %d, want %d (body=%s)", tt.path, rr.Code, tt.want, rr.Body.String())
+			}
+		})
+	}
+}
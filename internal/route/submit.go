@@ -7,12 +7,22 @@ import (
 
 	"github.com/gambarini/flip-shop/internal/model/cart"
 	"github.com/gambarini/flip-shop/internal/model/item"
-	"github.com/gambarini/flip-shop/internal/model/promotion"
+	"github.com/gambarini/flip-shop/internal/model/order"
+	"github.com/gambarini/flip-shop/internal/payment"
+	"github.com/gambarini/flip-shop/internal/pricing"
 	"github.com/gambarini/flip-shop/internal/repo"
 	"github.com/gambarini/flip-shop/utils"
 )
 
-func submit(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo.IItemRepository, promotions []promotion.Promotion) http.HandlerFunc {
+// submit handles PUT /cart/{cartID}/status/submitted. It checks the coupons attached to the cart,
+// resolves which of the active promotions in effect and of the coupons apply, or picks those giving
+// the best deal when so configured, recording which were considered, redeems the coupons that applied,
+// submits the cart, takes the purchased quantities out of stock and places its order.
+// No money moves inside a transaction: the submission is first rehearsed in a view to price the cart,
+// its total is authorized with the payment gateway, and the submission is then committed with that
+// authorization. A declined or timed out payment leaves the cart as it was; an authorization whose
+// submission fails to commit is voided.
+func submit(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo.IItemRepository, orderRepo repo.IOrderRepository, promotionRepo repo.IPromotionRepository, cfg config) http.HandlerFunc {
 
 	return func(response http.ResponseWriter, request *http.Request) {
 
@@ -29,49 +39,154 @@ func submit(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo.I
 			return
 		}
 
-		err = cartRepo.WithTx(func(tx utils.Tx) error {
+		// place submits the cart and places its order in tx, paid for by auth.
+		place := func(tx utils.Tx, auth payment.Authorization) (cart.Cart, error) {
 
-			for _, p := range promotions {
-				if err := p.Apply(
-					GetPurchasedItemForPromotion(submitCart),
-					AddPurchaseToCartForPromotion(tx, itemRepo, submitCart),
-					AddDiscountToPurchaseForPromotion(submitCart)); err != nil {
-					return err
+			c, err := cartRepo.FindCartByIDTx(tx, cartID)
+
+			if err != nil {
+				return c, err
+			}
+
+			rules, err := promotionRepo.ListPromotions(tx)
+
+			if err != nil {
+				return c, err
+			}
+
+			toApply, err := promotionsInEffect(rules, cfg.now().UTC())
+
+			if err != nil {
+				return c, err
+			}
+
+			redeemed, err := couponCandidates(tx, cfg, c, cfg.now().UTC())
+
+			if err != nil {
+				return c, err
+			}
+
+			toApply = append(toApply, redeemed...)
+
+			if cfg.bestDeal {
+				find := func(sku item.Sku) (item.Item, error) { return itemRepo.FindItemBySku(tx, sku) }
+
+				if toApply, err = pricing.BestDeal(c, toApply, find, cfg.searchLimit); err != nil {
+					return c, err
 				}
 			}
 
-			for _, pu := range submitCart.Purchases {
+			applied, err := pricing.Apply(&c, toApply, AddPurchaseToCartForPromotion(tx, itemRepo, c))
+
+			if err != nil {
+				return c, err
+			}
+
+			if err := redeemCoupons(tx, cfg, applied.CouponUses, cfg.now().UTC()); err != nil {
+				return c, err
+			}
+
+			c.Promotions = applied.Checks
+
+			if err := c.SubmitCart(cfg.now().UTC(), cfg.taxes); err != nil {
+				return c, err
+			}
+
+			for _, pu := range c.Purchases {
 
 				i, err := itemRepo.FindItemBySku(tx, pu.Sku)
 
 				if err != nil {
-					return err
+					return c, err
 				}
 
 				if err = i.RemoveItem(pu.Qty); err != nil {
-					return err
+					return c, err
 				}
 
 				if err = itemRepo.Store(tx, i); err != nil {
-					return err
+					return c, err
 				}
 
 			}
 
-			err = submitCart.SubmitCart()
+			number, err := orderRepo.NextNumber(tx)
 
 			if err != nil {
-				return err
+				return c, err
 			}
 
-			if err := cartRepo.Store(tx, submitCart); err != nil {
-				return err
+			o, err := order.NewOrder(number, c, cfg.now().UTC(), applied.Applied)
+
+			if err != nil {
+				return c, err
 			}
 
-			return nil
+			o.Promotions = applied.Order
+			if len(applied.CouponUses) > 0 {
+				o.CouponUses = applied.CouponUses
+			}
+			o.Payment = order.Payment{AuthorizationID: auth.ID, Authorized: auth.Amount}
+			c.OrderID = o.OrderID
+
+			if err := orderRepo.Store(tx, o); err != nil {
+				return c, err
+			}
+
+			if err := cartRepo.Store(tx, c); err != nil {
+				return c, err
+			}
+
+			return c, nil
+		}
+
+		var total int64
+
+		err = cartRepo.View(func(tx utils.Tx) error {
+			c, err := place(tx, payment.Authorization{})
+			total = c.Total
+			return err
 		})
 
+		var auth payment.Authorization
+
+		if err == nil && total > 0 {
+			auth, err = cfg.gateway.Authorize(request.Context(), cartID, total)
+		}
+
+		if err == nil {
+			err = cartRepo.WithTx(func(tx utils.Tx) error {
+
+				c, err := place(tx, auth)
+
+				if err != nil {
+					return err
+				}
+
+				if c.Total != auth.Amount {
+					return errCartChangedWhileAuthorizing
+				}
+
+				submitCart = c
+
+				return nil
+			})
+
+			if err != nil && auth.ID != "" {
+				if vErr := cfg.gateway.Void(request.Context(), auth.ID); vErr != nil {
+					srv.Logger().Error("payment_void_failed", utils.Fields{"cart_id": cartID, "authorization_id": auth.ID, "error": vErr.Error()})
+				}
+			}
+		}
+
+		if respondPaymentError(srv, response, err) {
+			return
+		}
+
 		switch {
+		case errors.Is(err, repo.ErrCartNotFound):
+			srv.ResponseErrorNotfound(response, err)
+			return
 		case errors.Is(err, repo.ErrItemNotFound):
 			srv.ResponseErrorEntityUnproc(response, err)
 			return
@@ -90,6 +205,18 @@ func submit(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo.I
 		case errors.Is(err, cart.ErrItemNotInCart):
 			srv.ResponseErrorEntityUnproc(response, err)
 			return
+		case errors.Is(err, cart.ErrCartNotAvailable):
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
+		case errors.Is(err, cart.ErrInvalidTransition):
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
+		case errors.Is(err, errCartChangedWhileAuthorizing):
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
+		case isCouponError(err):
+			srv.ResponseErrorEntityUnproc(response, err)
+			return
 		case err != nil:
 			srv.ResponseErrorServerErr(response, fmt.Errorf("error storing Cart: %w", err))
 			return
@@ -100,16 +227,9 @@ func submit(srv *utils.AppServer, cartRepo repo.ICartRepository, itemRepo repo.I
 	}
 }
 
-func AddDiscountToPurchaseForPromotion(cart cart.Cart) func(sku item.Sku, discount int64) error {
-	return func(sku item.Sku, discount int64) error {
-
-		if err := cart.DiscountPurchase(sku, discount); err != nil {
-			return err
-		}
-
-		return nil
-	}
-}
+// errCartChangedWhileAuthorizing is returned when the total of a cart changed between its
+// authorization and the commit of its submission.
+var errCartChangedWhileAuthorizing = errors.New("cart changed while its payment was authorized")
 
 // AddPurchaseToCartForPromotion reserves the promotional items before adding them to the cart to ensure
 // inventory invariants are maintained. If reservation fails (insufficient availability), the promotion
@@ -127,7 +247,7 @@ func AddPurchaseToCartForPromotion(tx utils.Tx, itemRepo repo.IItemRepository, c
 			return err
 		}
 
-		if err := cart.PurchaseItem(i, qty); err != nil {
+		if err := cart.AddPromotionItem(i, qty); err != nil {
 			return err
 		}
 
@@ -138,21 +258,3 @@ func AddPurchaseToCartForPromotion(tx utils.Tx, itemRepo repo.IItemRepository, c
 		return nil
 	}
 }
-
-func GetPurchasedItemForPromotion(cart cart.Cart) func(sku item.Sku) (promotion.PurchasedItem, bool) {
-	return func(sku item.Sku) (promotion.PurchasedItem, bool) {
-		pu, ok := cart.Purchases[sku]
-
-		if !ok {
-			return promotion.PurchasedItem{}, false
-		}
-
-		return promotion.PurchasedItem{
-			Name:     pu.Name,
-			Price:    pu.Price,
-			Qty:      pu.Qty,
-			Discount: pu.Discount,
-		}, true
-
-	}
-}
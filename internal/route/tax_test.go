@@ -0,0 +1,45 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gambarini/flip-shop/internal/model/cart"
+	"github.com/gambarini/flip-shop/internal/model/item"
+	"github.com/gambarini/flip-shop/internal/model/tax"
+	"github.com/gambarini/flip-shop/utils/memdb"
+)
+
+func TestSubmit_Taxes(t *testing.T) {
+	taxes := tax.Table{Jurisdiction: "XX", Rates: map[item.TaxCategory]tax.Rate{item.TaxCategoryStandard: 100000, "food": 50000}}
+	env := setupTestEnvWithDB(t, memdb.NewMemoryKVDatabase(), WithTaxes(taxes))
+
+	rr := doJSON(t, env.srv, http.MethodPost, "/items", map[string]interface{}{"sku": "BR34D", "name": "Bread", "price": 500, "qty": 5, "tax_category": "food"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create item failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := stockOf(t, env, "BR34D").TaxCategory; got != "food" {
+		t.Fatalf("TaxCategory = %q, want food", got)
+	}
+
+	oid := placeOrder(t, env, map[string]int{ItemGoogleHomeSku: 2, "BR34D": 2})
+	o := getOrderOf(t, env, oid)
+
+	rr = doJSON(t, env.srv, http.MethodGet, "/cart/"+o.CartID, nil)
+	var c cart.Cart
+	if err := json.Unmarshal(rr.Body.Bytes(), &c); err != nil {
+		t.Fatalf("invalid cart json: %v", err)
+	}
+
+	// Google Home is taxed at the standard rate, bread as food
+	if c.Purchases[ItemGoogleHomeSku].Tax != 1000 || c.Purchases["BR34D"].Tax != 50 {
+		t.Fatalf("unexpected line taxes: %+v", c.Purchases)
+	}
+	if c.Subtotal != 10998 || c.Discount != 0 || c.Tax != 1050 || c.Total != 12048 || c.TaxInclusive {
+		t.Fatalf("unexpected cart totals: subtotal %d, discount %d, tax %d, total %d", c.Subtotal, c.Discount, c.Tax, c.Total)
+	}
+	if o.Totals.Tax != 1050 || o.Totals.Total != 12048 || o.Payment.Authorized != 12048 {
+		t.Fatalf("unexpected order totals %+v, payment %+v", o.Totals, o.Payment)
+	}
+}
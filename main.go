@@ -2,15 +2,23 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"time"
 
+	"github.com/gambarini/flip-shop/internal/model/coupon"
 	"github.com/gambarini/flip-shop/internal/model/item"
 	"github.com/gambarini/flip-shop/internal/model/promotion"
+	"github.com/gambarini/flip-shop/internal/model/shipping"
+	"github.com/gambarini/flip-shop/internal/model/tax"
+	"github.com/gambarini/flip-shop/internal/model/tier"
 	"github.com/gambarini/flip-shop/internal/repo"
+	"github.com/gambarini/flip-shop/internal/reservation"
 	"github.com/gambarini/flip-shop/internal/route"
 	"github.com/gambarini/flip-shop/utils"
+	"github.com/gambarini/flip-shop/utils/filedb"
 	"github.com/gambarini/flip-shop/utils/memdb"
 )
 
@@ -19,37 +27,176 @@ const (
 	ItemMacBookProSku   = "43N23P"
 	ItemAlexaSpeakerSku = "A304SD"
 	RaspberyPiSku       = "234234"
+
+	defaultHoldSweepInterval = 30 * time.Second
 )
 
 var (
-	memDb               *memdb.MemoryKVDatabase
-	availablePromotions []promotion.Promotion
+	kvDb    utils.KVDatabase
+	closeDb func() error
+
+	holdDuration      = route.DefaultHoldDuration
+	holdSweepInterval = defaultHoldSweepInterval
+	sweeper           *reservation.Sweeper
+
+	taxes           tax.Table
+	priceTiers      tier.Table
+	shippingMethods shipping.Methods
+
+	bestDeal    bool
+	searchLimit int
 )
 
+// durationFromEnv parses the Go duration in the named environment variable, keeping def when unset.
+func durationFromEnv(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, v, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must not be negative", name, v)
+	}
+	return d, nil
+}
+
+// openDatabase selects the KV database implementation from FLIPSHOP_DB ("memory" or "file").
+// The file database keeps its snapshot and write-ahead log under FLIPSHOP_DATA_DIR.
+// FLIPSHOP_TX_MODE selects "pessimistic" (default) or "optimistic" transaction concurrency.
+func openDatabase() (utils.KVDatabase, func() error, error) {
+	var memOpts memdb.Options
+	switch os.Getenv("FLIPSHOP_TX_MODE") {
+	case "", "pessimistic":
+	case "optimistic":
+		memOpts.Concurrency = memdb.Optimistic
+	default:
+		return nil, nil, fmt.Errorf("unknown FLIPSHOP_TX_MODE %q, expected pessimistic or optimistic", os.Getenv("FLIPSHOP_TX_MODE"))
+	}
+
+	switch os.Getenv("FLIPSHOP_DB") {
+	case "", "memory":
+		return memdb.NewMemoryKVDatabaseWithOptions(memOpts), func() error { return nil }, nil
+	case "file":
+		dir := os.Getenv("FLIPSHOP_DATA_DIR")
+		if dir == "" {
+			dir = "./data"
+		}
+		fDb, err := filedb.NewFileKVDatabase(dir, filedb.Options{Memory: memOpts})
+		if err != nil {
+			return nil, nil, err
+		}
+		return fDb, fDb.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown FLIPSHOP_DB %q, expected memory or file", os.Getenv("FLIPSHOP_DB"))
+	}
+}
+
 func init() {
-	// Prepare the memory database with the items before the application starts
-	memDb = memdb.NewMemoryKVDatabase()
+	// Prepare the database with the items before the application starts
+	var err error
+	kvDb, closeDb, err = openDatabase()
+	if err != nil {
+		log.Fatalf("Error opening database, %s", err)
+	}
+
+	// Values are stored as JSON by default; FLIPSHOP_CODEC=binary selects the compact form
+	switch os.Getenv("FLIPSHOP_CODEC") {
+	case "", "json":
+	case "binary":
+		repo.RegisterCodecs(repo.Codecs, utils.BinaryFormat{})
+	default:
+		log.Fatalf("Error initializing, unknown FLIPSHOP_CODEC %q, expected json or binary", os.Getenv("FLIPSHOP_CODEC"))
+	}
+
+	// Cart lines keep their stock reserved for FLIPSHOP_HOLD_DURATION after the last purchase
+	if holdDuration, err = durationFromEnv("FLIPSHOP_HOLD_DURATION", holdDuration); err != nil {
+		log.Fatalf("Error initializing, %s", err)
+	}
+	if holdSweepInterval, err = durationFromEnv("FLIPSHOP_HOLD_SWEEP_INTERVAL", holdSweepInterval); err != nil {
+		log.Fatalf("Error initializing, %s", err)
+	} else if holdSweepInterval == 0 {
+		log.Fatalf("Error initializing, FLIPSHOP_HOLD_SWEEP_INTERVAL must be positive")
+	}
+
+	// Carts are taxed with the rates of FLIPSHOP_TAX_JURISDICTION read from FLIPSHOP_TAX_FILE
+	if path := os.Getenv("FLIPSHOP_TAX_FILE"); path != "" {
+		if taxes, err = tax.LoadFile(path, os.Getenv("FLIPSHOP_TAX_JURISDICTION")); err != nil {
+			log.Fatalf("Error initializing, loading tax rates: %s", err)
+		}
+	}
+
+	// Cart lines are priced with the price breaks of FLIPSHOP_PRICE_TIERS_FILE
+	if path := os.Getenv("FLIPSHOP_PRICE_TIERS_FILE"); path != "" {
+		if priceTiers, err = tier.LoadFile(path); err != nil {
+			log.Fatalf("Error initializing, loading price breaks: %s", err)
+		}
+	}
+
+	// Carts can be shipped with the methods of FLIPSHOP_SHIPPING_FILE
+	if path := os.Getenv("FLIPSHOP_SHIPPING_FILE"); path != "" {
+		if shippingMethods, err = shipping.LoadFile(path); err != nil {
+			log.Fatalf("Error initializing, loading shipping methods: %s", err)
+		}
+	}
+
+	// FLIPSHOP_PROMOTION_MODE=best_deal applies the promotions giving each cart its lowest total,
+	// evaluating at most FLIPSHOP_PROMOTION_SEARCH_LIMIT combinations
+	switch os.Getenv("FLIPSHOP_PROMOTION_MODE") {
+	case "", "priority":
+	case "best_deal":
+		bestDeal = true
+	default:
+		log.Fatalf("Error initializing, unknown FLIPSHOP_PROMOTION_MODE %q, expected priority or best_deal", os.Getenv("FLIPSHOP_PROMOTION_MODE"))
+	}
+	if v := os.Getenv("FLIPSHOP_PROMOTION_SEARCH_LIMIT"); v != "" {
+		if searchLimit, err = strconv.Atoi(v); err != nil || searchLimit <= 0 {
+			log.Fatalf("Error initializing, invalid FLIPSHOP_PROMOTION_SEARCH_LIMIT %q: must be a positive integer", v)
+		}
+	}
+
+	// A durable database keeps its inventory across restarts; only seed an empty one
+	if items, err := kvDb.List(repo.ItemStoreName); err != nil {
+		log.Fatalf("Error initializing, %s", err)
+	} else if len(items) > 0 {
+		return
+	}
 
 	// Optionally seed inventory from environment variable FLIPSHOP_INVENTORY_JSON
-	// Expected format: [{"sku":"120P90","name":"Google Home","price":4999,"qty":10}, ...]
+	// Expected format: [{"sku":"120P90","name":"Google Home","price":4999,"qty":10,"tax_category":"standard","category":"smart_speaker","weight":1200}, ...]
 	type invItem struct {
-		Sku   string `json:"sku"`
-		Name  string `json:"name"`
-		Price int64  `json:"price"`
-		Qty   int    `json:"qty"`
+		Sku         string `json:"sku"`
+		Name        string `json:"name"`
+		Price       int64  `json:"price"`
+		Qty         int    `json:"qty"`
+		TaxCategory string `json:"tax_category"`
+		Category    string `json:"category"`
+		Weight      int    `json:"weight"`
 	}
 
+	itemRepo := repo.NewItemRepository(kvDb)
+
 	seed := func(tx utils.Tx) error {
 		// Default inventory
-		tx.Write(repo.ItemStoreName, ItemGoogleHomeSku, item.Item{Sku: ItemGoogleHomeSku, Name: "Google Home", QtyAvailable: 10, Price: 4999, QtyReserved: 0})
-		tx.Write(repo.ItemStoreName, ItemMacBookProSku, item.Item{Sku: ItemMacBookProSku, Name: "MacBook Pro", QtyAvailable: 5, Price: 539999, QtyReserved: 0})
-		tx.Write(repo.ItemStoreName, ItemAlexaSpeakerSku, item.Item{Sku: ItemAlexaSpeakerSku, Name: "Alexa Speaker", QtyAvailable: 10, Price: 10950, QtyReserved: 0})
-		tx.Write(repo.ItemStoreName, RaspberyPiSku, item.Item{Sku: RaspberyPiSku, Name: "Raspberry Pi B", QtyAvailable: 2, Price: 3000, QtyReserved: 0})
+		if err := itemRepo.Store(tx, item.Item{Sku: ItemGoogleHomeSku, Name: "Google Home", Category: "smart_speaker", QtyAvailable: 10, Price: 4999, QtyReserved: 0}); err != nil {
+			return err
+		}
+		if err := itemRepo.Store(tx, item.Item{Sku: ItemMacBookProSku, Name: "MacBook Pro", Category: "computer", QtyAvailable: 5, Price: 539999, QtyReserved: 0}); err != nil {
+			return err
+		}
+		if err := itemRepo.Store(tx, item.Item{Sku: ItemAlexaSpeakerSku, Name: "Alexa Speaker", Category: "smart_speaker", QtyAvailable: 10, Price: 10950, QtyReserved: 0}); err != nil {
+			return err
+		}
+		if err := itemRepo.Store(tx, item.Item{Sku: RaspberyPiSku, Name: "Raspberry Pi B", Category: "computer", QtyAvailable: 2, Price: 3000, QtyReserved: 0}); err != nil {
+			return err
+		}
 		return nil
 	}
 
 	if invJSON := os.Getenv("FLIPSHOP_INVENTORY_JSON"); invJSON != "" {
-		_ = memDb.WithTx(func(tx utils.Tx) error {
+		_ = kvDb.WithTx(func(tx utils.Tx) error {
 			var items []invItem
 			if err := json.Unmarshal([]byte(invJSON), &items); err != nil {
 				// fallback to defaults on parse error
@@ -60,53 +207,165 @@ func init() {
 				if it.Sku == "" || it.Price < 0 || it.Qty < 0 {
 					continue
 				}
-				tx.Write(repo.ItemStoreName, it.Sku, item.Item{Sku: item.Sku(it.Sku), Name: it.Name, QtyAvailable: it.Qty, Price: it.Price, QtyReserved: 0})
+				if err := itemRepo.Store(tx, item.Item{Sku: item.Sku(it.Sku), Name: it.Name, QtyAvailable: it.Qty, Price: it.Price, QtyReserved: 0, TaxCategory: item.TaxCategory(it.TaxCategory), Category: item.Category(it.Category), Weight: it.Weight}); err != nil {
+					return err
+				}
 			}
 			return nil
 		})
 	} else {
-		if err := memDb.WithTx(seed); err != nil {
+		if err := kvDb.WithTx(seed); err != nil {
 			log.Fatalf("Error initializing, %s", err)
 		}
 	}
 }
 
+// defaultPromotions returns the promotions of the store when no promotions file is configured.
+func defaultPromotions() []promotion.Definition {
+	return []promotion.Definition{
+		{Type: promotion.NameFreeItem, Sku: ItemMacBookProSku, FreeSku: RaspberyPiSku, FreePrice: 3000},
+		{Type: promotion.NameQtyFree, Sku: ItemGoogleHomeSku, Qty: 3},
+		{Type: promotion.NameQtyPercentage, Sku: ItemAlexaSpeakerSku, Qty: 3, Percentage: 0.1},
+	}
+}
+
+// seedPromotions stores the promotions of FLIPSHOP_PROMOTIONS_FILE, or the defaults, as the active
+// promotions of the store. A durable database keeps the promotions managed at runtime; only seed an empty one.
+// The items the promotions of the file refer to must be in the inventory.
+func seedPromotions(itemRepo repo.IItemRepository, promotionRepo repo.IPromotionRepository) error {
+
+	return promotionRepo.WithTx(func(tx utils.Tx) error {
+
+		if rules, err := promotionRepo.ListPromotions(tx); err != nil || len(rules) > 0 {
+			return err
+		}
+
+		defs := defaultPromotions()
+
+		if path := os.Getenv("FLIPSHOP_PROMOTIONS_FILE"); path != "" {
+
+			items, err := itemRepo.ListItems(tx)
+
+			if err != nil {
+				return err
+			}
+
+			skus := make(map[item.Sku]bool, len(items))
+			for _, it := range items {
+				skus[it.Sku] = true
+			}
+
+			if defs, err = promotion.LoadFile(path, func(sku item.Sku) bool { return skus[sku] }); err != nil {
+				return err
+			}
+		}
+
+		for _, d := range defs {
+			number, err := promotionRepo.NextNumber(tx)
+			if err != nil {
+				return err
+			}
+			rule, err := promotion.NewRule(number, d, time.Now().UTC())
+			if err != nil {
+				return err
+			}
+			if err := promotionRepo.Store(tx, rule); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// defaultCoupons returns the coupons of the store when no coupons file is configured.
+func defaultCoupons() []coupon.Definition {
+	return []coupon.Definition{
+		{Code: "HOME10", Promotion: promotion.Definition{Type: promotion.NameQtyPercentage, Sku: ItemGoogleHomeSku, Percentage: 0.1}, MaxRedemptions: 100},
+	}
+}
+
+// loadCoupons returns the coupons of FLIPSHOP_COUPONS_FILE, or the defaults.
+// The items the coupons of the file refer to must be in the inventory.
+func loadCoupons(itemRepo repo.IItemRepository) (coupons coupon.Catalog, err error) {
+
+	path := os.Getenv("FLIPSHOP_COUPONS_FILE")
+
+	if path == "" {
+		return coupon.Build(defaultCoupons(), nil)
+	}
+
+	err = itemRepo.View(func(tx utils.Tx) error {
+
+		items, err := itemRepo.ListItems(tx)
+
+		if err != nil {
+			return err
+		}
+
+		skus := make(map[item.Sku]bool, len(items))
+		for _, it := range items {
+			skus[it.Sku] = true
+		}
+
+		coupons, err = coupon.LoadFile(path, func(sku item.Sku) bool { return skus[sku] })
+
+		return err
+	})
+
+	return coupons, err
+}
+
 func main() {
 
-	initializeFunc := func(srv *utils.AppServer) (err error) {
+	if err := seedPromotions(repo.NewItemRepository(kvDb), repo.NewPromotionRepository(kvDb)); err != nil {
+		log.Fatalf("Error initializing, seeding promotions: %s", err)
+	}
 
-		// Here we setup the expected promotions
-		availablePromotions = append(availablePromotions, promotion.FreeItemPromotion{
-			PurchasedItemSku: ItemMacBookProSku,
-			FreeItemSku:      RaspberyPiSku,
-			FreeItemPrice:    3000,
-		})
+	// Coupon promotions only apply to the carts their code is attached to
+	coupons, err := loadCoupons(repo.NewItemRepository(kvDb))
 
-		availablePromotions = append(availablePromotions, promotion.ItemQtyPriceFreePromotion{
-			PurchasedItemSku: ItemGoogleHomeSku,
-			PurchasedQty:     3,
-		})
+	if err != nil {
+		log.Fatalf("Error initializing, loading coupons: %s", err)
+	}
 
-		availablePromotions = append(availablePromotions, promotion.ItemQtyPriceDiscountPercentagePromotion{
-			PurchasedItemSku:   ItemAlexaSpeakerSku,
-			PurchasedQty:       3,
-			PercentageDiscount: 0.1,
-		})
+	initializeFunc := func(srv *utils.AppServer) (err error) {
 
-		itemRepo := repo.NewItemRepository(memDb)
-		cartRepo := repo.NewCartRepository(memDb)
+		itemRepo := repo.NewItemRepository(kvDb)
+		cartRepo := repo.NewCartRepository(kvDb)
+		orderRepo := repo.NewOrderRepository(kvDb)
+		couponRepo := repo.NewCouponRepository(kvDb)
+		promotionRepo := repo.NewPromotionRepository(kvDb)
 
-		err = route.SetRoutes(srv, itemRepo, cartRepo, availablePromotions)
+		opts := []route.Option{
+			route.WithHoldDuration(holdDuration),
+			route.WithTaxes(taxes),
+			route.WithPriceTiers(priceTiers),
+			route.WithShippingMethods(shippingMethods),
+			route.WithCoupons(coupons, couponRepo),
+		}
+		if bestDeal {
+			opts = append(opts, route.WithBestDeal(searchLimit))
+		}
+
+		err = route.SetRoutes(srv, itemRepo, cartRepo, orderRepo, promotionRepo, opts...)
 
 		if err != nil {
 			return err
 		}
 
+		// Release the stock of cart lines whose reservation lapsed
+		sweeper = reservation.NewSweeper(itemRepo, cartRepo, nil)
+		sweeper.Start(holdSweepInterval, srv.Logger())
+
 		return nil
 	}
 
 	cleanupFunc := func(srv *utils.AppServer) (err error) {
-		return nil
+		if sweeper != nil {
+			sweeper.Stop()
+		}
+		return closeDb()
 	}
 
 	// Configure port and version from environment variables, preserving defaults
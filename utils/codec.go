@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Record layout produced by VersionedCodec:
+//
+//	[1 byte format id][2 bytes schema version, big endian][payload]
+//
+// The format id lets records written with one Format be decoded after the store
+// switched to another; the schema version selects how the payload is decoded.
+const recordHeaderSize = 3
+
+const (
+	formatIDJSON   = byte('j')
+	formatIDBinary = byte('b')
+)
+
+var (
+	// ErrCodecNotFound is returned when no codec is registered for a store.
+	ErrCodecNotFound = errors.New("codec not found for store")
+	// ErrUnknownSchemaVersion is returned when a record was written with a schema version the codec does not know.
+	ErrUnknownSchemaVersion = errors.New("unknown schema version")
+	// ErrInvalidRecord is returned when the bytes do not form a valid encoded record.
+	ErrInvalidRecord = errors.New("invalid encoded record")
+)
+
+type (
+	// Codec
+	// Serializes the values of a store to bytes and back
+	Codec interface {
+		// Encode returns the byte representation of v
+		Encode(v interface{}) ([]byte, error)
+		// Decode returns the value represented by data
+		Decode(data []byte) (interface{}, error)
+	}
+
+	// Format
+	// Marshals a Go value into a byte representation
+	Format interface {
+		// ID identifies the format inside encoded records
+		ID() byte
+		Marshal(v interface{}) ([]byte, error)
+		Unmarshal(data []byte, v interface{}) error
+	}
+
+	// JSONFormat encodes values as JSON. Human readable, tolerant to added/removed fields.
+	JSONFormat struct{}
+
+	// BinaryFormat encodes values with encoding/gob. More compact than JSON for numeric data.
+	BinaryFormat struct{}
+
+	// Schema
+	// Describes one version of the records kept in a store
+	Schema struct {
+		// Version of the record layout, unique per codec
+		Version uint16
+		// New returns a pointer to an empty record of this version to decode into
+		New func() interface{}
+		// Upgrade converts a decoded record of this version into the current model value
+		Upgrade func(decoded interface{}) (interface{}, error)
+	}
+
+	// VersionedCodec
+	// Encodes values with the latest schema version and decodes records written
+	// with any registered version, upgrading them to the current model.
+	VersionedCodec struct {
+		format  Format
+		current uint16
+		schemas map[uint16]Schema
+	}
+
+	// CodecRegistry
+	// Holds the codec used by each store
+	// Thread safe for concurrent access
+	CodecRegistry struct {
+		lock   sync.RWMutex
+		codecs map[StoreName]Codec
+	}
+)
+
+var formats = map[byte]Format{
+	formatIDJSON:   JSONFormat{},
+	formatIDBinary: BinaryFormat{},
+}
+
+func (JSONFormat) ID() byte { return formatIDJSON }
+
+func (JSONFormat) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (JSONFormat) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+func (BinaryFormat) ID() byte { return formatIDBinary }
+
+func (BinaryFormat) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (BinaryFormat) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// NewVersionedCodec creates a codec writing records with the given format.
+// The schema with the highest version is the current one used for encoding.
+func NewVersionedCodec(format Format, schemas ...Schema) *VersionedCodec {
+	c := &VersionedCodec{
+		format:  format,
+		schemas: make(map[uint16]Schema, len(schemas)),
+	}
+	for _, s := range schemas {
+		c.schemas[s.Version] = s
+		if s.Version > c.current {
+			c.current = s.Version
+		}
+	}
+	return c
+}
+
+// Encode marshals v with the current schema version.
+func (c *VersionedCodec) Encode(v interface{}) ([]byte, error) {
+	payload, err := c.format.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	data[0] = c.format.ID()
+	binary.BigEndian.PutUint16(data[1:3], c.current)
+
+	return append(data, payload...), nil
+}
+
+// Decode unmarshals a record written with any known format and schema version.
+func (c *VersionedCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) < recordHeaderSize {
+		return nil, ErrInvalidRecord
+	}
+
+	format, ok := formats[data[0]]
+	if !ok {
+		return nil, ErrInvalidRecord
+	}
+
+	version := binary.BigEndian.Uint16(data[1:3])
+	schema, ok := c.schemas[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownSchemaVersion, version)
+	}
+
+	decoded := schema.New()
+	if err := format.Unmarshal(data[recordHeaderSize:], decoded); err != nil {
+		return nil, err
+	}
+
+	return schema.Upgrade(decoded)
+}
+
+// NewCodecRegistry creates an empty codec registry.
+func NewCodecRegistry() *CodecRegistry {
+	return &CodecRegistry{codecs: make(map[StoreName]Codec)}
+}
+
+// Register sets the codec for a store, replacing any previous one.
+func (r *CodecRegistry) Register(name StoreName, codec Codec) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.codecs[name] = codec
+}
+
+// Codec returns the codec registered for a store.
+// return ErrCodecNotFound if none was registered
+func (r *CodecRegistry) Codec(name StoreName) (Codec, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	c, ok := r.codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrCodecNotFound, name)
+	}
+	return c, nil
+}
+
+// Encode serializes v with the codec of the given store.
+func (r *CodecRegistry) Encode(name StoreName, v interface{}) ([]byte, error) {
+	c, err := r.Codec(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.Encode(v)
+}
+
+// Decode deserializes a value read from the given store.
+// Values are expected to be []byte as written by Encode.
+func (r *CodecRegistry) Decode(name StoreName, v interface{}) (interface{}, error) {
+	c, err := r.Codec(name)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected []byte, got %T", ErrInvalidRecord, v)
+	}
+	return c.Decode(data)
+}
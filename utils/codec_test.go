@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type recordV1 struct {
+	Name  string
+	Price int64
+}
+
+type recordV2 struct {
+	Name     string
+	Price    int64
+	Currency string
+}
+
+var (
+	schemaV1 = Schema{
+		Version: 1,
+		New:     func() interface{} { return &recordV1{} },
+		Upgrade: func(decoded interface{}) (interface{}, error) {
+			r := decoded.(*recordV1)
+			return recordV2{Name: r.Name, Price: r.Price, Currency: "AUD"}, nil
+		},
+	}
+	schemaV2 = Schema{
+		Version: 2,
+		New:     func() interface{} { return &recordV2{} },
+		Upgrade: func(decoded interface{}) (interface{}, error) {
+			return *decoded.(*recordV2), nil
+		},
+	}
+)
+
+func TestVersionedCodec_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+	}{
+		{"json", JSONFormat{}},
+		{"binary", BinaryFormat{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewVersionedCodec(tt.format, schemaV1, schemaV2)
+			want := recordV2{Name: "Google Home", Price: 4999, Currency: "USD"}
+
+			data, err := c.Encode(want)
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			got, err := c.Decode(data)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Decode() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestVersionedCodec_DecodesOlderVersionsAndFormats(t *testing.T) {
+	// records written before the struct evolved, in both formats
+	oldJSON, _ := NewVersionedCodec(JSONFormat{}, schemaV1).Encode(recordV1{Name: "Alexa", Price: 10950})
+	oldBinary, _ := NewVersionedCodec(BinaryFormat{}, schemaV1).Encode(recordV1{Name: "Alexa", Price: 10950})
+
+	current := NewVersionedCodec(BinaryFormat{}, schemaV1, schemaV2)
+	want := recordV2{Name: "Alexa", Price: 10950, Currency: "AUD"}
+
+	for name, data := range map[string][]byte{"json v1": oldJSON, "binary v1": oldBinary} {
+		t.Run(name, func(t *testing.T) {
+			got, err := current.Decode(data)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Decode() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestVersionedCodec_DecodeErrors(t *testing.T) {
+	newer, _ := NewVersionedCodec(JSONFormat{}, schemaV1, schemaV2).Encode(recordV2{})
+	c := NewVersionedCodec(JSONFormat{}, schemaV1)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"unknown version", newer, ErrUnknownSchemaVersion},
+		{"too short", []byte{'j'}, ErrInvalidRecord},
+		{"unknown format", []byte{'x', 0, 1, '{', '}'}, ErrInvalidRecord},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := c.Decode(tt.data); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCodecRegistry(t *testing.T) {
+	r := NewCodecRegistry()
+	r.Register(StoreName("TEST"), NewVersionedCodec(JSONFormat{}, schemaV2))
+
+	if _, err := r.Encode(StoreName("MISSING"), recordV2{}); !errors.Is(err, ErrCodecNotFound) {
+		t.Fatalf("Encode() error = %v, want %v", err, ErrCodecNotFound)
+	}
+	if _, err := r.Decode(StoreName("TEST"), "not bytes"); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("Decode() error = %v, want %v", err, ErrInvalidRecord)
+	}
+
+	data, err := r.Encode(StoreName("TEST"), recordV2{Name: "Pi"})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	got, err := r.Decode(StoreName("TEST"), data)
+	if err != nil || got.(recordV2).Name != "Pi" {
+		t.Fatalf("Decode() = %v, %v", got, err)
+	}
+}
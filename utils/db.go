@@ -1,6 +1,9 @@
 package utils
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 type (
 	StoreName string
@@ -12,6 +15,10 @@ type (
 		// WithTx
 		// Enclosures logic that requires a transaction
 		WithTx(txHandler TxHandler) error
+		// View
+		// Enclosures read-only logic against a snapshot of the last commit
+		// Never blocks nor is blocked by WithTx; writes made in the handler are discarded
+		View(txHandler TxHandler) error
 		// Read
 		// Return the value for a key
 		// return ErrValueNotFound if key/value does not exist
@@ -20,13 +27,31 @@ type (
 		// Returns all values stored under the given store name
 		// Implementations should not expose internal mutable maps; return a copy/slice
 		List(name StoreName) ([]interface{}, error)
+		// RegisterIndex
+		// Declare a secondary index on a store; declaring an index name already registered on the store does nothing
+		// Existing values are indexed immediately; later writes and deletes keep the index up to date
+		RegisterIndex(name StoreName, index string, extract IndexExtractor)
+		// SetExpiryHook
+		// Register a hook called for every key removed because its TTL lapsed
+		// Passing nil removes the hook
+		SetExpiryHook(hook ExpiryHook)
 	}
 
+	// IndexExtractor
+	// Returns the index values of a stored value; a value can be indexed under zero or more values
+	IndexExtractor func(v interface{}) []string
+
+	// ExpiryHook
+	// Called with an expired key/value inside the transaction that removes it
+	// Returning an error keeps the key; its removal is retried later
+	ExpiryHook func(tx Tx, name StoreName, kv KeyValue) error
+
 	// KVRepository
 	// Interface defining repositories for key/value database
 	// Enable a repository to handle transactions explicitly
 	KVRepository interface {
 		WithTx(txHandler TxHandler) error
+		View(txHandler TxHandler) error
 	}
 
 	// Tx
@@ -39,6 +64,34 @@ type (
 		// Write
 		// Write a value for a key within a transaction
 		Write(name StoreName, key string, v interface{})
+		// WriteWithTTL
+		// Write a value for a key that expires after ttl within a transaction
+		// Expired keys are removed by the database in the background; until then they can still be read
+		// A later Write of the key without TTL makes it permanent again
+		WriteWithTTL(name StoreName, key string, v interface{}, ttl time.Duration)
+		// Delete
+		// Remove a key within a transaction
+		// Deleting a key that does not exist is a no-op
+		Delete(name StoreName, key string)
+		// Scan
+		// Return the key/values whose key starts with prefix, ordered by key
+		// An empty prefix returns the whole store
+		Scan(name StoreName, prefix string) ([]KeyValue, error)
+		// Range
+		// Call fn for each key/value with from <= key < to, in key order, until fn returns false
+		// An empty to means no upper bound
+		Range(name StoreName, from, to string, fn func(kv KeyValue) bool) error
+		// Lookup
+		// Return the key/values of the store indexed under value by the given index, ordered by key
+		// return ErrIndexNotFound if the index was not registered
+		Lookup(name StoreName, index string, value string) ([]KeyValue, error)
+	}
+
+	// KeyValue
+	// A key and its value as returned by scans
+	KeyValue struct {
+		Key   string
+		Value interface{}
 	}
 
 	// TxHandler
@@ -48,4 +101,5 @@ type (
 
 var (
 	ErrValueNotFound = errors.New("value not found for key")
+	ErrIndexNotFound = errors.New("index not found for store")
 )
@@ -0,0 +1,303 @@
+// Package filedb provides a durable key/value database backed by files on disk.
+// It keeps the working set in a memdb.MemoryKVDatabase and appends the writes of
+// every committed transaction to a write-ahead log (WAL) before the commit is
+// made visible. On startup the latest snapshot is loaded and the WAL is replayed
+// on top of it. The WAL is periodically compacted into a new snapshot file.
+//
+// Values are encoded with encoding/gob, so concrete value types stored through
+// this database must be registered with gob.Register.
+package filedb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gambarini/flip-shop/utils/memdb"
+)
+
+const (
+	snapshotFileName = "snapshot.db"
+	walFileName      = "wal.log"
+	walOldFileName   = "wal.log.old"
+
+	defaultCompactThreshold = 1000
+	defaultCompactInterval  = time.Minute
+)
+
+var (
+	// ErrClosed is returned when committing to a database that has been closed.
+	ErrClosed = errors.New("database is closed")
+
+	errNothingToCompact = errors.New("nothing to compact")
+)
+
+type (
+	// Options configures the file-backed database.
+	// Zero values fall back to sensible defaults.
+	Options struct {
+		// CompactThreshold is the number of WAL records after which a compaction is triggered.
+		CompactThreshold int
+		// CompactInterval is how often the WAL is compacted when it is not empty.
+		CompactInterval time.Duration
+		// NoSync disables fsync after each WAL append. Faster, but committed
+		// transactions may be lost on a machine crash.
+		NoSync bool
+		// Memory configures the in-memory database serving the working set.
+		Memory memdb.Options
+	}
+
+	// FileKVDatabase
+	// Durable key/value database
+	// Thread safe for concurrent read/write access
+	// Reads are served from memory; writes are logged to disk before commit.
+	FileKVDatabase struct {
+		*memdb.MemoryKVDatabase
+		dir  string
+		opts Options
+
+		compactLock sync.Mutex // serializes compactions
+
+		lock    sync.Mutex // guards wal, records, closing and closed
+		wal     *os.File
+		records int
+		closing bool // Close was called; only the first call closes
+		closed  bool
+
+		compact chan struct{}
+		done    chan struct{}
+		wg      sync.WaitGroup
+	}
+)
+
+// NewFileKVDatabase opens (or creates) a durable database in the given directory,
+// recovering its content from the snapshot and write-ahead log found there.
+func NewFileKVDatabase(dir string, opts Options) (*FileKVDatabase, error) {
+
+	if opts.CompactThreshold <= 0 {
+		opts.CompactThreshold = defaultCompactThreshold
+	}
+	if opts.CompactInterval <= 0 {
+		opts.CompactInterval = defaultCompactInterval
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	// The reaper must not commit before the WAL is hooked in, start it last
+	memOpts := opts.Memory
+	memOpts.ReapInterval = 0
+
+	db := &FileKVDatabase{
+		MemoryKVDatabase: memdb.NewMemoryKVDatabaseWithOptions(memOpts),
+		dir:              dir,
+		opts:             opts,
+		compact:          make(chan struct{}, 1),
+		done:             make(chan struct{}),
+	}
+
+	if err := db.recover(); err != nil {
+		return nil, err
+	}
+
+	// Fold everything recovered into a fresh snapshot so we start from an empty log
+	if db.records > 0 {
+		if err := writeSnapshot(db.path(snapshotFileName), db.MemoryKVDatabase.Dump()); err != nil {
+			return nil, err
+		}
+		for _, name := range []string{walOldFileName, walFileName} {
+			if err := os.Remove(db.path(name)); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		db.records = 0
+	}
+
+	wal, err := os.OpenFile(db.path(walFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	db.wal = wal
+
+	db.MemoryKVDatabase.SetCommitHook(db.logCommit)
+
+	db.wg.Add(1)
+	go db.compactLoop()
+
+	if opts.Memory.ReapInterval > 0 {
+		db.MemoryKVDatabase.StartReaper(opts.Memory.ReapInterval)
+	}
+
+	return db, nil
+}
+
+// Close stops the reaper and background compaction, compacts the log one last time and closes the files.
+func (db *FileKVDatabase) Close() error {
+	_ = db.MemoryKVDatabase.Close()
+
+	db.lock.Lock()
+	if db.closing {
+		db.lock.Unlock()
+		return nil
+	}
+	db.closing = true
+	db.lock.Unlock()
+
+	close(db.done)
+	db.wg.Wait()
+
+	compactErr := db.Compact()
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.closed = true
+
+	if err := db.wal.Close(); err != nil {
+		return err
+	}
+	return compactErr
+}
+
+// Compact writes the current content of the database into a new snapshot file
+// and truncates the write-ahead log.
+func (db *FileKVDatabase) Compact() error {
+
+	db.compactLock.Lock()
+	defer db.compactLock.Unlock()
+
+	// Rotate the WAL and take the dump under the memdb write lock, so that every
+	// record in the rotated WAL is in the dump and every later commit goes to the
+	// new WAL.
+	data, err := db.MemoryKVDatabase.Checkpoint(func() error {
+		db.lock.Lock()
+		defer db.lock.Unlock()
+
+		if db.closed {
+			return ErrClosed
+		}
+		if db.records == 0 {
+			return errNothingToCompact
+		}
+		// A rotated WAL left by a failed compaction must not be overwritten;
+		// its records are in this dump too, so it goes once the snapshot is written.
+		if _, err := os.Stat(db.path(walOldFileName)); os.IsNotExist(err) {
+			return db.rotate()
+		}
+		return nil
+	})
+	if errors.Is(err, errNothingToCompact) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := writeSnapshot(db.path(snapshotFileName), data); err != nil {
+		return err
+	}
+
+	return os.Remove(db.path(walOldFileName))
+}
+
+// rotate moves the current WAL aside and opens an empty one. Callers must hold db.lock.
+func (db *FileKVDatabase) rotate() error {
+	if err := db.wal.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(db.path(walFileName), db.path(walOldFileName)); err != nil {
+		return err
+	}
+	wal, err := os.OpenFile(db.path(walFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	db.wal = wal
+	db.records = 0
+	return nil
+}
+
+// logCommit appends the mutations of a transaction to the WAL.
+// It runs as the memdb commit hook, so a failure aborts the transaction.
+func (db *FileKVDatabase) logCommit(mutations []memdb.Mutation) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	if err := appendRecord(db.wal, mutations); err != nil {
+		return err
+	}
+
+	if !db.opts.NoSync {
+		if err := db.wal.Sync(); err != nil {
+			return err
+		}
+	}
+
+	db.records++
+	if db.records >= db.opts.CompactThreshold {
+		select {
+		case db.compact <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// recover loads the snapshot and replays any WAL files into memory.
+func (db *FileKVDatabase) recover() error {
+
+	data, err := readSnapshot(db.path(snapshotFileName))
+	if err != nil {
+		return err
+	}
+
+	var loaded []memdb.Mutation
+	for store, kv := range data {
+		for k, v := range kv {
+			loaded = append(loaded, memdb.Mutation{Store: store, Key: k, Value: v})
+		}
+	}
+	db.MemoryKVDatabase.Replay(loaded)
+
+	for _, name := range []string{walOldFileName, walFileName} {
+		n, err := replayLog(db.path(name), func(mutations []memdb.Mutation) error {
+			db.MemoryKVDatabase.Replay(mutations)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		db.records += n
+	}
+
+	return nil
+}
+
+func (db *FileKVDatabase) compactLoop() {
+	defer db.wg.Done()
+
+	ticker := time.NewTicker(db.opts.CompactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.done:
+			return
+		case <-ticker.C:
+		case <-db.compact:
+		}
+		// Errors are retried on the next tick; the WAL remains the source of truth.
+		_ = db.Compact()
+	}
+}
+
+func (db *FileKVDatabase) path(name string) string {
+	return filepath.Join(db.dir, name)
+}
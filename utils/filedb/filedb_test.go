@@ -0,0 +1,329 @@
+package filedb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gambarini/flip-shop/utils"
+	"github.com/gambarini/flip-shop/utils/memdb"
+)
+
+const testStore = utils.StoreName("TEST")
+
+func openTestDB(t *testing.T, dir string, opts Options) *FileKVDatabase {
+	t.Helper()
+	opts.NoSync = true
+	db, err := NewFileKVDatabase(dir, opts)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return db
+}
+
+func write(t *testing.T, db *FileKVDatabase, key string, v interface{}) {
+	t.Helper()
+	if err := db.WithTx(func(tx utils.Tx) error {
+		tx.Write(testStore, key, v)
+		return nil
+	}); err != nil {
+		t.Fatalf("write %s: %v", key, err)
+	}
+}
+
+func TestFileKVDatabase_RecoversFromWAL(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, Options{})
+
+	write(t, db, "key1", 1)
+	write(t, db, "key2", "two")
+	write(t, db, "key1", 11)
+
+	// rolled back transaction must not be logged
+	boom := errors.New("boom")
+	if err := db.WithTx(func(tx utils.Tx) error {
+		tx.Write(testStore, "key3", 3)
+		return boom
+	}); !errors.Is(err, boom) {
+		t.Fatalf("expected rollback error, got %v", err)
+	}
+
+	// Simulate a crash: reopen without Close so no compaction happens
+	reopened := openTestDB(t, dir, Options{})
+	defer reopened.Close()
+
+	tests := []struct {
+		key     string
+		want    interface{}
+		wantErr error
+	}{
+		{"key1", 11, nil},
+		{"key2", "two", nil},
+		{"key3", nil, utils.ErrValueNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			got, err := reopened.Read(testStore, tt.key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Read() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("Read() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileKVDatabase_CompactAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, Options{})
+
+	for i := 0; i < 10; i++ {
+		write(t, db, "counter", i)
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, walFileName))
+	if err != nil {
+		t.Fatalf("stat wal: %v", err)
+	}
+	if info.Size() != 0 {
+		t.Fatalf("expected empty WAL after compaction, got %d bytes", info.Size())
+	}
+
+	write(t, db, "after", true)
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	reopened := openTestDB(t, dir, Options{})
+	defer reopened.Close()
+
+	if got, err := reopened.Read(testStore, "counter"); err != nil || got != 9 {
+		t.Fatalf("counter = %v (err %v), want 9", got, err)
+	}
+	if got, err := reopened.Read(testStore, "after"); err != nil || got != true {
+		t.Fatalf("after = %v (err %v), want true", got, err)
+	}
+}
+
+func TestFileKVDatabase_CompactThreshold(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, Options{CompactThreshold: 1})
+	defer db.Close()
+
+	write(t, db, "key1", 1)
+
+	// Compaction runs in the background; trigger one explicitly to avoid timing on it
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, snapshotFileName)); err != nil {
+		t.Fatalf("expected snapshot file: %v", err)
+	}
+}
+
+func TestFileKVDatabase_TornTailIsDiscarded(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, Options{})
+
+	write(t, db, "key1", 1)
+	write(t, db, "key2", 2)
+
+	// Chop the last bytes off the log as if the process died mid-append
+	walPath := filepath.Join(dir, walFileName)
+	info, err := os.Stat(walPath)
+	if err != nil {
+		t.Fatalf("stat wal: %v", err)
+	}
+	if err := os.Truncate(walPath, info.Size()-3); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+
+	reopened := openTestDB(t, dir, Options{})
+	defer reopened.Close()
+
+	if got, err := reopened.Read(testStore, "key1"); err != nil || got != 1 {
+		t.Fatalf("key1 = %v (err %v), want 1", got, err)
+	}
+	if _, err := reopened.Read(testStore, "key2"); !errors.Is(err, utils.ErrValueNotFound) {
+		t.Fatalf("expected torn record to be discarded, got err %v", err)
+	}
+
+	// The recovered database keeps accepting commits
+	write(t, reopened, "key3", 3)
+	if got, err := reopened.Read(testStore, "key3"); err != nil || got != 3 {
+		t.Fatalf("key3 = %v (err %v), want 3", got, err)
+	}
+}
+
+func TestFileKVDatabase_RecoversDeletes(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, Options{})
+
+	write(t, db, "key1", 1)
+	write(t, db, "key2", 2)
+	if err := db.WithTx(func(tx utils.Tx) error {
+		tx.Delete(testStore, "key1")
+		return nil
+	}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	reopened := openTestDB(t, dir, Options{})
+	defer reopened.Close()
+
+	if _, err := reopened.Read(testStore, "key1"); !errors.Is(err, utils.ErrValueNotFound) {
+		t.Fatalf("expected key1 to stay deleted, got err %v", err)
+	}
+	if got, err := reopened.Read(testStore, "key2"); err != nil || got != 2 {
+		t.Fatalf("key2 = %v (err %v), want 2", got, err)
+	}
+}
+
+func TestFileKVDatabase_IndexesRebuiltOnReopen(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, Options{})
+	byValue := func(v interface{}) []string { return []string{v.(string)} }
+	db.RegisterIndex(testStore, "value", byValue)
+
+	write(t, db, "key1", "a")
+	write(t, db, "key2", "b")
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	write(t, db, "key3", "a")
+
+	reopened := openTestDB(t, dir, Options{})
+	defer reopened.Close()
+	reopened.RegisterIndex(testStore, "value", byValue)
+
+	if err := reopened.WithTx(func(tx utils.Tx) error {
+		kvs, err := tx.Lookup(testStore, "value", "a")
+		if err != nil {
+			return err
+		}
+		if len(kvs) != 2 || kvs[0].Key != "key1" || kvs[1].Key != "key3" {
+			t.Fatalf("Lookup() = %v, want key1 and key3", kvs)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("lookup tx: %v", err)
+	}
+}
+
+func TestFileKVDatabase_TTLSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Unix(1000, 0)
+	opts := Options{Memory: memdb.Options{Clock: func() time.Time { return now }}}
+	db := openTestDB(t, dir, opts)
+
+	if err := db.WithTx(func(tx utils.Tx) error {
+		tx.WriteWithTTL(testStore, "snapshotted", 1, time.Minute)
+		return nil
+	}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err := db.WithTx(func(tx utils.Tx) error {
+		tx.WriteWithTTL(testStore, "logged", 2, time.Minute)
+		return nil
+	}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	reopened := openTestDB(t, dir, opts)
+	defer reopened.Close()
+
+	now = now.Add(time.Hour)
+	if n, err := reopened.Reap(); err != nil || n != 2 {
+		t.Fatalf("Reap() = %d (err %v), want 2", n, err)
+	}
+}
+
+func TestFileKVDatabase_ConcurrentClose(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), Options{})
+	write(t, db, "key", "a")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := db.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestFileKVDatabase_CompactDuringCommits(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, Options{})
+
+	const writers, perWriter = 4, 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				key := fmt.Sprintf("key-%d-%d", w, i)
+				if err := db.WithTx(func(tx utils.Tx) error {
+					tx.Write(testStore, key, i)
+					return nil
+				}); err != nil {
+					t.Errorf("write %s: %v", key, err)
+				}
+			}
+		}(w)
+	}
+
+	stop := make(chan struct{})
+	var compactors sync.WaitGroup
+	for c := 0; c < 2; c++ {
+		compactors.Add(1)
+		go func() {
+			defer compactors.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := db.Compact(); err != nil {
+					t.Errorf("compact: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(stop)
+	compactors.Wait()
+
+	// Simulate a crash: reopen without Close so only the snapshot and WALs count
+	reopened := openTestDB(t, dir, Options{})
+	defer reopened.Close()
+
+	for w := 0; w < writers; w++ {
+		for i := 0; i < perWriter; i++ {
+			key := fmt.Sprintf("key-%d-%d", w, i)
+			if got, err := reopened.Read(testStore, key); err != nil || got != i {
+				t.Fatalf("%s = %v (err %v), want %d", key, got, err, i)
+			}
+		}
+	}
+}
@@ -22,39 +22,53 @@ import (
 func Test_Integration_Create_Add_Submit(t *testing.T) {
 	// Build flip-shop HTTP handler with real routes, repos, and seeded inventory
 	memDb := memdb.NewMemoryKVDatabase()
+	itemRepo := repo.NewItemRepository(memDb)
+	cartRepo := repo.NewCartRepository(memDb)
 	seedInventory := func(tx utils.Tx) error {
-		tx.Write(repo.ItemStoreName, "120P90", item.Item{Sku: "120P90", Name: "Google Home", QtyAvailable: 10, Price: 4999})
-		tx.Write(repo.ItemStoreName, "43N23P", item.Item{Sku: "43N23P", Name: "MacBook Pro", QtyAvailable: 5, Price: 539999})
-		tx.Write(repo.ItemStoreName, "A304SD", item.Item{Sku: "A304SD", Name: "Alexa Speaker", QtyAvailable: 10, Price: 10950})
-		tx.Write(repo.ItemStoreName, "234234", item.Item{Sku: "234234", Name: "Raspberry Pi B", QtyAvailable: 2, Price: 3000})
+		if err := itemRepo.Store(tx, item.Item{Sku: "120P90", Name: "Google Home", QtyAvailable: 10, Price: 4999}); err != nil {
+			return err
+		}
+		if err := itemRepo.Store(tx, item.Item{Sku: "43N23P", Name: "MacBook Pro", QtyAvailable: 5, Price: 539999}); err != nil {
+			return err
+		}
+		if err := itemRepo.Store(tx, item.Item{Sku: "A304SD", Name: "Alexa Speaker", QtyAvailable: 10, Price: 10950}); err != nil {
+			return err
+		}
+		if err := itemRepo.Store(tx, item.Item{Sku: "234234", Name: "Raspberry Pi B", QtyAvailable: 2, Price: 3000}); err != nil {
+			return err
+		}
 		return nil
 	}
 	if err := memDb.WithTx(seedInventory); err != nil {
 		t.Fatalf("seed error: %v", err)
 	}
 
-	availablePromotions := []promotion.Promotion{
-		promotion.FreeItemPromotion{
-			PurchasedItemSku: "43N23P",
-			FreeItemSku:      "234234",
-			FreeItemPrice:    3000,
-		},
-		promotion.ItemQtyPriceFreePromotion{
-			PurchasedItemSku: "120P90",
-			PurchasedQty:     3,
-		},
-		promotion.ItemQtyPriceDiscountPercentagePromotion{
-			PurchasedItemSku:   "A304SD",
-			PurchasedQty:       3,
-			PercentageDiscount: 0.1,
-		},
+	promotionRepo := repo.NewPromotionRepository(memDb)
+	if err := promotionRepo.WithTx(func(tx utils.Tx) error {
+		for _, d := range []promotion.Definition{
+			{Type: promotion.NameFreeItem, Sku: "43N23P", FreeSku: "234234", FreePrice: 3000},
+			{Type: promotion.NameQtyFree, Sku: "120P90", Qty: 3},
+			{Type: promotion.NameQtyPercentage, Sku: "A304SD", Qty: 3, Percentage: 0.1},
+		} {
+			number, err := promotionRepo.NextNumber(tx)
+			if err != nil {
+				return err
+			}
+			rule, err := promotion.NewRule(number, d, time.Now().UTC())
+			if err != nil {
+				return err
+			}
+			if err := promotionRepo.Store(tx, rule); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("seed promotions error: %v", err)
 	}
 
-	itemRepo := repo.NewItemRepository(memDb)
-	cartRepo := repo.NewCartRepository(memDb)
-
 	app := utils.NewServer(0) // handler only; not starting a real listener
-	if err := route.SetRoutes(app, itemRepo, cartRepo, availablePromotions); err != nil {
+	if err := route.SetRoutes(app, itemRepo, cartRepo, repo.NewOrderRepository(memDb), promotionRepo); err != nil {
 		t.Fatalf("route setup error: %v", err)
 	}
 	// Host the handler in an httptest server
@@ -35,6 +35,8 @@ func NewServer(logger *log.Logger, cfg Config) *Server {
 	s.tools["cart.purchase.add"] = s.handleCartPurchaseAdd
 	s.tools["cart.purchase.remove"] = s.handleCartPurchaseRemove
 	s.tools["cart.submit"] = s.handleCartSubmit
+	s.tools["cart.cancel"] = s.handleCartCancel
+	s.tools["order.cancel"] = s.handleOrderCancel
 	return s
 }
 
@@ -115,11 +117,26 @@ type purchaseParams struct {
 	Qty    int    `json:"qty"`
 }
 
+type cartCancelParams struct {
+	CartID string `json:"cartID"`
+	Reason string `json:"reason"`
+}
+
+type orderCancelParams struct {
+	OrderID string `json:"orderID"`
+	Reason  string `json:"reason"`
+}
+
 // Response wrapper: MCP plan returns { cart: Cart }
 type cartResponse struct {
 	Cart any `json:"cart"`
 }
 
+// Response wrapper for order tools: { order: Order }
+type orderResponse struct {
+	Order any `json:"order"`
+}
+
 // Handlers
 
 func (s *Server) handleCartCreate(ctx context.Context, _ json.RawMessage) (any, error) {
@@ -205,6 +222,52 @@ func (s *Server) handleCartSubmit(ctx context.Context, raw json.RawMessage) (any
 	return cartResponse{Cart: cart}, nil
 }
 
+func (s *Server) handleCartCancel(ctx context.Context, raw json.RawMessage) (any, error) {
+	var p cartCancelParams
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, errors.New("invalid params: expected {cartID, reason}")
+	}
+	if p.CartID == "" || p.Reason == "" {
+		return nil, errors.New("invalid params: cartID and reason must be non-empty")
+	}
+	reqBody := map[string]any{"reason": p.Reason}
+	body, status, err := s.doJSON(ctx, http.MethodPut, path.Join("/cart", p.CartID, "status", "cancelled"), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if status < 200 || status >= 300 {
+		return nil, mapHTTPToMCPError(status, body)
+	}
+	var cart any
+	if err := json.Unmarshal(body, &cart); err != nil {
+		return nil, fmt.Errorf("decode cart: %w", err)
+	}
+	return cartResponse{Cart: cart}, nil
+}
+
+func (s *Server) handleOrderCancel(ctx context.Context, raw json.RawMessage) (any, error) {
+	var p orderCancelParams
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, errors.New("invalid params: expected {orderID, reason}")
+	}
+	if p.OrderID == "" || p.Reason == "" {
+		return nil, errors.New("invalid params: orderID and reason must be non-empty")
+	}
+	reqBody := map[string]any{"reason": p.Reason}
+	body, status, err := s.doJSON(ctx, http.MethodPost, path.Join("/orders", p.OrderID, "cancel"), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if status < 200 || status >= 300 {
+		return nil, mapHTTPToMCPError(status, body)
+	}
+	var order any
+	if err := json.Unmarshal(body, &order); err != nil {
+		return nil, fmt.Errorf("decode order: %w", err)
+	}
+	return orderResponse{Order: order}, nil
+}
+
 // doJSON performs an HTTP request to flip-shop, sending/receiving JSON.
 func (s *Server) doJSON(ctx context.Context, method, relativePath string, payload any) ([]byte, int, error) {
 	u, err := url.Parse(s.config.BaseURL)
@@ -312,6 +375,24 @@ func (s *Server) Declarations() []ToolDeclaration {
 		},
 	}
 
+	cartCancelParam := map[string]any{
+		"type":     "object",
+		"required": []string{"cartID", "reason"},
+		"properties": map[string]any{
+			"cartID": map[string]any{"type": "string", "pattern": uuidPattern},
+			"reason": map[string]any{"type": "string", "minLength": 1},
+		},
+	}
+
+	orderCancelParam := map[string]any{
+		"type":     "object",
+		"required": []string{"orderID", "reason"},
+		"properties": map[string]any{
+			"orderID": map[string]any{"type": "string", "pattern": uuidPattern},
+			"reason":  map[string]any{"type": "string", "minLength": 1},
+		},
+	}
+
 	cartOutput := map[string]any{
 		"type":     "object",
 		"required": []string{"cart"},
@@ -357,6 +438,30 @@ func (s *Server) Declarations() []ToolDeclaration {
 				{"params": map[string]any{"cartID": "123e4567-e89b-12d3-a456-426614174000"}},
 			},
 		},
+		{
+			Name:        "cart.cancel",
+			Description: "Cancel the cart: release its reservations, or cancel its order and restore stock when submitted",
+			InputSchema: cartCancelParam,
+			OutputSchema: cartOutput,
+			Examples: []map[string]any{
+				{"params": map[string]any{"cartID": "123e4567-e89b-12d3-a456-426614174000", "reason": "customer request"}},
+			},
+		},
+		{
+			Name:        "order.cancel",
+			Description: "Cancel a placed order and restore the stock of its lines",
+			InputSchema: orderCancelParam,
+			OutputSchema: map[string]any{
+				"type":     "object",
+				"required": []string{"order"},
+				"properties": map[string]any{
+					"order": map[string]any{"type": "object"},
+				},
+			},
+			Examples: []map[string]any{
+				{"params": map[string]any{"orderID": "5f0c3c59-6f0f-4b8e-9d0e-3a8f1f2f4e61", "reason": "customer request"}},
+			},
+		},
 	}
 
 	return decls
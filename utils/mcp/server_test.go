@@ -115,6 +115,44 @@ func TestCartSubmit_OK(t *testing.T) {
 	_ = res.(cartResponse)
 }
 
+func TestCancel_OK(t *testing.T) {
+	cases := []struct {
+		tool   string
+		params map[string]any
+		method string
+		path   string
+	}{
+		{tool: "cart.cancel", params: map[string]any{"cartID": "xyz", "reason": "changed mind"}, method: http.MethodPut, path: "/cart/xyz/status/cancelled"},
+		{tool: "order.cancel", params: map[string]any{"orderID": "abc", "reason": "changed mind"}, method: http.MethodPost, path: "/orders/abc/cancel"},
+	}
+	for _, c := range cases {
+		t.Run(c.tool, func(t *testing.T) {
+			var got recordedRequest
+			srv, _ := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+				got = recordedRequest{Method: r.Method, Path: r.URL.Path, Body: readBody(t, r)}
+				if r.Method != c.method || r.URL.Path != c.path {
+					w.WriteHeader(500)
+					return
+				}
+				_ = json.NewEncoder(w).Encode(testCart{ID: "xyz"})
+			})
+
+			res, err := srv.invoke(context.Background(), c.tool, c.params)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			switch res.(type) {
+			case cartResponse, orderResponse:
+			default:
+				t.Fatalf("unexpected response type %T", res)
+			}
+			if !strings.Contains(got.Body, "\"reason\":\"changed mind\"") {
+				t.Fatalf("expected body to contain reason, got %s", got.Body)
+			}
+		})
+	}
+}
+
 func TestErrorMapping_HTTPToMCP(t *testing.T) {
 	cases := []struct{
 		status int
@@ -152,6 +190,11 @@ func TestParamValidation(t *testing.T) {
 	if err == nil || !strings.Contains(err.Error(), "qty > 0") {
 		t.Fatalf("expected validation error, got %v", err)
 	}
+	// missing reason for cancel
+	_, err = srv.invoke(context.Background(), "order.cancel", map[string]any{"orderID": "x"})
+	if err == nil || !strings.Contains(err.Error(), "reason") {
+		t.Fatalf("expected reason validation error, got %v", err)
+	}
 	// missing cartID for submit
 	_, err = srv.invoke(context.Background(), "cart.submit", map[string]any{})
 	if err == nil || !strings.Contains(err.Error(), "cartID") {
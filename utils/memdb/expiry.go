@@ -0,0 +1,192 @@
+package memdb
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gambarini/flip-shop/utils"
+)
+
+// expiryStore is the internal store holding the expiry of keys written with a TTL.
+// Each key is "<store>\x00<key>" bound to the expiry as Unix nanoseconds (int64).
+// Unlike indexes it is regular data: it is logged, dumped and replayed with the
+// stores it refers to, so expiries survive a restart of a file-backed database.
+const expiryStore = utils.StoreName("\x00expiry")
+
+// reaper runs Reap periodically until stopped.
+type reaper struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func expiryKey(name utils.StoreName, key string) string {
+	return string(name) + "\x00" + key
+}
+
+func parseExpiryKey(ek string) (utils.StoreName, string) {
+	name, key, _ := strings.Cut(ek, "\x00")
+	return utils.StoreName(name), key
+}
+
+// WriteWithTTL writes a value that expires ttl after now, as told by the database clock.
+func (tx *MemoryKVTx) WriteWithTTL(name utils.StoreName, key string, v interface{}, ttl time.Duration) {
+	tx.write(name, key, v)
+	tx.write(expiryStore, expiryKey(name, key), tx.clock().Add(ttl).UnixNano())
+}
+
+// clearExpiry makes a key permanent by removing its expiry, if any.
+func (tx *MemoryKVTx) clearExpiry(name utils.StoreName, key string) {
+	if name == expiryStore {
+		return
+	}
+	tx.Delete(expiryStore, expiryKey(name, key))
+}
+
+func (tx *MemoryKVTx) clock() time.Time {
+	if tx.now != nil {
+		return tx.now()
+	}
+	return time.Now()
+}
+
+// expire removes the key of an expiry entry if it is still due at now, calling
+// the hook first. The entry is read again since the key may have been written
+// after the reaper listed it.
+func (tx *MemoryKVTx) expire(ek string, now int64, hook utils.ExpiryHook) (bool, error) {
+	at, err := tx.Read(expiryStore, ek)
+	if errors.Is(err, utils.ErrValueNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if at.(int64) > now {
+		return false, nil
+	}
+
+	name, key := parseExpiryKey(ek)
+	v, err := tx.Read(name, key)
+	switch {
+	case errors.Is(err, utils.ErrValueNotFound):
+		// dangling expiry, nothing to notify about
+		tx.Delete(expiryStore, ek)
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	if hook != nil {
+		if err := hook(tx, name, utils.KeyValue{Key: key, Value: v}); err != nil {
+			return false, err
+		}
+	}
+
+	tx.Delete(name, key)
+	return true, nil
+}
+
+// SetExpiryHook registers a hook called for every key removed by Reap.
+// Passing nil removes the hook.
+func (mDb *MemoryKVDatabase) SetExpiryHook(hook utils.ExpiryHook) {
+	mDb.lock.Lock()
+	defer mDb.lock.Unlock()
+	mDb.expiryHook = hook
+}
+
+// Reap removes the keys whose TTL lapsed and returns how many were removed.
+// Each key is removed in its own transaction, together with whatever the expiry
+// hook does, so a failing hook only keeps its own key around until the next reap.
+func (mDb *MemoryKVDatabase) Reap() (int, error) {
+	now := mDb.now().UnixNano()
+
+	mDb.lock.RLock()
+	s, hook := mDb.state.Load(), mDb.expiryHook
+	mDb.lock.RUnlock()
+
+	var due []string
+	if m, ok := s.stores[expiryStore]; ok {
+		m.Range(func(ek string, v interface{}) bool {
+			if v.(record).value.(int64) <= now {
+				due = append(due, ek)
+			}
+			return true
+		})
+	}
+	sort.Strings(due)
+
+	var (
+		reaped int
+		errs   []error
+	)
+	for _, ek := range due {
+		var expired bool
+		err := mDb.WithTx(func(tx utils.Tx) (err error) {
+			expired, err = tx.(*MemoryKVTx).expire(ek, now, hook)
+			return err
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if expired {
+			reaped++
+		}
+	}
+
+	return reaped, errors.Join(errs...)
+}
+
+// StartReaper runs Reap every interval in the background until Close is called.
+// It does nothing if the reaper is already running.
+func (mDb *MemoryKVDatabase) StartReaper(interval time.Duration) {
+	mDb.lock.Lock()
+	defer mDb.lock.Unlock()
+	if mDb.reaper != nil {
+		return
+	}
+
+	r := &reaper{stop: make(chan struct{})}
+	mDb.reaper = r
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+			}
+			// Errors are retried on the next tick; the expired keys are still there.
+			_, _ = mDb.Reap()
+		}
+	}()
+}
+
+// Close stops the background reaper, if running.
+func (mDb *MemoryKVDatabase) Close() error {
+	mDb.lock.Lock()
+	r := mDb.reaper
+	mDb.reaper = nil
+	mDb.lock.Unlock()
+
+	if r != nil {
+		close(r.stop)
+		r.wg.Wait()
+	}
+	return nil
+}
+
+func (mDb *MemoryKVDatabase) now() time.Time {
+	if mDb.opts.Clock != nil {
+		return mDb.opts.Clock()
+	}
+	return time.Now()
+}
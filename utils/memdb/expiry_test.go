@@ -0,0 +1,136 @@
+package memdb
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gambarini/flip-shop/utils"
+)
+
+const sessionStore = utils.StoreName("SESSION")
+
+// fakeClock is a settable clock for TTL tests.
+type fakeClock struct {
+	lock sync.Mutex
+	t    time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func TestMemoryKVDatabase_Reap(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	mDb := NewMemoryKVDatabaseWithOptions(Options{Clock: clock.Now})
+
+	if err := mDb.WithTx(func(tx utils.Tx) error {
+		tx.WriteWithTTL(sessionStore, "short", 1, time.Minute)
+		tx.WriteWithTTL(sessionStore, "long", 2, time.Hour)
+		tx.WriteWithTTL(sessionStore, "renewed", 3, time.Minute)
+		tx.Write(sessionStore, "permanent", 4)
+		return nil
+	}); err != nil {
+		t.Fatalf("WithTx() error = %v", err)
+	}
+	// writing without TTL makes the key permanent again
+	if err := mDb.WithTx(func(tx utils.Tx) error {
+		tx.Write(sessionStore, "renewed", 33)
+		return nil
+	}); err != nil {
+		t.Fatalf("WithTx() error = %v", err)
+	}
+
+	if n, err := mDb.Reap(); err != nil || n != 0 {
+		t.Fatalf("Reap() before expiry = %d (err %v), want 0", n, err)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if n, err := mDb.Reap(); err != nil || n != 1 {
+		t.Fatalf("Reap() = %d (err %v), want 1", n, err)
+	}
+
+	tests := []struct {
+		key     string
+		wantErr error
+	}{
+		{"short", utils.ErrValueNotFound},
+		{"long", nil},
+		{"renewed", nil},
+		{"permanent", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if _, err := mDb.Read(sessionStore, tt.key); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Read() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMemoryKVDatabase_ExpiryHook(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	mDb := NewMemoryKVDatabaseWithOptions(Options{Clock: clock.Now})
+
+	boom := errors.New("boom")
+	mDb.SetExpiryHook(func(tx utils.Tx, name utils.StoreName, kv utils.KeyValue) error {
+		if kv.Key == "failing" {
+			return boom
+		}
+		// the hook runs in the removing transaction and can write along with it
+		tx.Write(counterStore, "expired-"+kv.Key, kv.Value)
+		return nil
+	})
+
+	if err := mDb.WithTx(func(tx utils.Tx) error {
+		tx.WriteWithTTL(sessionStore, "ok", 1, time.Second)
+		tx.WriteWithTTL(sessionStore, "failing", 2, time.Second)
+		return nil
+	}); err != nil {
+		t.Fatalf("WithTx() error = %v", err)
+	}
+
+	clock.Advance(time.Second)
+	n, err := mDb.Reap()
+	if n != 1 || !errors.Is(err, boom) {
+		t.Fatalf("Reap() = %d (err %v), want 1 and %v", n, err, boom)
+	}
+
+	if got, err := mDb.Read(counterStore, "expired-ok"); err != nil || got != 1 {
+		t.Fatalf("hook write = %v (err %v), want 1", got, err)
+	}
+	// the key whose hook failed is kept for the next reap
+	if _, err := mDb.Read(sessionStore, "failing"); err != nil {
+		t.Fatalf("expected failing key to be kept, got err %v", err)
+	}
+}
+
+func TestMemoryKVDatabase_BackgroundReaper(t *testing.T) {
+	mDb := NewMemoryKVDatabaseWithOptions(Options{ReapInterval: time.Millisecond})
+	defer mDb.Close()
+
+	if err := mDb.WithTx(func(tx utils.Tx) error {
+		tx.WriteWithTTL(sessionStore, "key", 1, time.Millisecond)
+		return nil
+	}); err != nil {
+		t.Fatalf("WithTx() error = %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := mDb.Read(sessionStore, "key"); errors.Is(err, utils.ErrValueNotFound) {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("expected the reaper to remove the expired key")
+}
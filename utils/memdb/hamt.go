@@ -0,0 +1,256 @@
+package memdb
+
+import (
+	"math/bits"
+)
+
+// hamtBits is the number of hash bits consumed per trie level (32-way branching).
+const (
+	hamtBits = 5
+	hamtMask = 1<<hamtBits - 1
+
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+type (
+	// pmap is an immutable hash array mapped trie from string keys to values.
+	// Every update returns a new pmap sharing all untouched nodes with the
+	// previous one, so a snapshot is just a pointer and an update copies only
+	// the O(log32 n) nodes on the path to the changed key.
+	pmap struct {
+		root *hamtNode
+		size int
+	}
+
+	// hamtNode holds up to 32 slots, only the occupied ones are allocated.
+	// Bit i of bitmap is set when slot i is occupied; its entry lives at
+	// index popcount(bitmap below i) of slots.
+	hamtNode struct {
+		bitmap uint32
+		slots  []hamtSlot
+	}
+
+	// hamtSlot is either a sub trie or a leaf.
+	hamtSlot struct {
+		node *hamtNode
+		leaf *hamtLeaf
+	}
+
+	// hamtLeaf holds the entries whose keys share a full 64 bits hash.
+	// There is almost always a single entry.
+	hamtLeaf struct {
+		hash    uint64
+		entries []hamtEntry
+	}
+
+	hamtEntry struct {
+		key   string
+		value interface{}
+	}
+)
+
+var emptyPmap = &pmap{root: &hamtNode{}}
+
+// hashKey is an allocation free FNV-1a hash of the key.
+func hashKey(key string) uint64 {
+	h := uint64(fnvOffset64)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// Len returns the number of keys in the map.
+func (m *pmap) Len() int {
+	return m.size
+}
+
+// Get returns the value stored for key.
+func (m *pmap) Get(key string) (interface{}, bool) {
+	hash := hashKey(key)
+	n := m.root
+	for shift := uint(0); ; shift += hamtBits {
+		bit := uint32(1) << ((hash >> shift) & hamtMask)
+		if n.bitmap&bit == 0 {
+			return nil, false
+		}
+		slot := n.slots[n.index(bit)]
+		if slot.leaf != nil {
+			if slot.leaf.hash != hash {
+				return nil, false
+			}
+			for _, e := range slot.leaf.entries {
+				if e.key == key {
+					return e.value, true
+				}
+			}
+			return nil, false
+		}
+		n = slot.node
+	}
+}
+
+// Set returns a new map where key is bound to value.
+func (m *pmap) Set(key string, value interface{}) *pmap {
+	root, added := m.root.set(hashKey(key), 0, key, value)
+	size := m.size
+	if added {
+		size++
+	}
+	return &pmap{root: root, size: size}
+}
+
+// Delete returns a new map without key. The same map is returned when key is absent.
+func (m *pmap) Delete(key string) *pmap {
+	root, removed := m.root.delete(hashKey(key), 0, key)
+	if !removed {
+		return m
+	}
+	if root == nil {
+		root = &hamtNode{}
+	}
+	return &pmap{root: root, size: m.size - 1}
+}
+
+// Range calls fn for every entry in unspecified order until fn returns false.
+func (m *pmap) Range(fn func(key string, value interface{}) bool) {
+	m.root.each(fn)
+}
+
+func (n *hamtNode) index(bit uint32) int {
+	return bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+func (n *hamtNode) set(hash uint64, shift uint, key string, value interface{}) (*hamtNode, bool) {
+	bit := uint32(1) << ((hash >> shift) & hamtMask)
+	idx := n.index(bit)
+
+	if n.bitmap&bit == 0 {
+		slots := make([]hamtSlot, len(n.slots)+1)
+		copy(slots, n.slots[:idx])
+		slots[idx] = hamtSlot{leaf: &hamtLeaf{hash: hash, entries: []hamtEntry{{key, value}}}}
+		copy(slots[idx+1:], n.slots[idx:])
+		return &hamtNode{bitmap: n.bitmap | bit, slots: slots}, true
+	}
+
+	var (
+		slot  = n.slots[idx]
+		added bool
+	)
+
+	switch {
+	case slot.node != nil:
+		var child *hamtNode
+		child, added = slot.node.set(hash, shift+hamtBits, key, value)
+		slot = hamtSlot{node: child}
+
+	case slot.leaf.hash == hash:
+		entries := make([]hamtEntry, len(slot.leaf.entries), len(slot.leaf.entries)+1)
+		copy(entries, slot.leaf.entries)
+		added = true
+		for i := range entries {
+			if entries[i].key == key {
+				entries[i].value = value
+				added = false
+				break
+			}
+		}
+		if added {
+			entries = append(entries, hamtEntry{key, value})
+		}
+		slot = hamtSlot{leaf: &hamtLeaf{hash: hash, entries: entries}}
+
+	default:
+		// Two different hashes share this slot: push both one level down
+		child, _ := newLeafNode(slot.leaf, shift+hamtBits).set(hash, shift+hamtBits, key, value)
+		slot = hamtSlot{node: child}
+		added = true
+	}
+
+	return n.withSlot(idx, slot), added
+}
+
+// newLeafNode returns a node holding only the given leaf.
+func newLeafNode(leaf *hamtLeaf, shift uint) *hamtNode {
+	bit := uint32(1) << ((leaf.hash >> shift) & hamtMask)
+	return &hamtNode{bitmap: bit, slots: []hamtSlot{{leaf: leaf}}}
+}
+
+// withSlot returns a copy of the node with the slot at idx replaced.
+func (n *hamtNode) withSlot(idx int, slot hamtSlot) *hamtNode {
+	slots := make([]hamtSlot, len(n.slots))
+	copy(slots, n.slots)
+	slots[idx] = slot
+	return &hamtNode{bitmap: n.bitmap, slots: slots}
+}
+
+// withoutSlot returns a copy of the node with the slot at idx removed, or nil if it becomes empty.
+func (n *hamtNode) withoutSlot(idx int, bit uint32) *hamtNode {
+	if len(n.slots) == 1 {
+		return nil
+	}
+	slots := make([]hamtSlot, len(n.slots)-1)
+	copy(slots, n.slots[:idx])
+	copy(slots[idx:], n.slots[idx+1:])
+	return &hamtNode{bitmap: n.bitmap &^ bit, slots: slots}
+}
+
+func (n *hamtNode) delete(hash uint64, shift uint, key string) (*hamtNode, bool) {
+	bit := uint32(1) << ((hash >> shift) & hamtMask)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+	idx := n.index(bit)
+	slot := n.slots[idx]
+
+	if slot.node != nil {
+		child, removed := slot.node.delete(hash, shift+hamtBits, key)
+		switch {
+		case !removed:
+			return n, false
+		case child == nil:
+			return n.withoutSlot(idx, bit), true
+		case len(child.slots) == 1 && child.slots[0].leaf != nil:
+			// collapse a sub trie left with a single leaf
+			return n.withSlot(idx, child.slots[0]), true
+		default:
+			return n.withSlot(idx, hamtSlot{node: child}), true
+		}
+	}
+
+	if slot.leaf.hash != hash {
+		return n, false
+	}
+	for i, e := range slot.leaf.entries {
+		if e.key != key {
+			continue
+		}
+		if len(slot.leaf.entries) == 1 {
+			return n.withoutSlot(idx, bit), true
+		}
+		entries := make([]hamtEntry, 0, len(slot.leaf.entries)-1)
+		entries = append(entries, slot.leaf.entries[:i]...)
+		entries = append(entries, slot.leaf.entries[i+1:]...)
+		return n.withSlot(idx, hamtSlot{leaf: &hamtLeaf{hash: hash, entries: entries}}), true
+	}
+	return n, false
+}
+
+func (n *hamtNode) each(fn func(key string, value interface{}) bool) bool {
+	for _, slot := range n.slots {
+		if slot.node != nil {
+			if !slot.node.each(fn) {
+				return false
+			}
+			continue
+		}
+		for _, e := range slot.leaf.entries {
+			if !fn(e.key, e.value) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package memdb
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestPmap_MatchesBuiltinMap(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	want := map[string]int{}
+	m := emptyPmap
+
+	for i := 0; i < 20000; i++ {
+		key := fmt.Sprintf("key-%d", rnd.Intn(5000))
+		if rnd.Intn(4) == 0 {
+			delete(want, key)
+			m = m.Delete(key)
+			continue
+		}
+		want[key] = i
+		m = m.Set(key, i)
+	}
+
+	if m.Len() != len(want) {
+		t.Fatalf("Len() = %d, want %d", m.Len(), len(want))
+	}
+	for k, v := range want {
+		got, ok := m.Get(k)
+		if !ok || got != v {
+			t.Fatalf("Get(%s) = %v, %v, want %v", k, got, ok, v)
+		}
+	}
+	seen := 0
+	m.Range(func(key string, value interface{}) bool {
+		if want[key] != value {
+			t.Fatalf("Range() %s = %v, want %v", key, value, want[key])
+		}
+		seen++
+		return true
+	})
+	if seen != len(want) {
+		t.Fatalf("Range() visited %d keys, want %d", seen, len(want))
+	}
+}
+
+func TestPmap_UpdatesDoNotChangePreviousVersions(t *testing.T) {
+	v1 := emptyPmap.Set("a", 1).Set("b", 2)
+	v2 := v1.Set("a", 10).Delete("b").Set("c", 3)
+
+	tests := []struct {
+		name   string
+		m      *pmap
+		key    string
+		want   interface{}
+		wantOk bool
+	}{
+		{"v1 a", v1, "a", 1, true},
+		{"v1 b", v1, "b", 2, true},
+		{"v1 c", v1, "c", nil, false},
+		{"v2 a", v2, "a", 10, true},
+		{"v2 b", v2, "b", nil, false},
+		{"v2 c", v2, "c", 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.m.Get(tt.key)
+			if ok != tt.wantOk || got != tt.want {
+				t.Errorf("Get() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestHamtNode_FullHashCollision(t *testing.T) {
+	// Force two keys onto the same 64 bits hash
+	const hash = uint64(42)
+	n, _ := (&hamtNode{}).set(hash, 0, "x", 1)
+	n, added := n.set(hash, 0, "y", 2)
+	if !added {
+		t.Fatalf("expected second colliding key to be added")
+	}
+	if len(n.slots) != 1 || len(n.slots[0].leaf.entries) != 2 {
+		t.Fatalf("expected a single leaf with 2 entries, got %+v", n.slots)
+	}
+
+	n, removed := n.delete(hash, 0, "x")
+	if !removed || len(n.slots[0].leaf.entries) != 1 || n.slots[0].leaf.entries[0].key != "y" {
+		t.Fatalf("expected only y left after delete, got %+v", n.slots)
+	}
+}
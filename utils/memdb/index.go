@@ -0,0 +1,173 @@
+package memdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gambarini/flip-shop/utils"
+)
+
+// indexStorePrefix marks the internal stores holding secondary indexes.
+// They live next to the data stores in a snapshot, so they are shared,
+// copied on write and committed exactly like them, but are never logged
+// or dumped: they are derived from the data stores.
+const indexStorePrefix = "\x00index/"
+
+// indexSet holds the extractors of the registered indexes by store and index name.
+type indexSet map[utils.StoreName]map[string]utils.IndexExtractor
+
+// indexStore returns the name of the internal store holding an index.
+// Each key of that store is an index value bound to the set (a *pmap) of primary keys indexed under it.
+func indexStore(name utils.StoreName, index string) utils.StoreName {
+	return utils.StoreName(indexStorePrefix + string(name) + "/" + index)
+}
+
+func isIndexStore(name utils.StoreName) bool {
+	return strings.HasPrefix(string(name), indexStorePrefix)
+}
+
+// put sets the record of a key, or deletes the key when r is nil, in stores
+// and updates the indexes registered on the store accordingly.
+func put(stores map[utils.StoreName]*pmap, indexes indexSet, name utils.StoreName, key string, r *record) {
+	m, ok := stores[name]
+	if !ok {
+		m = emptyPmap
+	}
+
+	for index, extract := range indexes[name] {
+		is := indexStore(name, index)
+		im, ok := stores[is]
+		if !ok {
+			im = emptyPmap
+		}
+		if old, ok := m.Get(key); ok {
+			for _, v := range extract(old.(record).value) {
+				im = unindex(im, v, key)
+			}
+		}
+		if r != nil {
+			for _, v := range extract(r.value) {
+				im = addIndex(im, v, key)
+			}
+		}
+		stores[is] = im
+	}
+
+	if r == nil {
+		stores[name] = m.Delete(key)
+	} else {
+		stores[name] = m.Set(key, *r)
+	}
+}
+
+// addIndex returns the index with key added to the set of value.
+func addIndex(im *pmap, value, key string) *pmap {
+	keys := emptyPmap
+	if v, ok := im.Get(value); ok {
+		keys = v.(*pmap)
+	}
+	return im.Set(value, keys.Set(key, struct{}{}))
+}
+
+// unindex returns the index with key removed from the set of value.
+func unindex(im *pmap, value, key string) *pmap {
+	v, ok := im.Get(value)
+	if !ok {
+		return im
+	}
+	keys := v.(*pmap).Delete(key)
+	if keys.Len() == 0 {
+		return im.Delete(value)
+	}
+	return im.Set(value, keys)
+}
+
+// RegisterIndex declares a secondary index on a store and builds it from the committed values.
+// Registering an index already declared on the store does nothing, so every repository
+// built on the database can declare the indexes it uses without rebuilding them.
+func (mDb *MemoryKVDatabase) RegisterIndex(name utils.StoreName, index string, extract utils.IndexExtractor) {
+	mDb.lock.Lock()
+	defer mDb.lock.Unlock()
+
+	s := mDb.state.Load()
+	if _, ok := s.indexes[name][index]; ok {
+		return
+	}
+	next := &snapshot{
+		stores:        make(map[utils.StoreName]*pmap, len(s.stores)+1),
+		storeVersions: make(map[utils.StoreName]uint64, len(s.storeVersions)+1),
+		indexes:       make(indexSet, len(s.indexes)+1),
+		version:       s.version + 1,
+	}
+	for n, m := range s.stores {
+		next.stores[n] = m
+	}
+	for n, v := range s.storeVersions {
+		next.storeVersions[n] = v
+	}
+	for n, extractors := range s.indexes {
+		next.indexes[n] = extractors
+	}
+
+	// copy on write the extractors of the store, transactions may hold the previous set
+	extractors := make(map[string]utils.IndexExtractor, len(s.indexes[name])+1)
+	for i, e := range s.indexes[name] {
+		extractors[i] = e
+	}
+	extractors[index] = extract
+	next.indexes[name] = extractors
+
+	im := emptyPmap
+	if m, ok := s.stores[name]; ok {
+		m.Range(func(key string, v interface{}) bool {
+			for _, iv := range extract(v.(record).value) {
+				im = addIndex(im, iv, key)
+			}
+			return true
+		})
+	}
+	is := indexStore(name, index)
+	next.stores[is] = im
+	next.storeVersions[is] = next.version
+
+	mDb.state.Store(next)
+}
+
+// Lookup returns the key/values indexed under value, ordered by key.
+// In optimistic mode a lookup is validated like a scan of the index, so a
+// concurrent commit that changes what the lookup would return is a conflict.
+func (tx *MemoryKVTx) Lookup(name utils.StoreName, index string, value string) ([]utils.KeyValue, error) {
+	if _, ok := tx.base.indexes[name][index]; !ok {
+		return nil, fmt.Errorf("%w: %s/%s", utils.ErrIndexNotFound, name, index)
+	}
+
+	is := indexStore(name, index)
+	tx.trackScan(is)
+
+	im, ok := tx.view()[is]
+	if !ok {
+		return []utils.KeyValue{}, nil
+	}
+	v, ok := im.Get(value)
+	if !ok {
+		return []utils.KeyValue{}, nil
+	}
+
+	keys := make([]string, 0, v.(*pmap).Len())
+	v.(*pmap).Range(func(key string, _ interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	sort.Strings(keys)
+
+	res := make([]utils.KeyValue, 0, len(keys))
+	for _, key := range keys {
+		v, err := tx.Read(name, key)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, utils.KeyValue{Key: key, Value: v})
+	}
+	return res, nil
+}
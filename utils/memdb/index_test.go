@@ -0,0 +1,152 @@
+package memdb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gambarini/flip-shop/utils"
+)
+
+const colorStore = utils.StoreName("COLOR")
+
+// colorIndex indexes "color:size" strings by color.
+func colorIndex(v interface{}) []string {
+	s := v.(string)
+	for i := range s {
+		if s[i] == ':' {
+			return []string{s[:i]}
+		}
+	}
+	return nil
+}
+
+func lookupKeys(t *testing.T, tx utils.Tx, color string) []string {
+	t.Helper()
+	kvs, err := tx.Lookup(colorStore, "color", color)
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	keys := []string{}
+	for _, kv := range kvs {
+		keys = append(keys, kv.Key)
+	}
+	return keys
+}
+
+func TestMemoryKVDatabase_IndexFollowsWritesAndDeletes(t *testing.T) {
+	mDb := newTestDB(map[utils.StoreName]map[string]interface{}{
+		colorStore: {"k1": "red:S", "k2": "blue:M", "k3": "red:L"},
+	})
+	// registered after the data exists: the index is built from it
+	mDb.RegisterIndex(colorStore, "color", colorIndex)
+
+	if err := mDb.WithTx(func(tx utils.Tx) error {
+		tx.Write(colorStore, "k4", "red:XL")
+		tx.Write(colorStore, "k1", "blue:S")
+		tx.Delete(colorStore, "k3")
+		return nil
+	}); err != nil {
+		t.Fatalf("WithTx() error = %v", err)
+	}
+
+	tests := []struct {
+		color string
+		want  []string
+	}{
+		{"red", []string{"k4"}},
+		{"blue", []string{"k1", "k2"}},
+		{"green", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.color, func(t *testing.T) {
+			_ = mDb.WithTx(func(tx utils.Tx) error {
+				if got := lookupKeys(t, tx, tt.color); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Lookup(%s) = %v, want %v", tt.color, got, tt.want)
+				}
+				return nil
+			})
+		})
+	}
+
+	// indexes are derived data and are left out of dumps
+	if got := len(mDb.Dump()); got != 1 {
+		t.Fatalf("Dump() has %d stores, want 1", got)
+	}
+}
+
+func TestMemoryKVDatabase_RegisterIndexOnce(t *testing.T) {
+	mDb := newTestDB(map[utils.StoreName]map[string]interface{}{colorStore: {"k1": "red:S"}})
+	mDb.RegisterIndex(colorStore, "color", colorIndex)
+	version := mDb.current().version
+
+	// a second declaration, e.g. by another repository, neither rebuilds nor replaces the index
+	mDb.RegisterIndex(colorStore, "color", func(interface{}) []string { return []string{"red"} })
+
+	if got := mDb.current().version; got != version {
+		t.Fatalf("version = %d, want %d", got, version)
+	}
+	if err := mDb.WithTx(func(tx utils.Tx) error {
+		tx.Write(colorStore, "k2", "blue:M")
+		return nil
+	}); err != nil {
+		t.Fatalf("WithTx() error = %v", err)
+	}
+	_ = mDb.View(func(tx utils.Tx) error {
+		if got := lookupKeys(t, tx, "red"); !reflect.DeepEqual(got, []string{"k1"}) {
+			t.Errorf("Lookup(red) = %v, want [k1]", got)
+		}
+		return nil
+	})
+}
+
+func TestMemoryKVTx_LookupSeesOwnWritesAndRollsBack(t *testing.T) {
+	mDb := NewMemoryKVDatabase()
+	mDb.RegisterIndex(colorStore, "color", colorIndex)
+
+	boom := errors.New("boom")
+	err := mDb.WithTx(func(tx utils.Tx) error {
+		tx.Write(colorStore, "k1", "red:S")
+		if got := lookupKeys(t, tx, "red"); !reflect.DeepEqual(got, []string{"k1"}) {
+			t.Errorf("Lookup() = %v, want own write", got)
+		}
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("WithTx() error = %v, want %v", err, boom)
+	}
+
+	_ = mDb.WithTx(func(tx utils.Tx) error {
+		if got := lookupKeys(t, tx, "red"); len(got) != 0 {
+			t.Errorf("Lookup() = %v after rollback, want none", got)
+		}
+		return nil
+	})
+}
+
+func TestMemoryKVTx_LookupUnknownIndex(t *testing.T) {
+	tx := newTx(emptySnapshot, false)
+	if _, err := tx.Lookup(colorStore, "size", "S"); !errors.Is(err, utils.ErrIndexNotFound) {
+		t.Fatalf("Lookup() error = %v, want %v", err, utils.ErrIndexNotFound)
+	}
+}
+
+func TestOptimistic_LookupConflictsWithIndexedInsert(t *testing.T) {
+	mDb := NewMemoryKVDatabaseWithOptions(Options{
+		Concurrency: Optimistic,
+		Retry:       RetryPolicy{MaxAttempts: 1},
+	})
+	mDb.RegisterIndex(colorStore, "color", colorIndex)
+
+	err := mDb.WithTx(func(tx utils.Tx) error {
+		tx.Write(counterStore, "reds", len(lookupKeys(t, tx, "red")))
+		// a concurrent transaction adds a key the lookup would have returned
+		return mDb.WithTx(func(tx utils.Tx) error {
+			tx.Write(colorStore, "k1", "red:S")
+			return nil
+		})
+	})
+	if !errors.Is(err, ErrTxConflict) {
+		t.Fatalf("WithTx() error = %v, want %v", err, ErrTxConflict)
+	}
+}
@@ -1,13 +1,32 @@
 // Package memdb provides a simple in-memory key/value database with
-// mutex-guarded serializable isolation. Each call to WithTx executes inside a
-// critical section protected by a global mutex, ensuring no interleaving between
-// concurrent transactions. Writes are applied using a copy-on-write snapshot per
-// transaction: changes are committed atomically only if the handler returns nil;
-// otherwise, they are discarded (rollback).
+// serializable isolation in one of two modes.
+//
+// Data is kept in persistent (immutable, structurally shared) hash tries, one
+// per store. A transaction starts from a snapshot of the database, which is a
+// single pointer, and a commit only copies the trie nodes on the paths of the
+// keys it changed.
+//
+// Pessimistic (default): each call to WithTx executes inside a critical section
+// protected by a global mutex, ensuring no interleaving between concurrent
+// transactions. Changes are committed atomically only if the handler returns
+// nil; otherwise, they are discarded (rollback).
+//
+// Optimistic: transactions run concurrently against the snapshot taken when they
+// started, recording the version of every key they read. At commit the read set is
+// validated against the committed versions; on conflict the transaction is
+// discarded and the handler is retried according to the RetryPolicy. Handlers must
+// therefore be free of side effects outside the transaction.
+//
+// In either mode, View runs a read-only handler against the latest committed
+// snapshot without taking the mutex, so long reads never hold up transactions.
 package memdb
 
 import (
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gambarini/flip-shop/utils"
 )
@@ -16,25 +35,78 @@ type (
 	// MemoryKVDatabase
 	// Key/value memory database
 	// Thread safe for concurrent read/write access
-	// Serializable isolation via global mutex and copy-on-write transactional semantics.
+	// Serializable isolation via global mutex (or optimistic validation) and copy-on-write snapshots.
 	MemoryKVDatabase struct {
-		lock sync.RWMutex
-		tx   *MemoryKVTx
+		lock       sync.RWMutex
+		state      atomic.Pointer[snapshot] // swapped under lock; loaded without it
+		commitHook CommitHook
+		expiryHook utils.ExpiryHook
+		reaper     *reaper
+		opts       Options
 	}
 
-	// MemoryKVTx represents a transaction view over the underlying data.
-	// It holds a map of stores, each a map from key to value.
+	// MemoryKVTx represents a transaction view over a snapshot of the database.
+	// Reads see the snapshot taken when the transaction started plus the
+	// transaction's own writes, kept in a copy-on-write view of the stores.
 	MemoryKVTx struct {
-		data map[utils.StoreName]map[string]interface{}
+		base   *snapshot
+		stores map[utils.StoreName]*pmap  // nil until the first write
+		reads  map[storeKey]uint64        // versions read; tracked in optimistic mode only
+		scans  map[utils.StoreName]uint64 // store versions scanned; tracked in optimistic mode only
+		writes []Mutation
+		now    func() time.Time // database clock, used to compute expiries
 	}
+
+	// snapshot is an immutable view of every store as of one commit.
+	// storeVersions holds the version of the last commit that changed each store.
+	snapshot struct {
+		stores        map[utils.StoreName]*pmap
+		storeVersions map[utils.StoreName]uint64
+		indexes       indexSet
+		version       uint64
+	}
+
+	// record is a stored value and the version of the commit that wrote it.
+	record struct {
+		value   interface{}
+		version uint64
+	}
+
+	// Mutation describes a single write or delete performed by a committed transaction.
+	Mutation struct {
+		Store   utils.StoreName
+		Key     string
+		Value   interface{}
+		Deleted bool
+	}
+
+	// CommitHook is invoked with the writes of a transaction right before it is
+	// committed, while the database lock is held. Returning an error aborts the
+	// commit and the transaction is rolled back.
+	CommitHook func(mutations []Mutation) error
 )
 
-// NewMemoryKVDatabase creates a new in-memory key/value database.
+var emptySnapshot = &snapshot{stores: map[utils.StoreName]*pmap{}, storeVersions: map[utils.StoreName]uint64{}, indexes: indexSet{}}
+
+// NewMemoryKVDatabase creates a new in-memory key/value database with pessimistic concurrency.
 func NewMemoryKVDatabase() *MemoryKVDatabase {
-	return &MemoryKVDatabase{
+	return NewMemoryKVDatabaseWithOptions(Options{})
+}
+
+// NewMemoryKVDatabaseWithOptions creates a new in-memory key/value database configured by opts.
+func NewMemoryKVDatabaseWithOptions(opts Options) *MemoryKVDatabase {
+	if opts.Retry.MaxAttempts <= 0 {
+		opts.Retry.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	mDb := &MemoryKVDatabase{
 		lock: sync.RWMutex{},
-		tx:   &MemoryKVTx{data: make(map[utils.StoreName]map[string]interface{})},
+		opts: opts,
+	}
+	mDb.state.Store(emptySnapshot)
+	if opts.ReapInterval > 0 {
+		mDb.StartReaper(opts.ReapInterval)
 	}
+	return mDb
 }
 
 // NewDMemoryKVDatabase is deprecated; use NewMemoryKVDatabase instead.
@@ -42,78 +114,310 @@ func NewDMemoryKVDatabase() *MemoryKVDatabase {
 	return NewMemoryKVDatabase()
 }
 
-func (tx MemoryKVTx) Read(name utils.StoreName, key string) (v interface{}, err error) {
-	v, ok := tx.data[name][key]
+// get returns the record stored for a key in the snapshot.
+func (s *snapshot) get(name utils.StoreName, key string) (record, bool) {
+	m, ok := s.stores[name]
+	if !ok {
+		return record{}, false
+	}
+	v, ok := m.Get(key)
+	if !ok {
+		return record{}, false
+	}
+	return v.(record), true
+}
+
+// apply returns a new snapshot with the mutations committed under the next version.
+func (s *snapshot) apply(mutations []Mutation) *snapshot {
+	next := &snapshot{
+		stores:        make(map[utils.StoreName]*pmap, len(s.stores)+1),
+		storeVersions: make(map[utils.StoreName]uint64, len(s.storeVersions)+1),
+		indexes:       s.indexes,
+		version:       s.version + 1,
+	}
+	for name, m := range s.stores {
+		next.stores[name] = m
+	}
+	for name, v := range s.storeVersions {
+		next.storeVersions[name] = v
+	}
+	for _, mu := range mutations {
+		if mu.Deleted {
+			put(next.stores, s.indexes, mu.Store, mu.Key, nil)
+		} else {
+			put(next.stores, s.indexes, mu.Store, mu.Key, &record{value: mu.Value, version: next.version})
+		}
+		next.storeVersions[mu.Store] = next.version
+		for index := range s.indexes[mu.Store] {
+			next.storeVersions[indexStore(mu.Store, index)] = next.version
+		}
+	}
+	return next
+}
+
+func newTx(base *snapshot, trackReads bool) *MemoryKVTx {
+	tx := &MemoryKVTx{base: base}
+	if trackReads {
+		tx.reads = make(map[storeKey]uint64)
+		tx.scans = make(map[utils.StoreName]uint64)
+	}
+	return tx
+}
+
+func (tx *MemoryKVTx) Read(name utils.StoreName, key string) (v interface{}, err error) {
+	if tx.reads != nil {
+		tx.trackRead(name, key)
+	}
+
+	m, ok := tx.view()[name]
 	if !ok {
-		return v, utils.ErrValueNotFound
+		return nil, utils.ErrValueNotFound
 	}
-	return v, nil
+	r, ok := m.Get(key)
+	if !ok {
+		return nil, utils.ErrValueNotFound
+	}
+	return r.(record).value, nil
+}
+
+func (tx *MemoryKVTx) Write(name utils.StoreName, key string, v interface{}) {
+	tx.write(name, key, v)
+	tx.clearExpiry(name, key)
 }
 
-func (tx MemoryKVTx) Write(name utils.StoreName, key string, v interface{}) {
-	if _, ok := tx.data[name]; !ok {
-		tx.data[name] = map[string]interface{}{}
+func (tx *MemoryKVTx) write(name utils.StoreName, key string, v interface{}) {
+	tx.own(name)
+	put(tx.stores, tx.base.indexes, name, key, &record{value: v})
+	tx.writes = append(tx.writes, Mutation{Store: name, Key: key, Value: v})
+}
+
+func (tx *MemoryKVTx) Delete(name utils.StoreName, key string) {
+	m, ok := tx.view()[name]
+	if !ok {
+		return
 	}
-	tx.data[name][key] = v
+	if _, ok := m.Get(key); !ok {
+		return
+	}
+	tx.own(name)
+	put(tx.stores, tx.base.indexes, name, key, nil)
+	tx.writes = append(tx.writes, Mutation{Store: name, Key: key, Deleted: true})
+	tx.clearExpiry(name, key)
+}
+
+// Scan returns the key/values of the store whose key starts with prefix, ordered by key.
+func (tx *MemoryKVTx) Scan(name utils.StoreName, prefix string) ([]utils.KeyValue, error) {
+	var res []utils.KeyValue
+	err := tx.Range(name, prefix, "", func(kv utils.KeyValue) bool {
+		if !strings.HasPrefix(kv.Key, prefix) {
+			return false
+		}
+		res = append(res, kv)
+		return true
+	})
+	return res, err
 }
 
-// cloneData performs a shallow copy of the top-level store map and each inner
-// key/value map. Values are copied by reference, which is acceptable given the
-// domain models in this project are treated as immutable within a transaction
-// unless overwritten explicitly.
-func cloneData(src map[utils.StoreName]map[string]interface{}) map[utils.StoreName]map[string]interface{} {
-	copy := make(map[utils.StoreName]map[string]interface{}, len(src))
-	for store, kv := range src {
-		inner := make(map[string]interface{}, len(kv))
-		for k, v := range kv {
-			inner[k] = v
+// Range iterates the key/values with from <= key < to in key order.
+// The iteration runs over the transaction view, so it is consistent with the
+// snapshot the transaction started from and includes its own writes.
+func (tx *MemoryKVTx) Range(name utils.StoreName, from, to string, fn func(kv utils.KeyValue) bool) error {
+	tx.trackScan(name)
+
+	m, ok := tx.view()[name]
+	if !ok {
+		return nil
+	}
+
+	// The trie is unordered: collect the bounded entries and sort them by key
+	kvs := make([]utils.KeyValue, 0, m.Len())
+	m.Range(func(key string, v interface{}) bool {
+		if key >= from && (to == "" || key < to) {
+			kvs = append(kvs, utils.KeyValue{Key: key, Value: v.(record).value})
+		}
+		return true
+	})
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+
+	for _, kv := range kvs {
+		if !fn(kv) {
+			break
 		}
-		copy[store] = inner
 	}
-	return copy
+	return nil
+}
+
+// view returns the stores as seen by the transaction.
+func (tx *MemoryKVTx) view() map[utils.StoreName]*pmap {
+	if tx.stores != nil {
+		return tx.stores
+	}
+	return tx.base.stores
+}
+
+// own prepares the copy-on-write view for a write and returns the current map of the store.
+func (tx *MemoryKVTx) own(name utils.StoreName) *pmap {
+	if tx.stores == nil {
+		tx.stores = make(map[utils.StoreName]*pmap, len(tx.base.stores)+1)
+		for n, m := range tx.base.stores {
+			tx.stores[n] = m
+		}
+	}
+	m, ok := tx.stores[name]
+	if !ok {
+		m = emptyPmap
+	}
+	return m
+}
+
+// trackRead records the snapshot version of a key the first time it is read.
+// Validating against the snapshot version is also correct for keys the
+// transaction wrote itself: it only requires nobody else committed them since.
+func (tx *MemoryKVTx) trackRead(name utils.StoreName, key string) {
+	sk := storeKey{name, key}
+	if _, seen := tx.reads[sk]; seen {
+		return
+	}
+	r, _ := tx.base.get(name, key)
+	tx.reads[sk] = r.version
+}
+
+// trackScan records the snapshot version of a store the first time it is scanned.
+func (tx *MemoryKVTx) trackScan(name utils.StoreName) {
+	if tx.scans == nil {
+		return
+	}
+	if _, seen := tx.scans[name]; !seen {
+		tx.scans[name] = tx.base.storeVersions[name]
+	}
 }
 
 func (mDb *MemoryKVDatabase) WithTx(txHandler utils.TxHandler) error {
+	if mDb.opts.Concurrency == Optimistic {
+		return mDb.withOptimisticTx(txHandler)
+	}
+
 	// Ensure serializable isolation across transactions
 	mDb.lock.Lock()
 	defer mDb.lock.Unlock()
 
-	// Create a transactional snapshot (copy-on-write)
-	snapshot := cloneData(mDb.tx.data)
-	tx := &MemoryKVTx{data: snapshot}
+	// Start from the current snapshot; taking it is O(1)
+	tx := newTx(mDb.state.Load(), false)
+	tx.now = mDb.now
 
 	// Execute user handler against the snapshot
 	if err := txHandler(tx); err != nil {
-		// rollback by discarding snapshot
+		// rollback by discarding the transaction view
 		return err
 	}
 
-	// Commit by replacing the live data with the snapshot
-	mDb.tx = tx
+	return mDb.commit(tx)
+}
+
+// View runs a read-only handler against the latest committed snapshot. It takes
+// no write lock, so long reads do not hold up transactions; writes are discarded.
+func (mDb *MemoryKVDatabase) View(txHandler utils.TxHandler) error {
+	tx := newTx(mDb.current(), false)
+	tx.now = mDb.now
+	return txHandler(tx)
+}
+
+// commit publishes the writes of the transaction. Callers must hold the write lock.
+func (mDb *MemoryKVDatabase) commit(tx *MemoryKVTx) error {
+	if len(tx.writes) == 0 {
+		return nil
+	}
+
+	// Give the commit hook a chance to veto (e.g. a failed log append)
+	if mDb.commitHook != nil {
+		if err := mDb.commitHook(tx.writes); err != nil {
+			return err
+		}
+	}
+
+	mDb.state.Store(mDb.state.Load().apply(tx.writes))
 	return nil
 }
 
+// Replay applies mutations committed earlier, e.g. recovered from a log, without
+// calling the commit hook. Indexes are kept up to date as for any commit.
+func (mDb *MemoryKVDatabase) Replay(mutations []Mutation) {
+	mDb.lock.Lock()
+	defer mDb.lock.Unlock()
+	if len(mutations) > 0 {
+		mDb.state.Store(mDb.state.Load().apply(mutations))
+	}
+}
+
+// current returns the latest committed snapshot. Snapshots are immutable, so it
+// does not wait for a transaction in progress.
+func (mDb *MemoryKVDatabase) current() *snapshot {
+	return mDb.state.Load()
+}
+
+// SetCommitHook registers a hook called with the writes of every transaction
+// before it commits. Passing nil removes the hook.
+func (mDb *MemoryKVDatabase) SetCommitHook(hook CommitHook) {
+	mDb.lock.Lock()
+	defer mDb.lock.Unlock()
+	mDb.commitHook = hook
+}
+
+// Dump returns a copy of all stores and their key/values as of the last commit.
+// Secondary indexes are not included; they are rebuilt when registered.
+func (mDb *MemoryKVDatabase) Dump() map[utils.StoreName]map[string]interface{} {
+	return mDb.current().dump()
+}
+
+// Checkpoint runs f while holding the write lock and returns the dump as of that
+// point, so no transaction can commit between f and the dump. The dump is
+// not taken if f fails.
+func (mDb *MemoryKVDatabase) Checkpoint(f func() error) (map[utils.StoreName]map[string]interface{}, error) {
+	mDb.lock.Lock()
+	if err := f(); err != nil {
+		mDb.lock.Unlock()
+		return nil, err
+	}
+	s := mDb.state.Load()
+	mDb.lock.Unlock()
+
+	return s.dump(), nil
+}
+
+func (s *snapshot) dump() map[utils.StoreName]map[string]interface{} {
+	data := make(map[utils.StoreName]map[string]interface{}, len(s.stores))
+	for name, m := range s.stores {
+		if isIndexStore(name) {
+			continue
+		}
+		kv := make(map[string]interface{}, m.Len())
+		m.Range(func(key string, v interface{}) bool {
+			kv[key] = v.(record).value
+			return true
+		})
+		data[name] = kv
+	}
+	return data
+}
+
 func (mDb *MemoryKVDatabase) Read(name utils.StoreName, key string) (v interface{}, err error) {
-	mDb.lock.RLock()
-	defer mDb.lock.RUnlock()
-	v, ok := mDb.tx.data[name][key]
+	r, ok := mDb.current().get(name, key)
 	if !ok {
-		return v, utils.ErrValueNotFound
+		return nil, utils.ErrValueNotFound
 	}
-	return v, nil
+	return r.value, nil
 }
 
 // List returns a snapshot slice with all values for a given store name.
 func (mDb *MemoryKVDatabase) List(name utils.StoreName) ([]interface{}, error) {
-	mDb.lock.RLock()
-	defer mDb.lock.RUnlock()
-	store, ok := mDb.tx.data[name]
+	store, ok := mDb.current().stores[name]
 	if !ok {
 		return []interface{}{}, nil
 	}
-	res := make([]interface{}, 0, len(store))
-	for _, v := range store {
-		res = append(res, v)
-	}
+	res := make([]interface{}, 0, store.Len())
+	store.Range(func(_ string, v interface{}) bool {
+		res = append(res, v.(record).value)
+		return true
+	})
 	return res, nil
 }
@@ -1,21 +1,30 @@
 package memdb
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gambarini/flip-shop/utils"
 	"reflect"
-	"sync"
 	"testing"
 )
 
+// newTestDB creates a database holding the given stores.
+func newTestDB(data map[utils.StoreName]map[string]interface{}) *MemoryKVDatabase {
+	mDb := NewMemoryKVDatabase()
+	_ = mDb.WithTx(func(tx utils.Tx) error {
+		for name, kv := range data {
+			for k, v := range kv {
+				tx.Write(name, k, v)
+			}
+		}
+		return nil
+	})
+	return mDb
+}
+
 func TestMemoryKVDatabase_Read(t *testing.T) {
 
-	mDb := &MemoryKVDatabase{
-		lock: sync.RWMutex{},
-		tx: &MemoryKVTx{
-			data: map[utils.StoreName]map[string]interface{}{utils.StoreName("TEST"): {"key1": 1, "key2": 2, "key3": 3}},
-		},
-	}
+	mDb := newTestDB(map[utils.StoreName]map[string]interface{}{utils.StoreName("TEST"): {"key1": 1, "key2": 2, "key3": 3}})
 
 	type args struct {
 		name utils.StoreName
@@ -49,12 +58,7 @@ func TestMemoryKVDatabase_Read(t *testing.T) {
 }
 
 func TestMemoryKVDatabase_WithTx(t *testing.T) {
-	mDb := &MemoryKVDatabase{
-		lock: sync.RWMutex{},
-		tx: &MemoryKVTx{
-			data: map[utils.StoreName]map[string]interface{}{utils.StoreName("TEST"): {"key1": 1, "key2": 2, "key3": 3}},
-		},
-	}
+	mDb := newTestDB(map[utils.StoreName]map[string]interface{}{utils.StoreName("TEST"): {"key1": 1, "key2": 2, "key3": 3}})
 
 	type args struct {
 		txHandler utils.TxHandler
@@ -82,10 +86,44 @@ func TestMemoryKVDatabase_WithTx(t *testing.T) {
 	}
 }
 
-func TestMemoryKVTx_Read(t *testing.T) {
-	tx := &MemoryKVTx{
-		data: map[utils.StoreName]map[string]interface{}{utils.StoreName("TEST"): {"key1": 1, "key2": 2, "key3": 3}},
+func TestMemoryKVDatabase_View(t *testing.T) {
+	mDb := newTestDB(map[utils.StoreName]map[string]interface{}{utils.StoreName("TEST"): {"key1": 1}})
+
+	// a view reads the last commit while a transaction holds the write lock
+	inTx, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- mDb.WithTx(func(tx utils.Tx) error {
+			tx.Write("TEST", "key1", 2)
+			close(inTx)
+			<-release
+			return nil
+		})
+	}()
+	<-inTx
+
+	if err := mDb.View(func(tx utils.Tx) error {
+		v, err := tx.Read("TEST", "key1")
+		if err != nil || v != 1 {
+			t.Errorf("Read() = %v, %v, want 1", v, err)
+		}
+		tx.Write("TEST", "key2", 2)
+		return nil
+	}); err != nil {
+		t.Fatalf("View() error = %v", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("WithTx() error = %v", err)
+	}
+	if _, err := mDb.Read("TEST", "key2"); !errors.Is(err, utils.ErrValueNotFound) {
+		t.Errorf("expected the writes of a view discarded, got %v", err)
 	}
+}
+
+func TestMemoryKVTx_Read(t *testing.T) {
+	tx := newTx(newTestDB(map[utils.StoreName]map[string]interface{}{utils.StoreName("TEST"): {"key1": 1, "key2": 2, "key3": 3}}).state.Load(), false)
 
 	type args struct {
 		name utils.StoreName
@@ -120,9 +158,7 @@ func TestMemoryKVTx_Read(t *testing.T) {
 }
 
 func TestMemoryKVTx_Write(t *testing.T) {
-	tx := &MemoryKVTx{
-		data: map[utils.StoreName]map[string]interface{}{utils.StoreName("TEST"): {}},
-	}
+	tx := newTx(emptySnapshot, false)
 
 	type args struct {
 		name utils.StoreName
@@ -144,8 +180,100 @@ func TestMemoryKVTx_Write(t *testing.T) {
 
 			tx.Write(tt.args.name, tt.args.key, tt.args.v)
 
-			if !reflect.DeepEqual(tx.data[tt.args.name][tt.args.key], tt.wantV) {
-				t.Errorf("data stored = %v, want %v", tx.data[tt.args.name][tt.args.key], tt.wantV)
+			gotV, err := tx.Read(tt.args.name, tt.args.key)
+			if err != nil || !reflect.DeepEqual(gotV, tt.wantV) {
+				t.Errorf("data stored = %v (err %v), want %v", gotV, err, tt.wantV)
+			}
+		})
+	}
+}
+
+func TestMemoryKVTx_Delete(t *testing.T) {
+	mDb := newTestDB(map[utils.StoreName]map[string]interface{}{
+		"TEST": {"key1": 1, "key2": 2},
+	})
+
+	if err := mDb.WithTx(func(tx utils.Tx) error {
+		tx.Delete("TEST", "key1")
+		tx.Delete("TEST", "missing")
+		tx.Delete("NONE", "key1")
+		if _, err := tx.Read("TEST", "key1"); !errors.Is(err, utils.ErrValueNotFound) {
+			t.Errorf("Read() after Delete error = %v, want %v", err, utils.ErrValueNotFound)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("WithTx() error = %v", err)
+	}
+
+	if _, err := mDb.Read("TEST", "key1"); !errors.Is(err, utils.ErrValueNotFound) {
+		t.Fatalf("expected key1 deleted, got err %v", err)
+	}
+	if got, err := mDb.Read("TEST", "key2"); err != nil || got != 2 {
+		t.Fatalf("key2 = %v (err %v), want 2", got, err)
+	}
+}
+
+func TestMemoryKVTx_ScanAndRange(t *testing.T) {
+	tx := newTx(newTestDB(map[utils.StoreName]map[string]interface{}{
+		"TEST": {"a1": 1, "a2": 2, "b1": 3, "b2": 4, "c1": 5},
+	}).current(), false)
+	tx.Write("TEST", "a3", 6)
+	tx.Delete("TEST", "b2")
+
+	keys := func(kvs []utils.KeyValue) []string {
+		res := []string{}
+		for _, kv := range kvs {
+			res = append(res, kv.Key)
+		}
+		return res
+	}
+
+	scans := []struct {
+		name   string
+		store  utils.StoreName
+		prefix string
+		want   []string
+	}{
+		{"prefix with own write", "TEST", "a", []string{"a1", "a2", "a3"}},
+		{"prefix with own delete", "TEST", "b", []string{"b1"}},
+		{"all keys", "TEST", "", []string{"a1", "a2", "a3", "b1", "c1"}},
+		{"no match", "TEST", "z", []string{}},
+		{"missing store", "NONE", "", []string{}},
+	}
+	for _, tt := range scans {
+		t.Run("scan "+tt.name, func(t *testing.T) {
+			got, err := tx.Scan(tt.store, tt.prefix)
+			if err != nil {
+				t.Fatalf("Scan() error = %v", err)
+			}
+			if !reflect.DeepEqual(keys(got), tt.want) {
+				t.Errorf("Scan() = %v, want %v", keys(got), tt.want)
+			}
+		})
+	}
+
+	ranges := []struct {
+		name     string
+		from, to string
+		limit    int
+		want     []string
+	}{
+		{"bounded", "a2", "b2", 0, []string{"a2", "a3", "b1"}},
+		{"open ended", "b", "", 0, []string{"b1", "c1"}},
+		{"stopped early", "", "", 2, []string{"a1", "a2"}},
+	}
+	for _, tt := range ranges {
+		t.Run("range "+tt.name, func(t *testing.T) {
+			got := []string{}
+			err := tx.Range("TEST", tt.from, tt.to, func(kv utils.KeyValue) bool {
+				got = append(got, kv.Key)
+				return tt.limit == 0 || len(got) < tt.limit
+			})
+			if err != nil {
+				t.Fatalf("Range() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Range() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -0,0 +1,104 @@
+package memdb
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gambarini/flip-shop/utils"
+)
+
+const (
+	// Pessimistic serializes transactions with a global mutex.
+	Pessimistic Concurrency = iota
+	// Optimistic runs transactions concurrently and validates their read sets at commit.
+	Optimistic
+)
+
+var (
+	// ErrTxConflict is returned when an optimistic transaction still conflicts
+	// after exhausting its retry policy.
+	ErrTxConflict = errors.New("transaction conflict")
+
+	// DefaultRetryPolicy is used when Options.Retry.MaxAttempts is not set.
+	DefaultRetryPolicy = RetryPolicy{MaxAttempts: 10, Backoff: 100 * time.Microsecond}
+)
+
+type (
+	// Concurrency selects how transactions are isolated from each other.
+	Concurrency int
+
+	// RetryPolicy bounds the automatic retries of conflicting optimistic transactions.
+	RetryPolicy struct {
+		// MaxAttempts is the total number of times a handler is run before ErrTxConflict is returned.
+		MaxAttempts int
+		// Backoff is multiplied by the attempt number and waited before each retry.
+		Backoff time.Duration
+	}
+
+	// Options configures a MemoryKVDatabase.
+	Options struct {
+		Concurrency Concurrency
+		Retry       RetryPolicy
+		// ReapInterval starts a background reaper removing expired keys at this
+		// interval. Zero disables it; Reap can still be called explicitly.
+		ReapInterval time.Duration
+		// Clock returns the current time for TTLs. Defaults to time.Now.
+		Clock func() time.Time
+	}
+
+	storeKey struct {
+		store utils.StoreName
+		key   string
+	}
+)
+
+// withOptimisticTx runs the handler, retrying it when commit validation fails.
+func (mDb *MemoryKVDatabase) withOptimisticTx(txHandler utils.TxHandler) error {
+	policy := mDb.opts.Retry
+
+	for attempt := 1; ; attempt++ {
+		err := mDb.runOptimistic(txHandler)
+
+		if !errors.Is(err, ErrTxConflict) || attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		time.Sleep(policy.Backoff * time.Duration(attempt))
+	}
+}
+
+func (mDb *MemoryKVDatabase) runOptimistic(txHandler utils.TxHandler) error {
+	tx := newTx(mDb.current(), true)
+	tx.now = mDb.now
+
+	if err := txHandler(tx); err != nil {
+		// rollback by discarding the transaction view
+		return err
+	}
+
+	// A read-only transaction saw a consistent snapshot and needs no validation
+	if len(tx.writes) == 0 {
+		return nil
+	}
+
+	mDb.lock.Lock()
+	defer mDb.lock.Unlock()
+
+	state := mDb.state.Load()
+
+	for sk, version := range tx.reads {
+		r, _ := state.get(sk.store, sk.key)
+		if r.version != version {
+			return ErrTxConflict
+		}
+	}
+
+	// Scans are validated per store, which also catches keys inserted or deleted since
+	for name, version := range tx.scans {
+		if state.storeVersions[name] != version {
+			return ErrTxConflict
+		}
+	}
+
+	return mDb.commit(tx)
+}
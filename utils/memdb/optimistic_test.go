@@ -0,0 +1,131 @@
+package memdb
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/gambarini/flip-shop/utils"
+)
+
+const counterStore = utils.StoreName("COUNTER")
+
+func increment(tx utils.Tx) error {
+	v, err := tx.Read(counterStore, "n")
+	n := 0
+	switch {
+	case errors.Is(err, utils.ErrValueNotFound):
+	case err != nil:
+		return err
+	default:
+		n = v.(int)
+	}
+	tx.Write(counterStore, "n", n+1)
+	return nil
+}
+
+func TestOptimistic_ConcurrentIncrementsAreSerializable(t *testing.T) {
+	mDb := NewMemoryKVDatabaseWithOptions(Options{
+		Concurrency: Optimistic,
+		Retry:       RetryPolicy{MaxAttempts: 1000},
+	})
+
+	const workers = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- mDb.WithTx(increment)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("WithTx() error = %v", err)
+		}
+	}
+
+	got, err := mDb.Read(counterStore, "n")
+	if err != nil || got != workers {
+		t.Fatalf("counter = %v (err %v), want %d", got, err, workers)
+	}
+}
+
+func TestOptimistic_ConflictRetriesAreBounded(t *testing.T) {
+	mDb := NewMemoryKVDatabaseWithOptions(Options{
+		Concurrency: Optimistic,
+		Retry:       RetryPolicy{MaxAttempts: 3},
+	})
+
+	attempts := 0
+	err := mDb.WithTx(func(tx utils.Tx) error {
+		attempts++
+		if err := increment(tx); err != nil {
+			return err
+		}
+		// a concurrent transaction commits a change to the key we read
+		return mDb.WithTx(increment)
+	})
+
+	if !errors.Is(err, ErrTxConflict) {
+		t.Fatalf("WithTx() error = %v, want %v", err, ErrTxConflict)
+	}
+	if attempts != 3 {
+		t.Fatalf("handler ran %d times, want 3", attempts)
+	}
+	// only the inner transactions committed
+	if got, _ := mDb.Read(counterStore, "n"); got != 3 {
+		t.Fatalf("counter = %v, want 3", got)
+	}
+}
+
+func TestOptimistic_ReadYourWritesAndRollback(t *testing.T) {
+	mDb := NewMemoryKVDatabaseWithOptions(Options{Concurrency: Optimistic})
+
+	boom := errors.New("boom")
+	err := mDb.WithTx(func(tx utils.Tx) error {
+		tx.Write(counterStore, "n", 42)
+		v, err := tx.Read(counterStore, "n")
+		if err != nil || v != 42 {
+			t.Fatalf("Read() = %v (err %v), want own write 42", v, err)
+		}
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("WithTx() error = %v, want %v", err, boom)
+	}
+	if _, err := mDb.Read(counterStore, "n"); !errors.Is(err, utils.ErrValueNotFound) {
+		t.Fatalf("expected rolled back write to be absent, got err %v", err)
+	}
+}
+
+func TestOptimistic_ScanConflictsWithInsert(t *testing.T) {
+	mDb := NewMemoryKVDatabaseWithOptions(Options{
+		Concurrency: Optimistic,
+		Retry:       RetryPolicy{MaxAttempts: 1},
+	})
+
+	err := mDb.WithTx(func(tx utils.Tx) error {
+		kvs, err := tx.Scan(counterStore, "")
+		if err != nil {
+			return err
+		}
+		tx.Write(counterStore, "count", len(kvs))
+		// a concurrent transaction inserts a key the scan would have returned
+		return mDb.WithTx(func(tx utils.Tx) error {
+			tx.Write(counterStore, "phantom", 1)
+			return nil
+		})
+	})
+
+	if !errors.Is(err, ErrTxConflict) {
+		t.Fatalf("WithTx() error = %v, want %v", err, ErrTxConflict)
+	}
+	if _, err := mDb.Read(counterStore, "count"); !errors.Is(err, utils.ErrValueNotFound) {
+		t.Fatalf("expected conflicting write to be discarded, got err %v", err)
+	}
+}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"math"
+	"math/big"
+	"sort"
 )
 
 // Money representation: all monetary values are represented as integer cents (int64).
@@ -57,3 +59,54 @@ func SaturatingMulInt64Int(a int64, b int) int64 {
 	}
 	return SaturatingMulInt64(a, int64(b))
 }
+
+// AllocateInt64 splits a non-negative amount across weights in proportion to them. Shares are truncated
+// and the cents left over go one each to the largest remainders, the first weights first on ties, so the
+// shares add up to amount. Negative weights count as 0; every share is 0 when the weights add up to 0.
+func AllocateInt64(amount int64, weights []int64) []int64 {
+
+	shares := make([]int64, len(weights))
+
+	sum := new(big.Int)
+	for _, w := range weights {
+		if w > 0 {
+			sum.Add(sum, big.NewInt(w))
+		}
+	}
+
+	if amount <= 0 || sum.Sign() == 0 {
+		return shares
+	}
+
+	remainders := make([]*big.Int, len(weights))
+	left := amount
+
+	for i, w := range weights {
+		remainders[i] = new(big.Int)
+		if w <= 0 {
+			continue
+		}
+		share := new(big.Int).Mul(big.NewInt(amount), big.NewInt(w))
+		share.QuoRem(share, sum, remainders[i])
+		shares[i] = share.Int64()
+		left -= shares[i]
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return remainders[order[i]].Cmp(remainders[order[j]]) > 0 })
+
+	for _, i := range order {
+		if left == 0 {
+			break
+		}
+		if weights[i] > 0 {
+			shares[i]++
+			left--
+		}
+	}
+
+	return shares
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestAllocateInt64(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  int64
+		weights []int64
+		want    []int64
+	}{
+		{"proportional", 1000, []int64{3000, 1000}, []int64{750, 250}},
+		{"left over cents to the largest remainders", 100, []int64{1, 1, 1}, []int64{34, 33, 33}},
+		{"left over cents by remainder", 10, []int64{2, 5, 7}, []int64{1, 4, 5}},
+		{"negative and zero weights get nothing", 90, []int64{-5, 0, 3}, []int64{0, 0, 90}},
+		{"no weight", 90, []int64{0, 0}, []int64{0, 0}},
+		{"no amount", 0, []int64{1, 2}, []int64{0, 0}},
+		{"large amounts", math.MaxInt64, []int64{math.MaxInt64, math.MaxInt64}, []int64{math.MaxInt64/2 + 1, math.MaxInt64 / 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AllocateInt64(tt.amount, tt.weights); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("AllocateInt64() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -243,6 +243,20 @@ func (srv *AppServer) ResponseErrorNotfound(response http.ResponseWriter, err er
 	_, _ = response.Write([]byte(fmt.Sprintf("{\"error\":\"%s\"}", err)))
 }
 
+func (srv *AppServer) ResponseErrorPaymentRequired(response http.ResponseWriter, err error) {
+	srv.Logger().Error("error_payment_required", Fields{"error": err.Error()})
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusPaymentRequired)
+	_, _ = response.Write([]byte(fmt.Sprintf("{\"error\":\"%s\"}", err)))
+}
+
+func (srv *AppServer) ResponseErrorGatewayTimeout(response http.ResponseWriter, err error) {
+	srv.Logger().Error("error_gateway_timeout", Fields{"error": err.Error()})
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusGatewayTimeout)
+	_, _ = response.Write([]byte(fmt.Sprintf("{\"error\":\"%s\"}", err)))
+}
+
 // RespondJSON writes a JSON response with the given status code. It ensures headers are set before body
 // and centralizes JSON encoding and error handling.
 func (srv *AppServer) RespondJSON(w http.ResponseWriter, status int, v interface{}) {